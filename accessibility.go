@@ -0,0 +1,238 @@
+package mailer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Accessibility rules reported by CheckAccessibility.
+const (
+	// AccessibilityImageAlt flags images without an alt attribute.
+	AccessibilityImageAlt = "image-alt"
+
+	// AccessibilityLang flags documents without a lang attribute.
+	AccessibilityLang = "html-lang"
+
+	// AccessibilityFontSize flags text set below a legible size.
+	AccessibilityFontSize = "font-size"
+
+	// AccessibilityContrast flags inline text and background colors with
+	// insufficient contrast.
+	AccessibilityContrast = "color-contrast"
+)
+
+// minFontSizePx is the smallest font size, in pixels, not flagged as too small.
+const minFontSizePx = 12
+
+// minContrastRatio is the WCAG AA contrast ratio for normal text.
+const minContrastRatio = 4.5
+
+// AccessibilityIssue describes an accessibility problem in an HTML email body.
+type AccessibilityIssue struct {
+	// Rule identifies the check that failed, e.g. AccessibilityImageAlt.
+	Rule string
+
+	// Element is the tag name of the offending element.
+	Element string
+
+	// Message describes the problem.
+	Message string
+}
+
+// String returns a human-readable description of the issue.
+func (i AccessibilityIssue) String() string {
+	return fmt.Sprintf("%s <%s>: %s", i.Rule, i.Element, i.Message)
+}
+
+// CheckAccessibility inspects rendered HTML for common accessibility problems:
+// images without alt text, a missing lang attribute, tiny font sizes and inline
+// color combinations with insufficient contrast. Checks are heuristic and only
+// consider inline styles.
+func CheckAccessibility(body string) []AccessibilityIssue {
+	var issues []AccessibilityIssue
+	hasLang := false
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		attrs := make(map[string]string, len(token.Attr))
+		for _, attr := range token.Attr {
+			attrs[strings.ToLower(attr.Key)] = attr.Val
+		}
+
+		switch token.Data {
+		case "html":
+			if strings.TrimSpace(attrs["lang"]) != "" {
+				hasLang = true
+			}
+		case "img":
+			if _, ok := attrs["alt"]; !ok {
+				issues = append(issues, AccessibilityIssue{
+					Rule:    AccessibilityImageAlt,
+					Element: token.Data,
+					Message: fmt.Sprintf("image %q has no alt text", attrs["src"]),
+				})
+			}
+		case "font":
+			if size, err := strconv.Atoi(attrs["size"]); err == nil && size <= 1 {
+				issues = append(issues, AccessibilityIssue{
+					Rule:    AccessibilityFontSize,
+					Element: token.Data,
+					Message: "font size 1 is too small to read",
+				})
+			}
+		}
+
+		if style, ok := attrs["style"]; ok {
+			issues = append(issues, checkInlineStyle(token.Data, style)...)
+		}
+	}
+
+	if !hasLang {
+		issues = append(issues, AccessibilityIssue{
+			Rule:    AccessibilityLang,
+			Element: "html",
+			Message: "document has no lang attribute",
+		})
+	}
+
+	return issues
+}
+
+// checkInlineStyle checks font sizes and color contrast in a style attribute.
+func checkInlineStyle(element, style string) []AccessibilityIssue {
+	var issues []AccessibilityIssue
+	declarations := parseStyle(style)
+
+	if size, ok := declarations["font-size"]; ok {
+		if px, ok := fontSizePx(size); ok && px < minFontSizePx {
+			issues = append(issues, AccessibilityIssue{
+				Rule:    AccessibilityFontSize,
+				Element: element,
+				Message: fmt.Sprintf("font size %s is below %dpx", size, minFontSizePx),
+			})
+		}
+	}
+
+	background := declarations["background-color"]
+	if background == "" {
+		background = declarations["background"]
+	}
+	fg, fgOK := parseColor(declarations["color"])
+	bg, bgOK := parseColor(background)
+	if fgOK && bgOK {
+		if ratio := contrastRatio(fg, bg); ratio < minContrastRatio {
+			issues = append(issues, AccessibilityIssue{
+				Rule:    AccessibilityContrast,
+				Element: element,
+				Message: fmt.Sprintf("contrast ratio %.2f:1 between %s and %s is below %.1f:1",
+					ratio, declarations["color"], background, minContrastRatio),
+			})
+		}
+	}
+
+	return issues
+}
+
+// parseStyle splits a style attribute into lower-cased property/value pairs.
+func parseStyle(style string) map[string]string {
+	declarations := make(map[string]string)
+	for _, declaration := range strings.Split(style, ";") {
+		property, value, found := strings.Cut(declaration, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		declarations[strings.ToLower(strings.TrimSpace(property))] = strings.ToLower(value)
+	}
+	return declarations
+}
+
+// fontSizePx converts px and pt font sizes to pixels.
+func fontSizePx(size string) (float64, bool) {
+	switch {
+	case strings.HasSuffix(size, "px"):
+		value, err := strconv.ParseFloat(strings.TrimSuffix(size, "px"), 64)
+		return value, err == nil
+	case strings.HasSuffix(size, "pt"):
+		value, err := strconv.ParseFloat(strings.TrimSuffix(size, "pt"), 64)
+		return value * 4 / 3, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// parseColor parses #rgb, #rrggbb and rgb(r, g, b) colors.
+func parseColor(value string) ([3]float64, bool) {
+	var rgb [3]float64
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, "#"):
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return rgb, false
+		}
+		for i := 0; i < 3; i++ {
+			component, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+			if err != nil {
+				return rgb, false
+			}
+			rgb[i] = float64(component)
+		}
+		return rgb, true
+	case strings.HasPrefix(value, "rgb(") && strings.HasSuffix(value, ")"):
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "rgb("), ")"), ",")
+		if len(parts) != 3 {
+			return rgb, false
+		}
+		for i, part := range parts {
+			component, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || component < 0 || component > 255 {
+				return rgb, false
+			}
+			rgb[i] = float64(component)
+		}
+		return rgb, true
+	default:
+		return rgb, false
+	}
+}
+
+// contrastRatio returns the WCAG contrast ratio between two colors.
+func contrastRatio(a, b [3]float64) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// relativeLuminance returns the WCAG relative luminance of an sRGB color.
+func relativeLuminance(rgb [3]float64) float64 {
+	var linear [3]float64
+	for i, component := range rgb {
+		c := component / 255
+		if c <= 0.03928 {
+			linear[i] = c / 12.92
+		} else {
+			linear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*linear[0] + 0.7152*linear[1] + 0.0722*linear[2]
+}
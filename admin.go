@@ -0,0 +1,148 @@
+package mailer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// adminProviderHealth is the JSON representation of a provider's health.
+type adminProviderHealth struct {
+	Provider  string  `json:"provider"`
+	Fallback  bool    `json:"fallback"`
+	State     string  `json:"state"`
+	Failures  int     `json:"failures"`
+	ErrorRate float64 `json:"error_rate"`
+	Healthy   bool    `json:"healthy"`
+}
+
+// adminRateLimits is the JSON representation of the rate limiter levels.
+type adminRateLimits struct {
+	Enabled   bool             `json:"enabled"`
+	Rate      int              `json:"rate,omitempty"`
+	Period    string           `json:"period,omitempty"`
+	Burst     int              `json:"burst,omitempty"`
+	Available int              `json:"available"`
+	Rules     []adminRateLimit `json:"rules,omitempty"`
+}
+
+// adminRateLimit is the JSON representation of a rate limit rule's level.
+// Available is omitted for keyed rules, such as per-recipient rules, which
+// have a level per key.
+type adminRateLimit struct {
+	Name         string   `json:"name"`
+	Rate         int      `json:"rate"`
+	Period       string   `json:"period"`
+	PerRecipient bool     `json:"per_recipient"`
+	Per          string   `json:"per,omitempty"`
+	Available    *float64 `json:"available,omitempty"`
+}
+
+// adminTemplate is the JSON representation of a registered template.
+type adminTemplate struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Source    string    `json:"source,omitempty"`
+	ParsedAt  time.Time `json:"parsed_at"`
+	Version   string    `json:"version"`
+	Variables []string  `json:"variables"`
+}
+
+// adminLoad is the JSON representation of the client's send load.
+type adminLoad struct {
+	InFlight int     `json:"in_flight"`
+	Rate     float64 `json:"rate"`
+	Draining bool    `json:"draining"`
+}
+
+// AdminHandler returns an http.Handler serving read-only JSON views of the
+// client's state, for mounting under an internal admin router:
+//
+//	GET /stats             rolling-window provider statistics
+//	GET /providers         provider health, as returned by ProviderHealth
+//	GET /circuit-breakers  circuit breaker state per provider
+//	GET /rate-limits       rate limiter and rate limit rule levels
+//	GET /templates         registered templates
+//	GET /load              in-flight sends and send rate
+//	GET /version           build information
+//
+// The paths are relative to the handler, so mount it with
+// http.StripPrefix. The handler exposes no credentials but does reveal
+// provider names and template variables, so it must not be reachable from
+// the public internet.
+func (c *Client) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := c.Stats()
+		writeAdminJSON(w, statsExport{
+			GeneratedAt: c.clock.Now().UTC(),
+			Window:      stats.Window.String(),
+			Providers:   providerStatsRows(stats),
+		})
+	})
+	mux.HandleFunc("GET /providers", func(w http.ResponseWriter, r *http.Request) {
+		health := []adminProviderHealth{}
+		for _, h := range c.ProviderHealth() {
+			health = append(health, adminProviderHealth{
+				Provider:  h.Provider,
+				Fallback:  h.Fallback,
+				State:     h.State.String(),
+				Failures:  h.Failures,
+				ErrorRate: h.ErrorRate,
+				Healthy:   h.Healthy,
+			})
+		}
+		writeAdminJSON(w, health)
+	})
+	mux.HandleFunc("GET /circuit-breakers", func(w http.ResponseWriter, r *http.Request) {
+		breakers := c.postMortemBreakers()
+		if breakers == nil {
+			breakers = []PostMortemBreaker{}
+		}
+		writeAdminJSON(w, breakers)
+	})
+	mux.HandleFunc("GET /rate-limits", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, c.adminRateLimits())
+	})
+	mux.HandleFunc("GET /templates", func(w http.ResponseWriter, r *http.Request) {
+		templates := []adminTemplate{}
+		if c.templateEng != nil {
+			for _, info := range c.templateEng.List() {
+				templates = append(templates, adminTemplate(info))
+			}
+		}
+		writeAdminJSON(w, templates)
+	})
+	mux.HandleFunc("GET /load", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, adminLoad(c.Load()))
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, GetVersionInfo())
+	})
+	return mux
+}
+
+// adminRateLimits returns the levels of the rate limiter and rate limit
+// rules.
+func (c *Client) adminRateLimits() adminRateLimits {
+	var limits adminRateLimits
+	if c.rateLimiter != nil && c.rateLimiter.config.Enabled {
+		config := c.rateLimiter.config
+		limits.Enabled = true
+		limits.Rate = config.Rate
+		limits.Period = config.Period.String()
+		limits.Burst = config.Burst
+		limits.Available = c.rateLimiter.available()
+	}
+	limits.Rules = c.rateRules.levels()
+	return limits
+}
+
+// writeAdminJSON writes v as the indented JSON response.
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// AssetStore uploads template assets to a CDN-backed location such as an S3 bucket.
+type AssetStore interface {
+	// PutAsset stores data under key. Keys contain a content hash, so
+	// implementations may skip uploads for keys that already exist.
+	PutAsset(ctx context.Context, key, contentType string, data []byte) error
+}
+
+// AssetConfig configures the template asset pipeline.
+type AssetConfig struct {
+	// Directory is the local directory containing images, fonts and other assets.
+	Directory string
+
+	// BaseURL is the public URL the uploaded assets are served from.
+	BaseURL string
+
+	// Store uploads assets when the engine is created (optional).
+	// When nil, assets are expected to be published out of band.
+	Store AssetStore
+}
+
+// loadAssets hashes every file in the asset directory, uploads it to the
+// configured store and records its cache-busted URL.
+func (te *TemplateEngineImpl) loadAssets(ctx context.Context) error {
+	cfg := te.config.Assets
+	fsys, root, source := te.dirFS(cfg.Directory)
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+
+	return fs.WalkDir(fsys, root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("failed to read asset %s: %w", source(file), err)
+		}
+
+		name := file
+		if root != "." {
+			name = strings.TrimPrefix(file, root+"/")
+		}
+
+		key := hashedAssetKey(name, data)
+		if cfg.Store != nil {
+			contentType := mime.TypeByExtension(filepath.Ext(name))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			if err := cfg.Store.PutAsset(ctx, key, contentType, data); err != nil {
+				return fmt.Errorf("failed to upload asset %s: %w", name, err)
+			}
+		}
+
+		te.assets[name] = baseURL + "/" + key
+		return nil
+	})
+}
+
+// assetURL returns the cache-busted URL of the named asset.
+// It backs the "asset" template function.
+func (te *TemplateEngineImpl) assetURL(name string) (string, error) {
+	url, ok := te.assets[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return "", fmt.Errorf("asset not found: %s", name)
+	}
+	return url, nil
+}
+
+// hashedAssetKey inserts a short content hash before the file extension,
+// e.g. "img/logo.png" becomes "img/logo.3f2a9c1b7d4e.png".
+func hashedAssetKey(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:12]
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// MetadataAttachments is the SendResult.Metadata key holding an
+// []AttachmentRecord describing each attachment of the email as sent.
+const MetadataAttachments = "attachments"
+
+// ScanVerdict is the verdict of an AttachmentScanner on an attachment.
+type ScanVerdict string
+
+// Scan verdicts. Scanners may return other verdicts, such as "suspicious",
+// all of which reject the email.
+const (
+	// ScanClean lets the attachment be sent.
+	ScanClean ScanVerdict = "clean"
+
+	// ScanInfected rejects the email carrying the attachment.
+	ScanInfected ScanVerdict = "infected"
+)
+
+// AttachmentScanner scans attachments before they are sent, e.g. with a
+// virus scanner such as ClamAV. Emails with an attachment the scanner does
+// not find clean are rejected with a validation error.
+type AttachmentScanner interface {
+	// Scan returns the verdict on the content of an attachment. An error
+	// fails the send.
+	Scan(ctx context.Context, filename, contentType string, data []byte) (ScanVerdict, error)
+}
+
+// AttachmentRecord describes an attachment as it was handed to the provider,
+// for auditing exactly what content was delivered.
+type AttachmentRecord struct {
+	// Filename is the name of the file as it appears in the email.
+	Filename string
+
+	// ContentType is the MIME content type the attachment was sent as.
+	ContentType string
+
+	// Disposition is "inline" or "attachment".
+	Disposition string
+
+	// ContentID is the Content-ID of inline attachments.
+	ContentID string
+
+	// Size is the size of the content in bytes.
+	Size int64
+
+	// EncodedSize is the size in bytes of the base64 encoded content, wrapped
+	// at 76 characters per line as in MIME messages.
+	EncodedSize int64
+
+	// SHA256 is the hex encoded SHA-256 checksum of the content.
+	SHA256 string
+
+	// Verdict is the scanner's verdict, or empty when no scanner is
+	// configured.
+	Verdict ScanVerdict
+}
+
+// attachmentAudit holds the buffered content and records of an email's
+// attachments.
+type attachmentAudit struct {
+	data    [][]byte
+	records []AttachmentRecord
+}
+
+// auditAttachments reads the attachments of an email, recording their size
+// and checksum and scanning them when a scanner is configured. It returns
+// nil for emails without attachments.
+func (c *Client) auditAttachments(ctx context.Context, email *Email) (*attachmentAudit, error) {
+	if !email.HasAttachments() {
+		return nil, nil
+	}
+
+	audit := &attachmentAudit{
+		data:    make([][]byte, len(email.Attachments)),
+		records: make([]AttachmentRecord, len(email.Attachments)),
+	}
+	for i, attachment := range email.Attachments {
+		if attachment.Data == nil {
+			return nil, NewValidationErrorWithValue("attachments", "attachment has no data", attachment.Filename)
+		}
+		data, err := io.ReadAll(attachment.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %s: %w", attachment.Filename, err)
+		}
+
+		record := AttachmentRecord{
+			Filename:    attachment.Filename,
+			ContentType: attachment.DetectContentType(),
+			Disposition: "attachment",
+			Size:        int64(len(data)),
+			EncodedSize: encodedSize(len(data)),
+		}
+		if attachment.Inline {
+			record.Disposition = "inline"
+			record.ContentID = attachment.ContentID
+		}
+		sum := sha256.Sum256(data)
+		record.SHA256 = hex.EncodeToString(sum[:])
+
+		if scanner := c.config.AttachmentScanner; scanner != nil {
+			verdict, err := scanner.Scan(ctx, record.Filename, record.ContentType, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to scan attachment %s: %w", attachment.Filename, err)
+			}
+			if verdict != ScanClean {
+				return nil, NewValidationErrorWithValue("attachments",
+					"attachment failed scan with verdict "+string(verdict), attachment.Filename)
+			}
+			record.Verdict = verdict
+		}
+
+		audit.data[i] = data
+		audit.records[i] = record
+	}
+	return audit, nil
+}
+
+// rewind returns a copy of the email whose attachments read the audited
+// content from the start, so that each send attempt sends the same bytes.
+func (a *attachmentAudit) rewind(email *Email) *Email {
+	if a == nil {
+		return email
+	}
+
+	attachments := make([]Attachment, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		attachment.Data = bytes.NewReader(a.data[i])
+		attachment.Size = int64(len(a.data[i]))
+		attachments[i] = attachment
+	}
+
+	copied := *email
+	copied.Attachments = attachments
+	return &copied
+}
+
+// annotate records the attachments in the result's metadata.
+func (a *attachmentAudit) annotate(result *SendResult) {
+	if a == nil || result == nil {
+		return
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]interface{})
+	}
+	result.Metadata[MetadataAttachments] = a.records
+}
+
+// encodedSize returns the size of n bytes encoded as base64 wrapped at 76
+// characters per line with CRLF line endings.
+func encodedSize(n int) int64 {
+	encoded := base64.StdEncoding.EncodedLen(n)
+	lines := (encoded + 75) / 76
+	return int64(encoded + 2*lines)
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// baggageAttribution returns the configured baggage members present in the
+// context, in configuration order.
+func (c *Client) baggageAttribution(ctx context.Context) []baggage.Member {
+	keys := c.config.Monitoring.BaggageKeys
+	if len(keys) == 0 {
+		return nil
+	}
+
+	bag := baggage.FromContext(ctx)
+	var members []baggage.Member
+	for _, key := range keys {
+		if member := bag.Member(key); member.Key() != "" {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// applyBaggage records the configured baggage members of the context on the
+// current span and in the email's metadata, where providers that support it
+// pass them on as tags or custom arguments. Metadata the email already has
+// takes precedence; the email is returned unchanged when nothing applies.
+func (c *Client) applyBaggage(ctx context.Context, email *Email) *Email {
+	members := c.baggageAttribution(ctx)
+	if len(members) == 0 {
+		return email
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		attrs := make([]attribute.KeyValue, 0, len(members))
+		for _, member := range members {
+			attrs = append(attrs, attribute.String(member.Key(), member.Value()))
+		}
+		span.SetAttributes(attrs...)
+	}
+
+	for _, member := range members {
+		if _, ok := email.Metadata[member.Key()]; !ok {
+			email = email.WithMetadata(member.Key(), member.Value())
+		}
+	}
+	return email
+}
+
+// validateBaggageKeys checks the baggage keys copied into email metadata.
+func validateBaggageKeys(keys []string) error {
+	for _, key := range keys {
+		switch {
+		case key == "":
+			return NewValidationError("monitoring.baggage_keys", "baggage key must not be empty")
+		case strings.HasPrefix(key, "mailer."):
+			return NewValidationErrorWithValue("monitoring.baggage_keys", "baggage key must not use the reserved mailer. prefix", key)
+		}
+	}
+	return nil
+}
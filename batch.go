@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sync"
+)
+
+// Merge fields read from each row by BuildBatchFromTemplate.
+const (
+	// BatchFieldEmail is the row key holding the recipient's email address.
+	BatchFieldEmail = "email"
+
+	// BatchFieldName is the row key holding the recipient's display name (optional).
+	BatchFieldName = "name"
+
+	// BatchFieldSubject is the row key holding the email subject (optional).
+	BatchFieldSubject = "subject"
+)
+
+// batchBufferPool reuses render buffers across BuildBatchFromTemplate calls.
+var batchBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// BuildBatchFromTemplate renders the HTML template tmpl once per row, such as
+// rows read from a CSV file, and returns one email per row addressed to the
+// row's "email" field. The template is parsed once and rendered with the
+// engine's template functions; rows missing a referenced field are errors.
+// The returned emails have no From address, so callers can set it and make
+// other changes before passing them to SendBatch.
+func BuildBatchFromTemplate(tmpl string, rows []map[string]any) ([]*Email, error) {
+	engine := &TemplateEngineImpl{}
+	parsed, err := template.New("batch").
+		Option("missingkey=error").
+		Funcs(engine.getTemplateFuncs()).
+		Parse(tmpl)
+	if err != nil {
+		return nil, NewTemplateError("batch", "parse", "failed to parse template", err)
+	}
+
+	buf := batchBufferPool.Get().(*bytes.Buffer)
+	defer batchBufferPool.Put(buf)
+
+	emails := make([]*Email, 0, len(rows))
+	for i, row := range rows {
+		address, _ := row[BatchFieldEmail].(string)
+		if address == "" {
+			return nil, fmt.Errorf("row %d: %w", i, NewValidationError(BatchFieldEmail, "recipient email is required"))
+		}
+
+		buf.Reset()
+		if err := parsed.Execute(buf, row); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, NewTemplateError("batch", "render", "failed to execute template", err))
+		}
+
+		name, _ := row[BatchFieldName].(string)
+		subject, _ := row[BatchFieldSubject].(string)
+		emails = append(emails, &Email{
+			To:       []Address{{Email: address, Name: name}},
+			Subject:  subject,
+			HTMLBody: buf.String(),
+		})
+	}
+
+	return emails, nil
+}
@@ -0,0 +1,27 @@
+package mailer
+
+import "strings"
+
+// withBounceDomain returns provider settings with domain as their
+// "bounce_domain" setting, or the settings unchanged when domain is empty or
+// they set their own. The caller's settings are not modified.
+func withBounceDomain(settings ProviderSettings, domain string) ProviderSettings {
+	if domain == "" || settings.Get("bounce_domain") != "" {
+		return settings
+	}
+
+	merged := make(ProviderSettings, len(settings)+1)
+	for key, value := range settings {
+		merged[key] = value
+	}
+	merged.Set("bounce_domain", domain)
+	return merged
+}
+
+// validBounceDomain reports whether domain looks like a domain name.
+func validBounceDomain(domain string) bool {
+	if strings.ContainsAny(domain, "@ \t\r\n/") || !strings.Contains(domain, ".") {
+		return false
+	}
+	return !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".") && !strings.Contains(domain, "..")
+}
@@ -0,0 +1,238 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// BulkRequest sends one template to many recipients, each rendered with
+// their own data, with SendBulk.
+type BulkRequest struct {
+	// Template is the name of the template to use.
+	Template string
+
+	// From is the sender's address.
+	From Address
+
+	// Subject is the email subject. If empty, the template should provide it.
+	Subject string
+
+	// Recipients are the recipients and their template data.
+	Recipients []BulkRecipient
+
+	// Options provides additional template rendering options.
+	Options *TemplateOptions
+
+	// Priority indicates the email priority level.
+	Priority Priority
+
+	// Headers contains custom email headers.
+	Headers map[string]string
+
+	// Metadata contains arbitrary data for tracking and analytics.
+	Metadata map[string]interface{}
+}
+
+// BulkRecipient is a recipient of a bulk send and the data the template is
+// rendered with for them.
+type BulkRecipient struct {
+	// Address is the recipient's address.
+	Address Address
+
+	// Data is the recipient's template data.
+	Data map[string]any
+}
+
+// BulkResult reports the outcome of a bulk send for each recipient.
+type BulkResult struct {
+	// Total is the number of recipients.
+	Total int
+
+	// Sent is the number of recipients whose email was accepted.
+	Sent int
+
+	// Failed is the number of recipients whose email was not rendered or
+	// not accepted.
+	Failed int
+
+	// Personalized is the number of recipients whose email shared content
+	// rendered once, personalized by substitution.
+	Personalized int
+
+	// Recipients are the results of the recipients, in request order.
+	Recipients []BulkRecipientResult
+}
+
+// BulkRecipientResult is the outcome of a bulk send for one recipient.
+type BulkRecipientResult struct {
+	// Address is the recipient's address.
+	Address Address
+
+	// Error is why the recipient's email was not sent; nil when it was.
+	Error error
+}
+
+// SendBulk renders the template for each recipient with their data and sends
+// the emails as a batch, reporting the result of each recipient. The
+// template is also rendered once with a %recipient.<key>% token in place of
+// each data value; recipients whose email is that rendering with their
+// values substituted share its content, so that providers can send them in
+// a single request with their own personalization (SendGrid
+// personalizations, Mailgun recipient variables). Others, such as those
+// whose data drives conditionals, are sent individually rendered.
+//
+// Recipients whose email fails to render, is invalid or is not accepted are
+// reported in the result rather than failing the request, whose error is
+// reserved for problems with the request as a whole.
+func (c *Client) SendBulk(ctx context.Context, req *BulkRequest) (*BulkResult, error) {
+	ctx, span := c.tracer.Start(ctx, "mailer.Client.SendBulk")
+	defer span.End()
+
+	if err := c.checkOpen(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	if req == nil {
+		err := NewValidationError("request", "bulk request is required")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return nil, err
+	}
+	if len(req.Recipients) == 0 {
+		err := NewValidationError("recipients", "at least one recipient is required")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.String("mailer.template.name", req.Template),
+		attribute.Int("mailer.bulk.recipients", len(req.Recipients)),
+	)
+
+	result := &BulkResult{
+		Total:      len(req.Recipients),
+		Recipients: make([]BulkRecipientResult, len(req.Recipients)),
+	}
+
+	// Render the shared content, with a token for each data value
+	tokens := make(map[string]any)
+	for _, recipient := range req.Recipients {
+		for key := range recipient.Data {
+			tokens[key] = SubstitutionToken(key)
+		}
+	}
+	base, err := c.renderEmail(ctx, span, bulkTemplateRequest(req, req.Recipients[0].Address, tokens))
+	if err != nil {
+		base = nil
+	}
+
+	// Render each recipient's email, sharing the content where it is the
+	// same, and check it before the batch so that one invalid email does
+	// not fail the others
+	var emails []*Email
+	var indexes []int
+	for i, recipient := range req.Recipients {
+		result.Recipients[i].Address = recipient.Address
+
+		email, err := c.renderEmail(ctx, span, bulkTemplateRequest(req, recipient.Address, recipient.Data))
+		if err == nil {
+			err = email.Validate()
+		}
+		if err != nil {
+			result.Recipients[i].Error = fmt.Errorf("recipient %d: %w", i, err)
+			continue
+		}
+		if shared := personalizedEmail(base, email, recipient); shared != nil {
+			email = shared
+			result.Personalized++
+		}
+
+		emails = append(emails, email)
+		indexes = append(indexes, i)
+	}
+
+	if len(emails) > 0 {
+		err := c.SendBatch(ctx, emails)
+		var batchErr *BatchError
+		switch {
+		case errors.As(err, &batchErr):
+			for _, item := range batchErr.Errors {
+				result.Recipients[indexes[item.Index]].Error = item.Error
+			}
+		case err != nil:
+			for _, i := range indexes {
+				result.Recipients[i].Error = err
+			}
+		}
+	}
+
+	for _, recipient := range result.Recipients {
+		if recipient.Error != nil {
+			result.Failed++
+		} else {
+			result.Sent++
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("mailer.bulk.sent", result.Sent),
+		attribute.Int("mailer.bulk.failed", result.Failed),
+		attribute.Int("mailer.bulk.personalized", result.Personalized),
+	)
+	if result.Failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d/%d recipients failed", result.Failed, result.Total))
+	} else {
+		span.SetStatus(codes.Ok, "bulk send completed successfully")
+	}
+
+	return result, nil
+}
+
+// bulkTemplateRequest returns the template request rendering the bulk
+// request's template for the recipient at address with data.
+func bulkTemplateRequest(req *BulkRequest, address Address, data map[string]any) *TemplateRequest {
+	return &TemplateRequest{
+		Template: req.Template,
+		To:       []Address{address},
+		From:     req.From,
+		Subject:  req.Subject,
+		Data:     data,
+		Options:  req.Options,
+		Priority: req.Priority,
+		Headers:  req.Headers,
+		Metadata: req.Metadata,
+	}
+}
+
+// personalizedEmail returns the shared base email personalized for the
+// recipient, if substituting their data into it gives their rendered email,
+// and nil otherwise.
+func personalizedEmail(base, rendered *Email, recipient BulkRecipient) *Email {
+	if base == nil {
+		return nil
+	}
+
+	variables := make(map[string]string, len(recipient.Data))
+	for key, value := range recipient.Data {
+		switch value.(type) {
+		case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			variables[key] = fmt.Sprint(value)
+		default:
+			return nil
+		}
+	}
+
+	email := Personalize(base, []Recipient{{Address: recipient.Address, Variables: variables}})[0]
+	substituted := email.WithSubstitutions()
+	if substituted.Subject != rendered.Subject || substituted.HTMLBody != rendered.HTMLBody || substituted.TextBody != rendered.TextBody {
+		return nil
+	}
+	return email
+}
@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Decryptor decrypts sealed configuration bundles, e.g. with age or AWS KMS.
+type Decryptor interface {
+	// Decrypt returns the plaintext of ciphertext.
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// DecryptorFunc adapts a function to the Decryptor interface.
+type DecryptorFunc func(ctx context.Context, ciphertext []byte) ([]byte, error)
+
+// Decrypt calls f.
+func (f DecryptorFunc) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return f(ctx, ciphertext)
+}
+
+// ConfigBundle is the provider credentials and routing configuration
+// shipped as an encrypted JSON artifact and applied with WithConfigBundle.
+// Fields left empty keep the configuration's values.
+type ConfigBundle struct {
+	// Provider is the type of the primary provider.
+	Provider ProviderType `json:"provider"`
+
+	// Primary contains settings for the primary provider.
+	Primary ProviderSettings `json:"primary"`
+
+	// Fallback contains settings for the fallback provider, including its
+	// "type".
+	Fallback ProviderSettings `json:"fallback,omitempty"`
+
+	// FailoverErrorRate is the error rate at which the primary provider is
+	// considered unhealthy; see ProviderConfig.FailoverErrorRate.
+	FailoverErrorRate float64 `json:"failover_error_rate,omitempty"`
+
+	// IPPools maps email categories to IP pools, overriding the pools of
+	// the same categories in the configuration.
+	IPPools map[string]string `json:"ip_pools,omitempty"`
+
+	// BounceDomain is the dedicated domain for bounces; see
+	// Config.BounceDomain.
+	BounceDomain string `json:"bounce_domain,omitempty"`
+}
+
+// LoadConfigBundle reads the encrypted bundle at path and decrypts it with
+// decryptor. Unknown fields are rejected, so that a misspelled setting is
+// not silently ignored.
+func LoadConfigBundle(ctx context.Context, path string, decryptor Decryptor) (*ConfigBundle, error) {
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config bundle: %w", err)
+	}
+	return ParseConfigBundle(ctx, ciphertext, decryptor)
+}
+
+// ParseConfigBundle decrypts and parses an encrypted bundle, such as one
+// fetched from a secrets store.
+func ParseConfigBundle(ctx context.Context, ciphertext []byte, decryptor Decryptor) (*ConfigBundle, error) {
+	if decryptor == nil {
+		return nil, NewValidationError("decryptor", "a decryptor is required for config bundles")
+	}
+
+	plaintext, err := decryptor.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt config bundle: %w", err)
+	}
+	// Don't leave credentials in memory longer than needed
+	defer clear(plaintext)
+
+	decoder := json.NewDecoder(bytes.NewReader(plaintext))
+	decoder.DisallowUnknownFields()
+	var bundle ConfigBundle
+	if err := decoder.Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse config bundle: %w", err)
+	}
+
+	if bundle.Provider != "" && !bundle.Provider.Valid() {
+		return nil, NewValidationErrorWithValue("bundle.provider", "invalid or unsupported provider type", bundle.Provider)
+	}
+	if bundle.Fallback != nil && !ProviderType(bundle.Fallback.Get("type")).Valid() {
+		return nil, NewValidationErrorWithValue("bundle.fallback.type", "invalid or unsupported provider type", bundle.Fallback.Get("type"))
+	}
+
+	return &bundle, nil
+}
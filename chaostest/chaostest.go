@@ -0,0 +1,274 @@
+// Package chaostest runs a client against fault-injection providers that
+// follow scripted outages, such as flapping server errors, sustained rate
+// limiting or slow responses, and reports how the client's retry, circuit
+// breaker and failover configuration coped, so it can be validated before it
+// meets a real outage:
+//
+//	func TestReliability(t *testing.T) {
+//		report, err := chaostest.Run(context.Background(), chaostest.Scenario{
+//			Primary:  []chaostest.Phase{chaostest.Flapping(time.Second, 0.5), chaostest.RateLimited(time.Second)},
+//			Sends:    200,
+//			Interval: 10 * time.Millisecond,
+//		},
+//			mailer.WithRetry(3, 10*time.Millisecond, 100*time.Millisecond, 2),
+//			mailer.WithCircuitBreaker(5, 2, 500*time.Millisecond),
+//		)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		if err := report.Check(chaostest.Expectations{
+//			MinSuccessRate: 1,
+//			MaxLatency:     time.Second,
+//			Failover:       true,
+//		}); err != nil {
+//			t.Error(err)
+//		}
+//	}
+//
+// Scenarios run in real time, so keep their phases short.
+package chaostest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+// Scenario scripts the providers of a run and the sends made through them.
+type Scenario struct {
+	// Primary is the script of the primary provider.
+	Primary []Phase
+
+	// Fallback is the script of the fallback provider, which is healthy
+	// when empty.
+	Fallback []Phase
+
+	// Sends is the number of emails sent (default: 100).
+	Sends int
+
+	// Concurrency is the number of sends in progress at once (default: 10).
+	Concurrency int
+
+	// Interval is the time between the start of consecutive sends, which
+	// spreads them over the phases. Zero starts them as fast as the
+	// concurrency allows.
+	Interval time.Duration
+}
+
+// Report is the aggregate outcome of a run.
+type Report struct {
+	// Sends is the number of emails sent.
+	Sends int
+
+	// Succeeded is the number of sends that returned no error.
+	Succeeded int
+
+	// Failed is the number of sends that returned an error.
+	Failed int
+
+	// Duplicates is the number of sends delivered more than once, e.g.
+	// retried after timing out on a slow response.
+	Duplicates int
+
+	// Unrecovered is the number of sends that failed on the primary and
+	// were not delivered by a retry or the fallback.
+	Unrecovered int
+
+	// Deliveries is the number of emails each provider accepted, by name.
+	Deliveries map[string]int
+
+	// LatencyP50, LatencyP95 and LatencyMax are percentiles of the time
+	// Send took, including retries and failover.
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyMax time.Duration
+
+	// Errors are the distinct errors returned by failed sends, with their
+	// counts.
+	Errors map[string]int
+}
+
+// SuccessRate returns the fraction of sends that succeeded.
+func (r *Report) SuccessRate() float64 {
+	if r.Sends == 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / float64(r.Sends)
+}
+
+// Expectations are the properties a run must have.
+type Expectations struct {
+	// MinSuccessRate is the minimum fraction of sends that must succeed.
+	MinSuccessRate float64
+
+	// MaxLatency bounds the time any send may take. Zero disables the check.
+	MaxLatency time.Duration
+
+	// MaxLatencyP95 bounds the 95th percentile send time. Zero disables
+	// the check.
+	MaxLatencyP95 time.Duration
+
+	// AllowDuplicates accepts sends delivered more than once.
+	AllowDuplicates bool
+
+	// Failover requires every send that failed on the primary to be
+	// delivered by a retry or the fallback.
+	Failover bool
+}
+
+// Check returns an error describing every expectation the run does not
+// meet, or nil.
+func (r *Report) Check(e Expectations) error {
+	var errs []error
+	if rate := r.SuccessRate(); rate < e.MinSuccessRate {
+		errs = append(errs, fmt.Errorf("success rate %.3f is below %.3f (%d of %d sends failed)", rate, e.MinSuccessRate, r.Failed, r.Sends))
+	}
+	if e.MaxLatency > 0 && r.LatencyMax > e.MaxLatency {
+		errs = append(errs, fmt.Errorf("slowest send took %v, over %v", r.LatencyMax, e.MaxLatency))
+	}
+	if e.MaxLatencyP95 > 0 && r.LatencyP95 > e.MaxLatencyP95 {
+		errs = append(errs, fmt.Errorf("p95 send latency is %v, over %v", r.LatencyP95, e.MaxLatencyP95))
+	}
+	if !e.AllowDuplicates && r.Duplicates > 0 {
+		errs = append(errs, fmt.Errorf("%d sends were delivered more than once", r.Duplicates))
+	}
+	if e.Failover && r.Unrecovered > 0 {
+		errs = append(errs, fmt.Errorf("%d sends failed on the primary and were not delivered", r.Unrecovered))
+	}
+	return errors.Join(errs...)
+}
+
+// Run sends the scenario's emails through a client configured with opts,
+// such as retry, circuit breaker and rate limit options, and with
+// fault-injection primary and fallback providers following the scenario's
+// scripts, and reports the outcome.
+func Run(ctx context.Context, scenario Scenario, opts ...mailer.Option) (*Report, error) {
+	if scenario.Sends <= 0 {
+		scenario.Sends = 100
+	}
+	if scenario.Concurrency <= 0 {
+		scenario.Concurrency = 10
+	}
+
+	primary := NewProvider("primary", scenario.Primary...)
+	defer primary.Close()
+	fallback := NewProvider("fallback", scenario.Fallback...)
+	defer fallback.Close()
+
+	opts = append([]mailer.Option{mailer.WithLogging("error", "text", "stderr")}, opts...)
+	opts = append(opts,
+		mailer.WithProvider(ProviderType, primary.Settings()),
+		mailer.WithFallbackProvider(ProviderType, fallback.Settings()),
+	)
+	client, err := mailer.New(mailer.DefaultConfig(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	latencies := make([]time.Duration, scenario.Sends)
+	errs := make([]error, scenario.Sends)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, scenario.Concurrency)
+	var ticker *time.Ticker
+	if scenario.Interval > 0 {
+		ticker = time.NewTicker(scenario.Interval)
+		defer ticker.Stop()
+	}
+
+sends:
+	for i := range scenario.Sends {
+		if ticker != nil && i > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				break sends
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break sends
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			errs[i] = client.Send(ctx, scenarioEmail(i))
+			latencies[i] = time.Since(start)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return report(latencies, errs, primary, fallback), nil
+}
+
+// scenarioEmail returns the i-th email of a run.
+func scenarioEmail(i int) *mailer.Email {
+	send := strconv.Itoa(i)
+	return &mailer.Email{
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: "recipient-" + send + "@example.com"}},
+		Subject:  "chaostest " + send,
+		TextBody: "chaostest",
+		Headers:  map[string]string{HeaderSend: send},
+	}
+}
+
+// report aggregates the outcome of the sends of a run.
+func report(latencies []time.Duration, errs []error, primary, fallback *Provider) *Report {
+	r := &Report{
+		Sends:      len(errs),
+		Deliveries: make(map[string]int),
+		Errors:     make(map[string]int),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			r.Failed++
+			r.Errors[err.Error()]++
+		} else {
+			r.Succeeded++
+		}
+	}
+
+	delivered := make(map[string]int)
+	for _, provider := range []*Provider{primary, fallback} {
+		for send, n := range provider.Deliveries() {
+			delivered[send] += n
+			r.Deliveries[provider.Name()] += n
+		}
+	}
+	for _, n := range delivered {
+		if n > 1 {
+			r.Duplicates++
+		}
+	}
+	for send := range primary.Failures() {
+		if delivered[send] == 0 {
+			r.Unrecovered++
+		}
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	if len(sorted) > 0 {
+		r.LatencyP50 = sorted[len(sorted)*50/100]
+		r.LatencyP95 = sorted[min(len(sorted)*95/100, len(sorted)-1)]
+		r.LatencyMax = sorted[len(sorted)-1]
+	}
+	return r
+}
@@ -0,0 +1,127 @@
+package chaostest_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/chaostest"
+)
+
+func chaosEmail(send int) *mailer.Email {
+	return &mailer.Email{
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: "recipient@example.com"}},
+		Subject:  "Hello",
+		TextBody: "Hello",
+		Headers:  map[string]string{chaostest.HeaderSend: strconv.Itoa(send)},
+	}
+}
+
+func TestProviderFailsEvenlyAtErrorRate(t *testing.T) {
+	provider := chaostest.NewProvider("primary", chaostest.Flapping(time.Hour, 0.5))
+	t.Cleanup(provider.Close)
+
+	for send := range 4 {
+		_, err := provider.Send(context.Background(), chaosEmail(send))
+		if failed := send%2 == 1; (err != nil) != failed {
+			t.Errorf("send %d error = %v, want failure %v", send, err, failed)
+		}
+		if err != nil && !mailer.IsRetryable(err) {
+			t.Errorf("send %d error %v is not retryable", send, err)
+		}
+	}
+	if got := len(provider.Deliveries()); got != 2 {
+		t.Errorf("delivered %d sends, want 2", got)
+	}
+	if got := len(provider.Failures()); got != 2 {
+		t.Errorf("failed %d sends, want 2", got)
+	}
+}
+
+func TestProviderRateLimitsWith429(t *testing.T) {
+	provider := chaostest.NewProvider("primary", chaostest.RateLimited(time.Hour))
+	t.Cleanup(provider.Close)
+
+	_, err := provider.Send(context.Background(), chaosEmail(0))
+	var providerErr *mailer.ProviderError
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != 429 {
+		t.Fatalf("Send error = %v, want a provider error with status 429", err)
+	}
+	if !mailer.IsRetryable(err) {
+		t.Errorf("rate limit error %v is not retryable", err)
+	}
+}
+
+func TestRunRecoversFromFlappingPrimary(t *testing.T) {
+	report, err := chaostest.Run(context.Background(), chaostest.Scenario{
+		Primary: []chaostest.Phase{chaostest.Flapping(time.Minute, 0.5)},
+		Sends:   20,
+	},
+		mailer.WithRetry(3, time.Millisecond, 5*time.Millisecond, 2),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if err := report.Check(chaostest.Expectations{MinSuccessRate: 1, Failover: true}); err != nil {
+		t.Error(err)
+	}
+	if report.Sends != 20 || report.Succeeded != 20 {
+		t.Errorf("report = %+v, want 20 successful sends", report)
+	}
+}
+
+func TestRunFailsOverDuringOutage(t *testing.T) {
+	report, err := chaostest.Run(context.Background(), chaostest.Scenario{
+		Primary: []chaostest.Phase{chaostest.Flapping(time.Minute, 1)},
+		Sends:   10,
+	},
+		mailer.WithRetry(2, time.Millisecond, 5*time.Millisecond, 2),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if err := report.Check(chaostest.Expectations{MinSuccessRate: 1, Failover: true}); err != nil {
+		t.Error(err)
+	}
+	if report.Deliveries["fallback"] != 10 || report.Deliveries["primary"] != 0 {
+		t.Errorf("deliveries = %v, want all 10 by the fallback", report.Deliveries)
+	}
+}
+
+func TestReportCheckListsEveryUnmetExpectation(t *testing.T) {
+	report := &chaostest.Report{
+		Sends:       10,
+		Succeeded:   8,
+		Failed:      2,
+		Duplicates:  1,
+		Unrecovered: 2,
+		LatencyP95:  2 * time.Second,
+		LatencyMax:  3 * time.Second,
+	}
+
+	err := report.Check(chaostest.Expectations{
+		MinSuccessRate: 0.9,
+		MaxLatency:     time.Second,
+		MaxLatencyP95:  time.Second,
+		Failover:       true,
+	})
+	if err == nil {
+		t.Fatal("Check passed a run that meets no expectation")
+	}
+	for _, want := range []string{"success rate", "slowest send", "p95", "more than once", "not delivered"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Check error %q does not mention %q", err, want)
+		}
+	}
+
+	if err := report.Check(chaostest.Expectations{MinSuccessRate: 0.8, AllowDuplicates: true}); err != nil {
+		t.Errorf("Check = %v, want nil", err)
+	}
+}
@@ -0,0 +1,269 @@
+package chaostest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+// ProviderType is the provider type of fault-injection providers, configured
+// with the settings returned by Provider.Settings.
+const ProviderType mailer.ProviderType = "chaostest"
+
+// HeaderSend is the header identifying a send, used to count the deliveries
+// of each send across retries and providers. Emails without it are sent but
+// not counted.
+const HeaderSend = "X-Chaos-Send"
+
+func init() {
+	mailer.RegisterProvider(ProviderType, providerFromSettings)
+}
+
+// Phase is a period of a provider's scripted behavior.
+type Phase struct {
+	// Duration is how long the phase lasts.
+	Duration time.Duration
+
+	// ErrorRate is the fraction of sends that fail, spread evenly over the
+	// phase: 0.5 fails every other send.
+	ErrorRate float64
+
+	// StatusCode is the HTTP status of the failures: 429 fails with a
+	// retryable rate limit error, anything else with a temporary server
+	// error (default: 503).
+	StatusCode int
+
+	// Latency delays each response. The email is accepted before the delay,
+	// as when a provider's response is lost to a client timeout, so a send
+	// retried after timing out is delivered twice.
+	Latency time.Duration
+}
+
+// Healthy returns a phase in which every send succeeds.
+func Healthy(d time.Duration) Phase {
+	return Phase{Duration: d}
+}
+
+// Flapping returns a phase in which the given fraction of sends fail with
+// a 503 server error.
+func Flapping(d time.Duration, errorRate float64) Phase {
+	return Phase{Duration: d, ErrorRate: errorRate, StatusCode: 503}
+}
+
+// RateLimited returns a phase in which every send is rate limited with 429.
+func RateLimited(d time.Duration) Phase {
+	return Phase{Duration: d, ErrorRate: 1, StatusCode: 429}
+}
+
+// Slow returns a phase in which every response is delayed by latency.
+func Slow(d, latency time.Duration) Phase {
+	return Phase{Duration: d, Latency: latency}
+}
+
+// providers holds the fault-injection providers by ID, for the provider
+// factory to find from their settings.
+var (
+	providers sync.Map // string -> *Provider
+	nextID    atomic.Int64
+)
+
+// Provider is a fault-injection provider that follows a script of phases,
+// starting with the first send. It is healthy once the script has ended.
+// Emails are never delivered anywhere; accepted sends are only counted.
+type Provider struct {
+	name   string
+	id     string
+	phases []Phase
+
+	mu         sync.Mutex
+	start      time.Time
+	phase      int
+	owed       float64
+	accepted   int
+	deliveries map[string]int
+	failures   map[string]int
+}
+
+// NewProvider returns a fault-injection provider with the given name that
+// follows phases. Configure a client with it using Settings, and call Close
+// once the client is closed.
+func NewProvider(name string, phases ...Phase) *Provider {
+	p := &Provider{
+		name:       name,
+		id:         strconv.FormatInt(nextID.Add(1), 10),
+		phases:     phases,
+		deliveries: make(map[string]int),
+		failures:   make(map[string]int),
+	}
+	providers.Store(p.id, p)
+	return p
+}
+
+// providerFromSettings returns the provider the settings were created for.
+func providerFromSettings(settings mailer.ProviderSettings) (mailer.Provider, error) {
+	p, ok := providers.Load(settings.Get("id"))
+	if !ok {
+		return nil, fmt.Errorf("chaostest: no provider with id %q; use Provider.Settings", settings.Get("id"))
+	}
+	return p.(*Provider), nil
+}
+
+// Settings returns the settings configuring a client with the provider,
+// with ProviderType:
+//
+//	mailer.WithProvider(chaostest.ProviderType, provider.Settings())
+func (p *Provider) Settings() mailer.ProviderSettings {
+	return mailer.ProviderSettings{"id": p.id, "name": p.name}
+}
+
+// Close releases the provider, after which clients can no longer be
+// configured with its settings.
+func (p *Provider) Close() {
+	providers.Delete(p.id)
+}
+
+// Name implements mailer.Provider.
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// ValidateConfig implements mailer.Provider.
+func (p *Provider) ValidateConfig() error {
+	return nil
+}
+
+// Send implements mailer.Provider, failing, delaying or accepting the email
+// as the current phase dictates.
+func (p *Provider) Send(ctx context.Context, email *mailer.Email) (*mailer.SendResult, error) {
+	send := email.Headers[HeaderSend]
+
+	p.mu.Lock()
+	phase := p.current(time.Now())
+	if p.fails(phase) {
+		if send != "" {
+			p.failures[send]++
+		}
+		p.mu.Unlock()
+		return nil, p.failure(phase)
+	}
+	p.accepted++
+	if send != "" {
+		p.deliveries[send]++
+	}
+	result := &mailer.SendResult{
+		MessageID:     p.name + "-" + strconv.Itoa(p.accepted),
+		Provider:      p.name,
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+	}
+	p.mu.Unlock()
+
+	if phase.Latency > 0 {
+		timer := time.NewTimer(phase.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			err := mailer.NewTemporaryProviderError(p.name, "timeout", "response not received")
+			err.Cause = ctx.Err()
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// SendBatch implements mailer.Provider by sending the emails one by one.
+func (p *Provider) SendBatch(ctx context.Context, emails []*mailer.Email) (*mailer.BatchResult, error) {
+	result := &mailer.BatchResult{Total: len(emails), Provider: p.name}
+	for i, email := range emails {
+		sent, err := p.Send(ctx, email)
+		if err != nil {
+			result.Failed = append(result.Failed, mailer.BatchFailure{Index: i, Email: email, Error: err})
+			continue
+		}
+		result.Successful = append(result.Successful, sent)
+	}
+	return result, nil
+}
+
+// Deliveries returns the number of times each send, by its HeaderSend
+// value, was accepted.
+func (p *Provider) Deliveries() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deliveries := make(map[string]int, len(p.deliveries))
+	for send, n := range p.deliveries {
+		deliveries[send] = n
+	}
+	return deliveries
+}
+
+// Failures returns the number of times each send, by its HeaderSend value,
+// was failed.
+func (p *Provider) Failures() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	failures := make(map[string]int, len(p.failures))
+	for send, n := range p.failures {
+		failures[send] = n
+	}
+	return failures
+}
+
+// current returns the phase in effect at now, starting the script on the
+// first send; p.mu must be held.
+func (p *Provider) current(now time.Time) Phase {
+	if p.start.IsZero() {
+		p.start = now
+	}
+
+	elapsed := now.Sub(p.start)
+	for i, phase := range p.phases {
+		if elapsed < phase.Duration {
+			if i != p.phase {
+				p.phase, p.owed = i, 0
+			}
+			return phase
+		}
+		elapsed -= phase.Duration
+	}
+	return Phase{}
+}
+
+// fails reports whether the next send of the phase fails, failing sends
+// evenly at the phase's error rate; p.mu must be held.
+func (p *Provider) fails(phase Phase) bool {
+	if phase.ErrorRate <= 0 {
+		return false
+	}
+	p.owed += phase.ErrorRate
+	if p.owed < 1 {
+		return false
+	}
+	p.owed--
+	return true
+}
+
+// failure returns the error of a failed send in the phase.
+func (p *Provider) failure(phase Phase) error {
+	if phase.StatusCode == 429 {
+		err := mailer.NewRetryableProviderError(p.name, "rate_limited", "injected rate limit")
+		err.StatusCode = 429
+		return err
+	}
+
+	status := phase.StatusCode
+	if status == 0 {
+		status = 503
+	}
+	err := mailer.NewTemporaryProviderError(p.name, "server_error", "injected server error")
+	err.StatusCode = status
+	return err
+}
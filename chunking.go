@@ -0,0 +1,317 @@
+package mailer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for adaptive batching.
+const (
+	defaultMinChunkSize   = 10
+	defaultMaxChunkSize   = 1000
+	defaultMaxConcurrency = 4
+)
+
+// BatchConfig configures how SendBatch splits batches into chunks, each sent
+// to the provider in its own call through the retry, circuit breaker and
+// failover pipeline. By default batches are sent whole.
+type BatchConfig struct {
+	// ChunkSize is the number of emails per chunk, or the initial number
+	// when Adaptive is set. Zero sends batches whole unless Adaptive is set.
+	ChunkSize int
+
+	// Concurrency is the number of chunks sent at once, or the initial
+	// number when Adaptive is set (default: 1).
+	Concurrency int
+
+	// Adaptive grows and shrinks the chunk size and concurrency from the
+	// observed latency and errors of each chunk, learning across batches
+	// the largest load the provider accepts without throttling.
+	Adaptive bool
+
+	// MinChunkSize and MaxChunkSize bound the adaptive chunk size
+	// (default: 10 and 1000).
+	MinChunkSize int
+	MaxChunkSize int
+
+	// MaxConcurrency bounds the adaptive concurrency (default: 4).
+	MaxConcurrency int
+
+	// TargetLatency is the chunk latency adaptive batching aims to stay
+	// under (default: half the provider timeout).
+	TargetLatency time.Duration
+}
+
+// enabled reports whether batches are split into chunks.
+func (b BatchConfig) enabled() bool {
+	return b.ChunkSize > 0 || b.Adaptive
+}
+
+// validate checks the batch configuration.
+func (b BatchConfig) validate() error {
+	switch {
+	case b.ChunkSize < 0:
+		return NewValidationErrorWithValue("batch.chunk_size", "chunk size must not be negative", b.ChunkSize)
+	case b.Concurrency < 0:
+		return NewValidationErrorWithValue("batch.concurrency", "concurrency must not be negative", b.Concurrency)
+	case b.MinChunkSize < 0 || b.MaxChunkSize < 0:
+		return NewValidationError("batch.min_chunk_size", "chunk size bounds must not be negative")
+	case b.MaxChunkSize > 0 && b.MinChunkSize > b.MaxChunkSize:
+		return NewValidationError("batch.min_chunk_size", "minimum chunk size must not exceed the maximum")
+	case b.MaxConcurrency < 0:
+		return NewValidationErrorWithValue("batch.max_concurrency", "max concurrency must not be negative", b.MaxConcurrency)
+	case b.TargetLatency < 0:
+		return NewValidationErrorWithValue("batch.target_latency", "target latency must not be negative", b.TargetLatency)
+	}
+	return nil
+}
+
+// chunkController holds the current chunk size and concurrency, adjusted
+// after each chunk when batching is adaptive: additive growth while chunks
+// are fast and clean, multiplicative decrease on throttling or errors.
+type chunkController struct {
+	mu          sync.Mutex
+	initialized bool
+	size        int
+	concurrency int
+}
+
+// current returns the chunk size and concurrency to use, initializing them
+// from the configuration on first use.
+func (cc *chunkController) current(config BatchConfig) (size, concurrency int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.initialized {
+		cc.size = config.ChunkSize
+		cc.concurrency = max(config.Concurrency, 1)
+		if config.Adaptive {
+			minSize, maxSize, maxConcurrency := adaptiveBounds(config)
+			if cc.size == 0 {
+				cc.size = minSize
+			}
+			cc.size = min(max(cc.size, minSize), maxSize)
+			cc.concurrency = min(cc.concurrency, maxConcurrency)
+		}
+		cc.initialized = true
+	}
+	return cc.size, cc.concurrency
+}
+
+// observe adjusts the chunk size and concurrency after a chunk of size
+// emails took latency, of which retryable were rejected for retryable
+// reasons such as throttling. err is the error that failed the whole chunk,
+// if any.
+func (cc *chunkController) observe(config BatchConfig, target time.Duration, size int, latency time.Duration, retryable int, err error) {
+	if !config.Adaptive {
+		return
+	}
+	minSize, maxSize, maxConcurrency := adaptiveBounds(config)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	switch {
+	case err != nil || retryable > 0:
+		// Throttled or failing: back off both dimensions
+		cc.size = max(cc.size/2, minSize)
+		cc.concurrency = max(cc.concurrency/2, 1)
+	case latency > target:
+		// Slow: shrink chunks, which the provider takes longer to accept
+		cc.size = max(cc.size*3/4, minSize)
+	case size < cc.size:
+		// The chunk was the short tail of a batch and says little about
+		// larger chunks
+	case cc.size < maxSize:
+		cc.size = min(cc.size+max(cc.size/4, 1), maxSize)
+	default:
+		cc.concurrency = min(cc.concurrency+1, maxConcurrency)
+	}
+}
+
+// adaptiveBounds returns the configured adaptive bounds or their defaults.
+func adaptiveBounds(config BatchConfig) (minSize, maxSize, maxConcurrency int) {
+	minSize, maxSize, maxConcurrency = config.MinChunkSize, config.MaxChunkSize, config.MaxConcurrency
+	if minSize == 0 {
+		minSize = defaultMinChunkSize
+	}
+	if maxSize == 0 {
+		maxSize = max(defaultMaxChunkSize, minSize)
+	}
+	if maxConcurrency == 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+	return minSize, maxSize, maxConcurrency
+}
+
+// sendChunks sends the emails of a batch through the reliability pipeline,
+// whole or in chunks as configured, rewinding their attachments with audits
+// for each attempt. Emails of a chunk over the allowance of a rate limit rule
+// keyed by the provider it is sent through are reported as failed items, as
+// are emails of a chunk that failed as a whole; the error is returned only
+// when every chunk failed, so that a batch sent whole fails as before.
+func (c *Client) sendChunks(ctx context.Context, forced Provider, emails []*Email, audits []*attachmentAudit) (*BatchResult, error) {
+	send := func(chunk []*Email, audits []*attachmentAudit) (*BatchResult, error) {
+		// The emails of the chunk let through the provider of each attempt,
+		// with their index in the chunk, and those over its rules
+		var (
+			attempt  []*Email
+			indexes  []int
+			rejected []BatchFailure
+		)
+		charged := make(map[string][]bool)
+		admit := func(provider Provider) error {
+			attempt, indexes, rejected = nil, nil, nil
+			allowed, ok := charged[provider.Name()]
+			if !ok {
+				allowed = make([]bool, len(chunk))
+				charged[provider.Name()] = allowed
+			}
+			for i, email := range chunk {
+				if !allowed[i] {
+					if err := c.rateRules.allowProvider(email, provider.Name()); err != nil {
+						rejected = append(rejected, BatchFailure{Index: i, Email: email, Error: err})
+						continue
+					}
+					allowed[i] = true
+				}
+				attempt = append(attempt, audits[i].rewind(email))
+				indexes = append(indexes, i)
+			}
+			if len(attempt) == 0 {
+				return rejected[0].Error
+			}
+			return nil
+		}
+
+		var result *BatchResult
+		err := c.execute(ctx, func() error {
+			return c.withFailover(forced, nil, admit, func(provider Provider) error {
+				var sendErr error
+				result, sendErr = c.sendBatchWithProvider(ctx, attempt, provider)
+				return sendErr
+			})
+		})
+		if err != nil {
+			if len(attempt) == 0 && len(rejected) > 0 {
+				return &BatchResult{Total: len(chunk), Failed: rejected}, nil
+			}
+			return nil, err
+		}
+
+		for i := range result.Failed {
+			result.Failed[i].Index = indexes[result.Failed[i].Index]
+		}
+		if len(rejected) > 0 {
+			result.Failed = append(result.Failed, rejected...)
+			sort.Slice(result.Failed, func(i, j int) bool {
+				return result.Failed[i].Index < result.Failed[j].Index
+			})
+		}
+		result.Total = len(chunk)
+		return result, nil
+	}
+
+	config := c.config.Batch
+	if !config.enabled() {
+		return send(emails, audits)
+	}
+
+	target := config.TargetLatency
+	if target == 0 {
+		target = c.config.Provider.Timeout / 2
+	}
+
+	result := &BatchResult{Total: len(emails)}
+	var (
+		mu       sync.Mutex
+		cond     = sync.NewCond(&mu)
+		inflight int
+		wg       sync.WaitGroup
+		chunks   int
+		failures int
+		firstErr error
+	)
+
+	for start := 0; start < len(emails); {
+		size, concurrency := c.chunks.current(config)
+
+		mu.Lock()
+		for inflight >= concurrency {
+			cond.Wait()
+			_, concurrency = c.chunks.current(config)
+		}
+		inflight++
+		chunks++
+		mu.Unlock()
+
+		end := min(start+size, len(emails))
+		wg.Add(1)
+		go func(offset int, chunk []*Email, audits []*attachmentAudit) {
+			defer wg.Done()
+
+			began := c.clock.Now()
+			chunkResult, err := send(chunk, audits)
+			latency := c.clock.Now().Sub(began)
+
+			retryable := 0
+			if chunkResult != nil {
+				for _, failure := range chunkResult.Failed {
+					if IsRetryable(failure.Error) {
+						retryable++
+					}
+				}
+			}
+			c.chunks.observe(config, target, len(chunk), latency, retryable, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			inflight--
+			cond.Broadcast()
+
+			if err != nil {
+				failures++
+				if firstErr == nil {
+					firstErr = err
+				}
+				for i, email := range chunk {
+					result.Failed = append(result.Failed, BatchFailure{Index: offset + i, Email: email, Error: err})
+				}
+				return
+			}
+			if result.Provider == "" {
+				result.Provider = chunkResult.Provider
+			}
+			result.Successful = append(result.Successful, chunkResult.Successful...)
+			for _, failure := range chunkResult.Failed {
+				failure.Index += offset
+				result.Failed = append(result.Failed, failure)
+			}
+		}(start, emails[start:end], audits[start:end])
+		start = end
+	}
+	wg.Wait()
+
+	sort.Slice(result.Failed, func(i, j int) bool {
+		return result.Failed[i].Index < result.Failed[j].Index
+	})
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		size, concurrency := c.chunks.current(config)
+		span.SetAttributes(
+			attribute.Int("mailer.batch.chunks", chunks),
+			attribute.Int("mailer.batch.chunk_size", size),
+			attribute.Int("mailer.batch.concurrency", concurrency),
+		)
+	}
+
+	if failures == chunks {
+		return nil, firstErr
+	}
+	return result, nil
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/lattiq/mailer/internal/core"
 	"github.com/lattiq/mailer/internal/providers/mailgun"
+	"github.com/lattiq/mailer/internal/providers/postmark"
 	"github.com/lattiq/mailer/internal/providers/sendgrid"
 	"github.com/lattiq/mailer/internal/providers/ses"
 	"github.com/lattiq/mailer/internal/providers/smtp"
-	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -35,6 +38,9 @@ type (
 	Attachment       = core.Attachment
 	TemplateRequest  = core.TemplateRequest
 	TemplateOptions  = core.TemplateOptions
+	RequestSigner    = core.RequestSigner
+	Unsubscribe      = core.Unsubscribe
+	Tracking         = core.Tracking
 )
 
 // Priority constants
@@ -45,6 +51,11 @@ const (
 	PriorityUrgent = core.PriorityUrgent
 )
 
+// MetadataSESTemplate is the Email.Metadata key naming a stored SES template
+// to render the email from. SES renders the subject and bodies, which may be
+// left empty, using the email's Substitutions as template data.
+const MetadataSESTemplate = core.MetadataSESTemplate
+
 // Error constructor functions
 var (
 	NewValidationError          = core.NewValidationError
@@ -60,16 +71,37 @@ var (
 // Client implements the Mailer interface and provides email sending capabilities.
 // All methods are safe for concurrent use.
 type Client struct {
-	config         Config
-	provider       Provider
-	fallback       Provider
-	templateEng    TemplateEngine
-	retryManager   *RetryManager
-	rateLimiter    *RateLimiter
-	circuitBreaker *CircuitBreaker
-	tracer         trace.Tracer
-	mu             sync.RWMutex
-	closed         bool
+	config       Config
+	provider     Provider
+	fallback     Provider
+	routes       []Provider
+	routing      router
+	templateEng  TemplateEngine
+	retryManager *RetryManager
+	rateLimiter  *RateLimiter
+	rateRules    *ruleLimiter
+	idempotency  *idempotency
+	breakers     *breakers
+	stats        *rollingStats
+	metrics      *metrics
+	slo          sloTracker
+	inflight     inflightSends
+	pauses       pauses
+	chunks       chunkController
+	postMortems  postMortemWriter
+	active       activeSends
+	sendChain    SendFunc
+	typedChecks  sync.Map     // typedCheck -> typedCheckResult
+	providerMu   sync.RWMutex // guards the providers and their settings in config.Provider
+	rotateMu     sync.Mutex
+	clock        Clock
+	logger       *slog.Logger
+	logCloser    io.Closer
+	tracer       trace.Tracer
+	mu           sync.RWMutex
+	closed       bool
+	draining     bool
+	frozenAt     time.Time
 }
 
 // New creates a new email client with the given configuration.
@@ -87,126 +119,300 @@ func New(config Config, opts ...Option) (*Client, error) {
 
 	client := &Client{
 		config: config,
-		tracer: otel.Tracer("github.com/lattiq/mailer"),
+		stats:  newRollingStats(config.Monitoring.Metrics.Window, config.Clock),
+		clock:  clockOrDefault(config.Clock),
+		tracer: newTracer(config.Monitoring.Tracing),
 	}
-
-	// Initialize provider
-	provider, err := createProvider(config.Provider.Type, config.Provider.Primary)
+	instruments, err := newMetrics(config.Monitoring.Metrics, client.mxCacheStats)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create primary provider: %w", err)
+		return nil, fmt.Errorf("failed to create metrics: %w", err)
 	}
-	client.provider = provider
+	client.metrics = instruments
+	client.sendChain = chain(config.Middleware, client.send)
 
-	// Initialize fallback provider if configured
-	if config.Provider.Fallback != nil {
-		fallbackType := ProviderType(config.Provider.Fallback.Get("type"))
-		if fallbackType != "" {
-			fallback, err := createProvider(fallbackType, *config.Provider.Fallback)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create fallback provider: %w", err)
+	// Initialize providers, on the first send for serverless clients
+	if !config.Serverless {
+		if err := client.initProviders(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize logger
+	logger, logCloser, err := newLogger(config.Monitoring.Logging)
+	if err != nil {
+		return nil, err
+	}
+	client.logger = logger
+	client.logCloser = logCloser
+	logExperimentalFeatures(logger, config.Experimental)
+
+	// Warm provider connections if configured
+	if config.Provider.WarmPoolSize > 0 {
+		if err := client.warmProviders(); err != nil {
+			if logCloser != nil {
+				_ = logCloser.Close()
 			}
-			client.fallback = fallback
+			return nil, err
 		}
 	}
 
 	// Initialize template engine if enabled
 	if config.Templates.Enabled {
-		templateEng, err := NewTemplateEngine(config.Templates)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create template engine: %w", err)
+		templateEng := config.Templates.Engine
+		if templateEng == nil {
+			var err error
+			templateEng, err = newTemplateEngine(config.Templates, logger, client.clock)
+			if err != nil {
+				if logCloser != nil {
+					_ = logCloser.Close()
+				}
+				return nil, fmt.Errorf("failed to create template engine: %w", err)
+			}
 		}
 		client.templateEng = templateEng
+
+		if impl, ok := templateEng.(*TemplateEngineImpl); ok {
+			for _, name := range impl.SkippedMJML() {
+				logger.Warn("MJML template skipped: no MJML compiler configured", "template", name)
+			}
+		}
 	}
 
 	// Initialize retry manager
 	if config.Retry.Enabled {
 		client.retryManager = NewRetryManager(config.Retry)
+		client.retryManager.clock = client.clock
+		client.retryManager.rand = randOrDefault(config.Rand)
 	}
 
 	// Initialize rate limiter
 	if config.RateLimit.Enabled {
-		client.rateLimiter = NewRateLimiter(config.RateLimit)
+		if config.Serverless {
+			client.rateLimiter = newLazyRateLimiter(config.RateLimit, client.clock)
+		} else {
+			client.rateLimiter = NewRateLimiter(config.RateLimit)
+		}
 	}
+	client.rateRules = newRuleLimiter(config.RateLimit.Rules, client.clock)
+	client.idempotency = newIdempotency(config.Idempotency, client.clock, logger)
 
-	// Initialize circuit breaker
-	if config.CircuitBreaker.Enabled {
-		client.circuitBreaker = NewCircuitBreaker(config.CircuitBreaker)
-	}
+	// Initialize a circuit breaker per provider
+	client.breakers = newBreakers(config.CircuitBreaker, client.clock)
 
 	return client, nil
 }
 
-// Send sends a single email.
+// Send sends a single email through the configured middleware.
 func (c *Client) Send(ctx context.Context, email *Email) error {
+	// Reject a nil email before it reaches the middleware
+	if email == nil {
+		return NewValidationError("email", "email is required")
+	}
+	return c.sendChain(ctx, email)
+}
+
+// send sends a single email once it has passed the middleware.
+func (c *Client) send(ctx context.Context, email *Email) error {
 	ctx, span := c.tracer.Start(ctx, "mailer.Client.Send")
 	defer span.End()
 
-	// Check if client is closed
-	c.mu.RLock()
-	if c.closed {
-		c.mu.RUnlock()
-		span.RecordError(ErrClientClosed)
-		span.SetStatus(codes.Error, ErrClientClosed.Error())
-		return ErrClientClosed
+	start := c.clock.Now()
+
+	// Check if client is closed or draining, counting the send as active
+	release, err := c.begin()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
 	}
-	c.mu.RUnlock()
+	defer release()
 
-	// Add attributes to span
-	span.SetAttributes(
-		attribute.String("mailer.to", email.To[0].Email),
-		attribute.String("mailer.from", email.From.Email),
-		attribute.String("mailer.subject", email.Subject),
-		attribute.Int("mailer.recipients", len(email.To)),
-		attribute.String("mailer.provider", c.provider.Name()),
-	)
+	if err := c.initProviders(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "provider initialization failed")
+		return err
+	}
 
-	// Validate email
+	// Validate email before reading any of its fields
 	if err := email.Validate(); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "validation failed")
 		return err
 	}
+	if err := c.checkExperiments(email); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return err
+	}
 
-	// Apply rate limiting
-	if c.rateLimiter != nil {
-		if err := c.rateLimiter.Wait(ctx, email); err != nil {
+	email = c.applyCorrelationID(email)
+	correlationID := email.CorrelationID()
+	if correlationID != "" {
+		span.SetAttributes(attribute.String("mailer.correlation_id", correlationID))
+	}
+
+	// An email already sent with its idempotency key is not sent again; the
+	// key is held until the send completes so that concurrent repeats wait
+	// for its result
+	if key := email.IdempotencyKey; key != "" {
+		unlock, err := c.idempotency.lock(ctx, key)
+		if err != nil {
 			span.RecordError(err)
-			span.SetStatus(codes.Error, "rate limited")
+			span.SetStatus(codes.Error, "idempotency check failed")
 			return err
 		}
+		defer unlock()
+
+		result, release, err := c.idempotency.claim(ctx, key)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "idempotency check failed")
+			return err
+		}
+		defer release()
+		if result != nil {
+			span.SetAttributes(
+				attribute.Bool("mailer.idempotent_replay", true),
+				attribute.String("mailer.message_id", result.MessageID),
+			)
+			span.SetStatus(codes.Ok, "email already sent")
+			if c.config.OnSent != nil {
+				c.config.OnSent(ctx, email, result)
+			}
+			return nil
+		}
 	}
 
-	// Send with circuit breaker and retry
-	var result *SendResult
-	var err error
+	if err := c.checkContentLimits(email); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "content limits exceeded")
+		return err
+	}
 
-	sendFn := func() error {
-		var sendErr error
-		result, sendErr = c.sendWithProvider(ctx, email, c.provider)
+	email, err = c.checkTypos(email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return err
+	}
 
-		// Try fallback provider if primary fails and fallback is available
-		if sendErr != nil && c.fallback != nil && IsRetryable(sendErr) {
-			result, sendErr = c.sendWithProvider(ctx, email, c.fallback)
-		}
+	email, err = c.applyTextOnly(ctx, email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "text-only preference failed")
+		return err
+	}
 
-		return sendErr
+	if err := c.pauses.check(email); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "paused")
+		return err
 	}
 
-	// Apply circuit breaker if enabled
-	if c.circuitBreaker != nil {
-		err = c.circuitBreaker.Execute(sendFn)
-	} else {
-		err = sendFn()
+	audit, err := c.auditAttachments(ctx, email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "attachment check failed")
+		return err
+	}
+	if err := c.checkContentSize(email, audit); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "content limits exceeded")
+		return err
 	}
 
-	// Apply retry logic if enabled
-	if err != nil && c.retryManager != nil && IsRetryable(err) {
-		err = c.retryManager.Retry(ctx, sendFn)
+	email = c.applyTraceParent(ctx, c.applyBaggage(ctx, c.applyIPPool(email)))
+
+	forced, err := c.forcedProvider(ctx, email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return err
+	}
+
+	// Select the provider by the routing rules unless one is forced
+	selected := forced
+	var routed Provider
+	if forced == nil {
+		routed = c.ruleProvider(email)
+		selected = routed
+	}
+
+	// Add attributes to span
+	span.SetAttributes(emailAttributes(email)...)
+	span.SetAttributes(attribute.String("mailer.provider", c.providerName(selected)))
+
+	// Apply rate limit rules, then rate limiting. Rules keyed by provider
+	// are applied below, to each provider the email is sent through
+	if err := c.rateRules.allow(email); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "rate limited")
+		return err
+	}
+	if c.rateLimiter != nil {
+		if err := c.rateLimiter.Wait(ctx, email); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "rate limited")
+			return err
+		}
+	}
+
+	// Reroute or reject sends expected to miss their latency SLO
+	slo, hasSLO := c.config.LatencySLOs[email.Priority]
+	if hasSLO {
+		c.slo.update(email.Priority, func(stats *SLOStats) { stats.Sends++ })
+		if forced == nil {
+			// Evaluate the provider the send would go through, and keep it
+			// so that routing does not pick another one
+			first, second := c.failoverOrder(routed)
+			rerouted, err := c.routeForSLO(email, slo, start, first, second)
+			if err != nil {
+				span.SetAttributes(attribute.Bool("mailer.slo.breached", true))
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "latency SLO breach")
+				return err
+			}
+			if rerouted != nil {
+				forced = rerouted
+				span.SetAttributes(attribute.String("mailer.slo.rerouted_to", rerouted.Name()))
+			} else {
+				routed = first
+			}
+		}
+	}
+
+	// Send through the reliability pipeline, recording each provider call
+	// for the post-mortem of a failure
+	attemptCtx, attempts := c.withAttemptLog(ctx)
+	charged := make(map[string]bool)
+	admit := func(provider Provider) error {
+		if charged[provider.Name()] {
+			return nil
+		}
+		if err := c.rateRules.allowProvider(email, provider.Name()); err != nil {
+			return err
+		}
+		charged[provider.Name()] = true
+		return nil
+	}
+	var result *SendResult
+	err = c.execute(ctx, func() error {
+		return c.withFailover(forced, routed, admit, func(provider Provider) error {
+			var sendErr error
+			result, sendErr = c.sendWithProvider(attemptCtx, audit.rewind(email), provider)
+			return sendErr
+		})
+	})
+
+	if hasSLO && c.clock.Now().Sub(start) > slo.Dispatch {
+		c.slo.update(email.Priority, func(stats *SLOStats) { stats.Breaches++ })
+		span.SetAttributes(attribute.Bool("mailer.slo.breached", true))
 	}
 
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "send failed")
+		c.capturePostMortem(email, err, attempts)
 		return err
 	}
 
@@ -219,6 +425,20 @@ func (c *Client) Send(ctx context.Context, email *Email) error {
 	}
 	span.SetStatus(codes.Ok, "email sent successfully")
 
+	audit.annotate(result)
+	if email.IdempotencyKey != "" && result != nil {
+		if err := c.idempotency.store.Put(ctx, email.IdempotencyKey, result, c.idempotency.ttl); err != nil {
+			c.logger.Warn("failed to record idempotent send",
+				"idempotency_key", email.IdempotencyKey,
+				"correlation_id", correlationID,
+				"error", err,
+			)
+		}
+	}
+	if c.config.OnSent != nil && result != nil {
+		c.config.OnSent(ctx, email, result)
+	}
+
 	return nil
 }
 
@@ -227,42 +447,189 @@ func (c *Client) SendBatch(ctx context.Context, emails []*Email) error {
 	ctx, span := c.tracer.Start(ctx, "mailer.Client.SendBatch")
 	defer span.End()
 
-	// Check if client is closed
-	c.mu.RLock()
-	if c.closed {
-		c.mu.RUnlock()
-		span.RecordError(ErrClientClosed)
-		span.SetStatus(codes.Error, ErrClientClosed.Error())
-		return ErrClientClosed
+	// Check if client is closed or draining, counting the send as active
+	release, err := c.begin()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer release()
+
+	if err := c.initProviders(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "provider initialization failed")
+		return err
 	}
-	c.mu.RUnlock()
 
 	if len(emails) == 0 {
 		span.SetStatus(codes.Ok, "no emails to send")
 		return nil
 	}
 
+	// A batch forced to a provider through the context sends every email
+	// through it; otherwise sendForced honors each email's metadata
+	forced, err := c.forcedProvider(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return err
+	}
+
 	span.SetAttributes(
 		attribute.Int("mailer.batch.size", len(emails)),
-		attribute.String("mailer.provider", c.provider.Name()),
+		attribute.String("mailer.provider", c.providerName(forced)),
 	)
 
-	// Validate all emails first
+	// Reject nil emails before they reach the middleware
 	for i, email := range emails {
+		if email == nil {
+			validationErr := fmt.Errorf("email at index %d: %w", i, NewValidationError("email", "email is required"))
+			span.RecordError(validationErr)
+			span.SetStatus(codes.Error, "validation failed")
+			return validationErr
+		}
+	}
+
+	// Pass each email through the middleware, at the end of which the batch
+	// is sent and each email's outcome returned
+	batch := c.startBatchMiddleware(ctx, emails)
+	return batch.finish(c.sendBatch(ctx, span, forced, batch))
+}
+
+// sendBatch sends the emails of a batch the middleware passed on. Emails it
+// stopped with an error are reported as failed items, and those it stopped
+// without one count as sent.
+func (c *Client) sendBatch(ctx context.Context, span trace.Span, forced Provider, batch *batchMiddleware) error {
+	emails, contexts, stopped, rejected := batch.passed()
+
+	handled := 0
+	for _, s := range stopped {
+		if s {
+			handled++
+		}
+	}
+	handled -= len(rejected)
+
+	// Validate all emails first, including any the middleware replaced
+	// with nil
+	for i, email := range emails {
+		if stopped[i] {
+			continue
+		}
 		if err := email.Validate(); err != nil {
 			validationErr := fmt.Errorf("email at index %d: %w", i, err)
 			span.RecordError(validationErr)
 			span.SetStatus(codes.Error, "validation failed")
 			return validationErr
 		}
+		if err := c.checkExperiments(email); err != nil {
+			validationErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(validationErr)
+			span.SetStatus(codes.Error, "validation failed")
+			return validationErr
+		}
+		if _, err := c.forcedProvider(ctx, email); err != nil {
+			validationErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(validationErr)
+			span.SetStatus(codes.Error, "validation failed")
+			return validationErr
+		}
+		if err := c.checkContentLimits(email); err != nil {
+			limitErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(limitErr)
+			span.SetStatus(codes.Error, "content limits exceeded")
+			return limitErr
+		}
+	}
+
+	// Emails already sent with their idempotency key are not sent again and
+	// count as sent, and those whose key another send holds are failed
+	// items; the keys are held until the batch is sent
+	replayed, claimed, unlock, err := c.idempotency.claimBatch(ctx, emails, stopped)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "idempotency check failed")
+		return err
+	}
+	defer unlock()
+	for i := range replayed {
+		if replayed[i] {
+			stopped[i] = true
+			handled++
+		}
+	}
+	for _, item := range claimed {
+		stopped[item.Index] = true
+	}
+	rejected = append(rejected, claimed...)
+
+	// Assign correlation IDs, check recipient domains and attachments and
+	// record IP pools without modifying the caller's slice
+	pooled := make([]*Email, len(emails))
+	audits := make([]*attachmentAudit, len(emails))
+	for i, email := range emails {
+		if stopped[i] {
+			continue
+		}
+		checked, err := c.checkTypos(c.applyCorrelationID(email))
+		if err != nil {
+			typoErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(typoErr)
+			span.SetStatus(codes.Error, "validation failed")
+			return typoErr
+		}
+		checked, err = c.applyTextOnly(contexts[i], checked)
+		if err != nil {
+			textErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(textErr)
+			span.SetStatus(codes.Error, "text-only preference failed")
+			return textErr
+		}
+		audit, err := c.auditAttachments(contexts[i], checked)
+		if err != nil {
+			auditErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(auditErr)
+			span.SetStatus(codes.Error, "attachment check failed")
+			return auditErr
+		}
+		if err := c.checkContentSize(checked, audit); err != nil {
+			limitErr := fmt.Errorf("email at index %d: %w", i, err)
+			span.RecordError(limitErr)
+			span.SetStatus(codes.Error, "content limits exceeded")
+			return limitErr
+		}
+		pooled[i] = c.applyTraceParent(contexts[i], c.applyBaggage(contexts[i], c.applyIPPool(checked)))
+		audits[i] = audit
 	}
 
-	// Try batch send with primary provider
-	batchResult, err := c.sendBatchWithProvider(ctx, emails, c.provider)
+	// Leave out emails whose category or template is paused or over a rate
+	// limit rule, reporting them as failed items
+	var active []*Email
+	var activeAudits []*attachmentAudit
+	var indexes []int
+	for i, email := range pooled {
+		if stopped[i] {
+			continue
+		}
+		if err := c.pauses.check(email); err != nil {
+			rejected = append(rejected, BatchItemError{Index: i, Error: err})
+			continue
+		}
+		if err := c.rateRules.allow(email); err != nil {
+			rejected = append(rejected, BatchItemError{Index: i, Error: err})
+			continue
+		}
+		active = append(active, email)
+		activeAudits = append(activeAudits, audits[i])
+		indexes = append(indexes, i)
+	}
 
-	// If batch send fails and we have a fallback, try individual sends with fallback
-	if err != nil && c.fallback != nil {
-		return c.sendBatchIndividually(ctx, emails)
+	// Send the batch through the reliability pipeline, in chunks when
+	// configured
+	batchResult := &BatchResult{Total: len(active)}
+	if len(active) > 0 {
+		batchResult, err = c.sendForced(ctx, forced, active, activeAudits)
 	}
 
 	if err != nil {
@@ -270,10 +637,11 @@ func (c *Client) SendBatch(ctx context.Context, emails []*Email) error {
 		span.SetStatus(codes.Error, "batch send failed")
 		return err
 	}
+	c.recordBatch(ctx, active, batchResult)
 
 	// Set batch results
-	successCount := len(batchResult.Successful)
-	failureCount := len(batchResult.Failed)
+	successCount := len(batchResult.Successful) + handled
+	failureCount := len(batchResult.Failed) + len(rejected)
 
 	span.SetAttributes(
 		attribute.Int("mailer.batch.success_count", successCount),
@@ -288,13 +656,20 @@ func (c *Client) SendBatch(ctx context.Context, emails []*Email) error {
 			Failed:  failureCount,
 		}
 
-		// Convert batch failures to batch item errors
+		// Convert batch failures to batch item errors, indexed in the
+		// caller's batch
 		for _, failure := range batchResult.Failed {
 			batchErr.Errors = append(batchErr.Errors, BatchItemError{
-				Index: failure.Index,
+				Index: indexes[failure.Index],
 				Error: failure.Error,
 			})
 		}
+		if len(rejected) > 0 {
+			batchErr.Errors = append(batchErr.Errors, rejected...)
+			sort.Slice(batchErr.Errors, func(i, j int) bool {
+				return batchErr.Errors[i].Index < batchErr.Errors[j].Index
+			})
+		}
 
 		span.RecordError(batchErr)
 		span.SetStatus(codes.Error, fmt.Sprintf("%d/%d emails failed", failureCount, len(emails)))
@@ -310,57 +685,120 @@ func (c *Client) SendTemplate(ctx context.Context, req *TemplateRequest) error {
 	ctx, span := c.tracer.Start(ctx, "mailer.Client.SendTemplate")
 	defer span.End()
 
-	// Check if client is closed
-	c.mu.RLock()
-	if c.closed {
-		c.mu.RUnlock()
-		span.RecordError(ErrClientClosed)
-		span.SetStatus(codes.Error, ErrClientClosed.Error())
-		return ErrClientClosed
+	// Check if client is closed or draining; the rendered email is counted
+	// as active by Send
+	if err := c.checkOpen(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	email, err := c.renderEmail(ctx, span, req)
+	if err != nil {
+		return err
+	}
+
+	// Send the email
+	return c.Send(ctx, email)
+}
+
+// RenderEmail renders a template request into the email SendTemplate would
+// send, without sending it, e.g. to preview it or to hand it to another
+// system.
+func (c *Client) RenderEmail(ctx context.Context, req *TemplateRequest) (*Email, error) {
+	ctx, span := c.tracer.Start(ctx, "mailer.Client.RenderEmail")
+	defer span.End()
+
+	return c.renderEmail(ctx, span, req)
+}
+
+// renderEmail renders a template request, recording errors on span.
+func (c *Client) renderEmail(ctx context.Context, span trace.Span, req *TemplateRequest) (*Email, error) {
+	if req == nil {
+		err := NewValidationError("request", "template request is required")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		return nil, err
 	}
-	c.mu.RUnlock()
 
 	if c.templateEng == nil {
 		err := errors.New("template engine not enabled")
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "template engine not enabled")
-		return err
+		return nil, err
+	}
+
+	// Fill in the sender, headers and category the manifest declares
+	if defaults, ok := c.templateEng.Defaults(req.Template); ok {
+		req = applyTemplateDefaults(req, defaults)
+	}
+
+	options, err := c.resolveTemplateOptions(ctx, req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "locale resolution failed")
+		return nil, NewTemplateError(req.Template, "render", "failed to resolve locale", err)
 	}
 
 	span.SetAttributes(
 		attribute.String("mailer.template.name", req.Template),
+		attribute.String("mailer.template.locale", options.Locale),
 		attribute.Int("mailer.recipients", len(req.To)),
 	)
 
-	// Render template
+	if c.config.Monitoring.Logging.IncludeRequestResponse {
+		c.logger.Debug("rendering template", "template", req.Template, "data", RedactTemplateData(req.Data))
+	}
+
+	// Check required fields and apply formats from the data's mail tags
+	data, err := prepareTemplateData(req.Data, options)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid template data")
+		return nil, NewTemplateError(req.Template, "render", "invalid template data", err)
+	}
+
+	// Render template, preferring the variant for the resolved locale
 	renderedSubject := req.Subject
 	var renderedHTMLBody, renderedTextBody string
-	var err error
 
 	// Render subject if not provided
 	if renderedSubject == "" {
-		renderedSubject, err = c.templateEng.Render(req.Template+".subject", req.Data)
+		renderedSubject, err = c.renderLocalized(req.Template, options.Locale, TemplateTypeSubject, data)
 		if err != nil && !errors.Is(err, ErrTemplateNotFound) {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, "subject template render failed")
-			return NewTemplateError(req.Template, "render", "failed to render subject", err)
+			return nil, NewTemplateError(req.Template, "render", "failed to render subject", err)
 		}
 	}
 
 	// Render HTML body
-	renderedHTMLBody, err = c.templateEng.Render(req.Template+".html", req.Data)
+	renderedHTMLBody, err = c.renderLocalized(req.Template, options.Locale, TemplateTypeHTML, data)
 	if err != nil && !errors.Is(err, ErrTemplateNotFound) {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "HTML template render failed")
-		return NewTemplateError(req.Template, "render", "failed to render HTML body", err)
+		return nil, NewTemplateError(req.Template, "render", "failed to render HTML body", err)
+	}
+
+	// Move <style> rules into style attributes, which email clients keep
+	if c.config.Templates.InlineCSS && renderedHTMLBody != "" {
+		renderedHTMLBody, err = InlineCSS(renderedHTMLBody)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "CSS inlining failed")
+			return nil, NewTemplateError(req.Template, "render", "failed to inline CSS", err)
+		}
 	}
 
 	// Render text body
-	renderedTextBody, err = c.templateEng.Render(req.Template+".text", req.Data)
+	renderedTextBody, err = c.renderLocalized(req.Template, options.Locale, TemplateTypeText, data)
 	if err != nil && !errors.Is(err, ErrTemplateNotFound) {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "text template render failed")
-		return NewTemplateError(req.Template, "render", "failed to render text body", err)
+		return nil, NewTemplateError(req.Template, "render", "failed to render text body", err)
+	}
+	if renderedTextBody == "" && c.config.Templates.AutoText {
+		renderedTextBody = htmlToText(renderedHTMLBody)
 	}
 
 	// Convert metadata from interface{} to string
@@ -368,23 +806,55 @@ func (c *Client) SendTemplate(ctx context.Context, req *TemplateRequest) error {
 	for k, v := range req.Metadata {
 		metadata[k] = fmt.Sprintf("%v", v)
 	}
+	metadata[MetadataTemplate] = req.Template
 
 	// Create email from template request
 	email := &Email{
-		From:     req.From,
-		To:       req.To,
-		CC:       req.CC,
-		BCC:      req.BCC,
-		Subject:  renderedSubject,
-		HTMLBody: renderedHTMLBody,
-		TextBody: renderedTextBody,
-		Headers:  req.Headers,
-		Priority: req.Priority,
-		Metadata: metadata,
+		From:           req.From,
+		To:             req.To,
+		CC:             req.CC,
+		BCC:            req.BCC,
+		Subject:        renderedSubject,
+		HTMLBody:       renderedHTMLBody,
+		TextBody:       renderedTextBody,
+		Headers:        req.Headers,
+		Priority:       req.Priority,
+		Metadata:       metadata,
+		IdempotencyKey: req.IdempotencyKey,
+		Unsubscribe:    req.Unsubscribe,
+		Tracking:       req.Tracking,
+		Tags:           req.Tags,
 	}
 
-	// Send the email
-	return c.Send(ctx, email)
+	return email, nil
+}
+
+// Templates returns the client's template engine, for registering, listing
+// and reloading templates at runtime. It returns nil when templates are not
+// enabled.
+func (c *Client) Templates() TemplateEngine {
+	return c.templateEng
+}
+
+// ExpectedRateLimitWait estimates how long a send of email would wait for
+// rate limiter tokens behind the sends already waiting, so that callers can
+// enqueue it asynchronously instead of blocking. It is zero when rate
+// limiting is disabled or the tokens are available. Rate limit rules, which
+// never wait, are not considered.
+func (c *Client) ExpectedRateLimitWait(email *Email) time.Duration {
+	if c.rateLimiter == nil {
+		return 0
+	}
+	return c.rateLimiter.ExpectedWait(email)
+}
+
+// Stats returns rolling-window latency and error statistics for each provider
+// the client has sent through.
+func (c *Client) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.SLO = c.slo.snapshot()
+	stats.MXCache = c.mxCacheStats()
+	return stats
 }
 
 // Close closes the client and releases any resources.
@@ -405,16 +875,161 @@ func (c *Client) Close() error {
 		}
 	}
 
+	if c.logCloser != nil {
+		if err := c.logCloser.Close(); err != nil {
+			return fmt.Errorf("failed to close log file: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// emailAttributes returns the span attributes describing an email.
+// It only reads fields that are safe to access on any non-nil email,
+// including emails without recipients.
+func emailAttributes(email *Email) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("mailer.from", email.From.Email),
+		attribute.String("mailer.subject", email.Subject),
+		attribute.Int("mailer.recipients", len(email.To)),
+	}
+	if len(email.To) > 0 {
+		attrs = append(attrs, attribute.String("mailer.to", email.To[0].Email))
+	}
+	return attrs
+}
+
+// execute runs fn through the client's reliability pipeline, retrying
+// retryable failures according to the retry policy. All send paths go
+// through here so they behave the same.
+func (c *Client) execute(ctx context.Context, fn func() error) error {
+	if c.retryManager != nil {
+		return c.retryManager.Retry(ctx, fn)
+	}
+
+	return fn()
+}
+
+// withFailover calls fn with the routed provider, or the one the routing
+// policy picks when routed is nil, and, if that fails with a retryable error
+// or its circuit breaker is open and a fallback provider is configured, with
+// the fallback. A send routed to the fallback fails over to the primary. Each
+// call is guarded by the provider's own circuit breaker. While the first
+// provider is unavailable, because its breaker is open or its error rate is
+// over the failover threshold, the order is reversed. A forced provider is
+// called on its own. admit is called before each provider is, and an error
+// from it is returned without calling the provider.
+func (c *Client) withFailover(forced, routed Provider, admit, fn func(provider Provider) error) error {
+	call := func(provider Provider) error {
+		if err := admit(provider); err != nil {
+			return err
+		}
+		return c.guard(provider, fn)
+	}
+	if forced != nil {
+		return call(forced)
+	}
+
+	first, second := c.failoverOrder(routed)
+	err := call(first)
+	if err != nil && second != nil && (IsRetryable(err) || errors.Is(err, ErrCircuitBreakerOpen)) {
+		err = call(second)
+	}
+	return err
+}
+
+// failoverOrder returns the provider a send is attempted with first, routed
+// or chosen by the routing strategy, and the one it fails over to, if any.
+// An unavailable first provider is swapped with the second.
+func (c *Client) failoverOrder(routed Provider) (first, second Provider) {
+	primary, second := c.providers()
+	first = routed
+	if first == nil {
+		first = c.route()
+	}
+	if first == second {
+		second = primary
+	}
+	if second != nil && !c.available(first) {
+		first, second = second, first
+	}
+	return first, second
+}
+
+// providerName returns the name of the selected provider, or of the primary
+// provider when none is selected.
+func (c *Client) providerName(selected Provider) string {
+	if selected != nil {
+		return selected.Name()
+	}
+	primary, _ := c.providers()
+	return primary.Name()
+}
+
+// minFailoverSamples is the number of recent sends required before a
+// provider's error rate is trusted for failover decisions.
+const minFailoverSamples = 10
+
+// unhealthy reports whether the provider's rolling error rate has reached
+// the configured failover threshold.
+func (c *Client) unhealthy(provider Provider) bool {
+	threshold := c.config.Provider.FailoverErrorRate
+	if threshold <= 0 {
+		return false
+	}
+
+	stats := c.stats.provider(provider.Name())
+	return stats.Requests >= minFailoverSamples && stats.ErrorRate >= threshold
+}
+
+// providerTimeout returns the timeout of provider calls for emails of the
+// priority, and whether it is a priority override.
+func (c *Client) providerTimeout(priority Priority) (time.Duration, bool) {
+	if timeout, ok := c.config.Provider.PriorityTimeouts[priority]; ok {
+		return timeout, true
+	}
+	return c.config.Provider.Timeout, false
+}
+
 // sendWithProvider sends an email using a specific provider.
+// The call is bounded by the provider timeout of the email's priority.
 func (c *Client) sendWithProvider(ctx context.Context, email *Email, provider Provider) (*SendResult, error) {
-	startTime := time.Now()
+	timeout, override := c.providerTimeout(email.Priority)
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	defer c.inflight.acquire(provider)()
+
+	startTime := c.clock.Now()
 
-	result, err := provider.Send(ctx, email)
+	var result *SendResult
+	var err error
+	if outcome, ok := simulatedOutcome(email); ok {
+		result, err = c.simulate(outcome, provider)
+	} else {
+		result, err = provider.Send(sendCtx, email.Prepare(provider))
+	}
 
-	duration := time.Since(startTime)
+	duration := c.clock.Now().Sub(startTime)
+
+	if result != nil && result.CorrelationID == "" {
+		result.CorrelationID = email.CorrelationID()
+	}
+
+	// Distinguish the provider timeout from cancellation by the caller. A
+	// priority timeout is retryable so that the send fails over.
+	if err != nil && errors.Is(sendCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("%w after %v: %w", ErrProviderTimeout, timeout, err)
+		if override {
+			timeoutErr := NewTemporaryProviderError(provider.Name(), "timeout", "no response within "+timeout.String())
+			timeoutErr.Cause = err
+			err = timeoutErr
+		}
+	}
+
+	c.stats.record(provider.Name(), duration, err != nil)
+	c.metrics.record(ctx, provider.Name(), duration, err != nil)
+	recordAttempt(ctx, provider.Name(), startTime, duration, err)
 
 	// Add timing information to any existing span
 	if span := trace.SpanFromContext(ctx); span.IsRecording() {
@@ -428,11 +1043,31 @@ func (c *Client) sendWithProvider(ctx context.Context, email *Email, provider Pr
 
 // sendBatchWithProvider sends multiple emails using a specific provider.
 func (c *Client) sendBatchWithProvider(ctx context.Context, emails []*Email, provider Provider) (*BatchResult, error) {
-	startTime := time.Now()
+	prepared := make([]*Email, len(emails))
+	for i, email := range emails {
+		prepared[i] = email.Prepare(provider)
+	}
+
+	defer c.inflight.acquire(provider)()
+
+	startTime := c.clock.Now()
 
-	result, err := provider.SendBatch(ctx, emails)
+	result, err := c.sendBatchSimulated(ctx, prepared, provider)
 
-	duration := time.Since(startTime)
+	duration := c.clock.Now().Sub(startTime)
+
+	// Record one sample per email so batches weigh the same as single sends
+	perEmail := duration / time.Duration(len(emails))
+	failed := make(map[int]bool)
+	if result != nil {
+		for _, failure := range result.Failed {
+			failed[failure.Index] = true
+		}
+	}
+	for i := range emails {
+		c.stats.record(provider.Name(), perEmail, err != nil || failed[i])
+		c.metrics.record(ctx, provider.Name(), perEmail, err != nil || failed[i])
+	}
 
 	// Add timing information to any existing span
 	if span := trace.SpanFromContext(ctx); span.IsRecording() {
@@ -444,47 +1079,42 @@ func (c *Client) sendBatchWithProvider(ctx context.Context, emails []*Email, pro
 	return result, err
 }
 
-// sendBatchIndividually sends emails individually when batch operations fail.
-func (c *Client) sendBatchIndividually(ctx context.Context, emails []*Email) error {
-	var successCount, failureCount int
-	var batchErrors []BatchItemError
+// MaxWarmPoolSize is the largest ProviderConfig.WarmPoolSize: the number of
+// idle connections the HTTP-based providers keep to their API.
+const MaxWarmPoolSize = core.MaxIdleConnsPerHost
 
-	for i, email := range emails {
-		// Create child span for each email
-		emailCtx, emailSpan := c.tracer.Start(ctx, "mailer.Client.SendBatch.email",
-			trace.WithAttributes(
-				attribute.Int("mailer.batch.index", i),
-				attribute.String("mailer.to", email.To[0].Email),
-			),
-		)
+// warmProviders opens the configured number of connections to the primary and
+// fallback providers that support warming, within the provider timeout.
+func (c *Client) warmProviders() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Provider.Timeout)
+	defer cancel()
 
-		if err := c.Send(emailCtx, email); err != nil {
-			emailSpan.RecordError(err)
-			emailSpan.SetStatus(codes.Error, err.Error())
-			failureCount++
-			batchErrors = append(batchErrors, BatchItemError{
-				Index: i,
-				Error: err,
-			})
-		} else {
-			emailSpan.SetStatus(codes.Ok, "email sent")
-			successCount++
+	for _, provider := range c.configuredProviders() {
+		warmer, ok := provider.(core.Warmer)
+		if !ok {
+			continue
 		}
-		emailSpan.End()
-	}
 
-	if failureCount > 0 {
-		return &BatchError{
-			Message: fmt.Sprintf("%d/%d emails failed in fallback individual send", failureCount, len(emails)),
-			Errors:  batchErrors,
-			Total:   len(emails),
-			Failed:  failureCount,
+		start := c.clock.Now()
+		if err := warmer.Warm(ctx, c.config.Provider.WarmPoolSize); err != nil {
+			return fmt.Errorf("failed to warm provider %s: %w", provider.Name(), err)
 		}
+		c.logger.Debug("provider connections warmed",
+			"provider", provider.Name(),
+			"connections", c.config.Provider.WarmPoolSize,
+			"duration", c.clock.Now().Sub(start))
 	}
 
 	return nil
 }
 
+// NewProvider creates a standalone provider of the given type from settings,
+// as the client does for its configured providers. It is intended for tools
+// that drive providers directly, such as package migrate.
+func NewProvider(providerType ProviderType, settings ProviderSettings) (Provider, error) {
+	return createProvider(providerType, settings)
+}
+
 // createProvider creates a provider instance based on type and settings.
 func createProvider(providerType ProviderType, settings ProviderSettings) (Provider, error) {
 	switch providerType {
@@ -496,7 +1126,12 @@ func createProvider(providerType ProviderType, settings ProviderSettings) (Provi
 		return newMailgunProvider(settings)
 	case ProviderSMTP:
 		return newSMTPProvider(settings)
+	case ProviderPostmark:
+		return newPostmarkProvider(settings)
 	default:
+		if factory, ok := registeredProvider(providerType); ok {
+			return factory(settings)
+		}
 		return nil, fmt.Errorf("unsupported provider type: %s", providerType)
 	}
 }
@@ -516,3 +1151,7 @@ func newMailgunProvider(settings ProviderSettings) (Provider, error) {
 func newSMTPProvider(settings ProviderSettings) (Provider, error) {
 	return smtp.NewProvider(settings)
 }
+
+func newPostmarkProvider(settings ProviderSettings) (Provider, error) {
+	return postmark.NewProvider(settings)
+}
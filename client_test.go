@@ -0,0 +1,251 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+// newTestClient returns a client sending through a mock provider, with a
+// "welcome" template.
+func newTestClient(t *testing.T) (*mailer.Client, *mailertest.MockProvider) {
+	t.Helper()
+
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	templates := fstest.MapFS{
+		"templates/welcome.subject": {Data: []byte("Welcome")},
+		"templates/welcome.html":    {Data: []byte("<p>Hello {{.Name}}</p>")},
+	}
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithTemplatesFS(templates, "templates"),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, mock
+}
+
+func validEmail() *mailer.Email {
+	return &mailer.Email{
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: "recipient@example.com"}},
+		Subject:  "Hello",
+		TextBody: "Hello",
+	}
+}
+
+func assertValidationError(t *testing.T, err error) {
+	t.Helper()
+
+	var validationErr *mailer.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got error %v, want a *mailer.ValidationError", err)
+	}
+}
+
+func TestSendRejectsInvalidEmails(t *testing.T) {
+	tests := []struct {
+		name  string
+		email func() *mailer.Email
+	}{
+		{"nil email", func() *mailer.Email { return nil }},
+		{"empty To", func() *mailer.Email {
+			email := validEmail()
+			email.To = nil
+			return email
+		}},
+		{"invalid From", func() *mailer.Email {
+			email := validEmail()
+			email.From = mailer.Address{Email: "not-an-address"}
+			return email
+		}},
+		{"SES template with headers", func() *mailer.Email {
+			email := validEmail()
+			email.Metadata = map[string]string{mailer.MetadataSESTemplate: "digest"}
+			email.Headers = map[string]string{"X-Campaign": "spring"}
+			return email
+		}},
+		{"SES template with attachment", func() *mailer.Email {
+			email := validEmail()
+			email.Metadata = map[string]string{mailer.MetadataSESTemplate: "digest"}
+			email.Attachments = []mailer.Attachment{{Filename: "a.txt", Data: strings.NewReader("a")}}
+			return email
+		}},
+		{"SES template with unsubscribe", func() *mailer.Email {
+			email := validEmail()
+			email.Metadata = map[string]string{mailer.MetadataSESTemplate: "digest"}
+			email.Unsubscribe = &mailer.Unsubscribe{URL: "https://example.com/unsubscribe?u=1"}
+			return email
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run("Send/"+tt.name, func(t *testing.T) {
+			client, mock := newTestClient(t)
+
+			assertValidationError(t, client.Send(context.Background(), tt.email()))
+			if mock.Count() != 0 {
+				t.Errorf("provider received %d emails, want 0", mock.Count())
+			}
+		})
+
+		t.Run("SendBatch/"+tt.name, func(t *testing.T) {
+			client, mock := newTestClient(t)
+
+			err := client.SendBatch(context.Background(), []*mailer.Email{validEmail(), tt.email()})
+			assertValidationError(t, err)
+			if mock.Count() != 0 {
+				t.Errorf("provider received %d emails, want 0", mock.Count())
+			}
+		})
+	}
+}
+
+func TestSendTemplateRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		req  func() *mailer.TemplateRequest
+	}{
+		{"nil request", func() *mailer.TemplateRequest { return nil }},
+		{"empty To", func() *mailer.TemplateRequest {
+			return &mailer.TemplateRequest{
+				Template: "welcome",
+				From:     mailer.Address{Email: "sender@example.com"},
+			}
+		}},
+		{"invalid From", func() *mailer.TemplateRequest {
+			return &mailer.TemplateRequest{
+				Template: "welcome",
+				From:     mailer.Address{Email: "not-an-address"},
+				To:       []mailer.Address{{Email: "recipient@example.com"}},
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, mock := newTestClient(t)
+
+			assertValidationError(t, client.SendTemplate(context.Background(), tt.req()))
+			if mock.Count() != 0 {
+				t.Errorf("provider received %d emails, want 0", mock.Count())
+			}
+		})
+	}
+}
+
+func TestSendAddsHeadersOnlyForRaisedPriorities(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority mailer.Priority
+		want     string
+	}{
+		{"unset", mailer.Priority(0), ""},
+		{"normal", mailer.PriorityNormal, ""},
+		{"high", mailer.PriorityHigh, "2"},
+		{"urgent", mailer.PriorityUrgent, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, mock := newTestClient(t)
+
+			email := validEmail()
+			email.Priority = tt.priority
+			if err := client.Send(context.Background(), email); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			headers := mock.LastEmail().Headers
+			if got := headers["X-Priority"]; got != tt.want {
+				t.Errorf("X-Priority = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && len(headers) != 0 {
+				t.Errorf("got headers %v, want none", headers)
+			}
+		})
+	}
+}
+
+func TestDefaultEmailHasNoPriorityHeaders(t *testing.T) {
+	email := &mailer.Email{}
+	if headers := email.WithPriorityHeaders().Headers; len(headers) != 0 {
+		t.Errorf("got headers %v, want none", headers)
+	}
+}
+
+func TestContentLimitsCountAttachmentsOfUnknownSize(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithContentLimits(mailer.PriorityNormal, mailer.ContentLimits{MaxSize: 1024}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	newEmail := func() *mailer.Email {
+		email := validEmail()
+		email.Priority = mailer.PriorityNormal
+		// A MultiReader has no Len method, so the size is only known once read
+		email.Attachments = []mailer.Attachment{{
+			Filename: "report.csv",
+			Data:     io.MultiReader(strings.NewReader(strings.Repeat("x", 2048))),
+		}}
+		return email
+	}
+
+	assertValidationError(t, client.Send(context.Background(), newEmail()))
+	assertValidationError(t, client.SendBatch(context.Background(), []*mailer.Email{newEmail()}))
+	if mock.Count() != 0 {
+		t.Errorf("provider received %d emails, want 0", mock.Count())
+	}
+}
+
+func TestSameTypeFallbackGetsItsOwnName(t *testing.T) {
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailer.ProviderPostmark, mailer.ProviderSettings{"server_token": "primary"}),
+		mailer.WithFallbackProvider(mailer.ProviderPostmark, mailer.ProviderSettings{"server_token": "fallback"}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	var names []string
+	for _, health := range client.ProviderHealth() {
+		names = append(names, health.Provider)
+	}
+	if len(names) != 2 || names[0] != "postmark" || names[1] != "postmark-fallback" {
+		t.Fatalf("providers = %v, want [postmark postmark-fallback]", names)
+	}
+
+	// Rotation keeps the derived name rather than rejecting a name change;
+	// the health check that follows may fail without network access
+	err = client.RotateProviderCredentials(context.Background(), "postmark-fallback",
+		mailer.ProviderSettings{"server_token": "rotated"})
+	if err != nil && errors.As(err, new(*mailer.ValidationError)) {
+		t.Fatalf("RotateProviderCredentials: %v", err)
+	}
+}
+
+func TestFallbackNamedLikePrimaryIsRejected(t *testing.T) {
+	_, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailer.ProviderPostmark, mailer.ProviderSettings{"server_token": "primary"}),
+		mailer.WithFallbackProvider(mailer.ProviderPostmark, mailer.ProviderSettings{"server_token": "fallback", "name": "postmark"}),
+	)
+	assertValidationError(t, err)
+}
@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// Clock provides the current time and timers to the reliability subsystems,
+// so that tests can control retry delays, circuit breaker timeouts and
+// statistics windows.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Rand provides the randomness used for retry jitter.
+type Rand interface {
+	// Int63n returns a non-negative random number in [0, n). n is positive.
+	Int63n(n int64) int64
+}
+
+// SystemClock is the Clock backed by the time package. It is used when no
+// clock is configured.
+var SystemClock Clock = systemClock{}
+
+// SystemRand is the Rand backed by crypto/rand. It is used when no source of
+// randomness is configured.
+var SystemRand Rand = cryptoRand{}
+
+// systemClock implements Clock with the time package.
+type systemClock struct{}
+
+// Now returns time.Now().
+func (systemClock) Now() time.Time { return time.Now() }
+
+// After returns time.After(d).
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// cryptoRand implements Rand with crypto/rand.
+type cryptoRand struct{}
+
+// Int63n returns a cryptographically secure random number in [0, n), or 0 if
+// the system source of randomness fails.
+func (cryptoRand) Int63n(n int64) int64 {
+	value, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0
+	}
+	return value.Int64()
+}
+
+// clockOrDefault returns clock, or SystemClock when it is nil.
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+// randOrDefault returns r, or SystemRand when it is nil.
+func randOrDefault(r Rand) Rand {
+	if r == nil {
+		return SystemRand
+	}
+	return r
+}
+
+// applyClock makes the providers that write the current time into messages
+// read it from clock.
+func applyClock(clock Clock, providers ...Provider) {
+	for _, provider := range providers {
+		if settable, ok := provider.(core.ClockSettable); ok {
+			settable.SetClock(clock.Now)
+		}
+	}
+}
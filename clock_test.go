@@ -0,0 +1,64 @@
+package mailer_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+// fixedClock is a Clock stopped at a point in time.
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time { return c.now }
+
+func (c fixedClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func TestTemplatesRecordParseTimeOnClock(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	clock := fixedClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithTemplatesFS(fstest.MapFS{
+			"templates/welcome.html": {Data: []byte("<p>Hello</p>")},
+		}, "templates"),
+		mailer.WithClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	infos := client.Templates().(*mailer.TemplateEngineImpl).List()
+	if len(infos) == 0 {
+		t.Fatal("no templates registered")
+	}
+	for _, info := range infos {
+		if !info.ParsedAt.Equal(clock.now) {
+			t.Errorf("ParsedAt of %s = %v, want %v", info.Name, info.ParsedAt, clock.now)
+		}
+	}
+}
+
+func TestBuildMIMEMessageIDFollowsDate(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	message, err := mailer.BuildMIMEWithOptions(validEmail(), mailer.MIMEOptions{Date: date})
+	if err != nil {
+		t.Fatalf("BuildMIMEWithOptions: %v", err)
+	}
+
+	if !strings.Contains(string(message), "Message-ID: <"+strconv.FormatInt(date.UnixNano(), 10)+".") {
+		t.Errorf("Message-ID not generated from the date:\n%s", message)
+	}
+	if !strings.Contains(string(message), "Date: "+date.Format(time.RFC1123Z)) {
+		t.Errorf("Date header not set from the options:\n%s", message)
+	}
+}
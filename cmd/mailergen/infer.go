@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template/parse"
+	"unicode"
+)
+
+// shape is what a template needs of a value: the fields it references, or
+// the elements it ranges over.
+type shape struct {
+	fields map[string]*shape
+	elem   *shape
+}
+
+// field returns the shape of the named field, adding it if needed.
+func (s *shape) field(name string) *shape {
+	if s.fields == nil {
+		s.fields = make(map[string]*shape)
+	}
+	if s.fields[name] == nil {
+		s.fields[name] = &shape{}
+	}
+	return s.fields[name]
+}
+
+// path returns the shape at the end of a chain of fields.
+func (s *shape) path(idents []string) *shape {
+	for _, ident := range idents {
+		s = s.field(ident)
+	}
+	return s
+}
+
+// ranged returns the shape of the elements ranged over, marking s as a list.
+func (s *shape) ranged() *shape {
+	if s.elem == nil {
+		s.elem = &shape{}
+	}
+	return s.elem
+}
+
+// keyed reports whether the fields of s are map keys rather than struct
+// fields, which is the case when any is unexported.
+func (s *shape) keyed() bool {
+	for name := range s.fields {
+		if !unicode.IsUpper([]rune(name)[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// scope is the value of dot and the variables in a part of a template,
+// along with the templates it can invoke.
+type scope struct {
+	dot     *shape
+	vars    map[string]*shape
+	trees   map[string]*parse.Tree
+	invoked map[string]bool
+}
+
+// with returns the scope of a block with dot set to s.
+func (sc scope) with(dot *shape) scope {
+	vars := make(map[string]*shape, len(sc.vars))
+	for name, value := range sc.vars {
+		vars[name] = value
+	}
+	return scope{dot: dot, vars: vars, trees: sc.trees, invoked: sc.invoked}
+}
+
+// inferShape adds what a template needs of its data to root.
+func inferShape(root *shape, name, source string) error {
+	// Only the data is of interest, so functions need not be defined
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(source, "", "", trees); err != nil {
+		return err
+	}
+
+	walk(tree.Root, scope{
+		dot:     root,
+		vars:    map[string]*shape{"$": root},
+		trees:   trees,
+		invoked: map[string]bool{name: true},
+	})
+	return nil
+}
+
+// walk records what node needs of the values in scope.
+func walk(node parse.Node, sc scope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walk(child, sc)
+		}
+	case *parse.ActionNode:
+		pipe(n.Pipe, sc)
+	case *parse.IfNode:
+		pipe(n.Pipe, sc)
+		walk(n.List, sc.with(sc.dot))
+		walk(n.ElseList, sc.with(sc.dot))
+	case *parse.WithNode:
+		dot := pipe(n.Pipe, sc)
+		if dot == nil {
+			dot = &shape{}
+		}
+		walk(n.List, sc.with(dot))
+		walk(n.ElseList, sc.with(sc.dot))
+	case *parse.RangeNode:
+		list := pipe(n.Pipe, sc)
+		elem := &shape{}
+		if list != nil {
+			elem = list.ranged()
+		}
+		body := sc.with(elem)
+		if decls := n.Pipe.Decl; len(decls) > 0 {
+			body.vars[decls[len(decls)-1].Ident[0]] = elem
+		}
+		walk(n.List, body)
+		walk(n.ElseList, sc.with(sc.dot))
+	case *parse.TemplateNode:
+		// Templates defined alongside are walked with the data they are
+		// invoked with, once to allow recursion
+		dot := pipe(n.Pipe, sc)
+		if tree := sc.trees[n.Name]; tree != nil && dot != nil && !sc.invoked[n.Name] {
+			sc.invoked[n.Name] = true
+			walk(tree.Root, scope{dot: dot, vars: map[string]*shape{"$": dot}, trees: sc.trees, invoked: sc.invoked})
+		}
+	}
+}
+
+// pipe records what a pipeline needs of the values in scope and returns the
+// shape of its result when that is a value of the data.
+func pipe(p *parse.PipeNode, sc scope) *shape {
+	if p == nil {
+		return nil
+	}
+
+	var result *shape
+	for _, cmd := range p.Cmds {
+		result = nil
+		for _, arg := range cmd.Args {
+			result = value(arg, sc)
+		}
+		if len(cmd.Args) != 1 {
+			result = nil
+		}
+	}
+	if len(p.Cmds) != 1 {
+		result = nil
+	}
+
+	// Range declarations are bound by walk, to the element
+	if len(p.Decl) == 1 && !p.IsAssign && result != nil {
+		sc.vars[p.Decl[0].Ident[0]] = result
+	}
+	return result
+}
+
+// value records what an argument needs of the values in scope and returns
+// the shape of its value when that is a value of the data.
+func value(node parse.Node, sc scope) *shape {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return sc.dot
+	case *parse.FieldNode:
+		return sc.dot.path(n.Ident)
+	case *parse.VariableNode:
+		if base := sc.vars[n.Ident[0]]; base != nil {
+			return base.path(n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		if base := value(n.Node, sc); base != nil {
+			return base.path(n.Field)
+		}
+	case *parse.PipeNode:
+		return pipe(n, sc)
+	}
+	return nil
+}
+
+// dataType is a generated struct type.
+type dataType struct {
+	name   string
+	doc    string
+	fields []dataField
+}
+
+// dataField is a field of a generated struct type.
+type dataField struct {
+	name string
+	typ  string
+}
+
+// typeNamer names the generated types uniquely.
+type typeNamer struct {
+	used  map[string]bool
+	types []dataType
+}
+
+// declare returns the Go type of values of shape s, declaring the struct
+// types it needs named after name and documented as doc.
+func (n *typeNamer) declare(name, doc string, s *shape) string {
+	switch {
+	case s.elem != nil:
+		return "[]" + n.declare(name, "an element of "+doc, s.elem)
+	case len(s.fields) == 0:
+		return "any"
+	case s.keyed():
+		return "map[string]any"
+	}
+
+	name = n.unique(name)
+	declared := dataType{name: name, doc: doc}
+	n.types = append(n.types, declared)
+	index := len(n.types) - 1
+	for _, field := range sortedKeys(s.fields) {
+		typ := n.declare(strings.TrimSuffix(name, "Data")+field, "the "+field+" field of "+name, s.fields[field])
+		declared.fields = append(declared.fields, dataField{name: field, typ: typ})
+	}
+	n.types[index] = declared
+	return name
+}
+
+// unique returns name, suffixed with a number if it is already taken.
+func (n *typeNamer) unique(name string) string {
+	candidate := name
+	for i := 2; n.used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	n.used[candidate] = true
+	return candidate
+}
+
+// partSuffix matches the part of a template name, which the template's
+// parts share the data of.
+var partSuffix = regexp.MustCompile(`\.(html|text|txt|subject)$`)
+
+// localeSuffix matches the locale of a localized template variant, e.g.
+// the "de-AT" of "welcome.de-AT".
+var localeSuffix = regexp.MustCompile(`\.[a-z]{2,3}(-[A-Za-z0-9]+)?$`)
+
+// inferData returns the data types of the templates: one per template,
+// shared by its parts and localized variants, with a field for each value
+// they reference.
+func inferData(templates []compiledTemplate) ([]dataType, error) {
+	roots := make(map[string]*shape)
+	for _, tmpl := range templates {
+		base := partSuffix.ReplaceAllString(tmpl.name, "")
+		if roots[base] == nil {
+			roots[base] = &shape{}
+		}
+		if err := inferShape(roots[base], tmpl.name, tmpl.source); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", tmpl.name, err)
+		}
+	}
+
+	// Localized variants render with the data of their base template
+	for base, root := range roots {
+		unlocalized := localeSuffix.ReplaceAllString(base, "")
+		if target := roots[unlocalized]; unlocalized != base && target != nil {
+			merge(target, root)
+			delete(roots, base)
+		}
+	}
+
+	namer := &typeNamer{used: make(map[string]bool)}
+	for _, base := range sortedKeys(roots) {
+		root := roots[base]
+		if len(root.fields) == 0 || root.keyed() {
+			// Data of any type, or a map, renders such templates
+			continue
+		}
+		namer.declare(typeName(base)+"Data", "the data of the "+base+" template", root)
+	}
+	return namer.types, nil
+}
+
+// merge adds what from needs of a value to into.
+func merge(into, from *shape) {
+	for name, field := range from.fields {
+		merge(into.field(name), field)
+	}
+	if from.elem != nil {
+		merge(into.ranged(), from.elem)
+	}
+}
+
+// typeName returns the exported Go name of a template, e.g. "AuthResetPassword"
+// for "auth.reset-password".
+func typeName(template string) string {
+	var name strings.Builder
+	upper := true
+	for _, r := range template {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			name.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	if name.Len() == 0 || !unicode.IsLetter([]rune(name.String())[0]) {
+		return "T" + name.String()
+	}
+	return name.String()
+}
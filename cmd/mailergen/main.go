@@ -0,0 +1,183 @@
+// Command mailergen compiles a template directory into Go source, so that
+// templates ship inside the binary and are parsed when the package is
+// initialized, with no file system access or loading at client creation.
+// It is meant to be run by go generate:
+//
+//	//go:generate go run github.com/lattiq/mailer/cmd/mailergen -dir templates -out templates_gen.go
+//
+// The generated file declares a Templates engine, a WithTemplates option
+// that makes a client use it, and a data struct per template, such as
+// WelcomeData, with a field for each variable the template's parts
+// reference:
+//
+//	client, err := mailer.New(config, emails.WithTemplates())
+//	...
+//	err = client.SendTemplate(ctx, &mailer.TemplateRequest{
+//		Template: "welcome",
+//		To:       to,
+//		Data:     emails.WelcomeData{Name: "Jane"},
+//	})
+//
+// Templates are named and found as by the default template resolver.
+// Template manifests, translations and assets are not compiled; MJML
+// templates are compiled only with -mjml.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lattiq/mailer"
+)
+
+func main() {
+	dir := flag.String("dir", "templates", "template directory")
+	out := flag.String("out", "templates_gen.go", "output file")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "package name of the output file (default: $GOPACKAGE)")
+	ext := flag.String("ext", ".html,.txt", "comma-separated template file extensions")
+	strict := flag.Bool("strict", false, "fail rendering on missing keys")
+	mjml := flag.String("mjml", "", "command compiling MJML to HTML, e.g. \"mjml -i -s\"")
+	flag.Parse()
+
+	if *pkg == "" {
+		fail(fmt.Errorf("package name required: set -pkg or run through go generate"))
+	}
+
+	config := mailer.TemplateConfig{
+		Enabled:           true,
+		Directory:         *dir,
+		Extension:         strings.Split(*ext, ","),
+		StrictMissingKeys: *strict,
+	}
+	if *mjml != "" {
+		command := strings.Fields(*mjml)
+		config.MJML = mailer.MJMLCommand(command[0], command[1:]...)
+	}
+
+	source, err := generate(*pkg, config)
+	if err != nil {
+		fail(err)
+	}
+	if err := os.WriteFile(*out, source, 0o644); err != nil {
+		fail(err)
+	}
+}
+
+// fail reports err and exits.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mailergen:", err)
+	os.Exit(1)
+}
+
+// compiledTemplate is a template compiled into the generated source.
+type compiledTemplate struct {
+	name   string
+	source string
+}
+
+// generate returns the formatted Go source of package pkg compiling the
+// templates in the configured directory.
+func generate(pkg string, config mailer.TemplateConfig) ([]byte, error) {
+	// Load the templates as a client would, which parses them and names
+	// them by the default resolver
+	engine, err := mailer.NewTemplateEngine(config)
+	if err != nil {
+		return nil, err
+	}
+	impl := engine.(*mailer.TemplateEngineImpl)
+	if skipped := impl.SkippedMJML(); len(skipped) > 0 {
+		return nil, fmt.Errorf("MJML templates need -mjml: %s", strings.Join(skipped, ", "))
+	}
+
+	var templates []compiledTemplate
+	for _, info := range engine.List() {
+		source, err := templateSource(info, config)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, compiledTemplate{name: info.Name, source: source})
+	}
+
+	data, err := inferData(templates)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mailergen from %s; DO NOT EDIT.\n\n", filepath.ToSlash(config.Directory))
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import \"github.com/lattiq/mailer\"\n\n")
+
+	fmt.Fprintf(&buf, "// Templates is the engine of the templates compiled from %s, parsed\n", filepath.ToSlash(config.Directory))
+	fmt.Fprintf(&buf, "// when the package is initialized.\n")
+	fmt.Fprintf(&buf, "var Templates mailer.TemplateEngine\n\n")
+	fmt.Fprintf(&buf, "func init() {\n")
+	fmt.Fprintf(&buf, "engine, err := mailer.NewTemplateEngine(mailer.TemplateConfig{Enabled: true, StrictMissingKeys: %t})\n", config.StrictMissingKeys)
+	fmt.Fprintf(&buf, "if err != nil {\npanic(err)\n}\n")
+	fmt.Fprintf(&buf, "for _, tmpl := range []struct{ name, content string }{\n")
+	for _, tmpl := range templates {
+		fmt.Fprintf(&buf, "{%q, %s},\n", tmpl.name, quote(tmpl.source))
+	}
+	fmt.Fprintf(&buf, "} {\n")
+	fmt.Fprintf(&buf, "if err := engine.RegisterTemplate(tmpl.name, tmpl.content); err != nil {\npanic(err)\n}\n")
+	fmt.Fprintf(&buf, "}\n")
+	fmt.Fprintf(&buf, "Templates = engine\n")
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// WithTemplates makes a client render templates with Templates.\n")
+	fmt.Fprintf(&buf, "func WithTemplates() mailer.Option {\nreturn mailer.WithTemplateEngine(Templates)\n}\n")
+
+	for _, typ := range data {
+		fmt.Fprintf(&buf, "\n// %s is %s.\n", typ.name, typ.doc)
+		fmt.Fprintf(&buf, "type %s struct {\n", typ.name)
+		for _, field := range typ.fields {
+			fmt.Fprintf(&buf, "%s %s\n", field.name, field.typ)
+		}
+		fmt.Fprintf(&buf, "}\n")
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// quote returns s as a Go string literal, raw when that keeps it readable.
+func quote(s string) string {
+	if strings.ContainsAny(s, "`\r") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+// templateSource returns the source a template is registered from: its
+// file, compiled when it is MJML and trimmed when it is a subject.
+func templateSource(info mailer.TemplateInfo, config mailer.TemplateConfig) (string, error) {
+	content, err := os.ReadFile(info.Source)
+	if err != nil {
+		return "", err
+	}
+	switch filepath.Ext(info.Source) {
+	case ".subject":
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case ".mjml":
+		return config.MJML.Compile(context.Background(), string(content))
+	}
+	return string(content), nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,7 +1,13 @@
 package mailer
 
 import (
+	"context"
+	"io/fs"
+	"strconv"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config holds the complete mailer configuration.
@@ -9,12 +15,24 @@ type Config struct {
 	// Provider contains provider-specific configuration.
 	Provider ProviderConfig
 
+	// Routing selects the provider of each send by ordered rules on the
+	// email, such as its recipient domain or category.
+	Routing RoutingConfig
+
 	// Templates contains template engine configuration.
 	Templates TemplateConfig
 
 	// Retry contains retry policy configuration.
 	Retry RetryConfig
 
+	// Middleware wraps every send, the first middleware outermost. It runs
+	// for each email of Send, SendTemplate and SendBatch; see WithMiddleware.
+	Middleware []Middleware
+
+	// Batch configures splitting batches into chunks, optionally sized
+	// adaptively from the provider's observed latency and errors.
+	Batch BatchConfig
+
 	// RateLimit contains rate limiting configuration.
 	RateLimit RateLimitConfig
 
@@ -23,6 +41,71 @@ type Config struct {
 
 	// Monitoring contains observability configuration.
 	Monitoring MonitoringConfig
+
+	// ContentLimits restricts email size and complexity per priority class,
+	// e.g. to keep urgent OTP emails small and free of attachments.
+	ContentLimits map[Priority]ContentLimits
+
+	// LatencySLOs sets dispatch latency objectives per priority, e.g. so that
+	// urgent OTP emails are rerouted or rejected rather than sent late.
+	LatencySLOs map[Priority]LatencySLO
+
+	// Clock provides time to retries, the circuit breaker and statistics
+	// (default: SystemClock). Tests can supply a fake clock.
+	Clock Clock
+
+	// Rand provides randomness for retry jitter and weighted routing
+	// (default: SystemRand).
+	Rand Rand
+
+	// IPPools maps email categories to the provider IP pool they are sent
+	// from, keeping bulk streams off transactional IPs. The category is the
+	// "category" metadata value or X-Category header; the empty category
+	// applies to emails without one.
+	IPPools map[string]string
+
+	// BounceDomain is a dedicated domain for bounces, distinct from the From
+	// domain, such as "bounce.example.com". The Return-Path (envelope
+	// sender) of each email becomes the From local part at this domain,
+	// aligning SPF with a custom MAIL FROM setup. It is passed to providers
+	// as the "bounce_domain" setting unless their settings set their own.
+	BounceDomain string
+
+	// AttachmentScanner scans attachments before they are sent, rejecting
+	// emails with an attachment it does not find clean.
+	AttachmentScanner AttachmentScanner
+
+	// TextOnlyResolver, when set, is asked whether the recipients of each
+	// email prefer text-only emails; emails whose recipients all do are
+	// sent without their HTML part, as are emails with MetadataTextOnly set.
+	TextOnlyResolver TextOnlyResolver
+
+	// OnSent, when set, is called with the result of each email sent
+	// through Send or SendTemplate, e.g. to record attachment checksums and
+	// scan verdicts for auditing.
+	OnSent func(ctx context.Context, email *Email, result *SendResult)
+
+	// PostMortem captures a diagnostic bundle when a send fails after
+	// retries and failover, for bug reports and provider support tickets.
+	PostMortem PostMortemConfig
+
+	// TypoCheck detects recipients at likely misspelled domains, such as
+	// gamil.com, and warns, rejects or corrects them before sending.
+	TypoCheck TypoCheckConfig
+
+	// Idempotency deduplicates sends of emails with an IdempotencyKey.
+	Idempotency IdempotencyConfig
+
+	// Serverless makes the client suited to short-lived, frozen processes
+	// such as AWS Lambda: no background goroutines are started, the rate
+	// limiter refilling as it is used instead, and providers are created on
+	// the first send rather than by New. See Client.Freeze and Client.Thaw.
+	Serverless bool
+
+	// Experimental opts in to experimental features by name. Their behavior
+	// and configuration may change between minor releases; enabled features
+	// are logged when the client is created.
+	Experimental map[string]bool
 }
 
 // ProviderConfig contains provider-specific settings.
@@ -40,11 +123,47 @@ type ProviderConfig struct {
 	// Timeout is the maximum time to wait for provider operations.
 	Timeout time.Duration
 
+	// PriorityTimeouts overrides Timeout for the provider calls of emails of
+	// the given priorities, e.g. so that urgent emails fail fast to the
+	// fallback provider while bulk mail tolerates slow responses. A call
+	// exceeding its priority's timeout fails with a retryable error, which
+	// fails over to the fallback provider.
+	PriorityTimeouts map[Priority]time.Duration
+
 	// MaxConnsPerHost limits the number of connections per host for HTTP-based providers.
 	MaxConnsPerHost int
 
 	// IdleConnTimeout is the maximum time an idle connection will remain open.
 	IdleConnTimeout time.Duration
+
+	// FailoverErrorRate is the rolling error rate (0.0 to 1.0) at which the
+	// primary provider is considered unhealthy and the fallback is tried first.
+	// Zero disables health-based failover.
+	FailoverErrorRate float64
+
+	// WarmPoolSize is the number of connections opened to each provider when
+	// the client is created, validating connectivity and credentials so the
+	// first send does not pay the connection setup cost. Zero disables warming.
+	// It must not exceed MaxWarmPoolSize.
+	WarmPoolSize int
+
+	// RequestSigners sign the API requests of the HTTP-based providers with
+	// the given names, e.g. to add the headers or signature required by an
+	// internal gateway in front of the provider.
+	RequestSigners map[string]RequestSigner
+
+	// Routes are providers sends are split with, alongside the primary
+	// provider, according to the routing policy. The fallback provider is
+	// tried when the provider a send is routed to fails.
+	Routes []ProviderRoute
+
+	// PrimaryWeight is the primary provider's share of sends when routes
+	// are configured, relative to the routes' weights.
+	PrimaryWeight int
+
+	// Routing is the policy that routes sends between the primary provider
+	// and the routes (default: RoutingWeighted).
+	Routing RoutingPolicy
 }
 
 // ProviderType represents the type of email provider.
@@ -62,6 +181,9 @@ const (
 
 	// ProviderSMTP represents a generic SMTP server.
 	ProviderSMTP ProviderType = "smtp"
+
+	// ProviderPostmark represents the Postmark email service.
+	ProviderPostmark ProviderType = "postmark"
 )
 
 // String returns the string representation of the provider type.
@@ -69,10 +191,20 @@ func (pt ProviderType) String() string {
 	return string(pt)
 }
 
-// Valid checks if the provider type is supported.
+// Valid checks if the provider type is supported, either built in or
+// registered with RegisterProvider.
 func (pt ProviderType) Valid() bool {
+	if pt.builtin() {
+		return true
+	}
+	_, ok := registeredProvider(pt)
+	return ok
+}
+
+// builtin reports whether the provider type is implemented by the library.
+func (pt ProviderType) builtin() bool {
 	switch pt {
-	case ProviderAWSSES, ProviderSendGrid, ProviderMailgun, ProviderSMTP:
+	case ProviderAWSSES, ProviderSendGrid, ProviderMailgun, ProviderSMTP, ProviderPostmark:
 		return true
 	default:
 		return false
@@ -85,8 +217,19 @@ type TemplateConfig struct {
 	Enabled bool
 
 	// Directory is the path to the directory containing email templates.
+	// When FS is set, it is the root directory within FS ("" for its root).
 	Directory string
 
+	// FS is a file system, such as an embed.FS, to read templates,
+	// translations and assets from instead of the disk. Directory,
+	// Translations and Assets.Directory are then paths within it.
+	FS fs.FS
+
+	// Engine, when set, is used as the client's template engine in place of
+	// one created from this configuration, e.g. the engine of templates
+	// compiled into Go source by cmd/mailergen.
+	Engine TemplateEngine
+
 	// Extension is the file extension for template files (default: ".html", ".txt").
 	Extension []string
 
@@ -104,6 +247,49 @@ type TemplateConfig struct {
 	// WARNING: Only enable this if you trust all template content completely.
 	// These functions can lead to XSS vulnerabilities if misused.
 	AllowUnsafeFunctions bool
+
+	// StrictMissingKeys makes rendering fail when the data lacks a key the
+	// template references, instead of rendering "<no value>".
+	StrictMissingKeys bool
+
+	// Assets configures the asset pipeline backing the "asset" template function.
+	Assets AssetConfig
+
+	// TextPartCheck reports HTML templates without a text part when templates
+	// are loaded or reloaded: TemplateCheckWarn logs them and
+	// TemplateCheckError fails the load. Empty disables the check.
+	TextPartCheck string
+
+	// AutoText derives the text body of templated emails from their HTML
+	// body when the template has no text part.
+	AutoText bool
+
+	// Resolver defines how template files are named and how SendTemplate
+	// finds template parts (default: DefaultTemplateResolver).
+	Resolver TemplateResolver
+
+	// LocaleResolver fills in the locale and timezone of template requests
+	// that omit them (default: ContextLocaleResolver).
+	LocaleResolver LocaleResolver
+
+	// Translations is the path to a directory of message catalogs, one per
+	// locale such as "de.yaml" or "fr.json", used by the "t" template
+	// function.
+	Translations string
+
+	// DefaultLocale is the locale "t" falls back to for messages missing
+	// from the requested locale's catalog, and translates into when no
+	// locale is requested.
+	DefaultLocale string
+
+	// InlineCSS moves the rules of <style> elements in rendered HTML bodies
+	// into style attributes, since many email clients strip <style>.
+	InlineCSS bool
+
+	// MJML compiles ".mjml" template files to HTML as they are loaded.
+	// Without it they are skipped, with a warning, in favor of an HTML
+	// template of the same name.
+	MJML MJMLCompiler
 }
 
 // RetryConfig contains retry policy configuration.
@@ -129,6 +315,11 @@ type RetryConfig struct {
 	// RetryableErrors specifies which error types should be retried.
 	// If empty, all errors marked as retryable will be retried.
 	RetryableErrors []string
+
+	// Policies sets the backoff per class of retryable error, e.g. long
+	// waits for throttling and an immediate retry for reset connections.
+	// Classes without a policy use the backoff above.
+	Policies map[ErrorClass]RetryPolicy
 }
 
 // RateLimitConfig contains rate limiting configuration.
@@ -148,6 +339,33 @@ type RateLimitConfig struct {
 	// PerRecipient indicates whether rate limiting should be applied per recipient.
 	// If false, rate limiting is applied globally.
 	PerRecipient bool
+
+	// Reserved holds back tokens for emails of at least the given priority,
+	// so that urgent traffic is not starved by bulk sends when the limiter
+	// is saturated. An email may only take a token while more tokens remain
+	// than are reserved for priorities above its own. For example,
+	// {PriorityUrgent: 5, PriorityHigh: 10} keeps the last 5 tokens for
+	// urgent emails and the 10 before them for high and urgent emails.
+	Reserved map[Priority]int
+
+	// MaxWait is how long a send may wait for tokens, by priority and then
+	// in arrival order, when there are not enough. Sends whose expected wait
+	// exceeds it fail with a *RateLimitError, and those whose context
+	// deadline would pass first fail at once with an error matching
+	// context.DeadlineExceeded. Zero fails sends without waiting.
+	MaxWait time.Duration
+
+	// Block makes sends wait for tokens, by priority and then in arrival
+	// order, however long it takes, until their context is done; MaxWait is
+	// ignored. Sends whose context deadline would pass first still fail at
+	// once with an error matching context.DeadlineExceeded.
+	Block bool
+
+	// Rules cap the sends of specific templates or categories, overall or
+	// per recipient, domain, provider or tag, failing sends over a cap with
+	// a *RateLimitError naming the rule. Rules are enforced whether or not
+	// Enabled is set.
+	Rules []RateLimitRule
 }
 
 // CircuitBreakerConfig contains circuit breaker configuration.
@@ -178,6 +396,19 @@ type MonitoringConfig struct {
 
 	// Logging contains logging configuration.
 	Logging LoggingConfig
+
+	// BaggageKeys are OpenTelemetry baggage keys, such as "tenant.id" or
+	// "request.id", read from the context of each send and recorded under
+	// the same names as span attributes and email metadata, which providers
+	// pass on as tags or custom arguments for attribution.
+	BaggageKeys []string
+
+	// CorrelationIDs generates a correlation ID for each email sent without
+	// one in its metadata, under MetadataCorrelationID. The ID is recorded
+	// on spans, logs, send results and post-mortems and passed to providers
+	// with the email's metadata, so that it ties together the whole
+	// lifecycle of the email.
+	CorrelationIDs bool
 }
 
 // TracingConfig contains distributed tracing configuration.
@@ -199,6 +430,16 @@ type TracingConfig struct {
 
 	// Headers contains additional headers to send with traces.
 	Headers map[string]string
+
+	// DeliveryEvents records the trace context of each send in its
+	// metadata, under MetadataTraceParent, so that delivery events reported
+	// by provider webhooks can be attached to the trace of the send with
+	// RecordDeliveryEvent.
+	DeliveryEvents bool
+
+	// Provider is the tracer provider spans are created with (default: the
+	// global provider).
+	Provider trace.TracerProvider
 }
 
 // MetricsConfig contains metrics collection configuration.
@@ -214,10 +455,22 @@ type MetricsConfig struct {
 
 	// Interval is how often to report metrics.
 	Interval time.Duration
+
+	// Window is the rolling window over which provider latency and error
+	// statistics are computed (default: 5 minutes).
+	Window time.Duration
+
+	// Provider is the meter provider metrics are recorded with (default:
+	// the global provider).
+	Provider metric.MeterProvider
 }
 
 // LoggingConfig contains logging configuration.
 type LoggingConfig struct {
+	// Enabled indicates whether the client writes logs. Logs are discarded
+	// otherwise.
+	Enabled bool
+
 	// Level is the logging level (debug, info, warn, error).
 	Level string
 
@@ -274,8 +527,10 @@ func DefaultConfig() Config {
 				Enabled:   true,
 				Namespace: "mailer",
 				Interval:  30 * time.Second,
+				Window:    5 * time.Minute,
 			},
 			Logging: LoggingConfig{
+				Enabled:                false,
 				Level:                  "info",
 				Format:                 "json",
 				Output:                 "stdout",
@@ -294,6 +549,7 @@ func DefaultRetryConfig() RetryConfig {
 		MaxDelay:     5 * time.Second,
 		Multiplier:   2.0,
 		Jitter:       true,
+		Policies:     DefaultRetryPolicies(),
 	}
 }
 
@@ -313,6 +569,97 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for priority, timeout := range c.Provider.PriorityTimeouts {
+		if timeout <= 0 {
+			return &ValidationError{
+				Field:   "provider.priority_timeouts." + priority.String(),
+				Message: "timeout must be greater than 0",
+			}
+		}
+	}
+
+	if c.Provider.FailoverErrorRate < 0 || c.Provider.FailoverErrorRate > 1 {
+		return &ValidationError{
+			Field:   "provider.failover_error_rate",
+			Message: "failover error rate must be between 0.0 and 1.0",
+		}
+	}
+
+	if c.Provider.WarmPoolSize < 0 {
+		return &ValidationError{
+			Field:   "provider.warm_pool_size",
+			Message: "warm pool size must not be negative",
+		}
+	}
+
+	if c.Provider.WarmPoolSize > MaxWarmPoolSize {
+		return &ValidationError{
+			Field:   "provider.warm_pool_size",
+			Message: "warm pool size must not exceed the " + strconv.Itoa(MaxWarmPoolSize) + " idle connections kept per provider",
+			Value:   strconv.Itoa(c.Provider.WarmPoolSize),
+		}
+	}
+
+	if c.Serverless && c.Provider.WarmPoolSize > 0 {
+		return &ValidationError{
+			Field:   "provider.warm_pool_size",
+			Message: "serverless clients create providers on first use and cannot warm them",
+		}
+	}
+
+	if err := validateRoutes(c.Provider); err != nil {
+		return err
+	}
+
+	if err := c.validateExperiments(); err != nil {
+		return err
+	}
+
+	if err := validateRoutingRules(c.Routing); err != nil {
+		return err
+	}
+
+	if err := c.Batch.validate(); err != nil {
+		return err
+	}
+
+	if c.BounceDomain != "" && !validBounceDomain(c.BounceDomain) {
+		return &ValidationError{
+			Field:   "bounce_domain",
+			Message: "bounce domain must be a domain name",
+			Value:   c.BounceDomain,
+		}
+	}
+
+	for i, middleware := range c.Middleware {
+		if middleware == nil {
+			return &ValidationError{
+				Field:   "middleware",
+				Message: "middleware must not be nil",
+				Value:   strconv.Itoa(i),
+			}
+		}
+	}
+
+	for name, signer := range c.Provider.RequestSigners {
+		if signer == nil {
+			return &ValidationError{
+				Field:   "provider.request_signers",
+				Message: "request signer must not be nil",
+				Value:   name,
+			}
+		}
+	}
+
+	for priority, slo := range c.LatencySLOs {
+		if slo.Dispatch <= 0 {
+			return &ValidationError{
+				Field:   "latency_slos." + priority.String() + ".dispatch",
+				Message: "dispatch objective must be greater than 0",
+			}
+		}
+	}
+
 	if c.Retry.Enabled {
 		if c.Retry.MaxAttempts < 1 {
 			return &ValidationError{
@@ -326,6 +673,13 @@ func (c *Config) Validate() error {
 				Message: "multiplier must be greater than 1.0",
 			}
 		}
+		if err := validateRetryPolicies(c.Retry.Policies); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRateLimitRules(c.RateLimit.Rules); err != nil {
+		return err
 	}
 
 	if c.RateLimit.Enabled {
@@ -341,6 +695,58 @@ func (c *Config) Validate() error {
 				Message: "period must be greater than 0",
 			}
 		}
+
+		if c.RateLimit.MaxWait < 0 {
+			return &ValidationError{
+				Field:   "rate_limit.max_wait",
+				Message: "max wait must not be negative",
+			}
+		}
+
+		reserved := 0
+		for priority, tokens := range c.RateLimit.Reserved {
+			if tokens < 0 {
+				return &ValidationError{
+					Field:   "rate_limit.reserved",
+					Message: "reserved tokens must not be negative",
+					Value:   priority.String(),
+				}
+			}
+			reserved += tokens
+		}
+		if reserved > 0 && reserved >= c.RateLimit.Burst {
+			return &ValidationError{
+				Field:   "rate_limit.reserved",
+				Message: "reserved tokens must be fewer than the burst",
+			}
+		}
+	}
+
+	switch c.TypoCheck.Mode {
+	case "", TypoCheckWarn, TypoCheckReject, TypoCheckCorrect:
+	default:
+		return &ValidationError{
+			Field:   "typo_check.mode",
+			Message: "typo check mode must be empty, \"warn\", \"reject\" or \"correct\"",
+			Value:   c.TypoCheck.Mode,
+		}
+	}
+
+	if c.Idempotency.TTL < 0 {
+		return &ValidationError{
+			Field:   "idempotency.ttl",
+			Message: "idempotency TTL must not be negative",
+		}
+	}
+
+	switch c.Templates.TextPartCheck {
+	case "", TemplateCheckWarn, TemplateCheckError:
+	default:
+		return &ValidationError{
+			Field:   "templates.text_part_check",
+			Message: "text part check must be empty, \"warn\" or \"error\"",
+			Value:   c.Templates.TextPartCheck,
+		}
 	}
 
 	if c.Monitoring.Tracing.Enabled {
@@ -352,5 +758,9 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if err := validateBaggageKeys(c.Monitoring.BaggageKeys); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,36 @@
+package mailer
+
+import "github.com/lattiq/mailer/internal/core"
+
+// Contact list types, re-exported for the management API of providers that
+// manage contact lists and topic subscriptions natively, such as SES.
+type (
+	ContactList        = core.ContactList
+	ContactTopic       = core.ContactTopic
+	Contact            = core.Contact
+	SubscriptionStatus = core.SubscriptionStatus
+	ContactListManager = core.ContactListManager
+)
+
+// Subscription statuses
+const (
+	SubscriptionOptIn  = core.SubscriptionOptIn
+	SubscriptionOptOut = core.SubscriptionOptOut
+)
+
+// ContactLists returns the contact list management API of the primary
+// provider, or of the fallback when only it supports one. It returns
+// ErrContactListsUnsupported when neither does.
+func (c *Client) ContactLists() (ContactListManager, error) {
+	if err := c.initProviders(); err != nil {
+		return nil, err
+	}
+
+	primary, fallback := c.providers()
+	for _, provider := range []Provider{primary, fallback} {
+		if manager, ok := provider.(ContactListManager); ok {
+			return manager, nil
+		}
+	}
+	return nil, ErrContactListsUnsupported
+}
@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MetadataCorrelationID is the Email.Metadata key holding the email's
+// correlation ID. Callers may set it to an ID of their own, such as an
+// order or request ID; otherwise one is generated when correlation IDs are
+// enabled.
+const MetadataCorrelationID = core.MetadataCorrelationID
+
+// applyCorrelationID returns a copy of the email with a generated
+// correlation ID when correlation IDs are enabled and it has none, or the
+// email unchanged.
+func (c *Client) applyCorrelationID(email *Email) *Email {
+	if !c.config.Monitoring.CorrelationIDs || email.CorrelationID() != "" {
+		return email
+	}
+
+	r := randOrDefault(c.config.Rand)
+	return email.WithMetadata(MetadataCorrelationID, fmt.Sprintf("%016x%016x", r.Int63n(1<<62), r.Int63n(1<<62)))
+}
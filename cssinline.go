@@ -0,0 +1,536 @@
+package mailer
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// cssDeclaration is a property and value of a CSS rule or style attribute.
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// cssSelector is a selector of the subset that can be inlined: compounds of
+// a type or universal selector, classes and IDs, joined by descendant or
+// child combinators.
+type cssSelector struct {
+	// compounds are ordered from the subject of the selector leftwards.
+	compounds []cssCompound
+
+	// specificity is the count of IDs, classes and types, weighted.
+	specificity int
+}
+
+// cssCompound is a compound selector and the combinator joining it to the
+// compound on its left: a child combinator when child is set, and a
+// descendant combinator otherwise.
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+	child   bool
+}
+
+// cssRule is an inlinable rule of a style sheet.
+type cssRule struct {
+	selector     cssSelector
+	declarations []cssDeclaration
+	order        int
+}
+
+// cssMatch is a declaration matching an element, with its precedence.
+type cssMatch struct {
+	declaration cssDeclaration
+	specificity int
+	order       int
+}
+
+// InlineCSS moves the rules of the <style> elements of an HTML email body
+// into the style attributes of the elements they match, since many email
+// clients ignore or strip <style>. Rules that cannot be inlined, such as
+// @media queries and selectors with pseudo-classes or attributes, are kept
+// in the <style> element, which is removed when nothing is left in it.
+// Existing style attributes take precedence over non-important rules, as in
+// a browser. Style elements with a media attribute are left untouched.
+// Bodies without an <html> element are treated as fragments and returned
+// without one.
+func InlineCSS(body string) (string, error) {
+	if !strings.Contains(strings.ToLower(body), "<style") {
+		return body, nil
+	}
+
+	var roots []*html.Node
+	if strings.Contains(strings.ToLower(body), "<html") {
+		doc, err := html.Parse(strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		roots = []*html.Node{doc}
+	} else {
+		context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(strings.NewReader(body), context)
+		if err != nil {
+			return "", err
+		}
+		roots = nodes
+	}
+
+	// Collect the rules of every style element, keeping what cannot be
+	// inlined in place
+	var rules []cssRule
+	var empty []*html.Node
+	for _, root := range roots {
+		walkElements(root, func(n *html.Node) bool {
+			if n.DataAtom != atom.Style {
+				return true
+			}
+			if _, ok := htmlAttribute(n, "media"); ok {
+				return false
+			}
+			var sheet strings.Builder
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				sheet.WriteString(child.Data)
+			}
+			parsed, kept := parseStyleSheet(sheet.String(), len(rules))
+			rules = append(rules, parsed...)
+			for child := n.FirstChild; child != nil; child = n.FirstChild {
+				n.RemoveChild(child)
+			}
+			if strings.TrimSpace(kept) == "" {
+				empty = append(empty, n)
+			} else {
+				n.AppendChild(&html.Node{Type: html.TextNode, Data: kept})
+			}
+			return false
+		})
+	}
+	if len(rules) == 0 && len(empty) == 0 {
+		return body, nil
+	}
+	for _, n := range empty {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+			continue
+		}
+		// A top-level element of a fragment
+		for i, root := range roots {
+			if root == n {
+				roots = append(roots[:i], roots[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Apply the rules to each element outside the head
+	for _, root := range roots {
+		walkElements(root, func(n *html.Node) bool {
+			if n.DataAtom == atom.Head {
+				return false
+			}
+			applyRules(n, rules)
+			return true
+		})
+	}
+
+	var out strings.Builder
+	for _, root := range roots {
+		if err := html.Render(&out, root); err != nil {
+			return "", err
+		}
+	}
+	return out.String(), nil
+}
+
+// walkElements calls fn for n and each element below it, in document order,
+// skipping the children of elements for which fn returns false.
+func walkElements(n *html.Node, fn func(*html.Node) bool) {
+	if n.Type == html.ElementNode && !fn(n) {
+		return
+	}
+	for child := n.FirstChild; child != nil; {
+		// fn may remove the child from the tree
+		next := child.NextSibling
+		walkElements(child, fn)
+		child = next
+	}
+}
+
+// htmlAttribute returns the value of an element's attribute.
+func htmlAttribute(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// applyRules merges the declarations of the rules matching an element into
+// its style attribute.
+func applyRules(n *html.Node, rules []cssRule) {
+	var matches []cssMatch
+	for _, rule := range rules {
+		if !rule.selector.matches(n) {
+			continue
+		}
+		for _, declaration := range rule.declarations {
+			matches = append(matches, cssMatch{declaration, rule.selector.specificity, rule.order})
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].specificity != matches[j].specificity {
+			return matches[i].specificity < matches[j].specificity
+		}
+		return matches[i].order < matches[j].order
+	})
+
+	style, _ := htmlAttribute(n, "style")
+	inline := parseDeclarations(style)
+
+	// Cascade: rules, then the style attribute, then important rules, then
+	// important declarations of the style attribute
+	var merged []cssDeclaration
+	set := func(declaration cssDeclaration) {
+		for i := range merged {
+			if merged[i].property == declaration.property {
+				merged = append(merged[:i], merged[i+1:]...)
+				break
+			}
+		}
+		merged = append(merged, declaration)
+	}
+	for _, important := range []bool{false, true} {
+		for _, match := range matches {
+			if match.declaration.important == important {
+				declaration := match.declaration
+				declaration.important = false
+				set(declaration)
+			}
+		}
+		for _, declaration := range inline {
+			if declaration.important == important {
+				set(declaration)
+			}
+		}
+	}
+
+	parts := make([]string, 0, len(merged))
+	for _, declaration := range merged {
+		part := declaration.property + ": " + declaration.value
+		if declaration.important {
+			part += " !important"
+		}
+		parts = append(parts, part)
+	}
+	style = strings.Join(parts, "; ")
+
+	for i, attr := range n.Attr {
+		if attr.Namespace == "" && strings.EqualFold(attr.Key, "style") {
+			n.Attr[i].Val = style
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style})
+}
+
+// parseStyleSheet parses the rules of a style sheet, numbering them from
+// order. It returns the rules that can be inlined and the text of those that
+// cannot, such as at-rules.
+func parseStyleSheet(sheet string, order int) ([]cssRule, string) {
+	sheet = stripCSSComments(sheet)
+	sheet = strings.NewReplacer("<!--", "", "-->", "").Replace(sheet)
+
+	var rules []cssRule
+	var kept strings.Builder
+	for {
+		sheet = strings.TrimSpace(sheet)
+		if sheet == "" {
+			break
+		}
+
+		if sheet[0] == '@' {
+			// At-rules are kept whole, whether statements or blocks
+			end := cssBlockEnd(sheet)
+			kept.WriteString(sheet[:end])
+			kept.WriteByte('\n')
+			sheet = sheet[end:]
+			continue
+		}
+
+		open := strings.IndexByte(sheet, '{')
+		if open < 0 {
+			break
+		}
+		end := cssBlockEnd(sheet)
+		selectors := sheet[:open]
+		body := strings.TrimSuffix(sheet[open+1:end], "}")
+		sheet = sheet[end:]
+
+		declarations := parseDeclarations(body)
+		var unsupported []string
+		for _, text := range strings.Split(selectors, ",") {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			selector, ok := parseSelector(text)
+			if !ok {
+				unsupported = append(unsupported, text)
+				continue
+			}
+			rules = append(rules, cssRule{selector: selector, declarations: declarations, order: order})
+			order++
+		}
+		if len(unsupported) > 0 {
+			kept.WriteString(strings.Join(unsupported, ", ") + " {" + body + "}\n")
+		}
+	}
+	return rules, kept.String()
+}
+
+// cssBlockEnd returns the end of the statement or block at the start of css:
+// after its first semicolon outside a block, or after the brace closing its
+// first block.
+func cssBlockEnd(css string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth <= 0 {
+				return i + 1
+			}
+		case c == ';' && depth == 0:
+			return i + 1
+		}
+	}
+	return len(css)
+}
+
+// stripCSSComments removes /* */ comments outside strings.
+func stripCSSComments(css string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(css) {
+				out.WriteByte(c)
+				i++
+				c = css[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return out.String()
+			}
+			i += end + 3
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// parseDeclarations parses the declarations of a rule or style attribute,
+// splitting on semicolons outside strings and parentheses, such as those of
+// data URLs.
+func parseDeclarations(text string) []cssDeclaration {
+	var declarations []cssDeclaration
+	add := func(part string) {
+		property, value, found := strings.Cut(part, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !found || property == "" || value == "" {
+			return
+		}
+		important := false
+		if i := strings.LastIndexByte(value, '!'); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:i])
+		}
+		declarations = append(declarations, cssDeclaration{property: property, value: value, important: important})
+	}
+
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			add(text[start:i])
+			start = i + 1
+		}
+	}
+	add(text[start:])
+	return declarations
+}
+
+// parseSelector parses a selector, reporting false for those outside the
+// inlinable subset.
+func parseSelector(text string) (cssSelector, bool) {
+	var selector cssSelector
+	child := false
+	for _, token := range strings.Fields(strings.ReplaceAll(text, ">", " > ")) {
+		if token == ">" {
+			if child || len(selector.compounds) == 0 {
+				return cssSelector{}, false
+			}
+			child = true
+			continue
+		}
+
+		compound, specificity, ok := parseCompound(token)
+		if !ok {
+			return cssSelector{}, false
+		}
+		compound.child = child
+		child = false
+		selector.compounds = append(selector.compounds, compound)
+		selector.specificity += specificity
+	}
+	if child || len(selector.compounds) == 0 {
+		return cssSelector{}, false
+	}
+
+	for i, j := 0, len(selector.compounds)-1; i < j; i, j = i+1, j-1 {
+		selector.compounds[i], selector.compounds[j] = selector.compounds[j], selector.compounds[i]
+	}
+	return selector, true
+}
+
+// parseCompound parses a compound selector such as "td.header#top",
+// returning its specificity.
+func parseCompound(token string) (cssCompound, int, bool) {
+	var compound cssCompound
+	specificity := 0
+
+	name := func(s string) int {
+		n := 0
+		for n < len(s) && (s[n] == '-' || s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || s[n] >= '0' && s[n] <= '9' || s[n] >= 0x80) {
+			n++
+		}
+		return n
+	}
+
+	if token[0] == '*' {
+		token = token[1:]
+	} else if n := name(token); n > 0 {
+		compound.tag = strings.ToLower(token[:n])
+		token = token[n:]
+		specificity++
+	}
+
+	for token != "" {
+		kind := token[0]
+		n := name(token[1:])
+		if (kind != '.' && kind != '#') || n == 0 {
+			return cssCompound{}, 0, false
+		}
+		value := token[1 : n+1]
+		token = token[n+1:]
+		if kind == '#' {
+			if compound.id != "" && compound.id != value {
+				return cssCompound{}, 0, false
+			}
+			compound.id = value
+			specificity += 10000
+		} else {
+			compound.classes = append(compound.classes, value)
+			specificity += 100
+		}
+	}
+	return compound, specificity, true
+}
+
+// matches reports whether the selector matches an element.
+func (s cssSelector) matches(n *html.Node) bool {
+	return matchCompounds(s.compounds, n)
+}
+
+// matchCompounds matches compounds, ordered from the subject leftwards,
+// against an element and its ancestors.
+func matchCompounds(compounds []cssCompound, n *html.Node) bool {
+	if !compounds[0].matches(n) {
+		return false
+	}
+	if len(compounds) == 1 {
+		return true
+	}
+	for ancestor := n.Parent; ancestor != nil && ancestor.Type == html.ElementNode; ancestor = ancestor.Parent {
+		if matchCompounds(compounds[1:], ancestor) {
+			return true
+		}
+		if compounds[0].child {
+			return false
+		}
+	}
+	return false
+}
+
+// matches reports whether the compound selector matches an element.
+func (c cssCompound) matches(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := htmlAttribute(n, "id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := htmlAttribute(n, "class")
+		classes := strings.Fields(class)
+		for _, want := range c.classes {
+			found := false
+			for _, have := range classes {
+				if have == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
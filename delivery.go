@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetadataTraceParent is the Email.Metadata key holding the W3C trace context
+// of the send, recorded when delivery tracing is enabled. It is not reserved,
+// so providers return it with their webhook events like other metadata.
+const MetadataTraceParent = "traceparent"
+
+// DeliveryEventType is a stage of an email's delivery lifecycle.
+type DeliveryEventType string
+
+const (
+	// DeliveryAccepted indicates the provider accepted the email.
+	DeliveryAccepted DeliveryEventType = "accepted"
+
+	// DeliveryDelivered indicates the recipient's server accepted the email.
+	DeliveryDelivered DeliveryEventType = "delivered"
+
+	// DeliveryBounced indicates the email bounced.
+	DeliveryBounced DeliveryEventType = "bounced"
+
+	// DeliveryComplained indicates the recipient marked the email as spam.
+	DeliveryComplained DeliveryEventType = "complained"
+
+	// DeliveryOpened indicates the recipient opened the email.
+	DeliveryOpened DeliveryEventType = "opened"
+)
+
+// DeliveryEvent is a delivery lifecycle event reported by a provider, such as
+// a bounce from a webhook, normalized by the application.
+type DeliveryEvent struct {
+	// Type is the lifecycle stage of the event.
+	Type DeliveryEventType
+
+	// Provider is the name of the provider that reported the event.
+	Provider string
+
+	// MessageID is the provider's message ID of the email.
+	MessageID string
+
+	// Recipient is the address the event is about.
+	Recipient string
+
+	// Time is when the event happened (default: now).
+	Time time.Time
+
+	// Reason describes a bounce or complaint, e.g. the SMTP response.
+	Reason string
+
+	// Metadata is the email metadata returned with the event, such as
+	// SendGrid custom arguments or Mailgun user variables. Its
+	// MetadataTraceParent attaches the event to the trace of the send, and
+	// its MetadataCorrelationID matches the event to the email.
+	Metadata map[string]string
+}
+
+// applyTraceParent records the trace context of the send in the email's
+// metadata when delivery tracing is enabled, or returns the email unchanged.
+func (c *Client) applyTraceParent(ctx context.Context, email *Email) *Email {
+	if !c.config.Monitoring.Tracing.DeliveryEvents || !trace.SpanContextFromContext(ctx).IsValid() {
+		return email
+	}
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if value := carrier.Get(MetadataTraceParent); value != "" {
+		return email.WithMetadata(MetadataTraceParent, value)
+	}
+	return email
+}
+
+// RecordDeliveryEvent records a delivery lifecycle event as a span in the
+// trace of the send it belongs to, found from the event's
+// MetadataTraceParent, so that a trace follows an email from Send to its
+// final outcome. Events without a trace context start a trace of their own.
+// The event's correlation ID, when its metadata has one, is recorded with it.
+// Bounces and complaints are recorded with an error status.
+func (c *Client) RecordDeliveryEvent(ctx context.Context, event DeliveryEvent) error {
+	switch event.Type {
+	case DeliveryAccepted, DeliveryDelivered, DeliveryBounced, DeliveryComplained, DeliveryOpened:
+	default:
+		return NewValidationErrorWithValue("type", "unknown delivery event type", event.Type)
+	}
+
+	eventTime := event.Time
+	if eventTime.IsZero() {
+		eventTime = c.clock.Now()
+	}
+
+	// Attach the event to the trace of the send, linking it to the trace of
+	// the caller, e.g. the webhook request
+	parent := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier(event.Metadata))
+	var opts []trace.SpanStartOption
+	if caller := trace.SpanContextFromContext(ctx); caller.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: caller}))
+	}
+	opts = append(opts,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(eventTime),
+		trace.WithAttributes(
+			attribute.String("mailer.delivery.event", string(event.Type)),
+			attribute.String("mailer.provider", event.Provider),
+			attribute.String("mailer.message_id", event.MessageID),
+			attribute.String("mailer.recipient", event.Recipient),
+		),
+	)
+
+	_, span := c.tracer.Start(parent, "mailer.delivery."+string(event.Type), opts...)
+	if event.Reason != "" {
+		span.SetAttributes(attribute.String("mailer.delivery.reason", event.Reason))
+	}
+	correlationID := event.Metadata[MetadataCorrelationID]
+	if correlationID != "" {
+		span.SetAttributes(attribute.String("mailer.correlation_id", correlationID))
+	}
+	if event.Type == DeliveryBounced || event.Type == DeliveryComplained {
+		span.SetStatus(codes.Error, string(event.Type))
+	}
+	span.End(trace.WithTimestamp(eventTime))
+
+	c.logger.Info("delivery event",
+		"event", event.Type,
+		"provider", event.Provider,
+		"message_id", event.MessageID,
+		"correlation_id", correlationID,
+		"trace_id", trace.SpanContextFromContext(parent).TraceID().String(),
+	)
+	return nil
+}
@@ -37,6 +37,7 @@
 //   - AWS SES
 //   - SendGrid
 //   - Mailgun
+//   - Postmark
 //   - Generic SMTP
 //
 // # Features
@@ -0,0 +1,254 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// emlSkippedHeaders are the headers ParseEML does not copy into
+// Email.Headers: those it maps to Email fields, those describing the MIME
+// structure, which is rebuilt on send, and trace and authentication headers
+// added in transit, which must not be sent again.
+var emlSkippedHeaders = map[string]bool{
+	"From":                       true,
+	"To":                         true,
+	"Cc":                         true,
+	"Bcc":                        true,
+	"Subject":                    true,
+	"Date":                       true,
+	"Message-Id":                 true,
+	"Mime-Version":               true,
+	"Content-Type":               true,
+	"Content-Transfer-Encoding":  true,
+	"Content-Disposition":        true,
+	"X-Priority":                 true,
+	"Importance":                 true,
+	"Priority":                   true,
+	"Received":                   true,
+	"Return-Path":                true,
+	"Delivered-To":               true,
+	"Dkim-Signature":             true,
+	"Authentication-Results":     true,
+	"Arc-Seal":                   true,
+	"Arc-Message-Signature":      true,
+	"Arc-Authentication-Results": true,
+	"Received-Spf":               true,
+}
+
+// emlDecoder decodes RFC 2047 encoded words in any charset known to
+// htmlindex.
+var emlDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// ParseEML converts an RFC 5322 message, such as a stored .eml file, into an
+// Email for forwarding, resending or migrating it. The first text/plain and
+// text/html parts that are not attachments become the bodies, converted to
+// UTF-8, and the other parts become attachments, inline when they have a
+// Content-ID. Custom headers such as Reply-To and List-Unsubscribe are kept
+// in Headers, with the first value of repeated headers; trace headers such
+// as Received and DKIM-Signature are dropped. X-Priority sets the priority.
+// The email is not validated, so that recipients can be changed first.
+func ParseEML(r io.Reader) (*Email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	email := &Email{Priority: emlPriority(msg.Header.Get("X-Priority"))}
+	parser := &mail.AddressParser{WordDecoder: emlDecoder}
+	if from := msg.Header.Get("From"); from != "" {
+		address, err := parser.Parse(from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse From: %w", err)
+		}
+		email.From = Address{Name: address.Name, Email: address.Address}
+	}
+	for _, field := range []struct {
+		header string
+		dst    *[]Address
+	}{{"To", &email.To}, {"Cc", &email.CC}, {"Bcc", &email.BCC}} {
+		addresses, err := parseEMLAddresses(parser, msg.Header.Get(field.header))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", field.header, err)
+		}
+		*field.dst = addresses
+	}
+
+	email.Subject, err = emlDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Subject: %w", err)
+	}
+
+	for key, values := range msg.Header {
+		if emlSkippedHeaders[key] || len(values) == 0 {
+			continue
+		}
+		value, err := emlDecoder.DecodeHeader(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		if email.Headers == nil {
+			email.Headers = make(map[string]string)
+		}
+		email.Headers[key] = value
+	}
+
+	header := textproto.MIMEHeader(msg.Header)
+	if err := parseEMLPart(email, header, msg.Body); err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// parseEMLAddresses parses an address list header, which may be empty.
+func parseEMLAddresses(parser *mail.AddressParser, value string) ([]Address, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	list, err := parser.ParseList(value)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]Address, len(list))
+	for i, address := range list {
+		addresses[i] = Address{Name: address.Name, Email: address.Address}
+	}
+	return addresses, nil
+}
+
+// emlPriority maps an X-Priority header value to a priority.
+// Values such as "1 (Highest)" are matched by their leading digit.
+func emlPriority(value string) Priority {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, "1"):
+		return PriorityUrgent
+	case strings.HasPrefix(value, "2"):
+		return PriorityHigh
+	case strings.HasPrefix(value, "4"), strings.HasPrefix(value, "5"):
+		return PriorityLow
+	default:
+		return PriorityNormal
+	}
+}
+
+// parseEMLPart adds the body or attachment of a MIME part to the email,
+// descending into multipart parts.
+func parseEMLPart(email *Email, header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("%s part has no boundary", mediaType)
+		}
+		mr := multipart.NewReader(body, boundary)
+		for {
+			part, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+			}
+			if err := parseEMLPart(email, part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := emlDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	// Bodies: the first text parts that are not attachments
+	if disposition != "attachment" && filename == "" {
+		switch {
+		case mediaType == "text/plain" && email.TextBody == "":
+			email.TextBody, err = decodeCharset(content, params["charset"])
+			return err
+		case mediaType == "text/html" && email.HTMLBody == "":
+			email.HTMLBody, err = decodeCharset(content, params["charset"])
+			return err
+		}
+	}
+
+	contentID := strings.Trim(header.Get("Content-ID"), "<> ")
+	if filename == "" && mediaType == "message/rfc822" {
+		filename = "message.eml"
+	}
+	email.Attachments = append(email.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		Data:        bytes.NewReader(content),
+		Size:        int64(len(content)),
+		Inline:      disposition != "attachment" && contentID != "",
+		ContentID:   contentID,
+	})
+	return nil
+}
+
+// decodeTransferEncoding reads a part's content, decoding its content
+// transfer encoding.
+func decodeTransferEncoding(encoding string, r io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// Line breaks in base64 content are ignored by the decoder
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(r))
+	default:
+		return io.ReadAll(r)
+	}
+}
+
+// decodeCharset converts text in the given charset to UTF-8.
+func decodeCharset(content []byte, charset string) (string, error) {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return string(content), nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", fmt.Errorf("unsupported charset %q", charset)
+	}
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s text: %w", charset, err)
+	}
+	return string(decoded), nil
+}
+
+// charsetReader converts text in the given charset to UTF-8 for RFC 2047
+// decoding.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
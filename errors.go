@@ -3,6 +3,7 @@ package mailer
 import (
 	"errors"
 	"fmt"
+	"iter"
 	"time"
 )
 
@@ -31,6 +32,27 @@ var (
 
 	// ErrClientClosed indicates the client has been closed.
 	ErrClientClosed = errors.New("client closed")
+
+	// ErrSLOBreach indicates a send was rejected because it was expected to
+	// miss its priority's latency SLO.
+	ErrSLOBreach = errors.New("latency SLO breach")
+
+	// ErrPaused indicates a send was stopped because its category or
+	// template is paused.
+	ErrPaused = errors.New("paused")
+
+	// ErrDraining indicates a send was rejected because the client is
+	// draining before shutdown.
+	ErrDraining = errors.New("client draining")
+
+	// ErrContactListsUnsupported indicates that none of the client's
+	// providers manages contact lists.
+	ErrContactListsUnsupported = errors.New("contact lists not supported by provider")
+
+	// ErrIdempotencyKeyClaimed indicates a send was rejected because another
+	// process sharing the idempotency store is sending an email with the
+	// same idempotency key.
+	ErrIdempotencyKeyClaimed = errors.New("idempotency key claimed by another send")
 )
 
 // TemplateError represents an error in template processing.
@@ -71,6 +93,17 @@ type RateLimitError struct {
 
 	// Window is the time window for the rate limit.
 	Window time.Duration
+
+	// Rule is the name of the RateLimitRule that was exceeded, if any.
+	Rule string
+
+	// Recipient is the recipient whose allowance under a per-recipient rule
+	// was exceeded.
+	Recipient string
+
+	// Key is the recipient, domain, provider or tag whose allowance under a
+	// keyed rule was exceeded.
+	Key string
 }
 
 // Error implements the error interface.
@@ -78,6 +111,29 @@ func (e *RateLimitError) Error() string {
 	return fmt.Sprintf("rate limited: %s (retry after %v)", e.Message, e.RetryAfterDuration)
 }
 
+// PausedError reports the paused category or template that stopped a send.
+// It matches ErrPaused with errors.Is.
+type PausedError struct {
+	// Category is the paused category, if the category is paused.
+	Category string
+
+	// Template is the paused template, if the template is paused.
+	Template string
+}
+
+// Error implements the error interface.
+func (e *PausedError) Error() string {
+	if e.Category != "" {
+		return "category " + e.Category + " is paused"
+	}
+	return "template " + e.Template + " is paused"
+}
+
+// Unwrap returns ErrPaused.
+func (e *PausedError) Unwrap() error {
+	return ErrPaused
+}
+
 // BatchError represents errors that occurred during batch operations.
 type BatchError struct {
 	// Message is the overall error message.
@@ -98,6 +154,18 @@ func (e *BatchError) Error() string {
 	return fmt.Sprintf("batch error: %s (%d/%d failed)", e.Message, e.Failed, e.Total)
 }
 
+// Items returns an iterator over the failed items, yielding each item's index
+// in the batch and its error.
+func (e *BatchError) Items() iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		for _, item := range e.Errors {
+			if !yield(item.Index, item.Error) {
+				return
+			}
+		}
+	}
+}
+
 // BatchItemError represents an error for a specific item in a batch.
 type BatchItemError struct {
 	// Index is the position of the item in the batch.
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/lattiq/mailer"
+)
+
+func main() {
+	if err := run(context.Background(), os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the example, writing its progress to out, and returns an error
+// when an outcome is not the expected one.
+func run(ctx context.Context, out io.Writer) error {
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+
+	// Recipients at simulator.mailer never reach SendGrid, so the example
+	// runs offline with any API key
+	client, err := mailer.New(config,
+		mailer.WithSendGrid("example-api-key"),
+		mailer.WithoutRetry(),
+		mailer.WithLogging("error", "text", "stderr"),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// A campaign where one recipient hard bounces
+	recipients := []string{
+		"success+alice@simulator.mailer",
+		"bounce+bob@simulator.mailer",
+		"success+carol@simulator.mailer",
+	}
+	campaign := make([]*mailer.Email, len(recipients))
+	for i, recipient := range recipients {
+		campaign[i] = &mailer.Email{
+			From:     mailer.Address{Email: "news@example.com", Name: "Example News"},
+			To:       []mailer.Address{{Email: recipient}},
+			Subject:  "Spring sale",
+			TextBody: "Everything is 20% off this week.",
+			Metadata: map[string]string{"category": "marketing"},
+		}
+	}
+
+	err = client.SendBatch(ctx, campaign)
+	var batchErr *mailer.BatchError
+	if !errors.As(err, &batchErr) {
+		return fmt.Errorf("expected a batch error, got %v", err)
+	}
+	for index, itemErr := range batchErr.Items() {
+		fmt.Fprintf(out, "recipient %s failed: %v\n", recipients[index], itemErr)
+	}
+	if batchErr.Failed != 1 {
+		return fmt.Errorf("expected exactly one failure, got %d", batchErr.Failed)
+	}
+
+	// Pausing the campaign stops marketing mail but not transactional mail
+	// sent through the same client
+	client.PauseCategory("marketing")
+
+	err = client.SendBatch(ctx, campaign)
+	if !errors.Is(err, mailer.ErrPaused) && !(errors.As(err, &batchErr) && batchErr.Failed == len(campaign)) {
+		return fmt.Errorf("expected every campaign email to be paused, got %v", err)
+	}
+
+	otp := &mailer.Email{
+		From:     mailer.Address{Email: "auth@example.com"},
+		To:       []mailer.Address{{Email: mailer.SimulateSuccess}},
+		Subject:  "Your login code",
+		TextBody: "Your code is 123456.",
+		Priority: mailer.PriorityUrgent,
+		Metadata: map[string]string{"category": "authentication"},
+	}
+	if err := client.Send(ctx, otp); err != nil {
+		return fmt.Errorf("expected the OTP to be sent while marketing is paused, got %v", err)
+	}
+
+	client.ResumeCategory("marketing")
+	fmt.Fprintln(out, "campaign paused and resumed; transactional mail unaffected")
+	return nil
+}
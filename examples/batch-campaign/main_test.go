@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(context.Background(), &out); err != nil {
+		t.Fatalf("run: %v\noutput:\n%s", err, out.String())
+	}
+
+	for _, want := range []string{
+		"recipient bounce+bob@simulator.mailer failed",
+		"campaign paused and resumed; transactional mail unaffected",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "alice") || strings.Contains(out.String(), "carol") {
+		t.Errorf("output reports a failure for a successful recipient:\n%s", out.String())
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+func main() {
+	if err := run(context.Background(), os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the example, writing its progress to out, and returns an error
+// when an outcome is not the expected one.
+func run(ctx context.Context, out io.Writer) error {
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+
+	// Recipients at simulator.mailer never reach the providers, so the
+	// example runs offline with any credentials
+	client, err := mailer.New(config,
+		mailer.WithSendGrid("example-api-key"),
+		mailer.WithFallbackProvider(mailer.ProviderPostmark, mailer.ProviderSettings{
+			"server_token": "example-server-token",
+		}),
+		mailer.WithRetry(3, 10*time.Millisecond, 50*time.Millisecond, 2.0),
+		mailer.WithLogging("error", "text", "stderr"),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	send := func(ctx context.Context, to string) error {
+		return client.Send(ctx, &mailer.Email{
+			From:     mailer.Address{Email: "app@example.com"},
+			To:       []mailer.Address{{Email: to}},
+			Subject:  "Simulated send",
+			TextBody: "This email is never delivered.",
+		})
+	}
+
+	// Accepted
+	if err := send(ctx, mailer.SimulateSuccess); err != nil {
+		return fmt.Errorf("expected success, got %v", err)
+	}
+	fmt.Fprintln(out, "success: accepted")
+
+	// A hard bounce is permanent and is not retried
+	err = send(ctx, mailer.SimulateBounce)
+	var providerErr *mailer.ProviderError
+	if !errors.As(err, &providerErr) || mailer.IsRetryable(err) {
+		return fmt.Errorf("expected a permanent provider error, got %v", err)
+	}
+	fmt.Fprintf(out, "bounce: %v\n", err)
+
+	// Temporary failures and rate limiting are retried, failing over to
+	// Postmark, before the error is returned
+	for _, to := range []string{mailer.SimulateTemporaryFailure, mailer.SimulateRateLimit} {
+		err = send(ctx, to)
+		if err == nil {
+			return fmt.Errorf("expected %s to fail", to)
+		}
+		fmt.Fprintf(out, "%s: %v\n", to, err)
+	}
+
+	// Forcing a provider bypasses failover, e.g. to test the fallback alone
+	forced := mailer.ContextWithProvider(ctx, "postmark")
+	if err := send(forced, mailer.SimulateSuccess); err != nil {
+		return fmt.Errorf("expected the forced send to succeed, got %v", err)
+	}
+
+	stats := client.Stats()
+	for name, provider := range stats.Providers {
+		fmt.Fprintf(out, "%s: %d requests, %d errors\n", name, provider.Requests, provider.Errors)
+	}
+	if stats.Providers["postmark"].Requests == 0 {
+		return errors.New("expected the fallback to have been used")
+	}
+	return nil
+}
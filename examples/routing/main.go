@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func main() {
+	if err := run(context.Background(), os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run runs the example, writing its progress to out, and returns an error
+// when an outcome is not the expected one.
+func run(ctx context.Context, out io.Writer) error {
+	// Mock providers record the emails sent through them, so the example
+	// runs offline
+	primary := mailertest.NewMockProvider("primary")
+	secondary := mailertest.NewMockProvider("secondary")
+	backup := mailertest.NewMockProvider("backup")
+	for _, mock := range []*mailertest.MockProvider{primary, secondary, backup} {
+		defer mock.Close()
+	}
+
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+
+	// Sends are split 3:1 between the primary and secondary providers,
+	// emails to partner.example always go through the secondary, and
+	// retryable failures fail over to the backup
+	client, err := mailer.New(config,
+		mailer.WithProvider(mailertest.ProviderType, primary.Settings()),
+		mailer.WithProviderRoute(mailertest.ProviderType, 1, secondary.Settings()),
+		mailer.WithRouting(mailer.RoutingRoundRobin, 3),
+		mailer.WithExperimental(mailer.ExperimentWeightedRouting),
+		mailer.WithRoutingRule(mailer.RoutingRule{
+			Provider: "secondary",
+			Domains:  []string{"partner.example"},
+		}),
+		mailer.WithFallbackProvider(mailertest.ProviderType, backup.Settings()),
+		mailer.WithoutRetry(),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	send := func(to string) error {
+		return client.Send(ctx, &mailer.Email{
+			From:     mailer.Address{Email: "app@example.com"},
+			To:       []mailer.Address{{Email: to}},
+			Subject:  "Routed send",
+			TextBody: "This email is recorded by a mock provider.",
+		})
+	}
+
+	// Weighted round robin
+	for i := range 8 {
+		if err := send(fmt.Sprintf("user%d@example.com", i)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "split: primary %d, secondary %d\n", primary.Count(), secondary.Count())
+	if primary.Count() != 6 || secondary.Count() != 2 {
+		return fmt.Errorf("expected a 6/2 split, got %d/%d", primary.Count(), secondary.Count())
+	}
+
+	// Routing rule
+	if err := send("ops@partner.example"); err != nil {
+		return err
+	}
+	if email := secondary.LastEmail(); email == nil || email.To[0].Email != "ops@partner.example" {
+		return errors.New("expected the partner email to go through the secondary provider")
+	}
+	fmt.Fprintln(out, "rule: partner.example sent through secondary")
+
+	// Failover when the provider a send is routed to fails
+	primary.SetError(mailer.NewRetryableProviderError("primary", "unavailable", "service unavailable"))
+	secondary.SetError(mailer.NewRetryableProviderError("secondary", "unavailable", "service unavailable"))
+	if err := send("user8@example.com"); err != nil {
+		return fmt.Errorf("expected failover to the backup, got %v", err)
+	}
+	if backup.Count() != 1 {
+		return fmt.Errorf("expected one email through the backup, got %d", backup.Count())
+	}
+	fmt.Fprintln(out, "failover: sent through backup")
+	return nil
+}
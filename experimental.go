@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+)
+
+// Experimental features accepted in Config.Experimental.
+const (
+	// ExperimentWeightedRouting enables splitting sends between the primary
+	// provider and routes with a weight.
+	ExperimentWeightedRouting = "weighted_routing"
+
+	// ExperimentTracking enables per-email open and click tracking controls
+	// through Email.Tracking.
+	ExperimentTracking = "tracking"
+)
+
+// experimentalFeatures lists the feature flags accepted in Config.Experimental,
+// each with a short description logged when it is enabled. Subsystems whose
+// API may still change register their flag here and check it with
+// Config.ExperimentEnabled.
+var experimentalFeatures = map[string]string{
+	ExperimentWeightedRouting: "weighted, round-robin and least-errors routing between providers",
+	ExperimentTracking:        "per-email open and click tracking controls",
+}
+
+// ExperimentEnabled reports whether the named experimental feature is enabled.
+func (c *Config) ExperimentEnabled(name string) bool {
+	return c.Experimental[name]
+}
+
+// validateExperiments checks that the configuration only uses experimental
+// features that are enabled.
+func (c *Config) validateExperiments() error {
+	if c.ExperimentEnabled(ExperimentWeightedRouting) {
+		return nil
+	}
+	for i, route := range c.Provider.Routes {
+		if route.Weight > 0 {
+			return NewValidationError("provider.routes."+strconv.Itoa(i)+".weight",
+				"weighted routing is experimental and requires the \""+ExperimentWeightedRouting+"\" feature")
+		}
+	}
+	return nil
+}
+
+// checkExperiments checks that the email only uses experimental features that
+// are enabled.
+func (c *Client) checkExperiments(email *Email) error {
+	if email.Tracking != nil && !c.config.ExperimentEnabled(ExperimentTracking) {
+		return NewValidationError("tracking", "tracking controls are experimental and require the \""+ExperimentTracking+"\" feature")
+	}
+	return nil
+}
+
+// logExperimentalFeatures logs the enabled experimental features at startup,
+// warning about flags that name no known feature.
+func logExperimentalFeatures(logger *slog.Logger, flags map[string]bool) {
+	names := make([]string, 0, len(flags))
+	for name, enabled := range flags {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		description, ok := experimentalFeatures[name]
+		if !ok {
+			logger.Warn("unknown experimental feature ignored", "feature", name)
+			continue
+		}
+		logger.Info("experimental feature enabled", "feature", name, "description", description)
+	}
+}
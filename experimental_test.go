@@ -0,0 +1,55 @@
+package mailer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func TestWeightedRoutingRequiresExperiment(t *testing.T) {
+	primary := mailertest.NewMockProvider("primary")
+	t.Cleanup(primary.Close)
+	secondary := mailertest.NewMockProvider("secondary")
+	t.Cleanup(secondary.Close)
+
+	options := []mailer.Option{
+		mailer.WithProvider(mailertest.ProviderType, primary.Settings()),
+		mailer.WithProviderRoute(mailertest.ProviderType, 1, secondary.Settings()),
+	}
+	_, err := mailer.New(mailer.DefaultConfig(), options...)
+	assertValidationError(t, err)
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		append(options, mailer.WithExperimental(mailer.ExperimentWeightedRouting))...)
+	if err != nil {
+		t.Fatalf("New with weighted routing enabled: %v", err)
+	}
+	client.Close()
+}
+
+func TestTrackingRequiresExperiment(t *testing.T) {
+	client, mock := newTestClient(t)
+
+	email := validEmail()
+	email.Tracking = &mailer.Tracking{}
+	assertValidationError(t, client.Send(context.Background(), email))
+	assertValidationError(t, client.SendBatch(context.Background(), []*mailer.Email{email}))
+	if mock.Count() != 0 {
+		t.Fatalf("sent %d emails with tracking disabled, want 0", mock.Count())
+	}
+
+	enabled, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithExperimental(mailer.ExperimentTracking),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { enabled.Close() })
+
+	if err := enabled.Send(context.Background(), email); err != nil {
+		t.Fatalf("Send with tracking enabled: %v", err)
+	}
+}
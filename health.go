@@ -0,0 +1,134 @@
+package mailer
+
+import (
+	"sort"
+	"sync"
+)
+
+// ProviderHealth is the health of a configured provider, as seen by failover
+// routing.
+type ProviderHealth struct {
+	// Provider is the name of the provider.
+	Provider string
+
+	// Fallback reports whether the provider is the fallback provider.
+	Fallback bool
+
+	// State is the state of the provider's circuit breaker; always closed
+	// when the circuit breaker is disabled.
+	State CircuitBreakerState
+
+	// Failures is the failure count of the provider's circuit breaker.
+	Failures int
+
+	// ErrorRate is the provider's rolling-window error rate.
+	ErrorRate float64
+
+	// Healthy reports whether sends are routed to the provider first: its
+	// circuit breaker lets sends through and its error rate is below the
+	// failover threshold.
+	Healthy bool
+}
+
+// breakers holds a circuit breaker per provider, keyed by provider name so
+// that a provider's state survives credential rotation.
+type breakers struct {
+	config CircuitBreakerConfig
+	clock  Clock
+
+	mutex  sync.Mutex
+	byName map[string]*CircuitBreaker
+}
+
+// newBreakers returns the per-provider circuit breakers, or nil when the
+// circuit breaker is disabled.
+func newBreakers(config CircuitBreakerConfig, clock Clock) *breakers {
+	if !config.Enabled {
+		return nil
+	}
+	return &breakers{config: config, clock: clock, byName: make(map[string]*CircuitBreaker)}
+}
+
+// get returns the circuit breaker of the named provider, creating it on
+// first use.
+func (b *breakers) get(name string) *CircuitBreaker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	breaker, ok := b.byName[name]
+	if !ok {
+		breaker = NewCircuitBreaker(b.config)
+		breaker.clock = b.clock
+		b.byName[name] = breaker
+	}
+	return breaker
+}
+
+// guard calls fn with provider through the provider's circuit breaker.
+func (c *Client) guard(provider Provider, fn func(provider Provider) error) error {
+	if c.breakers == nil {
+		return fn(provider)
+	}
+	return c.breakers.get(provider.Name()).Execute(func() error {
+		return fn(provider)
+	})
+}
+
+// available reports whether sends are routed to the provider first.
+func (c *Client) available(provider Provider) bool {
+	if c.breakers != nil && !c.breakers.get(provider.Name()).allows() {
+		return false
+	}
+	return !c.unhealthy(provider)
+}
+
+// ProviderHealth returns the health of the primary, fallback and route
+// providers, for operators to see which provider sends are routed to and why.
+func (c *Client) ProviderHealth() []ProviderHealth {
+	_, fallback := c.providers()
+
+	var health []ProviderHealth
+	for _, provider := range c.configuredProviders() {
+		h := ProviderHealth{
+			Provider:  provider.Name(),
+			Fallback:  provider == fallback,
+			ErrorRate: c.stats.provider(provider.Name()).ErrorRate,
+			Healthy:   c.available(provider),
+		}
+		if c.breakers != nil {
+			breaker := c.breakers.get(provider.Name())
+			h.State = breaker.State()
+			h.Failures = breaker.FailureCount()
+		}
+		health = append(health, h)
+	}
+	return health
+}
+
+// postMortemBreakers returns the state of the circuit breakers of the
+// providers sent through, sorted by provider.
+func (c *Client) postMortemBreakers() []PostMortemBreaker {
+	if c.breakers == nil {
+		return nil
+	}
+
+	c.breakers.mutex.Lock()
+	names := make([]string, 0, len(c.breakers.byName))
+	for name := range c.breakers.byName {
+		names = append(names, name)
+	}
+	c.breakers.mutex.Unlock()
+	sort.Strings(names)
+
+	states := make([]PostMortemBreaker, len(names))
+	for i, name := range names {
+		breaker := c.breakers.get(name)
+		states[i] = PostMortemBreaker{
+			Provider:  name,
+			State:     breaker.State().String(),
+			Failures:  breaker.FailureCount(),
+			Successes: breaker.SuccessCount(),
+		}
+	}
+	return states
+}
@@ -0,0 +1,188 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	textTemplate "text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// translations holds message catalogs, keyed by locale and then message key.
+type translations map[string]map[string]string
+
+// loadTranslations loads the message catalogs in dir in fsys, one file per
+// locale named after it, such as "de.yaml", "de-AT.yml" or "fr.json".
+// Nested keys are joined with dots, so that "welcome: {greeting: Hallo}"
+// defines "welcome.greeting".
+func loadTranslations(fsys fs.FS, dir string, source func(string) string) (translations, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translations directory: %w", err)
+	}
+
+	catalogs := make(translations)
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		locale := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := catalogs[locale]; ok {
+			return nil, fmt.Errorf("multiple translation files for locale %s", locale)
+		}
+
+		name := path.Join(dir, entry.Name())
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read translations %s: %w", source(name), err)
+		}
+
+		var messages map[string]interface{}
+		if ext == ".json" {
+			err = json.Unmarshal(content, &messages)
+		} else {
+			err = yaml.Unmarshal(content, &messages)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse translations %s: %w", source(name), err)
+		}
+
+		catalog := make(map[string]string)
+		if err := flattenMessages(catalog, "", messages); err != nil {
+			return nil, fmt.Errorf("invalid translations %s: %w", source(name), err)
+		}
+		catalogs[locale] = catalog
+	}
+	return catalogs, nil
+}
+
+// flattenMessages adds the messages of a catalog to flat, prefixing their
+// keys with prefix.
+func flattenMessages(flat map[string]string, prefix string, messages map[string]interface{}) error {
+	for key, value := range messages {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case string:
+			flat[key] = v
+		case map[string]interface{}:
+			if err := flattenMessages(flat, key, v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("message %s is not a string", key)
+		}
+	}
+	return nil
+}
+
+// catalogLocale returns the most specific of the locale and its parent
+// languages that has a catalog, or an empty string when none has.
+func (tr translations) catalogLocale(locale string) string {
+	for _, candidate := range localeCandidates(locale) {
+		if _, ok := tr[candidate]; ok {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// translator returns the "t" template function for a locale. It looks a key
+// up in the locale, its parent languages and then the default locale, and
+// formats the message with fmt.Sprintf when given arguments. Keys without a
+// message render as the key itself.
+func (tr translations) translator(locale, defaultLocale string) func(key string, args ...interface{}) string {
+	candidates := append(localeCandidates(locale), localeCandidates(defaultLocale)...)
+	return func(key string, args ...interface{}) string {
+		message := key
+		for _, candidate := range candidates {
+			if translated, ok := tr[candidate][key]; ok {
+				message = translated
+				break
+			}
+		}
+		if len(args) > 0 {
+			return fmt.Sprintf(message, args...)
+		}
+		return message
+	}
+}
+
+// localizeHTML returns a copy of an HTML template per catalog locale, with
+// the "t" function translating into that locale. It must be called before
+// the template is executed.
+func (te *TemplateEngineImpl) localizeHTML(tmpl *template.Template) (map[string]*template.Template, error) {
+	if len(te.translations) == 0 {
+		return nil, nil
+	}
+	localized := make(map[string]*template.Template, len(te.translations))
+	for locale := range te.translations {
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		localized[locale] = clone.Funcs(template.FuncMap{"t": te.translations.translator(locale, te.config.DefaultLocale)})
+	}
+	return localized, nil
+}
+
+// localizeText returns a copy of a text template per catalog locale, with
+// the "t" function translating into that locale.
+func (te *TemplateEngineImpl) localizeText(tmpl *textTemplate.Template) (map[string]*textTemplate.Template, error) {
+	if len(te.translations) == 0 {
+		return nil, nil
+	}
+	localized := make(map[string]*textTemplate.Template, len(te.translations))
+	for locale := range te.translations {
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		localized[locale] = clone.Funcs(textTemplate.FuncMap{"t": te.translations.translator(locale, te.config.DefaultLocale)})
+	}
+	return localized, nil
+}
+
+// RenderLocale renders a template with the "t" function translating into
+// the most specific catalog of the locale or its parent languages. Without
+// such a catalog it renders like Render, translating into the default
+// locale.
+func (te *TemplateEngineImpl) RenderLocale(templateName, locale string, data interface{}) (string, error) {
+	te.mutex.RLock()
+	catalog := te.translations.catalogLocale(locale)
+	htmlTmpl := te.localizedHTML[templateName][catalog]
+	textTmpl := te.localizedText[templateName][catalog]
+	te.mutex.RUnlock()
+
+	switch {
+	case htmlTmpl != nil:
+		var buf strings.Builder
+		if err := htmlTmpl.Execute(&buf, data); err != nil {
+			return "", NewTemplateError(templateName, "render", "failed to execute HTML template", err)
+		}
+		return buf.String(), nil
+	case textTmpl != nil:
+		var buf strings.Builder
+		if err := textTmpl.Execute(&buf, data); err != nil {
+			return "", NewTemplateError(templateName, "render", "failed to execute text template", err)
+		}
+		return buf.String(), nil
+	}
+
+	return te.Render(templateName, data)
+}
+
+// Translate returns the message for key in the locale, falling back as the
+// "t" template function does, for text composed outside templates.
+func (te *TemplateEngineImpl) Translate(locale, key string, args ...interface{}) string {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	return te.translations.translator(locale, te.config.DefaultLocale)(key, args...)
+}
@@ -0,0 +1,329 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultIdempotencyTTL is how long sent results are recorded when no TTL is
+// configured.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyClaimTTL is how long a claim on an idempotency key is held by a
+// send that never releases it, e.g. because its process crashed.
+const idempotencyClaimTTL = 10 * time.Minute
+
+// idempotencySweepSize is the number of recorded results above which the
+// in-memory store drops expired ones.
+const idempotencySweepSize = 10000
+
+// IdempotencyConfig configures deduplication of sends by
+// Email.IdempotencyKey.
+type IdempotencyConfig struct {
+	// Store records the results of sends with an idempotency key
+	// (default: an in-memory store, which deduplicates the sends of a
+	// single client).
+	Store IdempotencyStore
+
+	// TTL is how long a result is recorded, and so how long a repeated send
+	// is recognized (default: 24 hours).
+	TTL time.Duration
+}
+
+// IdempotencyStore records the results of sends by idempotency key, so that
+// a send repeated by the caller, e.g. when replaying an outbox after a
+// crash, is not delivered twice. A store shared between processes, such as
+// one backed by Redis or the application's database, deduplicates repeated
+// sends across them. Concurrent sends with the same key are only
+// deduplicated within a client, unless the store is also an
+// IdempotencyClaimer.
+type IdempotencyStore interface {
+	// Get returns the result recorded for key, or nil when there is none or
+	// it has expired.
+	Get(ctx context.Context, key string) (*SendResult, error)
+
+	// Put records the result of the send with key for ttl, replacing any
+	// claim on the key.
+	Put(ctx context.Context, key string, result *SendResult, ttl time.Duration) error
+}
+
+// IdempotencyClaimer is implemented by idempotency stores that can claim a
+// key atomically, e.g. with Redis SET NX, so that processes sharing the
+// store never both send an email with the same key. A send claims its key
+// before it is sent, and the claim is replaced by Put when it succeeds or
+// released when it fails.
+type IdempotencyClaimer interface {
+	// Claim returns the result recorded for key, if any. Otherwise it
+	// claims the key for ttl unless another claim holds it, reporting
+	// whether it did.
+	Claim(ctx context.Context, key string, ttl time.Duration) (result *SendResult, claimed bool, err error)
+
+	// Release drops the claim on key, unless a result was recorded since.
+	Release(ctx context.Context, key string) error
+}
+
+// idempotency deduplicates sends by idempotency key.
+type idempotency struct {
+	store  IdempotencyStore
+	ttl    time.Duration
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+// newIdempotency returns the deduplication of sends through the configured
+// store, or an in-memory store when none is configured.
+func newIdempotency(config IdempotencyConfig, clock Clock, logger *slog.Logger) *idempotency {
+	store := config.Store
+	if store == nil {
+		store = &memoryIdempotencyStore{clock: clock, results: make(map[string]idempotentResult)}
+	}
+	ttl := config.TTL
+	if ttl == 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return &idempotency{store: store, ttl: ttl, logger: logger, inflight: make(map[string]chan struct{})}
+}
+
+// lock waits for a send in progress with the same key to finish, so that
+// concurrent sends with a key are sent once, then claims the key until
+// unlock is called.
+func (i *idempotency) lock(ctx context.Context, key string) (unlock func(), err error) {
+	for {
+		i.mu.Lock()
+		done, busy := i.inflight[key]
+		if !busy {
+			done = make(chan struct{})
+			i.inflight[key] = done
+			i.mu.Unlock()
+			return func() {
+				i.mu.Lock()
+				delete(i.inflight, key)
+				i.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		i.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// claim returns the result recorded for key, or claims the key in stores
+// that are IdempotencyClaimers, failing with ErrIdempotencyKeyClaimed when
+// another send holds it. release drops the claim once the send is done,
+// unless its result was recorded.
+func (i *idempotency) claim(ctx context.Context, key string) (result *SendResult, release func(), err error) {
+	claimer, ok := i.store.(IdempotencyClaimer)
+	if !ok {
+		result, err := i.store.Get(ctx, key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("idempotency store: %w", err)
+		}
+		return result, func() {}, nil
+	}
+
+	result, claimed, err := claimer.Claim(ctx, key, idempotencyClaimTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("idempotency store: %w", err)
+	}
+	if result != nil {
+		return result, func() {}, nil
+	}
+	if !claimed {
+		return nil, nil, ErrIdempotencyKeyClaimed
+	}
+	return nil, func() {
+		// The context may be done by the time the send is
+		if err := claimer.Release(context.WithoutCancel(ctx), key); err != nil {
+			i.logger.Warn("failed to release idempotency key", "idempotency_key", key, "error", err)
+		}
+	}, nil
+}
+
+// claimBatch claims the idempotency keys of the emails of a batch, other than
+// those skipped, and reports which of them were already sent and which were
+// rejected because another send holds their key. A key repeated within the
+// batch is sent once, the later emails with it counting as already sent.
+// Keys are locked in sorted order, so that concurrent batches sharing keys
+// do not deadlock. unlock releases the claimed keys once the batch is sent.
+func (i *idempotency) claimBatch(ctx context.Context, emails []*Email, skip []bool) (sent []bool, rejected []BatchItemError, unlock func(), err error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for n, email := range emails {
+		key := email.IdempotencyKey
+		if !skip[n] && key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var releases, unlocks []func()
+	unlock = func() {
+		for _, release := range releases {
+			release()
+		}
+		for _, u := range unlocks {
+			u()
+		}
+	}
+
+	// The outcome of each key: already sent, or rejected with an error
+	results := make(map[string]*SendResult, len(keys))
+	claimErrs := make(map[string]error)
+	for _, key := range keys {
+		u, err := i.lock(ctx, key)
+		if err != nil {
+			unlock()
+			return nil, nil, nil, err
+		}
+		unlocks = append(unlocks, u)
+
+		result, release, err := i.claim(ctx, key)
+		if errors.Is(err, ErrIdempotencyKeyClaimed) {
+			claimErrs[key] = err
+			continue
+		}
+		if err != nil {
+			unlock()
+			return nil, nil, nil, err
+		}
+		releases = append(releases, release)
+		results[key] = result
+	}
+
+	sent = make([]bool, len(emails))
+	sending := make(map[string]bool, len(keys))
+	for n, email := range emails {
+		key := email.IdempotencyKey
+		if skip[n] || key == "" {
+			continue
+		}
+		if err, ok := claimErrs[key]; ok {
+			rejected = append(rejected, BatchItemError{Index: n, Error: err})
+			continue
+		}
+		sent[n] = results[key] != nil || sending[key]
+		sending[key] = true
+	}
+	return sent, rejected, unlock, nil
+}
+
+// recordBatch records the results of the emails of a batch sent with an
+// idempotency key. Batch results are not ordered by email, so each email's
+// result is found by its correlation ID; an email without one is recorded
+// with a result naming the provider only.
+func (c *Client) recordBatch(ctx context.Context, emails []*Email, result *BatchResult) {
+	failed := make(map[int]bool, len(result.Failed))
+	for _, failure := range result.Failed {
+		failed[failure.Index] = true
+	}
+	byCorrelationID := make(map[string]*SendResult, len(result.Successful))
+	for _, sent := range result.Successful {
+		if sent != nil && sent.CorrelationID != "" {
+			byCorrelationID[sent.CorrelationID] = sent
+		}
+	}
+
+	for n, email := range emails {
+		if email.IdempotencyKey == "" || failed[n] {
+			continue
+		}
+		sent, ok := byCorrelationID[email.CorrelationID()]
+		if !ok || email.CorrelationID() == "" {
+			sent = &SendResult{Provider: result.Provider, Timestamp: c.clock.Now()}
+		}
+		if err := c.idempotency.store.Put(ctx, email.IdempotencyKey, sent, c.idempotency.ttl); err != nil {
+			c.logger.Warn("failed to record idempotent send",
+				"idempotency_key", email.IdempotencyKey,
+				"correlation_id", email.CorrelationID(),
+				"error", err,
+			)
+		}
+	}
+}
+
+// idempotentResult is a result recorded by the in-memory store, or a claim
+// when result is nil.
+type idempotentResult struct {
+	result  *SendResult
+	expires time.Time
+}
+
+// memoryIdempotencyStore records results in memory, deduplicating the sends
+// of a single client.
+type memoryIdempotencyStore struct {
+	clock   Clock
+	mu      sync.Mutex
+	results map[string]idempotentResult
+}
+
+// Get implements IdempotencyStore.
+func (s *memoryIdempotencyStore) Get(ctx context.Context, key string) (*SendResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recorded, ok := s.results[key]
+	if !ok || !s.clock.Now().Before(recorded.expires) {
+		return nil, nil
+	}
+	return recorded.result, nil
+}
+
+// Put implements IdempotencyStore.
+func (s *memoryIdempotencyStore) Put(ctx context.Context, key string, result *SendResult, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record(key, idempotentResult{result: result, expires: s.clock.Now().Add(ttl)})
+	return nil
+}
+
+// Claim implements IdempotencyClaimer.
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, key string, ttl time.Duration) (*SendResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	if recorded, ok := s.results[key]; ok && now.Before(recorded.expires) {
+		return recorded.result, false, nil
+	}
+	s.record(key, idempotentResult{expires: now.Add(ttl)})
+	return nil, true, nil
+}
+
+// Release implements IdempotencyClaimer.
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if recorded, ok := s.results[key]; ok && recorded.result == nil {
+		delete(s.results, key)
+	}
+	return nil
+}
+
+// record records a result or claim, dropping expired ones when the store
+// is large. The caller holds s.mu.
+func (s *memoryIdempotencyStore) record(key string, recorded idempotentResult) {
+	now := s.clock.Now()
+	if len(s.results) > idempotencySweepSize {
+		for k, r := range s.results {
+			if !now.Before(r.expires) {
+				delete(s.results, k)
+			}
+		}
+	}
+	s.results[key] = recorded
+}
@@ -0,0 +1,213 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+// sharedStore is an IdempotencyStore and IdempotencyClaimer shared by
+// clients, as a Redis store would be by processes. Lookups are delayed, so
+// that concurrent sends interleave.
+type sharedStore struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	results map[string]*mailer.SendResult
+	claims  map[string]bool
+}
+
+func newSharedStore(delay time.Duration) *sharedStore {
+	return &sharedStore{
+		delay:   delay,
+		results: make(map[string]*mailer.SendResult),
+		claims:  make(map[string]bool),
+	}
+}
+
+func (s *sharedStore) Get(ctx context.Context, key string) (*mailer.SendResult, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.results[key], nil
+}
+
+func (s *sharedStore) Put(ctx context.Context, key string, result *mailer.SendResult, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[key] = result
+	delete(s.claims, key)
+	return nil
+}
+
+func (s *sharedStore) Claim(ctx context.Context, key string, ttl time.Duration) (*mailer.SendResult, bool, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if result, ok := s.results[key]; ok {
+		return result, false, nil
+	}
+	if s.claims[key] {
+		return nil, false, nil
+	}
+	s.claims[key] = true
+	return nil, true, nil
+}
+
+func (s *sharedStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, key)
+	return nil
+}
+
+// newIdempotentClient returns a client sending through a new mock provider
+// and recording results in store, or in memory when store is nil.
+func newIdempotentClient(t *testing.T, store mailer.IdempotencyStore) (*mailer.Client, *mailertest.MockProvider) {
+	t.Helper()
+
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+	client, err := mailer.New(config,
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithIdempotency(store, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, mock
+}
+
+func keyedEmail(key string) *mailer.Email {
+	email := validEmail()
+	email.IdempotencyKey = key
+	return email
+}
+
+func TestSendReplaysIdempotentSends(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+	ctx := context.Background()
+
+	var results []*mailer.SendResult
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+	client, err := mailer.New(config,
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithOnSent(func(ctx context.Context, email *mailer.Email, result *mailer.SendResult) {
+			results = append(results, result)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer client.Close()
+
+	for range 3 {
+		if err := client.Send(ctx, keyedEmail("order-1")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := client.SendBatch(ctx, []*mailer.Email{keyedEmail("order-1"), keyedEmail("order-2"), keyedEmail("order-2")}); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	if mock.Count() != 2 {
+		t.Errorf("provider received %d emails, want 2", mock.Count())
+	}
+	if len(results) != 3 || results[1] != results[0] || results[2] != results[0] {
+		t.Errorf("OnSent got results %v, want the first send's result three times", results)
+	}
+}
+
+func TestSendBatchLocksKeysWithoutDeadlock(t *testing.T) {
+	client, mock := newIdempotentClient(t, newSharedStore(time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Pairs of batches claiming the same keys in opposite orders
+	const pairs = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*pairs)
+	for n := range pairs {
+		a, b := fmt.Sprintf("a-%d", n), fmt.Sprintf("b-%d", n)
+		for _, keys := range [][]string{{a, b}, {b, a}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- client.SendBatch(ctx, []*mailer.Email{keyedEmail(keys[0]), keyedEmail(keys[1])})
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("SendBatch: %v", err)
+		}
+	}
+	if mock.Count() != 2*pairs {
+		t.Errorf("provider received %d emails, want %d", mock.Count(), 2*pairs)
+	}
+}
+
+func TestSendRejectsKeysClaimedByAnotherClient(t *testing.T) {
+	store := newSharedStore(0)
+	client, mock := newIdempotentClient(t, store)
+	ctx := context.Background()
+
+	// Another process is sending with the key
+	if _, claimed, _ := store.Claim(ctx, "order-1", time.Minute); !claimed {
+		t.Fatal("could not claim the key")
+	}
+
+	if err := client.Send(ctx, keyedEmail("order-1")); !errors.Is(err, mailer.ErrIdempotencyKeyClaimed) {
+		t.Fatalf("Send got error %v, want ErrIdempotencyKeyClaimed", err)
+	}
+	err := client.SendBatch(ctx, []*mailer.Email{keyedEmail("order-1"), keyedEmail("order-2")})
+	var batchErr *mailer.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Failed != 1 || batchErr.Errors[0].Index != 0 || !errors.Is(batchErr.Errors[0].Error, mailer.ErrIdempotencyKeyClaimed) {
+		t.Fatalf("SendBatch got error %v, want the first email rejected", err)
+	}
+	if mock.Count() != 1 {
+		t.Errorf("provider received %d emails, want 1", mock.Count())
+	}
+
+	// The claim is replaced by the result once the other process is done
+	if err := store.Put(ctx, "order-1", &mailer.SendResult{MessageID: "sent-elsewhere"}, time.Hour); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := client.Send(ctx, keyedEmail("order-1")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if mock.Count() != 1 {
+		t.Errorf("provider received %d emails, want 1", mock.Count())
+	}
+}
+
+func TestSendReleasesClaimWhenSendFails(t *testing.T) {
+	store := newSharedStore(0)
+	client, mock := newIdempotentClient(t, store)
+	ctx := context.Background()
+
+	mock.FailNext(errors.New("provider down"))
+	if err := client.Send(ctx, keyedEmail("order-1")); err == nil {
+		t.Fatal("Send succeeded, want the injected failure")
+	}
+	if err := client.Send(ctx, keyedEmail("order-1")); err != nil {
+		t.Fatalf("retried Send: %v", err)
+	}
+	if mock.Count() != 1 {
+		t.Errorf("provider received %d emails, want 1", mock.Count())
+	}
+}
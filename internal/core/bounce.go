@@ -0,0 +1,17 @@
+package core
+
+import "strings"
+
+// ReturnPath returns the envelope sender (Return-Path) of an email sent from
+// from with bounces going to domain: the sender's local part at domain. It
+// returns the sender's address when domain is empty.
+func ReturnPath(from Address, domain string) string {
+	if domain == "" {
+		return from.Email
+	}
+	local := from.Email
+	if at := strings.LastIndex(local, "@"); at >= 0 {
+		local = local[:at]
+	}
+	return local + "@" + domain
+}
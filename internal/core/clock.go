@@ -0,0 +1,11 @@
+package core
+
+import "time"
+
+// ClockSettable is implemented by providers that write the current time into
+// the messages they build, such as the Date header and Message-ID, so that
+// they can use the client's clock.
+type ClockSettable interface {
+	// SetClock makes the provider read the current time from now.
+	SetClock(now func() time.Time)
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// SubscriptionStatus is whether a contact receives the emails of a topic.
+type SubscriptionStatus string
+
+const (
+	// SubscriptionOptIn subscribes to the topic.
+	SubscriptionOptIn SubscriptionStatus = "OPT_IN"
+
+	// SubscriptionOptOut unsubscribes from the topic.
+	SubscriptionOptOut SubscriptionStatus = "OPT_OUT"
+)
+
+// ContactList is a list of contacts subscribed to its topics, such as a
+// newsletter list with "weekly-digest" and "product-updates" topics.
+type ContactList struct {
+	// Name identifies the list.
+	Name string
+
+	// Description describes the list (optional).
+	Description string
+
+	// Topics are the subscription topics of the list.
+	Topics []ContactTopic
+}
+
+// ContactTopic is a subscription topic of a contact list.
+type ContactTopic struct {
+	// Name identifies the topic within its list.
+	Name string
+
+	// DisplayName is the name shown to contacts, e.g. on the provider's
+	// subscription preference page.
+	DisplayName string
+
+	// Description describes the topic to contacts (optional).
+	Description string
+
+	// DefaultStatus is the status of contacts without a preference for the
+	// topic.
+	DefaultStatus SubscriptionStatus
+}
+
+// Contact is a member of a contact list.
+type Contact struct {
+	// Email is the contact's address.
+	Email string
+
+	// Topics are the contact's explicit topic preferences, by topic name.
+	// Topics without one take their default status.
+	Topics map[string]SubscriptionStatus
+
+	// UnsubscribeAll unsubscribes the contact from every topic of the list.
+	UnsubscribeAll bool
+
+	// Attributes is arbitrary JSON data stored with the contact (optional).
+	Attributes string
+
+	// UpdatedAt is when the contact was last changed; set by the provider.
+	UpdatedAt time.Time
+}
+
+// ContactListManager is implemented by providers that manage contact lists
+// and topic subscriptions natively.
+type ContactListManager interface {
+	// CreateContactList creates a contact list with its topics.
+	CreateContactList(ctx context.Context, list ContactList) error
+
+	// DeleteContactList deletes a contact list and its contacts.
+	DeleteContactList(ctx context.Context, name string) error
+
+	// AddContact adds a contact to a list.
+	AddContact(ctx context.Context, list string, contact Contact) error
+
+	// UpdateContact replaces the topic preferences, unsubscribe-all flag and
+	// attributes of a contact in a list.
+	UpdateContact(ctx context.Context, list string, contact Contact) error
+
+	// RemoveContact removes the contact with the given address from a list.
+	RemoveContact(ctx context.Context, list, email string) error
+
+	// Contact returns the contact with the given address in a list.
+	Contact(ctx context.Context, list, email string) (*Contact, error)
+
+	// Contacts returns the contacts of a list, or only those subscribed to
+	// topic when it is not empty.
+	Contacts(ctx context.Context, list, topic string) ([]Contact, error)
+}
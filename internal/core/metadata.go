@@ -0,0 +1,85 @@
+package core
+
+import "strings"
+
+// MetadataCategory is the Email.Metadata key holding the email's category.
+const MetadataCategory = "category"
+
+// HeaderCategory is the header used as the email's category when its
+// metadata has none.
+const HeaderCategory = "X-Category"
+
+// MetadataCorrelationID is the Email.Metadata key holding the ID that ties
+// together the send of an email, its logs, its result and the delivery
+// events reported for it. It is not reserved, so providers pass it on as a
+// tag or custom argument and return it with their webhook events.
+const MetadataCorrelationID = "correlation_id"
+
+// Reserved Email.Metadata keys read or set by the client. They use a
+// "mailer." prefix so they do not collide with caller metadata.
+const (
+	// MetadataIPPool holds the IP pool selected for the email's category.
+	MetadataIPPool = "mailer.ip_pool"
+
+	// MetadataProvider names the configured provider a send is forced to use.
+	MetadataProvider = "mailer.provider"
+
+	// MetadataSESTemplate names a stored SES template the email is rendered
+	// from by SES, with the email's substitutions as template data.
+	MetadataSESTemplate = "mailer.ses_template"
+
+	// MetadataTemplate holds the name of the template the email was rendered from.
+	MetadataTemplate = "mailer.template"
+
+	// MetadataTextOnly, set to "true", sends the email without its HTML part.
+	MetadataTextOnly = "mailer.text_only"
+)
+
+// MetadataPrefix prefixes the reserved metadata keys.
+const MetadataPrefix = "mailer."
+
+// CallerMetadata returns the email's metadata without the reserved keys, for
+// providers that attach it to messages as tags or custom arguments, or nil
+// when there is none.
+func (e *Email) CallerMetadata() map[string]string {
+	var metadata map[string]string
+	for key, value := range e.Metadata {
+		if strings.HasPrefix(key, MetadataPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// Category returns the email's category from its "category" metadata,
+// falling back to the X-Category header.
+func (e *Email) Category() string {
+	if category := e.Metadata[MetadataCategory]; category != "" {
+		return category
+	}
+	return e.Headers[HeaderCategory]
+}
+
+// CorrelationID returns the email's correlation ID from its
+// "correlation_id" metadata, or an empty string when it has none.
+func (e *Email) CorrelationID() string {
+	return e.Metadata[MetadataCorrelationID]
+}
+
+// WithMetadata returns a copy of the email with key set to value. The
+// original email and its metadata map are not modified.
+func (e *Email) WithMetadata(key, value string) *Email {
+	metadata := make(map[string]string, len(e.Metadata)+1)
+	for k, v := range e.Metadata {
+		metadata[k] = v
+	}
+	metadata[key] = value
+
+	copied := *e
+	copied.Metadata = metadata
+	return &copied
+}
@@ -0,0 +1,563 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Content transfer encodings supported by BuildMessage.
+const (
+	// TransferEncodingAuto selects an encoding per part based on its content.
+	TransferEncodingAuto = "auto"
+
+	// TransferEncodingQuotedPrintable encodes parts as quoted-printable.
+	TransferEncodingQuotedPrintable = "quoted-printable"
+
+	// TransferEncodingBase64 encodes parts as base64.
+	TransferEncodingBase64 = "base64"
+
+	// TransferEncoding7Bit sends ASCII-only parts unencoded.
+	TransferEncoding7Bit = "7bit"
+
+	// TransferEncoding8Bit sends parts unencoded; requires an 8BITMIME-capable server.
+	TransferEncoding8Bit = "8bit"
+)
+
+// DefaultCharset is the charset used when none is configured.
+const DefaultCharset = "UTF-8"
+
+// maxLineLength is the RFC 5322 limit for line length excluding CRLF.
+const maxLineLength = 998
+
+// base64LineLength is the line length used when wrapping base64 content.
+const base64LineLength = 76
+
+// MIMEOptions controls how BuildMessage encodes a message.
+type MIMEOptions struct {
+	// Charset is the charset of text parts and encoded headers (default UTF-8).
+	Charset string
+
+	// TransferEncoding is the content transfer encoding of text parts
+	// (default TransferEncodingAuto).
+	TransferEncoding string
+
+	// MessageID is written as the Message-ID header when set, without angle brackets.
+	MessageID string
+
+	// Date is the message date (default: now).
+	Date time.Time
+
+	// Deterministic derives MIME boundaries from the content they separate
+	// and, when MessageID is empty and MessageIDDomain is set, the
+	// Message-ID from the message's headers and body, so that the same
+	// email and options always produce the same bytes.
+	Deterministic bool
+
+	// MessageIDDomain is the domain of Message-IDs derived in deterministic
+	// mode.
+	MessageIDDomain string
+}
+
+// Validate checks that the charset and transfer encoding are supported.
+func (o MIMEOptions) Validate() error {
+	if o.Charset != "" {
+		if _, err := htmlindex.Get(o.Charset); err != nil {
+			return NewValidationErrorWithValue("charset", "unsupported charset", o.Charset)
+		}
+	}
+
+	switch o.TransferEncoding {
+	case "", TransferEncodingAuto, TransferEncodingQuotedPrintable, TransferEncodingBase64,
+		TransferEncoding7Bit, TransferEncoding8Bit:
+		return nil
+	default:
+		return NewValidationErrorWithValue("transfer_encoding", "unsupported transfer encoding", o.TransferEncoding)
+	}
+}
+
+// MIMEOptionsFromSettings reads the "charset", "transfer_encoding" and
+// "deterministic_mime" provider settings.
+func MIMEOptionsFromSettings(settings ProviderSettings) MIMEOptions {
+	return MIMEOptions{
+		Charset:          settings.Get("charset"),
+		TransferEncoding: settings.Get("transfer_encoding"),
+		Deterministic:    settings.Get("deterministic_mime") == "true",
+	}
+}
+
+// BuildMessage serializes the email as an RFC 5322 message with MIME parts.
+// Non-ASCII header values are encoded as RFC 2047 encoded words and text
+// parts are converted to the configured charset and transfer encoding.
+// Inline attachments with a Content-ID are placed in multipart/related with
+// an HTML body, and other attachments in multipart/mixed.
+func BuildMessage(email *Email, opts MIMEOptions) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	charset := opts.Charset
+	if charset == "" {
+		charset = DefaultCharset
+	}
+
+	date := opts.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	// Body, wrapped in multipart/related with the inline images it
+	// references and in multipart/mixed with the other attachments
+	header, content, err := buildBody(email, charset, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	related, attached := splitAttachments(email)
+	if len(related) > 0 {
+		header, content, err = wrapParts("multipart/related", map[string]string{"type": mediaType(header)},
+			header, content, related, charset, opts.Deterministic)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(attached) > 0 {
+		header, content, err = wrapParts("multipart/mixed", nil, header, content, attached, charset, opts.Deterministic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Headers other than the date and Message-ID
+	var headers bytes.Buffer
+	writeHeader(&headers, "From", email.From.String())
+	if len(email.To) > 0 {
+		writeHeader(&headers, "To", joinAddresses(email.To))
+	}
+	if len(email.CC) > 0 {
+		writeHeader(&headers, "Cc", joinAddresses(email.CC))
+	}
+
+	subject, err := encodeHeaderValue(email.Subject, charset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subject: %w", err)
+	}
+	writeHeader(&headers, "Subject", subject)
+
+	// MIME-Version, custom headers, sorted for stable output, and the body
+	var rest bytes.Buffer
+	writeHeader(&rest, "MIME-Version", "1.0")
+	keys := make([]string, 0, len(email.Headers))
+	for key := range email.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := encodeHeaderValue(email.Headers[key], charset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode header %s: %w", key, err)
+		}
+		writeHeader(&rest, key, value)
+	}
+	writePartHeaders(&rest, header)
+	rest.WriteString("\r\n")
+	rest.Write(content)
+
+	messageID := opts.MessageID
+	if messageID == "" && opts.Deterministic && opts.MessageIDDomain != "" {
+		messageID = contentHash(headers.Bytes(), rest.Bytes())[:32] + "@" + opts.MessageIDDomain
+	}
+
+	var buf bytes.Buffer
+	buf.Write(headers.Bytes())
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	if messageID != "" {
+		writeHeader(&buf, "Message-ID", "<"+messageID+">")
+	}
+	buf.Write(rest.Bytes())
+	return buf.Bytes(), nil
+}
+
+// MessageID returns the Message-ID of a message built by BuildMessage,
+// without angle brackets, or an empty string when it has none.
+func MessageID(message []byte) string {
+	headers, _, _ := bytes.Cut(message, []byte("\r\n\r\n"))
+	for _, line := range strings.Split(string(headers), "\r\n") {
+		if value, ok := strings.CutPrefix(line, "Message-ID: "); ok {
+			return strings.Trim(value, "<>")
+		}
+	}
+	return ""
+}
+
+// contentHash returns the hex SHA-256 digest of the concatenated chunks.
+func contentHash(chunks ...[]byte) string {
+	hash := sha256.New()
+	for _, chunk := range chunks {
+		hash.Write(chunk)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// mimePart is the headers and content of a part of a multipart body.
+type mimePart struct {
+	header  textproto.MIMEHeader
+	content []byte
+}
+
+// writeMultipart writes parts as a multipart body to buf and returns its
+// boundary, random or, in deterministic mode, derived from the parts.
+func writeMultipart(buf *bytes.Buffer, parts []mimePart, deterministic bool) (string, error) {
+	mw := multipart.NewWriter(buf)
+	if deterministic {
+		hash := sha256.New()
+		for _, part := range parts {
+			keys := make([]string, 0, len(part.header))
+			for key := range part.header {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(hash, "%s: %s\r\n", key, strings.Join(part.header[key], ", "))
+			}
+			hash.Write(part.content)
+		}
+		if err := mw.SetBoundary("mailer-" + hex.EncodeToString(hash.Sum(nil))[:40]); err != nil {
+			return "", err
+		}
+	}
+
+	for _, part := range parts {
+		if err := writePart(mw, part.header, part.content); err != nil {
+			return "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	return mw.Boundary(), nil
+}
+
+// splitAttachments separates the inline attachments an HTML body references
+// by Content-ID, which belong in multipart/related with the body, from the
+// other attachments.
+func splitAttachments(email *Email) (related, attached []*Attachment) {
+	for i := range email.Attachments {
+		attachment := &email.Attachments[i]
+		if attachment.Inline && attachment.ContentID != "" && email.HTMLBody != "" {
+			related = append(related, attachment)
+		} else {
+			attached = append(attached, attachment)
+		}
+	}
+	return related, attached
+}
+
+// wrapParts returns the headers and content of a multipart part of the given
+// type, with the part described by header and content first, followed by
+// the attachments.
+func wrapParts(multipartType string, params map[string]string, header textproto.MIMEHeader, content []byte, attachments []*Attachment, charset string, deterministic bool) (textproto.MIMEHeader, []byte, error) {
+	parts := []mimePart{{header, content}}
+	for _, attachment := range attachments {
+		header, content, err := encodeAttachment(attachment, charset)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, mimePart{header, content})
+	}
+
+	var buf bytes.Buffer
+	boundary, err := writeMultipart(&buf, parts, deterministic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	typeParams := map[string]string{"boundary": boundary}
+	for key, value := range params {
+		typeParams[key] = value
+	}
+	wrapped := make(textproto.MIMEHeader)
+	wrapped.Set("Content-Type", mime.FormatMediaType(multipartType, typeParams))
+	return wrapped, buf.Bytes(), nil
+}
+
+// mediaType returns the media type of a part without its parameters.
+func mediaType(header textproto.MIMEHeader) string {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType
+}
+
+// buildBody returns the headers and content of the message body: a single
+// text part, or multipart/alternative when both bodies are present.
+func buildBody(email *Email, charset string, opts MIMEOptions) (textproto.MIMEHeader, []byte, error) {
+	switch {
+	case email.HTMLBody != "" && email.TextBody != "":
+		var parts []mimePart
+		for _, part := range []struct{ mediaType, body string }{
+			{"text/plain", email.TextBody},
+			{"text/html", email.HTMLBody},
+		} {
+			header, content, err := encodeTextPart(part.mediaType, part.body, charset, opts.TransferEncoding)
+			if err != nil {
+				return nil, nil, err
+			}
+			parts = append(parts, mimePart{header, content})
+		}
+
+		var buf bytes.Buffer
+		boundary, err := writeMultipart(&buf, parts, opts.Deterministic)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": boundary}))
+		return header, buf.Bytes(), nil
+	case email.HTMLBody != "":
+		return encodeTextPart("text/html", email.HTMLBody, charset, opts.TransferEncoding)
+	default:
+		return encodeTextPart("text/plain", email.TextBody, charset, opts.TransferEncoding)
+	}
+}
+
+// encodeAttachment returns the headers and base64 content of an attachment part.
+func encodeAttachment(attachment *Attachment, charset string) (textproto.MIMEHeader, []byte, error) {
+	if attachment.Data == nil {
+		return nil, nil, NewValidationErrorWithValue("attachments", "attachment has no data", attachment.Filename)
+	}
+
+	data, err := io.ReadAll(attachment.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read attachment %s: %w", attachment.Filename, err)
+	}
+
+	header, err := attachmentHeader(attachment, charset)
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Set("Content-Transfer-Encoding", TransferEncodingBase64)
+
+	var content bytes.Buffer
+	writeBase64(&content, data)
+
+	return header, content.Bytes(), nil
+}
+
+// partHeaderOrder is the order in which part headers are written at the top level.
+var partHeaderOrder = []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-ID"}
+
+// writePartHeaders writes the MIME headers of a part that forms the message body.
+func writePartHeaders(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for _, key := range partHeaderOrder {
+		if value := header.Get(key); value != "" {
+			writeHeader(buf, key, value)
+		}
+	}
+}
+
+// writePart writes a part to a multipart writer.
+func writePart(mw *multipart.Writer, header textproto.MIMEHeader, content []byte) error {
+	w, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// encodeTextPart converts body to charset and applies the transfer encoding,
+// returning the part headers and encoded content.
+func encodeTextPart(mediaType, body, charset, encoding string) (textproto.MIMEHeader, []byte, error) {
+	content, err := convertCharset(body, charset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert %s body to %s: %w", mediaType, charset, err)
+	}
+
+	if encoding == "" || encoding == TransferEncodingAuto {
+		encoding = selectTransferEncoding(content)
+	}
+	if (encoding == TransferEncoding7Bit && !isASCII(content)) || !linesWithinLimit(content) {
+		// Unencoded parts must be ASCII (7bit) and respect the line length limit
+		encoding = TransferEncodingQuotedPrintable
+	}
+
+	encoded, err := applyTransferEncoding(content, encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": charset}))
+	header.Set("Content-Transfer-Encoding", encoding)
+
+	return header, encoded, nil
+}
+
+// selectTransferEncoding picks 7bit for short-lined ASCII, base64 for content
+// that is mostly non-ASCII and quoted-printable otherwise.
+func selectTransferEncoding(content []byte) string {
+	nonASCII := 0
+	for _, b := range content {
+		if b >= utf8.RuneSelf {
+			nonASCII++
+		}
+	}
+
+	switch {
+	case nonASCII == 0 && linesWithinLimit(content):
+		return TransferEncoding7Bit
+	case nonASCII*3 > len(content):
+		return TransferEncodingBase64
+	default:
+		return TransferEncodingQuotedPrintable
+	}
+}
+
+// applyTransferEncoding encodes content and normalizes line endings to CRLF.
+func applyTransferEncoding(content []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch encoding {
+	case TransferEncodingQuotedPrintable:
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write(content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	case TransferEncodingBase64:
+		writeBase64(&buf, content)
+	default:
+		normalized := strings.ReplaceAll(string(content), "\r\n", "\n")
+		buf.WriteString(strings.ReplaceAll(normalized, "\n", "\r\n"))
+	}
+
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\r\n")) {
+		buf.WriteString("\r\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes content as base64 wrapped at 76 characters per line.
+func writeBase64(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > base64LineLength {
+		_, _ = io.WriteString(w, encoded[:base64LineLength]+"\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	if encoded != "" {
+		_, _ = io.WriteString(w, encoded+"\r\n")
+	}
+}
+
+// convertCharset converts UTF-8 text to the given charset.
+func convertCharset(s, charset string) ([]byte, error) {
+	if strings.EqualFold(charset, DefaultCharset) || isASCII([]byte(s)) {
+		return []byte(s), nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := enc.NewEncoder().String(s)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(converted), nil
+}
+
+// encodeHeaderValue converts a header value to charset and encodes it as
+// RFC 2047 encoded words when it contains non-ASCII characters.
+func encodeHeaderValue(value, charset string) (string, error) {
+	// Strip line breaks to prevent header injection
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+
+	if isASCII([]byte(value)) {
+		return value, nil
+	}
+
+	converted, err := convertCharset(value, charset)
+	if err != nil {
+		return "", err
+	}
+
+	if selectTransferEncoding(converted) == TransferEncodingBase64 {
+		return mime.BEncoding.Encode(charset, string(converted)), nil
+	}
+	return mime.QEncoding.Encode(charset, string(converted)), nil
+}
+
+// attachmentHeader returns the Content-Type, Content-Disposition and
+// Content-ID headers of an attachment part. The filename is sent as an RFC
+// 2231 parameter in Content-Disposition and, for clients that only read the
+// Content-Type name, as an RFC 2047 encoded word.
+func attachmentHeader(attachment *Attachment, charset string) (textproto.MIMEHeader, error) {
+	name, err := encodeHeaderValue(attachment.Filename, charset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attachment name %s: %w", attachment.Filename, err)
+	}
+
+	disposition := "attachment"
+	if attachment.Inline {
+		disposition = "inline"
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", mime.FormatMediaType(attachment.DetectContentType(), map[string]string{"name": name}))
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	if attachment.Inline && attachment.ContentID != "" {
+		header.Set("Content-ID", "<"+attachment.ContentID+">")
+	}
+	return header, nil
+}
+
+// writeHeader writes a single header line.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+// joinAddresses formats a list of addresses for an address header.
+func joinAddresses(addresses []Address) string {
+	formatted := make([]string, len(addresses))
+	for i, addr := range addresses {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// isASCII reports whether content contains only 7-bit characters.
+func isASCII(content []byte) bool {
+	for _, b := range content {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// linesWithinLimit reports whether every line fits the RFC 5322 line length limit.
+func linesWithinLimit(content []byte) bool {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSuffix(line, []byte("\r"))) > maxLineLength {
+			return false
+		}
+	}
+	return true
+}
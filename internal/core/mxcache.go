@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMXCacheTTL is how long MX lookups are cached when no TTL is
+// configured.
+const DefaultMXCacheTTL = 5 * time.Minute
+
+// DefaultMXCacheStaleTTL is how long an expired MX lookup is still served
+// when refreshing it fails, when no stale TTL is configured.
+const DefaultMXCacheStaleTTL = time.Hour
+
+// maxMXCacheEntries is the number of cached domains above which entries
+// past their stale TTL are swept.
+const maxMXCacheEntries = 10000
+
+// MXResolver looks up MX records, as net.Resolver does.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// MXCacheStats are the counters of an MX cache since it was created.
+type MXCacheStats struct {
+	// Hits is the number of lookups answered from the cache.
+	Hits int64
+
+	// Misses is the number of lookups sent to the resolver.
+	Misses int64
+
+	// Stale is the number of lookups answered with an expired entry because
+	// refreshing it failed.
+	Stale int64
+
+	// Entries is the number of domains cached.
+	Entries int
+}
+
+// MXCacher is implemented by providers that cache the MX records of
+// recipient domains.
+type MXCacher interface {
+	// MXCacheStats returns the counters of the provider's MX cache.
+	MXCacheStats() MXCacheStats
+
+	// PreresolveMX looks up and caches the MX records of the domains.
+	PreresolveMX(ctx context.Context, domains []string) error
+}
+
+// mxCacheEntry is a cached MX lookup. A domain without MX records is cached
+// with err set to the resolver's not-found error.
+type mxCacheEntry struct {
+	records []*net.MX
+	err     error
+	expires time.Time
+}
+
+// MXCache caches the MX records of domains for a TTL, so that sends to
+// high-volume domains do not each look them up. An expired entry is served
+// for up to the stale TTL while the resolver fails. Its methods are safe
+// for concurrent use.
+type MXCache struct {
+	resolver MXResolver
+	ttl      time.Duration
+	staleTTL time.Duration
+	now      func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]mxCacheEntry
+	stats   MXCacheStats
+}
+
+// NewMXCache returns an MX cache looking records up with resolver, caching
+// them for ttl and serving them stale for up to staleTTL past it. A zero
+// ttl or staleTTL uses its default.
+func NewMXCache(resolver MXResolver, ttl, staleTTL time.Duration) *MXCache {
+	if ttl <= 0 {
+		ttl = DefaultMXCacheTTL
+	}
+	if staleTTL <= 0 {
+		staleTTL = DefaultMXCacheStaleTTL
+	}
+	return &MXCache{
+		resolver: resolver,
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		now:      time.Now,
+		entries:  make(map[string]mxCacheEntry),
+	}
+}
+
+// LookupMX returns the MX records of domain from the cache, looking them up
+// when they are not cached or have expired. Concurrent lookups of a domain
+// that is not cached may each query the resolver.
+func (c *MXCache) LookupMX(ctx context.Context, domain string) ([]*net.MX, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	now := c.now()
+
+	c.mutex.Lock()
+	entry, cached := c.entries[domain]
+	if cached && now.Before(entry.expires) {
+		c.stats.Hits++
+		c.mutex.Unlock()
+		return entry.records, entry.err
+	}
+	c.stats.Misses++
+	c.mutex.Unlock()
+
+	records, err := c.resolver.LookupMX(ctx, domain)
+
+	var dnsErr *net.DNSError
+	notFound := errors.As(err, &dnsErr) && dnsErr.IsNotFound
+	if err != nil && !notFound {
+		// Serve the expired entry while the resolver fails
+		if cached && now.Before(entry.expires.Add(c.staleTTL)) {
+			c.mutex.Lock()
+			c.stats.Stale++
+			c.mutex.Unlock()
+			return entry.records, entry.err
+		}
+		return nil, err
+	}
+
+	c.store(domain, mxCacheEntry{records: records, err: err, expires: now.Add(c.ttl)}, now)
+	return records, err
+}
+
+// Preresolve looks up the MX records of the domains that are not cached or
+// have expired, e.g. ahead of a campaign to high-volume domains, and
+// returns the errors of the lookups that failed. Domains without MX records
+// are not errors.
+func (c *MXCache) Preresolve(ctx context.Context, domains []string) error {
+	var errs []error
+	for _, domain := range domains {
+		_, err := c.LookupMX(ctx, domain)
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the cache's counters.
+func (c *MXCache) Stats() MXCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// store caches the lookup of domain, first sweeping the entries past their
+// stale TTL when the cache is full.
+func (c *MXCache) store(domain string, entry mxCacheEntry, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= maxMXCacheEntries {
+		for cached, old := range c.entries {
+			if !now.Before(old.expires.Add(c.staleTTL)) {
+				delete(c.entries, cached)
+			}
+		}
+	}
+	c.entries[domain] = entry
+}
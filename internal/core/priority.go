@@ -0,0 +1,47 @@
+package core
+
+// Headers returns the headers that convey the priority to mail clients:
+// X-Priority, read by most clients, Importance, read by Outlook, and the
+// Priority header of RFC 2156. Normal and low priority have no headers: low
+// is the zero value, so emails that never set a priority would otherwise
+// all be flagged as low priority.
+func (p Priority) Headers() map[string]string {
+	switch p {
+	case PriorityUrgent:
+		return map[string]string{"X-Priority": "1", "Importance": "high", "Priority": "urgent"}
+	case PriorityHigh:
+		return map[string]string{"X-Priority": "2", "Importance": "high", "Priority": "urgent"}
+	default:
+		return nil
+	}
+}
+
+// WithPriorityHeaders returns a copy of the email with the headers of its
+// priority added. Headers the email already has, compared
+// case-insensitively, are kept. The email itself is returned when there is
+// nothing to add.
+func (e *Email) WithPriorityHeaders() *Email {
+	var added map[string]string
+	for key, value := range e.Priority.Headers() {
+		if hasHeader(e.Headers, key) {
+			continue
+		}
+		if added == nil {
+			added = make(map[string]string, 3)
+		}
+		added[key] = value
+	}
+	if added == nil {
+		return e
+	}
+
+	withHeaders := *e
+	withHeaders.Headers = make(map[string]string, len(e.Headers)+len(added))
+	for key, value := range e.Headers {
+		withHeaders.Headers[key] = value
+	}
+	for key, value := range added {
+		withHeaders.Headers[key] = value
+	}
+	return &withHeaders
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"net/http"
+)
+
+// RequestSigner adds authentication to an outgoing provider API request, such
+// as headers or an HMAC signature required by a gateway in front of the
+// provider. It runs after the provider's own authentication has been added.
+// Signers that read the body must leave it readable, e.g. by reading a copy
+// obtained from req.GetBody. A returned error fails the request.
+type RequestSigner func(req *http.Request) error
+
+// RequestSignable is implemented by providers that make HTTP API calls and
+// can pass them through a RequestSigner.
+type RequestSignable interface {
+	// SetRequestSigner makes the provider sign every API request with signer.
+	SetRequestSigner(signer RequestSigner)
+}
+
+// SigningTransport returns a transport that signs each request with signer
+// before sending it with base, or http.DefaultTransport if base is nil.
+func SigningTransport(base http.RoundTripper, signer RequestSigner) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signingTransport{base: base, signer: signer}
+}
+
+// signingTransport implements SigningTransport.
+type signingTransport struct {
+	base   http.RoundTripper
+	signer RequestSigner
+}
+
+// RoundTrip signs a copy of req, leaving the caller's request unmodified as
+// http.RoundTripper requires, and sends it.
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+	if err := t.signer(signed); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// CloseIdleConnections closes the idle connections of the base transport, so
+// that http.Client.CloseIdleConnections reaches it through the signer.
+func (t *signingTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
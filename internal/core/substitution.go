@@ -0,0 +1,57 @@
+package core
+
+import (
+	"html"
+	"strings"
+)
+
+// SubstitutionProvider is implemented by providers that apply
+// Email.Substitutions natively.
+type SubstitutionProvider interface {
+	// SupportsSubstitutions reports whether the provider substitutes
+	// %recipient.<key>% tokens itself.
+	SupportsSubstitutions() bool
+}
+
+// SubstitutionToken returns the token replaced by the value for key.
+func SubstitutionToken(key string) string {
+	return "%recipient." + key + "%"
+}
+
+// Prepare returns the email as it is handed to provider: with the headers of
+// its Unsubscribe and priority added, and its substitutions applied unless
+// the provider applies them natively. A nil provider, as when building the
+// email's MIME message, applies them.
+func (e *Email) Prepare(provider Provider) *Email {
+	email := e.WithUnsubscribeHeaders().WithPriorityHeaders()
+	if native, ok := provider.(SubstitutionProvider); ok && native.SupportsSubstitutions() {
+		return email
+	}
+	return email.WithSubstitutions()
+}
+
+// WithSubstitutions returns a copy of the email with its substitution tokens
+// replaced in the subject and bodies. Values are HTML-escaped in the HTML
+// body and inserted as they are in the subject and text body. The email
+// itself is returned when it has no substitutions.
+func (e *Email) WithSubstitutions() *Email {
+	if len(e.Substitutions) == 0 {
+		return e
+	}
+
+	pairs := make([]string, 0, len(e.Substitutions)*2)
+	escaped := make([]string, 0, len(e.Substitutions)*2)
+	for key, value := range e.Substitutions {
+		pairs = append(pairs, SubstitutionToken(key), value)
+		escaped = append(escaped, SubstitutionToken(key), html.EscapeString(value))
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	substituted := *e
+	substituted.Subject = replacer.Replace(e.Subject)
+	substituted.HTMLBody = strings.NewReplacer(escaped...).Replace(e.HTMLBody)
+	substituted.TextBody = replacer.Replace(e.TextBody)
+	substituted.Substitutions = nil
+
+	return &substituted
+}
@@ -0,0 +1,30 @@
+package core
+
+import "testing"
+
+func TestWithSubstitutionsEscapesHTMLBody(t *testing.T) {
+	email := &Email{
+		Subject:       "Hi %recipient.name%",
+		HTMLBody:      "<p>Hi %recipient.name%</p>",
+		TextBody:      "Hi %recipient.name%",
+		Substitutions: map[string]string{"name": `Tom & "Jerry" <script>`},
+	}
+
+	substituted := email.WithSubstitutions()
+
+	if want := `Hi Tom & "Jerry" <script>`; substituted.Subject != want {
+		t.Errorf("Subject = %q, want %q", substituted.Subject, want)
+	}
+	if want := `Hi Tom & "Jerry" <script>`; substituted.TextBody != want {
+		t.Errorf("TextBody = %q, want %q", substituted.TextBody, want)
+	}
+	if want := "<p>Hi Tom &amp; &#34;Jerry&#34; &lt;script&gt;</p>"; substituted.HTMLBody != want {
+		t.Errorf("HTMLBody = %q, want %q", substituted.HTMLBody, want)
+	}
+	if substituted.Substitutions != nil {
+		t.Errorf("Substitutions = %v, want nil", substituted.Substitutions)
+	}
+	if email.HTMLBody != "<p>Hi %recipient.name%</p>" {
+		t.Errorf("original HTMLBody changed to %q", email.HTMLBody)
+	}
+}
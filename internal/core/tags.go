@@ -0,0 +1,40 @@
+package core
+
+import "strconv"
+
+// MaxTags is the number of tags an email may have, the number of categories
+// SendGrid accepts per message.
+const MaxTags = 10
+
+// MaxTagLength is the length in bytes a tag may have, the length Mailgun
+// accepts.
+const MaxTagLength = 128
+
+// validateTags checks that the tags are within the limits providers accept:
+// at most MaxTags distinct, non-empty tags of printable ASCII characters and
+// at most MaxTagLength bytes each.
+func validateTags(tags []string) error {
+	if len(tags) > MaxTags {
+		return NewValidationError("tags", "at most "+strconv.Itoa(MaxTags)+" tags are allowed")
+	}
+
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		if tag == "" {
+			return NewValidationError("tags", "tags must not be empty")
+		}
+		if len(tag) > MaxTagLength {
+			return NewValidationErrorWithValue("tags", "tag longer than "+strconv.Itoa(MaxTagLength)+" characters", tag)
+		}
+		for i := 0; i < len(tag); i++ {
+			if tag[i] < 0x20 || tag[i] > 0x7e {
+				return NewValidationErrorWithValue("tags", "tags must be printable ASCII", tag)
+			}
+		}
+		if seen[tag] {
+			return NewValidationErrorWithValue("tags", "duplicate tag", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package core
+
+// Tracking controls whether providers track opens and clicks of an email,
+// e.g. to turn tracking off for password resets and other sensitive mail.
+// Emails without it are tracked as the provider account is configured.
+type Tracking struct {
+	// Opens tracks opens with a tracking pixel.
+	Opens bool `json:"opens"`
+
+	// Clicks tracks clicks by rewriting links through the provider.
+	Clicks bool `json:"clicks"`
+}
+
+// Disabled reports whether tracking of opens or clicks is turned off.
+func (t *Tracking) Disabled() bool {
+	return t != nil && (!t.Opens || !t.Clicks)
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"net/http"
+)
+
+// MaxIdleConnsPerHost is the number of idle connections the HTTP-based
+// providers keep to their API, and so the largest useful warm pool size.
+const MaxIdleConnsPerHost = 64
+
+// NewHTTPClient returns an HTTP client for a provider's API calls, with its
+// own transport configured like http.DefaultTransport but keeping up to
+// MaxIdleConnsPerHost idle connections. Warmed connections are kept, and
+// closing the provider's idle connections leaves those of the rest of the
+// application alone.
+func NewHTTPClient() *http.Client {
+	var transport *http.Transport
+	if base, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = base.Clone()
+	} else {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+	transport.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, MaxIdleConnsPerHost)
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+)
+
+type idleCloserTransport struct {
+	closed int
+}
+
+func (t *idleCloserTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, http.ErrNotSupported
+}
+
+func (t *idleCloserTransport) CloseIdleConnections() {
+	t.closed++
+}
+
+func TestNewHTTPClientHasItsOwnTransport(t *testing.T) {
+	client := NewHTTPClient()
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Transport = %T, want *http.Transport", client.Transport)
+	}
+	if transport == http.DefaultTransport {
+		t.Error("client shares http.DefaultTransport")
+	}
+	if transport.MaxIdleConnsPerHost != MaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, MaxIdleConnsPerHost)
+	}
+	if NewHTTPClient().Transport == transport {
+		t.Error("clients share a transport")
+	}
+}
+
+func TestSigningTransportForwardsCloseIdleConnections(t *testing.T) {
+	base := &idleCloserTransport{}
+	client := &http.Client{Transport: SigningTransport(base, func(*http.Request) error { return nil })}
+
+	client.CloseIdleConnections()
+	if base.closed != 1 {
+		t.Errorf("base transport closed idle connections %d times, want 1", base.closed)
+	}
+}
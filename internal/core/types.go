@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"mime"
 	"net/mail"
 	"path/filepath"
@@ -148,10 +149,41 @@ type Email struct {
 	Headers     map[string]string `json:"headers"`     // Custom headers
 	Priority    Priority          `json:"priority"`    // Email priority
 	Metadata    map[string]string `json:"metadata"`    // Provider-specific metadata
+
+	// Substitutions holds per-recipient values for %recipient.<key>% tokens in
+	// the subject and bodies. Providers with native support substitute them
+	// server-side; otherwise the client substitutes them before sending.
+	Substitutions map[string]string `json:"substitutions,omitempty"`
+
+	// IdempotencyKey identifies the send across retries by the caller, e.g.
+	// an outbox row ID. A send with the key of an email already sent within
+	// the idempotency TTL is not delivered again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Unsubscribe generates the List-Unsubscribe and, for one-click
+	// unsubscribe, List-Unsubscribe-Post headers. It cannot be combined with
+	// a List-Unsubscribe header in Headers.
+	Unsubscribe *Unsubscribe `json:"unsubscribe,omitempty"`
+
+	// Tracking turns open and click tracking on or off for the email,
+	// overriding the provider account's settings. Providers without
+	// per-message tracking controls, such as SMTP, ignore it. Clients
+	// reject it unless the "tracking" experimental feature is enabled.
+	Tracking *Tracking `json:"tracking,omitempty"`
+
+	// Tags label the email for segmenting provider analytics, e.g.
+	// "password-reset" or "onboarding". They are sent as Mailgun tags,
+	// the Postmark tag, SendGrid categories and SES message tags; SMTP
+	// ignores them.
+	Tags []string `json:"tags,omitempty"`
 }
 
 // Validate checks if the email has valid structure and required fields.
 func (e *Email) Validate() error {
+	if e == nil {
+		return &ValidationError{Field: "email", Message: "email is required"}
+	}
+
 	if !e.From.Valid() {
 		return &ValidationError{Field: "from", Message: "invalid or missing sender address"}
 	}
@@ -187,6 +219,24 @@ func (e *Email) Validate() error {
 		}
 	}
 
+	if e.Unsubscribe != nil {
+		if err := e.Unsubscribe.Validate(); err != nil {
+			return err
+		}
+		if hasHeader(e.Headers, HeaderListUnsubscribe) {
+			return &ValidationError{Field: "unsubscribe", Message: "cannot be combined with a List-Unsubscribe header"}
+		}
+	}
+
+	if err := validateTags(e.Tags); err != nil {
+		return err
+	}
+
+	// Emails rendered from a stored SES template take their content from it
+	if e.Metadata[MetadataSESTemplate] != "" {
+		return e.ValidateSESTemplate()
+	}
+
 	if strings.TrimSpace(e.Subject) == "" {
 		return &ValidationError{Field: "subject", Message: "subject is required"}
 	}
@@ -198,6 +248,21 @@ func (e *Email) Validate() error {
 	return nil
 }
 
+// ValidateSESTemplate checks that an email rendered from a stored SES
+// template carries nothing SES would drop: SendTemplatedEmail takes neither
+// attachments nor custom headers, including those of Unsubscribe.
+func (e *Email) ValidateSESTemplate() error {
+	switch {
+	case e.HasAttachments():
+		return &ValidationError{Field: "attachments", Message: "emails sent with a stored SES template cannot have attachments"}
+	case len(e.Headers) > 0:
+		return &ValidationError{Field: "headers", Message: "emails sent with a stored SES template cannot have custom headers"}
+	case e.Unsubscribe != nil:
+		return &ValidationError{Field: "unsubscribe", Message: "emails sent with a stored SES template cannot have List-Unsubscribe headers"}
+	}
+	return nil
+}
+
 // HasAttachments returns true if the email has any attachments.
 func (e *Email) HasAttachments() bool {
 	return len(e.Attachments) > 0
@@ -261,6 +326,20 @@ type TemplateRequest struct {
 
 	// Metadata contains arbitrary data for tracking and analytics.
 	Metadata map[string]interface{}
+
+	// IdempotencyKey identifies the send across retries by the caller; see
+	// Email.IdempotencyKey.
+	IdempotencyKey string
+
+	// Unsubscribe generates the List-Unsubscribe headers; see
+	// Email.Unsubscribe.
+	Unsubscribe *Unsubscribe
+
+	// Tracking turns open and click tracking on or off; see Email.Tracking.
+	Tracking *Tracking
+
+	// Tags label the email for provider analytics; see Email.Tags.
+	Tags []string
 }
 
 // TemplateOptions provides additional options for template rendering.
@@ -322,6 +401,9 @@ type SendResult struct {
 	// Timestamp when the email was accepted by the provider.
 	Timestamp time.Time
 
+	// CorrelationID is the correlation ID of the email, if it has one.
+	CorrelationID string
+
 	// Metadata contains provider-specific information.
 	Metadata map[string]interface{}
 }
@@ -341,6 +423,29 @@ type BatchResult struct {
 	Provider string
 }
 
+// Results returns an iterator over the results of successfully sent emails.
+func (r *BatchResult) Results() iter.Seq[*SendResult] {
+	return func(yield func(*SendResult) bool) {
+		for _, result := range r.Successful {
+			if !yield(result) {
+				return
+			}
+		}
+	}
+}
+
+// Failures returns an iterator over the failed emails, keyed by their index
+// in the original batch.
+func (r *BatchResult) Failures() iter.Seq2[int, BatchFailure] {
+	return func(yield func(int, BatchFailure) bool) {
+		for _, failure := range r.Failed {
+			if !yield(failure.Index, failure) {
+				return
+			}
+		}
+	}
+}
+
 // BatchFailure represents a failed email in a batch operation.
 type BatchFailure struct {
 	// Index is the position of the failed email in the original batch.
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Unsubscribe headers generated from Email.Unsubscribe.
+const (
+	// HeaderListUnsubscribe lists the ways to unsubscribe (RFC 2369).
+	HeaderListUnsubscribe = "List-Unsubscribe"
+
+	// HeaderListUnsubscribePost marks the List-Unsubscribe URL as accepting
+	// one-click unsubscribe requests (RFC 8058).
+	HeaderListUnsubscribePost = "List-Unsubscribe-Post"
+)
+
+// Unsubscribe describes how recipients unsubscribe from an email. It is
+// sent as the List-Unsubscribe header and, for one-click unsubscribe, the
+// List-Unsubscribe-Post header, which mailbox providers such as Gmail and
+// Yahoo require of bulk senders.
+type Unsubscribe struct {
+	// MailTo is the address unsubscribe requests are emailed to.
+	MailTo string `json:"mailto,omitempty"`
+
+	// URL is the HTTPS URL of the unsubscribe endpoint.
+	URL string `json:"url,omitempty"`
+
+	// OneClick declares that URL unsubscribes the recipient on a POST
+	// request with the body "List-Unsubscribe=One-Click", without further
+	// interaction (RFC 8058). The URL must identify the recipient.
+	OneClick bool `json:"one_click,omitempty"`
+}
+
+// Validate checks that the unsubscribe address and URL are usable in the
+// List-Unsubscribe header.
+func (u *Unsubscribe) Validate() error {
+	if u.MailTo == "" && u.URL == "" {
+		return NewValidationError("unsubscribe", "a mailto address or URL is required")
+	}
+
+	if u.MailTo != "" {
+		address, err := mail.ParseAddress(u.MailTo)
+		if err != nil || address.Address != u.MailTo {
+			return NewValidationErrorWithValue("unsubscribe.mailto", "invalid address", u.MailTo)
+		}
+	}
+
+	if u.URL != "" {
+		parsed, err := url.Parse(u.URL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return NewValidationErrorWithValue("unsubscribe.url", "must be an HTTPS URL", u.URL)
+		}
+		// Commas and angle brackets would break the header's list syntax
+		if strings.ContainsAny(u.URL, "<>, \r\n") {
+			return NewValidationErrorWithValue("unsubscribe.url", "must not contain unescaped commas, spaces or angle brackets", u.URL)
+		}
+	}
+
+	if u.OneClick && u.URL == "" {
+		return NewValidationError("unsubscribe.one_click", "one-click unsubscribe requires a URL")
+	}
+	return nil
+}
+
+// Headers returns the List-Unsubscribe header and, for one-click
+// unsubscribe, the List-Unsubscribe-Post header.
+func (u *Unsubscribe) Headers() map[string]string {
+	var targets []string
+	if u.MailTo != "" {
+		targets = append(targets, "<mailto:"+u.MailTo+">")
+	}
+	if u.URL != "" {
+		targets = append(targets, "<"+u.URL+">")
+	}
+
+	headers := map[string]string{HeaderListUnsubscribe: strings.Join(targets, ", ")}
+	if u.OneClick {
+		headers[HeaderListUnsubscribePost] = "List-Unsubscribe=One-Click"
+	}
+	return headers
+}
+
+// WithUnsubscribeHeaders returns a copy of the email with the headers of
+// its Unsubscribe added in place of it. The email itself is returned when
+// it has none.
+func (e *Email) WithUnsubscribeHeaders() *Email {
+	if e.Unsubscribe == nil {
+		return e
+	}
+
+	withHeaders := *e
+	withHeaders.Headers = make(map[string]string, len(e.Headers)+2)
+	for key, value := range e.Headers {
+		withHeaders.Headers[key] = value
+	}
+	for key, value := range e.Unsubscribe.Headers() {
+		withHeaders.Headers[key] = value
+	}
+	withHeaders.Unsubscribe = nil
+	return &withHeaders
+}
+
+// hasHeader reports whether headers has the named header, compared
+// case-insensitively.
+func hasHeader(headers map[string]string, name string) bool {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// Warmer is implemented by providers that can establish and validate their
+// connections ahead of the first send.
+type Warmer interface {
+	// Warm opens up to the given number of connections to the provider,
+	// validating connectivity and credentials, so that later sends can
+	// reuse them where the provider's transport keeps them alive.
+	Warm(ctx context.Context, connections int) error
+}
+
+// IdleCloser is implemented by providers that keep idle connections alive
+// between sends.
+type IdleCloser interface {
+	// CloseIdleConnections closes the connections not in use by a send.
+	CloseIdleConnections()
+}
+
+// WarmConcurrently runs warm the given number of times concurrently, so that
+// each call opens its own connection, and returns the first error.
+func WarmConcurrently(ctx context.Context, connections int, warm func(ctx context.Context) error) error {
+	if connections < 1 {
+		connections = 1
+	}
+
+	errs := make([]error, connections)
+	var wg sync.WaitGroup
+	for i := range connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = warm(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mailgun/mailgun-go/v4"
@@ -31,6 +33,7 @@ func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
 
 	// Create Mailgun client
 	client := mailgun.NewMailgun(domain, apiKey)
+	client.SetClient(core.NewHTTPClient())
 
 	// Set base URL if provided (for EU customers)
 	if baseURL := settings.Get("base_url"); baseURL != "" {
@@ -47,13 +50,23 @@ func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
 
 // Send sends a single email using Mailgun.
 func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResult, error) {
-	// Create message - note: v4 API uses NewMessage as a standalone function
-	message := mailgun.NewMessage(email.From.String(), email.Subject, email.TextBody, email.To[0].String())
+	if len(email.To) == 0 {
+		return nil, core.NewValidationError("to", "at least one recipient is required")
+	}
+
+	// Substitutions are applied locally: recipient variables would make
+	// Mailgun send a separate copy to each To recipient
+	email = email.WithSubstitutions()
+
+	message, err := p.buildMessage(email)
+	if err != nil {
+		return nil, err
+	}
 
-	// Add additional recipients
-	for i := 1; i < len(email.To); i++ {
-		if err := message.AddRecipient(email.To[i].String()); err != nil {
-			return nil, core.NewProviderError("mailgun", "recipient_add_failed", fmt.Sprintf("failed to add recipient %s: %v", email.To[i].String(), err))
+	// Add recipients
+	for _, to := range email.To {
+		if err := message.AddRecipient(to.String()); err != nil {
+			return nil, core.NewProviderError("mailgun", "recipient_add_failed", fmt.Sprintf("failed to add recipient %s: %v", to.String(), err))
 		}
 	}
 
@@ -67,6 +80,161 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 		message.AddBCC(bcc.String())
 	}
 
+	mes, id, err := p.send(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &core.SendResult{
+		MessageID:     id,
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+		Metadata: map[string]interface{}{
+			"message": mes,
+		},
+	}, nil
+}
+
+// SendBatch sends emails that share their content and have a single To
+// recipient as one batch message, with each email's substitutions as its
+// recipient's variables, and other emails individually. Mailgun delivers a
+// separate copy to each batch recipient.
+func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
+	result := &core.BatchResult{
+		Total:    len(emails),
+		Provider: p.Name(),
+	}
+
+	for _, group := range groupBatch(emails) {
+		if len(group) == 1 {
+			email := emails[group[0]]
+			sendResult, err := p.Send(ctx, email)
+			if err != nil {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: group[0], Email: email, Error: err})
+			} else {
+				result.Successful = append(result.Successful, sendResult)
+			}
+			continue
+		}
+
+		message, err := p.buildMessage(emails[group[0]])
+		for _, i := range group {
+			if err != nil {
+				break
+			}
+			// Variables are always set, even when empty, so that batch
+			// recipients do not see each other
+			variables := make(map[string]any, len(emails[i].Substitutions))
+			for key, value := range emails[i].Substitutions {
+				variables[key] = value
+			}
+			err = message.AddRecipientAndVariables(emails[i].To[0].String(), variables)
+		}
+
+		var id string
+		if err == nil {
+			_, id, err = p.send(ctx, message)
+		} else {
+			err = core.NewProviderError("mailgun", "recipient_add_failed", err.Error())
+		}
+
+		for _, i := range group {
+			if err != nil {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: err})
+				continue
+			}
+			result.Successful = append(result.Successful, &core.SendResult{
+				MessageID:     id,
+				Provider:      p.Name(),
+				Timestamp:     time.Now(),
+				CorrelationID: emails[i].CorrelationID(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// groupBatch groups the indexes of emails that can share a batch message,
+// keeping each group within Mailgun's recipient limit. Emails with
+// attachments, CC or BCC recipients, or more than one To recipient are
+// always sent on their own.
+func groupBatch(emails []*core.Email) [][]int {
+	var groups [][]int
+	open := make(map[string]int) // content key -> index of the group being filled
+
+	for i, email := range emails {
+		if email.HasAttachments() || len(email.To) != 1 || len(email.CC) > 0 || len(email.BCC) > 0 {
+			groups = append(groups, []int{i})
+			continue
+		}
+
+		key := contentKey(email)
+		if g, ok := open[key]; ok && len(groups[g]) < mailgun.MaxNumberOfRecipients {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+
+		open[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	return groups
+}
+
+// contentKey identifies the content of an email: everything except its
+// recipients and substitutions.
+func contentKey(email *core.Email) string {
+	var key strings.Builder
+	key.WriteString(email.From.String())
+	key.WriteByte(0)
+	key.WriteString(email.Subject)
+	key.WriteByte(0)
+	key.WriteString(email.HTMLBody)
+	key.WriteByte(0)
+	key.WriteString(email.TextBody)
+	key.WriteByte(0)
+	fmt.Fprintf(&key, "%d", email.Priority)
+	key.WriteByte(0)
+	key.WriteString(email.Metadata[core.MetadataIPPool])
+	if tracking := email.Tracking; tracking != nil {
+		fmt.Fprintf(&key, "\x00%t,%t", tracking.Opens, tracking.Clicks)
+	}
+	fmt.Fprintf(&key, "\x00%d tags", len(email.Tags))
+	for _, tag := range email.Tags {
+		key.WriteString("\x00" + tag)
+	}
+
+	metadata := make([]string, 0, len(email.Metadata))
+	for name, value := range email.CallerMetadata() {
+		metadata = append(metadata, name+"="+value)
+	}
+	sort.Strings(metadata)
+	for _, variable := range metadata {
+		key.WriteByte(0)
+		key.WriteString(variable)
+	}
+
+	headers := make([]string, 0, len(email.Headers))
+	for name, value := range email.Headers {
+		headers = append(headers, name+":"+value)
+	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		key.WriteByte(0)
+		key.WriteString(header)
+	}
+
+	return key.String()
+}
+
+// buildMessage converts the content of an email to a Mailgun message without
+// recipients.
+func (p *Provider) buildMessage(email *core.Email) (*mailgun.Message, error) {
+	// Create message - note: v4 API uses NewMessage as a standalone function
+	message := mailgun.NewMessage(email.From.String(), email.Subject, email.TextBody)
+
 	// Set HTML body if provided
 	if email.HTMLBody != "" {
 		message.SetHTML(email.HTMLBody)
@@ -77,20 +245,42 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 		message.AddHeader(key, value)
 	}
 
-	// Set priority headers if specified
-	switch email.Priority {
-	case core.PriorityHigh:
-		message.AddHeader("X-Priority", "2")
-		message.AddHeader("Importance", "high")
-	case core.PriorityUrgent:
-		message.AddHeader("X-Priority", "1")
-		message.AddHeader("Importance", "high")
-	case core.PriorityLow:
-		message.AddHeader("X-Priority", "4")
-		message.AddHeader("Importance", "low")
+	// Test mode makes Mailgun accept the message without delivering it
+	if p.config.Get("test_mode") == "true" {
+		message.EnableTestMode()
+	}
+
+	// Send from the IP pool selected for the email's category; mailgun-go has
+	// no o:sending-ip-pool option, so the equivalent header is used
+	if pool := email.Metadata[core.MetadataIPPool]; pool != "" {
+		message.AddHeader("X-Mailgun-Sending-Ip-Pool", pool)
+	}
+
+	// Send tags as o:tag, which Mailgun reports statistics by
+	if len(email.Tags) > mailgun.MaxNumberOfTags {
+		return nil, core.NewValidationError("tags", fmt.Sprintf("Mailgun accepts at most %d tags per message", mailgun.MaxNumberOfTags))
+	}
+	if len(email.Tags) > 0 {
+		if err := message.AddTag(email.Tags...); err != nil {
+			return nil, core.NewProviderError("mailgun", "invalid_tags", err.Error())
+		}
 	}
 
-	// Add attachments
+	// Turn open and click tracking on or off for the email
+	if tracking := email.Tracking; tracking != nil {
+		message.SetTrackingOpens(tracking.Opens)
+		message.SetTrackingClicks(tracking.Clicks)
+	}
+
+	// Pass metadata through as user variables, returned in webhooks
+	for key, value := range email.CallerMetadata() {
+		if err := message.AddVariable(key, value); err != nil {
+			return nil, core.NewProviderError("mailgun", "invalid_metadata", err.Error())
+		}
+	}
+
+	// Add attachments; filenames are sent as UTF-8 form-data (RFC 7578) and
+	// encoded for the outgoing message by Mailgun
 	for _, attachment := range email.Attachments {
 		if attachment.Data != nil {
 			// Read the data into a byte slice
@@ -102,46 +292,49 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 		}
 	}
 
-	// Send the email - Mailgun v4 returns 3 values: mes, id, err
+	return message, nil
+}
+
+// send submits a message - Mailgun v4 returns 3 values: mes, id, err.
+func (p *Provider) send(ctx context.Context, message *mailgun.Message) (string, string, error) {
 	mes, id, err := p.client.Send(ctx, message)
 	if err != nil {
-		return nil, core.NewProviderError("mailgun", "send_failed", err.Error())
+		return "", "", core.NewProviderError("mailgun", "send_failed", err.Error())
 	}
+	return mes, id, nil
+}
 
-	return &core.SendResult{
-		MessageID: id,
-		Provider:  p.Name(),
-		Timestamp: time.Now(),
-		Metadata: map[string]interface{}{
-			"message": mes,
-		},
-	}, nil
+// SetRequestSigner makes the provider sign every Mailgun API request with
+// signer.
+func (p *Provider) SetRequestSigner(signer core.RequestSigner) {
+	client := *p.client.Client()
+	client.Transport = core.SigningTransport(client.Transport, signer)
+	p.client.SetClient(&client)
 }
 
-// SendBatch sends multiple emails using Mailgun.
-func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
-	var successful []*core.SendResult
-	var failed []core.BatchFailure
+// CloseIdleConnections closes the idle keep-alive connections of the HTTP
+// client used for sending.
+func (p *Provider) CloseIdleConnections() {
+	p.client.Client().CloseIdleConnections()
+}
 
-	for i, email := range emails {
-		result, err := p.Send(ctx, email)
-		if err != nil {
-			failed = append(failed, core.BatchFailure{
-				Index: i,
-				Email: email,
-				Error: err,
-			})
-		} else {
-			successful = append(successful, result)
+// Warm opens connections to the Mailgun API with concurrent lookups of the
+// sending domain, which also validate the API key and domain. The connections
+// are kept alive by the HTTP client used for sending.
+func (p *Provider) Warm(ctx context.Context, connections int) error {
+	return core.WarmConcurrently(ctx, connections, func(ctx context.Context) error {
+		if _, err := p.client.GetDomain(ctx, p.client.Domain()); err != nil {
+			return core.NewProviderError("mailgun", "warm_error", "failed to reach Mailgun: "+err.Error())
 		}
-	}
+		return nil
+	})
+}
 
-	return &core.BatchResult{
-		Total:      len(emails),
-		Successful: successful,
-		Failed:     failed,
-		Provider:   p.Name(),
-	}, nil
+// SupportsSubstitutions reports that Mailgun substitutes %recipient.<key>%
+// tokens itself: batches pass them as recipient variables and single sends
+// apply them locally.
+func (p *Provider) SupportsSubstitutions() bool {
+	return true
 }
 
 // ValidateConfig validates the Mailgun provider configuration.
@@ -155,7 +348,10 @@ func (p *Provider) ValidateConfig() error {
 	return nil
 }
 
-// Name returns the provider name.
+// Name returns the provider name, which can be overridden with the "name" setting.
 func (p *Provider) Name() string {
+	if name := p.config.Get("name"); name != "" {
+		return name
+	}
 	return "mailgun"
 }
@@ -0,0 +1,54 @@
+package mailgun
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+func testEmail(to string) *core.Email {
+	return &core.Email{
+		From:     core.Address{Email: "sender@example.com"},
+		To:       []core.Address{{Email: to}},
+		Subject:  "Hello",
+		HTMLBody: "<p>Hello {{name}}</p>",
+	}
+}
+
+func TestGroupBatch(t *testing.T) {
+	cc := testEmail("c@example.com")
+	cc.CC = []core.Address{{Email: "copy@example.com"}}
+	otherSubject := testEmail("d@example.com")
+	otherSubject.Subject = "Goodbye"
+
+	emails := []*core.Email{
+		testEmail("a@example.com"),
+		testEmail("b@example.com"),
+		cc,
+		otherSubject,
+		testEmail("e@example.com"),
+	}
+
+	want := [][]int{{0, 1, 4}, {2}, {3}}
+	if got := groupBatch(emails); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupBatch() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildMessageRejectsTooManyTags(t *testing.T) {
+	provider, err := NewProvider(core.ProviderSettings{"api_key": "key", "domain": "example.com"})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+
+	email := testEmail("a@example.com")
+	email.Tags = []string{"a", "b", "c", "d"}
+
+	_, err = provider.(*Provider).buildMessage(email)
+	var validationErr *core.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "tags" {
+		t.Errorf("buildMessage error = %v, want a validation error for tags", err)
+	}
+}
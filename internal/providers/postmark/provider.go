@@ -0,0 +1,374 @@
+package postmark
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// defaultBaseURL is the Postmark API endpoint.
+const defaultBaseURL = "https://api.postmarkapp.com"
+
+// maxBatchSize is Postmark's limit on the number of messages in a single
+// batch request.
+const maxBatchSize = 500
+
+// Provider implements the core.Provider interface for Postmark.
+type Provider struct {
+	client  *http.Client
+	config  core.ProviderSettings
+	baseURL string
+}
+
+// NewProvider creates a new Postmark provider.
+func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
+	if settings.Get("server_token") == "" {
+		return nil, core.NewValidationError("server_token", "Postmark server token is required")
+	}
+
+	baseURL := settings.Get("base_url")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	provider := &Provider{
+		client:  core.NewHTTPClient(),
+		config:  settings,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+
+	return provider, nil
+}
+
+// message is the JSON body of a Postmark email request.
+type message struct {
+	From          string            `json:"From"`
+	To            string            `json:"To"`
+	Cc            string            `json:"Cc,omitempty"`
+	Bcc           string            `json:"Bcc,omitempty"`
+	Subject       string            `json:"Subject"`
+	Tag           string            `json:"Tag,omitempty"`
+	HTMLBody      string            `json:"HtmlBody,omitempty"`
+	TextBody      string            `json:"TextBody,omitempty"`
+	Headers       []header          `json:"Headers,omitempty"`
+	Metadata      map[string]string `json:"Metadata,omitempty"`
+	Attachments   []attachment      `json:"Attachments,omitempty"`
+	MessageStream string            `json:"MessageStream,omitempty"`
+	TrackOpens    *bool             `json:"TrackOpens,omitempty"`
+	TrackLinks    string            `json:"TrackLinks,omitempty"`
+}
+
+// header is a custom message header.
+type header struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// attachment is a base64-encoded message attachment.
+type attachment struct {
+	Name        string `json:"Name"`
+	Content     string `json:"Content"`
+	ContentType string `json:"ContentType"`
+	ContentID   string `json:"ContentID,omitempty"`
+}
+
+// response is Postmark's result for a single message. ErrorCode is zero on
+// success.
+type response struct {
+	To          string    `json:"To"`
+	SubmittedAt time.Time `json:"SubmittedAt"`
+	MessageID   string    `json:"MessageID"`
+	ErrorCode   int       `json:"ErrorCode"`
+	Message     string    `json:"Message"`
+}
+
+// Send sends a single email using Postmark.
+func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResult, error) {
+	msg, err := p.buildMessage(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp response
+	if err := p.do(ctx, http.MethodPost, "/email", msg, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != 0 {
+		return nil, apiError(resp)
+	}
+
+	return p.sendResult(email, resp), nil
+}
+
+// SendBatch sends emails through Postmark's batch endpoint, in requests of up
+// to 500 messages. Messages Postmark rejects are reported individually.
+func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
+	result := &core.BatchResult{
+		Total:    len(emails),
+		Provider: p.Name(),
+	}
+
+	for start := 0; start < len(emails); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(emails))
+
+		// Build the request, failing emails that cannot be converted
+		var messages []*message
+		var indexes []int
+		for i := start; i < end; i++ {
+			msg, err := p.buildMessage(emails[i])
+			if err != nil {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: err})
+				continue
+			}
+			messages = append(messages, msg)
+			indexes = append(indexes, i)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		var responses []response
+		err := p.do(ctx, http.MethodPost, "/email/batch", messages, &responses)
+		if err == nil && len(responses) != len(messages) {
+			err = core.NewProviderError("postmark", "invalid_response",
+				fmt.Sprintf("batch response has %d results for %d messages", len(responses), len(messages)))
+		}
+
+		for j, i := range indexes {
+			switch {
+			case err != nil:
+				result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: err})
+			case responses[j].ErrorCode != 0:
+				result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: apiError(responses[j])})
+			default:
+				result.Successful = append(result.Successful, p.sendResult(emails[i], responses[j]))
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// buildMessage converts an email to a Postmark message.
+func (p *Provider) buildMessage(email *core.Email) (*message, error) {
+	if len(email.To) == 0 {
+		return nil, core.NewValidationError("to", "at least one recipient is required")
+	}
+
+	// Postmark accepts a single tag per message; without tags the email's
+	// category is sent as its tag
+	if len(email.Tags) > 1 {
+		return nil, core.NewValidationError("tags", "Postmark accepts at most 1 tag per message")
+	}
+	tag := email.Category()
+	if len(email.Tags) == 1 {
+		tag = email.Tags[0]
+	}
+
+	msg := &message{
+		From:          email.From.String(),
+		To:            joinAddresses(email.To),
+		Cc:            joinAddresses(email.CC),
+		Bcc:           joinAddresses(email.BCC),
+		Subject:       email.Subject,
+		Tag:           tag,
+		HTMLBody:      email.HTMLBody,
+		TextBody:      email.TextBody,
+		MessageStream: p.config.Get("message_stream"),
+	}
+
+	// Add custom headers
+	for name, value := range email.Headers {
+		msg.Headers = append(msg.Headers, header{Name: name, Value: value})
+	}
+
+	// Pass metadata through for webhooks and the activity feed, leaving out
+	// the library's reserved keys
+	msg.Metadata = email.CallerMetadata()
+
+	// Turn open and click tracking on or off for the email
+	if tracking := email.Tracking; tracking != nil {
+		opens := tracking.Opens
+		msg.TrackOpens = &opens
+		msg.TrackLinks = "None"
+		if tracking.Clicks {
+			msg.TrackLinks = "HtmlAndText"
+		}
+	}
+
+	// Add attachments; inline attachments are referenced by "cid:" content IDs
+	for _, att := range email.Attachments {
+		if att.Data == nil {
+			continue
+		}
+		data, err := io.ReadAll(att.Data)
+		if err != nil {
+			return nil, core.NewProviderError("postmark", "attachment_read_failed", err.Error())
+		}
+
+		pmAttachment := attachment{
+			Name:        att.Filename,
+			Content:     base64.StdEncoding.EncodeToString(data),
+			ContentType: att.DetectContentType(),
+		}
+		if att.Inline {
+			pmAttachment.ContentID = "cid:" + att.ContentID
+		}
+		msg.Attachments = append(msg.Attachments, pmAttachment)
+	}
+
+	return msg, nil
+}
+
+// do sends a request with body, if any, as JSON to the API path and decodes
+// the response into out.
+func (p *Provider) do(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return core.NewProviderError("postmark", "encode_failed", err.Error())
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return core.NewProviderError("postmark", "request_failed", err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", p.config.Get("server_token"))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return classifyError(0, "send_error", "failed to send email: "+err.Error(), err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return classifyError(0, "send_error", "failed to read response: "+err.Error(), err)
+	}
+
+	if resp.StatusCode >= 300 {
+		// API errors carry an error code and message in the usual response body
+		var apiResp response
+		if json.Unmarshal(data, &apiResp) == nil && apiResp.Message != "" {
+			return classifyError(resp.StatusCode, fmt.Sprintf("api_error_%d", apiResp.ErrorCode), apiResp.Message, nil)
+		}
+		return classifyError(resp.StatusCode, "api_error", "Postmark API error: "+string(data), nil)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return core.NewProviderError("postmark", "invalid_response", "failed to decode response: "+err.Error())
+	}
+
+	return nil
+}
+
+// sendResult converts the successful message response to email to a send
+// result.
+func (p *Provider) sendResult(email *core.Email, resp response) *core.SendResult {
+	timestamp := resp.SubmittedAt
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return &core.SendResult{
+		MessageID:     resp.MessageID,
+		Provider:      p.Name(),
+		Timestamp:     timestamp,
+		CorrelationID: email.CorrelationID(),
+		Metadata: map[string]interface{}{
+			"to":           resp.To,
+			"submitted_at": resp.SubmittedAt,
+		},
+	}
+}
+
+// SetRequestSigner makes the provider sign every Postmark API request with
+// signer.
+func (p *Provider) SetRequestSigner(signer core.RequestSigner) {
+	p.client.Transport = core.SigningTransport(p.client.Transport, signer)
+}
+
+// Warm opens connections to the Postmark API with concurrent requests for the
+// server's details, which also validate the server token. The connections are
+// kept alive for sending.
+func (p *Provider) Warm(ctx context.Context, connections int) error {
+	return core.WarmConcurrently(ctx, connections, func(ctx context.Context) error {
+		var server struct{}
+		return p.do(ctx, http.MethodGet, "/server", nil, &server)
+	})
+}
+
+// CloseIdleConnections closes the idle keep-alive connections to the
+// Postmark API.
+func (p *Provider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
+// ValidateConfig validates the provider configuration.
+func (p *Provider) ValidateConfig() error {
+	if p.config.Get("server_token") == "" {
+		return core.NewValidationError("server_token", "Postmark server token is required")
+	}
+	return nil
+}
+
+// Name returns the provider name, which can be overridden with the "name" setting.
+func (p *Provider) Name() string {
+	if name := p.config.Get("name"); name != "" {
+		return name
+	}
+	return "postmark"
+}
+
+// apiError converts a rejected message response to a provider error.
+func apiError(resp response) *core.ProviderError {
+	return core.NewProviderError("postmark", fmt.Sprintf("api_error_%d", resp.ErrorCode), resp.Message)
+}
+
+// classifyError converts a failed request into a provider error. Rate limiting,
+// server-side and transport failures are retryable so that the client can fail
+// over; other client errors are permanent.
+func classifyError(status int, code, message string, err error) *core.ProviderError {
+	var providerErr *core.ProviderError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		providerErr = core.NewProviderError("postmark", code, message)
+	case status == http.StatusTooManyRequests:
+		providerErr = core.NewRetryableProviderError("postmark", code, message)
+	case status >= 500:
+		providerErr = core.NewTemporaryProviderError("postmark", code, message)
+	case status > 0:
+		providerErr = core.NewProviderError("postmark", code, message)
+	default:
+		// No response was received, e.g. DNS or connection failures
+		providerErr = core.NewTemporaryProviderError("postmark", code, message)
+	}
+
+	providerErr.StatusCode = status
+	providerErr.Cause = err
+
+	return providerErr
+}
+
+// joinAddresses formats addresses as a comma-separated list.
+func joinAddresses(addresses []core.Address) string {
+	result := make([]string, len(addresses))
+	for i, addr := range addresses {
+		result[i] = addr.String()
+	}
+	return strings.Join(result, ", ")
+}
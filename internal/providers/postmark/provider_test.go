@@ -0,0 +1,207 @@
+package postmark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+func newTestProvider(t *testing.T, baseURL string) *Provider {
+	t.Helper()
+
+	provider, err := NewProvider(core.ProviderSettings{"server_token": "test-token", "base_url": baseURL})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider.(*Provider)
+}
+
+func testEmail() *core.Email {
+	return &core.Email{
+		From:     core.Address{Email: "sender@example.com"},
+		To:       []core.Address{{Email: "recipient@example.com"}},
+		Subject:  "Hello",
+		TextBody: "Hello",
+	}
+}
+
+func TestBuildMessageTag(t *testing.T) {
+	p := newTestProvider(t, "")
+
+	tests := []struct {
+		name     string
+		tags     []string
+		category string
+		want     string
+		wantErr  bool
+	}{
+		{name: "no tag"},
+		{name: "category", category: "welcome", want: "welcome"},
+		{name: "tag", tags: []string{"password-reset"}, want: "password-reset"},
+		{name: "tag over category", tags: []string{"password-reset"}, category: "welcome", want: "password-reset"},
+		{name: "more than one tag", tags: []string{"password-reset", "transactional"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := testEmail()
+			email.Tags = tt.tags
+			if tt.category != "" {
+				email.Metadata = map[string]string{core.MetadataCategory: tt.category}
+			}
+
+			msg, err := p.buildMessage(email)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildMessage error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var validationErr *core.ValidationError
+				if !errors.As(err, &validationErr) || validationErr.Field != "tags" {
+					t.Errorf("buildMessage error = %v, want a validation error on tags", err)
+				}
+				return
+			}
+			if msg.Tag != tt.want {
+				t.Errorf("Tag = %q, want %q", msg.Tag, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMapsRequestAndResponse(t *testing.T) {
+	var got message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/email" {
+			t.Errorf("request = %s %s, want POST /email", r.Method, r.URL.Path)
+		}
+		if token := r.Header.Get("X-Postmark-Server-Token"); token != "test-token" {
+			t.Errorf("server token = %q, want test-token", token)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Write([]byte(`{"To":"recipient@example.com","SubmittedAt":"2024-05-01T12:00:00Z","MessageID":"pm-1","ErrorCode":0,"Message":"OK"}`))
+	}))
+	defer server.Close()
+
+	email := testEmail()
+	email.CC = []core.Address{{Name: "Copy", Email: "cc@example.com"}}
+	email.HTMLBody = "<p>Hello</p>"
+	email.Headers = map[string]string{"X-Campaign": "spring"}
+	email.Metadata = map[string]string{"tenant": "acme", core.MetadataCorrelationID: "corr-1", core.MetadataProvider: "postmark"}
+	email.Tracking = &core.Tracking{Opens: true}
+	email.Attachments = []core.Attachment{
+		{Filename: "logo.png", ContentType: "image/png", Data: strings.NewReader("png"), Inline: true, ContentID: "logo"},
+	}
+
+	result, err := newTestProvider(t, server.URL).Send(context.Background(), email)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if got.From != "sender@example.com" || got.To != "recipient@example.com" || got.Cc != "Copy <cc@example.com>" {
+		t.Errorf("addresses = %q / %q / %q", got.From, got.To, got.Cc)
+	}
+	if got.Subject != "Hello" || got.HTMLBody != "<p>Hello</p>" || got.TextBody != "Hello" {
+		t.Errorf("content = %q / %q / %q", got.Subject, got.HTMLBody, got.TextBody)
+	}
+	if len(got.Headers) != 1 || got.Headers[0] != (header{Name: "X-Campaign", Value: "spring"}) {
+		t.Errorf("Headers = %+v", got.Headers)
+	}
+	if want := map[string]string{"tenant": "acme", core.MetadataCorrelationID: "corr-1"}; !reflect.DeepEqual(got.Metadata, want) {
+		t.Errorf("Metadata = %v, want %v", got.Metadata, want)
+	}
+	if got.TrackOpens == nil || !*got.TrackOpens || got.TrackLinks != "None" {
+		t.Errorf("tracking = %v / %q, want opens and no links", got.TrackOpens, got.TrackLinks)
+	}
+	wantAttachment := attachment{Name: "logo.png", Content: "cG5n", ContentType: "image/png", ContentID: "cid:logo"}
+	if len(got.Attachments) != 1 || got.Attachments[0] != wantAttachment {
+		t.Errorf("Attachments = %+v, want %+v", got.Attachments, wantAttachment)
+	}
+
+	if result.MessageID != "pm-1" || result.Provider != "postmark" || result.CorrelationID != "corr-1" {
+		t.Errorf("result = %+v", result)
+	}
+	if want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC); !result.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", result.Timestamp, want)
+	}
+}
+
+func TestSendClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		code      string
+		retryable bool
+	}{
+		{"rejected message", http.StatusOK, `{"ErrorCode":406,"Message":"Inactive recipient"}`, "api_error_406", false},
+		{"invalid request", http.StatusUnprocessableEntity, `{"ErrorCode":300,"Message":"Invalid email request"}`, "api_error_300", false},
+		{"rate limited", http.StatusTooManyRequests, `{"ErrorCode":429,"Message":"Rate limit exceeded"}`, "api_error_429", true},
+		{"server error", http.StatusInternalServerError, `oops`, "api_error", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			_, err := newTestProvider(t, server.URL).Send(context.Background(), testEmail())
+			var providerErr *core.ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("Send error = %v, want a *core.ProviderError", err)
+			}
+			if providerErr.Code != tt.code {
+				t.Errorf("Code = %q, want %q", providerErr.Code, tt.code)
+			}
+			if core.IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", core.IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestSendBatchReportsEachMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/email/batch" {
+			t.Errorf("path = %s, want /email/batch", r.URL.Path)
+		}
+		var messages []message
+		if err := json.NewDecoder(r.Body).Decode(&messages); err != nil || len(messages) != 2 {
+			t.Errorf("batch = %d messages (%v), want 2", len(messages), err)
+		}
+		w.Write([]byte(`[{"MessageID":"pm-1","ErrorCode":0},{"ErrorCode":406,"Message":"Inactive recipient"}]`))
+	}))
+	defer server.Close()
+
+	tagged := testEmail()
+	tagged.Tags = []string{"a", "b"}
+	emails := []*core.Email{testEmail(), tagged, testEmail()}
+
+	result, err := newTestProvider(t, server.URL).SendBatch(context.Background(), emails)
+	if err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	if len(result.Successful) != 1 || result.Successful[0].MessageID != "pm-1" {
+		t.Errorf("Successful = %+v, want pm-1", result.Successful)
+	}
+	if len(result.Failed) != 2 || result.Failed[0].Index != 1 || result.Failed[1].Index != 2 {
+		t.Fatalf("Failed = %+v, want indexes 1 and 2", result.Failed)
+	}
+	var providerErr *core.ProviderError
+	if !errors.As(result.Failed[1].Error, &providerErr) || providerErr.Code != "api_error_406" {
+		t.Errorf("error of index 2 = %v, want api_error_406", result.Failed[1].Error)
+	}
+}
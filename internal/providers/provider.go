@@ -3,6 +3,7 @@ package providers
 import (
 	"github.com/lattiq/mailer"
 	"github.com/lattiq/mailer/internal/providers/mailgun"
+	"github.com/lattiq/mailer/internal/providers/postmark"
 	"github.com/lattiq/mailer/internal/providers/sendgrid"
 	"github.com/lattiq/mailer/internal/providers/ses"
 	"github.com/lattiq/mailer/internal/providers/smtp"
@@ -27,3 +28,8 @@ func NewMailgunProvider(settings mailer.ProviderSettings) (mailer.Provider, erro
 func NewSMTPProvider(settings mailer.ProviderSettings) (mailer.Provider, error) {
 	return smtp.NewProvider(settings)
 }
+
+// NewPostmarkProvider creates a new Postmark provider.
+func NewPostmarkProvider(settings mailer.ProviderSettings) (mailer.Provider, error) {
+	return postmark.NewProvider(settings)
+}
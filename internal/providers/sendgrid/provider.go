@@ -2,8 +2,17 @@ package sendgrid
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 
@@ -14,6 +23,9 @@ import (
 type Provider struct {
 	client *sendgrid.Client
 	config core.ProviderSettings
+
+	// rest makes the API calls through the provider's own transport.
+	rest *rest.Client
 }
 
 // NewProvider creates a new SendGrid provider.
@@ -28,48 +40,261 @@ func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
 	provider := &Provider{
 		client: client,
 		config: settings,
+		rest:   &rest.Client{HTTPClient: core.NewHTTPClient()},
 	}
 
 	return provider, nil
 }
 
+// maxRecipientsPerRequest is SendGrid's limit on the total number of
+// recipients, across all personalizations, of a single mail send request.
+const maxRecipientsPerRequest = 1000
+
 // Send sends a single email using SendGrid.
 func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResult, error) {
-	// Convert from address
-	from := mail.NewEmail(email.From.Name, email.From.Email)
+	message, err := p.buildMessage(email)
+	if err != nil {
+		return nil, err
+	}
 
-	// Convert to addresses
-	if len(email.To) == 0 {
-		return nil, core.NewValidationError("to", "at least one recipient is required")
+	messageID, err := p.send(ctx, message)
+	if err != nil {
+		return nil, err
 	}
 
-	// For simplicity, we'll send to the first recipient and add others as personalizations
-	to := mail.NewEmail(email.To[0].Name, email.To[0].Email)
+	return &core.SendResult{
+		MessageID:     messageID,
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+	}, nil
+}
 
-	// Create the message
-	message := mail.NewSingleEmail(from, email.Subject, to, email.TextBody, email.HTMLBody)
+// SendBatch sends emails that share their sender, bodies and headers as a
+// single request with one personalization per email, and other emails
+// individually. Each personalization carries the email's recipients, subject
+// and substitutions.
+func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
+	result := &core.BatchResult{
+		Total:    len(emails),
+		Provider: p.Name(),
+	}
+
+	for _, group := range groupBatch(emails) {
+		if len(group) == 1 {
+			email := emails[group[0]]
+			sendResult, err := p.Send(ctx, email)
+			if err != nil {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: group[0], Email: email, Error: err})
+			} else {
+				result.Successful = append(result.Successful, sendResult)
+			}
+			continue
+		}
+
+		p.sendGroup(ctx, emails, group, result)
+	}
+
+	return result, nil
+}
+
+// sendGroup sends the emails at the group's indexes as a single request,
+// recording their outcome in result. SendGrid rejects the whole request when
+// some personalizations are invalid; those emails fail with their errors and
+// the others are sent again without them.
+func (p *Provider) sendGroup(ctx context.Context, emails []*core.Email, group []int, result *core.BatchResult) {
+	message, err := p.buildMessage(emails[group[0]])
+	var messageID string
+	if err == nil {
+		message.Personalizations = nil
+		for _, i := range group {
+			personalization := newPersonalization(emails[i])
+			personalization.Subject = emails[i].Subject
+			message.AddPersonalizations(personalization)
+		}
+		messageID, err = p.send(ctx, message)
+	}
 
-	// Add additional recipients if any
-	if len(email.To) > 1 || len(email.CC) > 0 || len(email.BCC) > 0 {
-		personalization := mail.NewPersonalization()
+	if rejected := rejectedPersonalizations(err, len(group)); rejected != nil && len(rejected) < len(group) {
+		var remaining []int
+		for position, i := range group {
+			if rejectErr, ok := rejected[position]; ok {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: rejectErr})
+			} else {
+				remaining = append(remaining, i)
+			}
+		}
+		p.sendGroup(ctx, emails, remaining, result)
+		return
+	}
+
+	for _, i := range group {
+		if err != nil {
+			result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: err})
+			continue
+		}
+		result.Successful = append(result.Successful, &core.SendResult{
+			MessageID:     messageID,
+			Provider:      p.Name(),
+			Timestamp:     time.Now(),
+			CorrelationID: emails[i].CorrelationID(),
+		})
+	}
+}
+
+// rejection is the error body of a request SendGrid rejected, naming the
+// fields at fault, such as "personalizations.2.to.0.email".
+type rejection struct {
+	Errors []struct {
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+// Error joins the rejection's messages.
+func (r *rejection) Error() string {
+	messages := make([]string, len(r.Errors))
+	for i, e := range r.Errors {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// rejectedPersonalizations returns the errors of the personalizations a
+// request of count personalizations was rejected for, by position, or nil
+// when err is not such a rejection or also has errors not tied to a
+// personalization.
+func rejectedPersonalizations(err error, count int) map[int]error {
+	var r *rejection
+	if !errors.As(err, &r) || len(r.Errors) == 0 {
+		return nil
+	}
 
-		// Add all TO recipients
-		for _, recipient := range email.To {
-			personalization.AddTos(mail.NewEmail(recipient.Name, recipient.Email))
+	messages := make(map[int][]string)
+	for _, e := range r.Errors {
+		field, ok := strings.CutPrefix(e.Field, "personalizations.")
+		if !ok {
+			return nil
 		}
+		index, _, _ := strings.Cut(field, ".")
+		position, convErr := strconv.Atoi(index)
+		if convErr != nil || position < 0 || position >= count {
+			return nil
+		}
+		messages[position] = append(messages[position], e.Message)
+	}
 
-		// Add CC recipients
-		for _, recipient := range email.CC {
-			personalization.AddCCs(mail.NewEmail(recipient.Name, recipient.Email))
+	rejected := make(map[int]error, len(messages))
+	for position, message := range messages {
+		providerErr := core.NewProviderError("sendgrid", "invalid_personalization", strings.Join(message, "; "))
+		providerErr.StatusCode = http.StatusBadRequest
+		rejected[position] = providerErr
+	}
+	return rejected
+}
+
+// groupBatch groups the indexes of emails that can share a single request,
+// keeping each group within SendGrid's recipient limit. Emails with
+// attachments are always sent on their own.
+func groupBatch(emails []*core.Email) [][]int {
+	var groups [][]int
+	open := make(map[string]int) // content key -> index of the group being filled
+	recipients := make(map[int]int)
+
+	for i, email := range emails {
+		count := email.TotalRecipients()
+		if email.HasAttachments() {
+			groups = append(groups, []int{i})
+			continue
 		}
 
-		// Add BCC recipients
-		for _, recipient := range email.BCC {
-			personalization.AddBCCs(mail.NewEmail(recipient.Name, recipient.Email))
+		key := contentKey(email)
+		if g, ok := open[key]; ok && recipients[g]+count <= maxRecipientsPerRequest {
+			groups[g] = append(groups[g], i)
+			recipients[g] += count
+			continue
 		}
 
-		message.Personalizations = []*mail.Personalization{personalization}
+		open[key] = len(groups)
+		recipients[len(groups)] = count
+		groups = append(groups, []int{i})
+	}
+
+	return groups
+}
+
+// contentKey identifies the request-level content of an email: everything
+// except what a personalization can carry.
+func contentKey(email *core.Email) string {
+	var key strings.Builder
+	key.WriteString(email.From.String())
+	key.WriteByte(0)
+	key.WriteString(email.HTMLBody)
+	key.WriteByte(0)
+	key.WriteString(email.TextBody)
+	key.WriteByte(0)
+	key.WriteString(email.Metadata[core.MetadataIPPool])
+	if tracking := email.Tracking; tracking != nil {
+		key.WriteString("\x00opens=" + strconv.FormatBool(tracking.Opens) + ",clicks=" + strconv.FormatBool(tracking.Clicks))
+	}
+	key.WriteString("\x00" + strconv.Itoa(len(email.Tags)) + " tags")
+	for _, tag := range email.Tags {
+		key.WriteString("\x00" + tag)
+	}
+
+	headers := make([]string, 0, len(email.Headers))
+	for name, value := range email.Headers {
+		headers = append(headers, name+":"+value)
 	}
+	sort.Strings(headers)
+	for _, header := range headers {
+		key.WriteByte(0)
+		key.WriteString(header)
+	}
+
+	return key.String()
+}
+
+// newPersonalization returns a personalization with the email's recipients,
+// substitutions and metadata.
+func newPersonalization(email *core.Email) *mail.Personalization {
+	personalization := mail.NewPersonalization()
+
+	for _, recipient := range email.To {
+		personalization.AddTos(mail.NewEmail(recipient.Name, recipient.Email))
+	}
+	for _, recipient := range email.CC {
+		personalization.AddCCs(mail.NewEmail(recipient.Name, recipient.Email))
+	}
+	for _, recipient := range email.BCC {
+		personalization.AddBCCs(mail.NewEmail(recipient.Name, recipient.Email))
+	}
+
+	// Map per-recipient substitutions onto the personalization
+	for key, value := range email.Substitutions {
+		personalization.SetSubstitution(core.SubstitutionToken(key), value)
+	}
+
+	// Pass metadata through as custom arguments, returned in event webhooks
+	for key, value := range email.CallerMetadata() {
+		personalization.SetCustomArg(key, value)
+	}
+
+	return personalization
+}
+
+// buildMessage converts an email to a SendGrid mail send request.
+func (p *Provider) buildMessage(email *core.Email) (*mail.SGMailV3, error) {
+	if len(email.To) == 0 {
+		return nil, core.NewValidationError("to", "at least one recipient is required")
+	}
+
+	from := mail.NewEmail(email.From.Name, email.From.Email)
+	to := mail.NewEmail(email.To[0].Name, email.To[0].Email)
+
+	// Create the message with all recipients in a single personalization
+	message := mail.NewSingleEmail(from, email.Subject, to, email.TextBody, email.HTMLBody)
+	message.Personalizations = []*mail.Personalization{newPersonalization(email)}
 
 	// Add custom headers
 	if len(email.Headers) > 0 {
@@ -81,51 +306,120 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 		}
 	}
 
-	// Send the email
-	response, err := p.client.Send(message)
+	// Send from the IP pool selected for the email's category
+	if pool := email.Metadata[core.MetadataIPPool]; pool != "" {
+		message.SetIPPoolID(pool)
+	}
+
+	// Send tags as categories, which SendGrid reports statistics by
+	if len(email.Tags) > 0 {
+		message.AddCategories(email.Tags...)
+	}
+
+	// Turn open and click tracking on or off for the email
+	if tracking := email.Tracking; tracking != nil {
+		message.SetTrackingSettings(mail.NewTrackingSettings().
+			SetOpenTracking(mail.NewOpenTrackingSetting().SetEnable(tracking.Opens)).
+			SetClickTracking(mail.NewClickTrackingSetting().SetEnable(tracking.Clicks).SetEnableText(tracking.Clicks)))
+	}
+
+	// Add attachments; SendGrid encodes non-ASCII filenames from the JSON payload
+	for _, attachment := range email.Attachments {
+		if attachment.Data == nil {
+			continue
+		}
+		data, err := io.ReadAll(attachment.Data)
+		if err != nil {
+			return nil, core.NewProviderError("sendgrid", "attachment_read_failed", err.Error())
+		}
+
+		sgAttachment := mail.NewAttachment().
+			SetContent(base64.StdEncoding.EncodeToString(data)).
+			SetType(attachment.DetectContentType()).
+			SetFilename(attachment.Filename).
+			SetDisposition("attachment")
+		if attachment.Inline {
+			sgAttachment.SetDisposition("inline").SetContentID(attachment.ContentID)
+		}
+		message.AddAttachment(sgAttachment)
+	}
+
+	return message, nil
+}
+
+// send submits a mail send request and returns its message ID.
+func (p *Provider) send(ctx context.Context, message *mail.SGMailV3) (string, error) {
+	// Send the email, aborting the HTTP call if ctx is cancelled
+	request := p.client.Request
+	request.Body = mail.GetRequestBody(message)
+	response, err := p.rest.SendWithContext(ctx, request)
 	if err != nil {
-		return nil, core.NewProviderError("sendgrid", "send_error", "failed to send email: "+err.Error())
+		providerErr := core.NewProviderError("sendgrid", "send_error", "failed to send email: "+err.Error())
+		providerErr.Cause = err
+		return "", providerErr
 	}
 
-	// Check response status
+	// Check response status, keeping the fields SendGrid rejected
 	if response.StatusCode >= 400 {
-		return nil, core.NewProviderError("sendgrid", "api_error", "SendGrid API error: "+response.Body)
+		providerErr := core.NewProviderError("sendgrid", "api_error", "SendGrid API error: "+response.Body)
+		providerErr.StatusCode = response.StatusCode
+		var r rejection
+		if json.Unmarshal([]byte(response.Body), &r) == nil && len(r.Errors) > 0 {
+			providerErr.Cause = &r
+		}
+		return "", providerErr
 	}
 
 	// Extract message ID from headers (SendGrid provides X-Message-Id)
 	messageID := response.Headers["X-Message-Id"]
 	if len(messageID) == 0 {
-		messageID = []string{"unknown"}
+		return "unknown", nil
 	}
 
-	return &core.SendResult{
-		MessageID: messageID[0],
-		Provider:  p.Name(),
-		Timestamp: time.Now(),
-	}, nil
+	return messageID[0], nil
 }
 
-// SendBatch sends multiple emails individually.
-func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
-	result := &core.BatchResult{
-		Total:    len(emails),
-		Provider: p.Name(),
-	}
+// Warm opens connections to the SendGrid API with concurrent requests for the
+// API key's scopes, which also validate the key. The connections are kept
+// alive by the HTTP client used for sending.
+func (p *Provider) Warm(ctx context.Context, connections int) error {
+	return core.WarmConcurrently(ctx, connections, func(ctx context.Context) error {
+		request := sendgrid.GetRequest(p.config.Get("api_key"), "/v3/scopes", "")
+		request.Method = http.MethodGet
 
-	for i, email := range emails {
-		sendResult, err := p.Send(ctx, email)
+		response, err := p.rest.SendWithContext(ctx, request)
 		if err != nil {
-			result.Failed = append(result.Failed, core.BatchFailure{
-				Index: i,
-				Email: email,
-				Error: err,
-			})
-		} else {
-			result.Successful = append(result.Successful, sendResult)
+			providerErr := core.NewTemporaryProviderError("sendgrid", "warm_error", "failed to reach SendGrid: "+err.Error())
+			providerErr.Cause = err
+			return providerErr
 		}
-	}
+		if response.StatusCode >= 400 {
+			providerErr := core.NewProviderError("sendgrid", "api_error", "SendGrid API error: "+response.Body)
+			providerErr.StatusCode = response.StatusCode
+			return providerErr
+		}
+		return nil
+	})
+}
 
-	return result, nil
+// CloseIdleConnections closes the idle keep-alive connections of the HTTP
+// client making the API calls.
+func (p *Provider) CloseIdleConnections() {
+	p.rest.HTTPClient.CloseIdleConnections()
+}
+
+// SetRequestSigner makes the provider sign every SendGrid API request with
+// signer.
+func (p *Provider) SetRequestSigner(signer core.RequestSigner) {
+	client := *p.rest.HTTPClient
+	client.Transport = core.SigningTransport(client.Transport, signer)
+	p.rest = &rest.Client{HTTPClient: &client}
+}
+
+// SupportsSubstitutions reports that SendGrid applies Email.Substitutions
+// natively through personalization substitutions.
+func (p *Provider) SupportsSubstitutions() bool {
+	return true
 }
 
 // ValidateConfig validates the provider configuration.
@@ -136,7 +430,10 @@ func (p *Provider) ValidateConfig() error {
 	return nil
 }
 
-// Name returns the provider name.
+// Name returns the provider name, which can be overridden with the "name" setting.
 func (p *Provider) Name() string {
+	if name := p.config.Get("name"); name != "" {
+		return name
+	}
 	return "sendgrid"
 }
@@ -0,0 +1,53 @@
+package sendgrid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+func TestSendReturnsWhenContextIsCancelled(t *testing.T) {
+	// The server stalls until the request is abandoned or the test ends
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider, err := NewProvider(core.ProviderSettings{"api_key": "test-key"})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	p := provider.(*Provider)
+	p.client.Request.BaseURL = server.URL + "/v3/mail/send"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	email := &core.Email{
+		From:     core.Address{Email: "sender@example.com"},
+		To:       []core.Address{{Email: "recipient@example.com"}},
+		Subject:  "Hello",
+		TextBody: "Hello",
+	}
+
+	start := time.Now()
+	_, err = p.Send(ctx, email)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Send returned after %v, want it to return once ctx is cancelled", elapsed)
+	}
+}
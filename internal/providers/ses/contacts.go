@@ -0,0 +1,197 @@
+package ses
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// CreateContactList creates an SES contact list with its topics.
+func (p *Provider) CreateContactList(ctx context.Context, list core.ContactList) error {
+	if list.Name == "" {
+		return core.NewValidationError("name", "contact list name is required")
+	}
+
+	input := &sesv2.CreateContactListInput{
+		ContactListName: aws.String(list.Name),
+		Description:     optionalString(list.Description),
+	}
+	for _, topic := range list.Topics {
+		status := topic.DefaultStatus
+		if status == "" {
+			status = core.SubscriptionOptIn
+		}
+		input.Topics = append(input.Topics, types.Topic{
+			TopicName:                 aws.String(topic.Name),
+			DisplayName:               aws.String(topic.DisplayName),
+			Description:               optionalString(topic.Description),
+			DefaultSubscriptionStatus: types.SubscriptionStatus(status),
+		})
+	}
+
+	if _, err := p.contacts.CreateContactList(ctx, input); err != nil {
+		return classifyError("contact_list_error", "failed to create contact list: "+err.Error(), err)
+	}
+	return nil
+}
+
+// DeleteContactList deletes an SES contact list and its contacts.
+func (p *Provider) DeleteContactList(ctx context.Context, name string) error {
+	_, err := p.contacts.DeleteContactList(ctx, &sesv2.DeleteContactListInput{
+		ContactListName: aws.String(name),
+	})
+	if err != nil {
+		return classifyError("contact_list_error", "failed to delete contact list: "+err.Error(), err)
+	}
+	return nil
+}
+
+// AddContact adds a contact to an SES contact list.
+func (p *Provider) AddContact(ctx context.Context, list string, contact core.Contact) error {
+	if contact.Email == "" {
+		return core.NewValidationError("email", "contact email is required")
+	}
+
+	_, err := p.contacts.CreateContact(ctx, &sesv2.CreateContactInput{
+		ContactListName:  aws.String(list),
+		EmailAddress:     aws.String(contact.Email),
+		AttributesData:   optionalString(contact.Attributes),
+		TopicPreferences: topicPreferences(contact.Topics),
+		UnsubscribeAll:   contact.UnsubscribeAll,
+	})
+	if err != nil {
+		return classifyError("contact_error", "failed to add contact: "+err.Error(), err)
+	}
+	return nil
+}
+
+// UpdateContact replaces the preferences and attributes of a contact in an
+// SES contact list.
+func (p *Provider) UpdateContact(ctx context.Context, list string, contact core.Contact) error {
+	if contact.Email == "" {
+		return core.NewValidationError("email", "contact email is required")
+	}
+
+	_, err := p.contacts.UpdateContact(ctx, &sesv2.UpdateContactInput{
+		ContactListName:  aws.String(list),
+		EmailAddress:     aws.String(contact.Email),
+		AttributesData:   optionalString(contact.Attributes),
+		TopicPreferences: topicPreferences(contact.Topics),
+		UnsubscribeAll:   contact.UnsubscribeAll,
+	})
+	if err != nil {
+		return classifyError("contact_error", "failed to update contact: "+err.Error(), err)
+	}
+	return nil
+}
+
+// RemoveContact removes a contact from an SES contact list.
+func (p *Provider) RemoveContact(ctx context.Context, list, email string) error {
+	_, err := p.contacts.DeleteContact(ctx, &sesv2.DeleteContactInput{
+		ContactListName: aws.String(list),
+		EmailAddress:    aws.String(email),
+	})
+	if err != nil {
+		return classifyError("contact_error", "failed to remove contact: "+err.Error(), err)
+	}
+	return nil
+}
+
+// Contact returns a contact of an SES contact list.
+func (p *Provider) Contact(ctx context.Context, list, email string) (*core.Contact, error) {
+	out, err := p.contacts.GetContact(ctx, &sesv2.GetContactInput{
+		ContactListName: aws.String(list),
+		EmailAddress:    aws.String(email),
+	})
+	if err != nil {
+		return nil, classifyError("contact_error", "failed to get contact: "+err.Error(), err)
+	}
+
+	contact := &core.Contact{
+		Email:          aws.ToString(out.EmailAddress),
+		Topics:         topicStatuses(out.TopicPreferences),
+		UnsubscribeAll: out.UnsubscribeAll,
+		Attributes:     aws.ToString(out.AttributesData),
+		UpdatedAt:      aws.ToTime(out.LastUpdatedTimestamp),
+	}
+	return contact, nil
+}
+
+// Contacts returns the contacts of an SES contact list, or only those
+// subscribed to topic, explicitly or by the topic's default, when it is not
+// empty.
+func (p *Provider) Contacts(ctx context.Context, list, topic string) ([]core.Contact, error) {
+	input := &sesv2.ListContactsInput{ContactListName: aws.String(list)}
+	if topic != "" {
+		input.Filter = &types.ListContactsFilter{
+			FilteredStatus: types.SubscriptionStatusOptIn,
+			TopicFilter: &types.TopicFilter{
+				TopicName:                         aws.String(topic),
+				UseDefaultIfPreferenceUnavailable: true,
+			},
+		}
+	}
+
+	var contacts []core.Contact
+	paginator := sesv2.NewListContactsPaginator(p.contacts, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, classifyError("contact_error", "failed to list contacts: "+err.Error(), err)
+		}
+		for _, contact := range page.Contacts {
+			contacts = append(contacts, core.Contact{
+				Email:          aws.ToString(contact.EmailAddress),
+				Topics:         topicStatuses(contact.TopicPreferences),
+				UnsubscribeAll: contact.UnsubscribeAll,
+				UpdatedAt:      aws.ToTime(contact.LastUpdatedTimestamp),
+			})
+		}
+	}
+	return contacts, nil
+}
+
+// topicPreferences converts topic statuses to SES topic preferences, sorted
+// by topic.
+func topicPreferences(topics map[string]core.SubscriptionStatus) []types.TopicPreference {
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	preferences := make([]types.TopicPreference, len(names))
+	for i, name := range names {
+		preferences[i] = types.TopicPreference{
+			TopicName:          aws.String(name),
+			SubscriptionStatus: types.SubscriptionStatus(topics[name]),
+		}
+	}
+	return preferences
+}
+
+// topicStatuses converts SES topic preferences to topic statuses.
+func topicStatuses(preferences []types.TopicPreference) map[string]core.SubscriptionStatus {
+	if len(preferences) == 0 {
+		return nil
+	}
+	topics := make(map[string]core.SubscriptionStatus, len(preferences))
+	for _, preference := range preferences {
+		topics[aws.ToString(preference.TopicName)] = core.SubscriptionStatus(preference.SubscriptionStatus)
+	}
+	return topics
+}
+
+// optionalString returns nil for an empty string, which SES rejects for
+// optional fields.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
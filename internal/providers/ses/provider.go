@@ -2,20 +2,32 @@ package ses
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/smithy-go"
 
 	"github.com/lattiq/mailer/internal/core"
 )
 
 // Provider implements the core.Provider interface for AWS SES.
 type Provider struct {
-	client *ses.Client
-	config core.ProviderSettings
+	client    *ses.Client
+	contacts  *sesv2.Client
+	awsConfig aws.Config
+	config    core.ProviderSettings
+
+	// now returns the time written into the Date header of raw messages
+	now func() time.Time
 }
 
 // NewProvider creates a new AWS SES provider.
@@ -50,20 +62,47 @@ func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
 		})
 	}
 
+	if err := core.MIMEOptionsFromSettings(settings).Validate(); err != nil {
+		return nil, err
+	}
+
 	client := ses.NewFromConfig(cfg)
 
 	provider := &Provider{
-		client: client,
-		config: settings,
+		client:    client,
+		contacts:  sesv2.NewFromConfig(cfg),
+		awsConfig: cfg,
+		config:    settings,
+		now:       time.Now,
 	}
 
 	return provider, nil
 }
 
-// Send sends a single email using AWS SES.
+// maxBulkDestinations is SES's limit on the number of destinations in a
+// single SendBulkTemplatedEmail call.
+const maxBulkDestinations = 50
+
+// Send sends a single email using AWS SES. Emails naming a stored SES template
+// are sent with SendTemplatedEmail, and rejected when they have attachments
+// or custom headers it cannot carry. Other emails with attachments or custom
+// headers, including priority headers, which SendEmail cannot carry, are
+// sent as raw MIME messages.
 func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResult, error) {
+	if email.Metadata[core.MetadataSESTemplate] != "" {
+		return p.sendTemplated(ctx, email)
+	}
+
+	// Substitutions are applied locally outside SES templates
+	email = email.WithSubstitutions()
+
+	if email.HasAttachments() || len(email.Headers) > 0 {
+		return p.sendRaw(ctx, email)
+	}
+
 	input := &ses.SendEmailInput{
-		Source: aws.String(email.From.String()),
+		Source:     aws.String(email.From.String()),
+		ReturnPath: p.returnPath(),
 		Destination: &types.Destination{
 			ToAddresses: p.convertAddresses(email.To),
 		},
@@ -73,6 +112,7 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 			},
 			Body: &types.Body{},
 		},
+		Tags: messageTags(email),
 	}
 
 	// Add CC addresses if present
@@ -99,60 +139,459 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 	}
 
 	// Add configuration set if specified
-	if configSet := p.config.Get("configuration_set"); configSet != "" {
-		input.ConfigurationSetName = aws.String(configSet)
-	}
+	input.ConfigurationSetName = p.configurationSet(email)
 
 	// Send the email
 	output, err := p.client.SendEmail(ctx, input)
 	if err != nil {
-		return nil, core.NewProviderError("aws_ses", "send_error", "failed to send email: "+err.Error())
+		return nil, classifyError("send_error", "failed to send email: "+err.Error(), err)
+	}
+
+	return &core.SendResult{
+		MessageID:     aws.ToString(output.MessageId),
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+	}, nil
+}
+
+// sendRaw sends an email as a MIME message built by core.BuildMessage, with
+// attachments as multipart/mixed parts and inline attachments referenced by
+// Content-ID. SES assigns the Message-ID.
+func (p *Provider) sendRaw(ctx context.Context, email *core.Email) (*core.SendResult, error) {
+	opts := core.MIMEOptionsFromSettings(p.config)
+	opts.Date = p.now()
+	message, err := core.BuildMessage(email, opts)
+	if err != nil {
+		return nil, core.NewProviderError("aws_ses", "message_build_error", "failed to build message: "+err.Error())
+	}
+
+	// Destinations carry every recipient, since BCC addresses are not in
+	// the message headers
+	destinations := p.convertAddresses(email.To)
+	destinations = append(destinations, p.convertAddresses(email.CC)...)
+	destinations = append(destinations, p.convertAddresses(email.BCC)...)
+
+	input := &ses.SendRawEmailInput{
+		Source:       aws.String(email.From.String()),
+		Destinations: destinations,
+		RawMessage:   &types.RawMessage{Data: message},
+		Tags:         messageTags(email),
+	}
+
+	// SendRawEmail has no ReturnPath; the verified return path address takes
+	// the place of the source, while the From header stays in the message
+	if returnPath := p.returnPath(); returnPath != nil {
+		input.Source = returnPath
+	}
+
+	// Add configuration set if specified
+	input.ConfigurationSetName = p.configurationSet(email)
+
+	output, err := p.client.SendRawEmail(ctx, input)
+	if err != nil {
+		return nil, classifyError("send_error", "failed to send raw email: "+err.Error(), err)
+	}
+
+	return &core.SendResult{
+		MessageID:     aws.ToString(output.MessageId),
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+	}, nil
+}
+
+// sendTemplated sends an email rendered by SES from the stored template named
+// in its metadata, with its substitutions as the template data.
+func (p *Provider) sendTemplated(ctx context.Context, email *core.Email) (*core.SendResult, error) {
+	if err := validateTemplated(email); err != nil {
+		return nil, err
+	}
+	data, err := templateData(email)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ses.SendTemplatedEmailInput{
+		Source:       aws.String(email.From.String()),
+		ReturnPath:   p.returnPath(),
+		Destination:  p.destination(email),
+		Template:     aws.String(email.Metadata[core.MetadataSESTemplate]),
+		TemplateData: aws.String(data),
+		Tags:         messageTags(email),
+	}
+
+	// Add configuration set if specified
+	input.ConfigurationSetName = p.configurationSet(email)
+
+	output, err := p.client.SendTemplatedEmail(ctx, input)
+	if err != nil {
+		return nil, classifyError("send_error", "failed to send templated email: "+err.Error(), err)
 	}
 
 	return &core.SendResult{
-		MessageID: aws.ToString(output.MessageId),
-		Provider:  p.Name(),
-		Timestamp: time.Now(),
+		MessageID:     aws.ToString(output.MessageId),
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
 	}, nil
 }
 
-// SendBatch sends multiple emails. AWS SES doesn't have a native batch API,
-// so we send emails individually.
+// SendBatch sends multiple emails. Emails naming the same stored SES template
+// and sender go out with SendBulkTemplatedEmail, up to 50 per call, with each
+// destination's status reported individually; other emails are sent one by one.
 func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.BatchResult, error) {
 	result := &core.BatchResult{
 		Total:    len(emails),
 		Provider: p.Name(),
 	}
 
-	for i, email := range emails {
-		sendResult, err := p.Send(ctx, email)
-		if err != nil {
-			result.Failed = append(result.Failed, core.BatchFailure{
-				Index: i,
-				Email: email,
-				Error: err,
-			})
-		} else {
-			result.Successful = append(result.Successful, sendResult)
+	for _, group := range groupBatch(emails) {
+		if len(group) == 1 {
+			email := emails[group[0]]
+			sendResult, err := p.Send(ctx, email)
+			if err != nil {
+				result.Failed = append(result.Failed, core.BatchFailure{Index: group[0], Email: email, Error: err})
+			} else {
+				result.Successful = append(result.Successful, sendResult)
+			}
+			continue
 		}
+
+		p.sendBulk(ctx, emails, group, result)
 	}
 
 	return result, nil
 }
 
+// sendBulk sends the grouped emails with a single SendBulkTemplatedEmail call
+// and records each destination's outcome in result.
+func (p *Provider) sendBulk(ctx context.Context, emails []*core.Email, group []int, result *core.BatchResult) {
+	fail := func(i int, err error) {
+		result.Failed = append(result.Failed, core.BatchFailure{Index: i, Email: emails[i], Error: err})
+	}
+
+	first := emails[group[0]]
+	input := &ses.SendBulkTemplatedEmailInput{
+		Source:              aws.String(first.From.String()),
+		ReturnPath:          p.returnPath(),
+		Template:            aws.String(first.Metadata[core.MetadataSESTemplate]),
+		DefaultTemplateData: aws.String("{}"),
+	}
+	input.ConfigurationSetName = p.configurationSet(first)
+
+	var sent []int
+	for _, i := range group {
+		data, err := templateData(emails[i])
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		input.Destinations = append(input.Destinations, types.BulkEmailDestination{
+			Destination:             p.destination(emails[i]),
+			ReplacementTemplateData: aws.String(data),
+			ReplacementTags:         messageTags(emails[i]),
+		})
+		sent = append(sent, i)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	output, err := p.client.SendBulkTemplatedEmail(ctx, input)
+	if err == nil && len(output.Status) != len(sent) {
+		err = core.NewProviderError("aws_ses", "invalid_response", "bulk send returned a status count that does not match its destinations")
+	}
+	if err != nil {
+		if _, ok := err.(*core.ProviderError); !ok {
+			err = classifyError("send_error", "failed to send bulk templated email: "+err.Error(), err)
+		}
+		for _, i := range sent {
+			fail(i, err)
+		}
+		return
+	}
+
+	for j, i := range sent {
+		status := output.Status[j]
+		if status.Status != types.BulkEmailStatusSuccess {
+			fail(i, core.NewProviderError("aws_ses", string(status.Status), aws.ToString(status.Error)))
+			continue
+		}
+		result.Successful = append(result.Successful, &core.SendResult{
+			MessageID:     aws.ToString(status.MessageId),
+			Provider:      p.Name(),
+			Timestamp:     time.Now(),
+			CorrelationID: emails[i].CorrelationID(),
+		})
+	}
+}
+
+// groupBatch groups the indexes of emails that can share a bulk templated
+// send: same SES template and sender, no attachments or custom headers, at
+// most 50 per group. Other emails are in groups of their own, so that those
+// a template cannot carry fail on their own.
+func groupBatch(emails []*core.Email) [][]int {
+	var groups [][]int
+	open := make(map[string]int) // template and sender -> index of the group being filled
+
+	for i, email := range emails {
+		template := email.Metadata[core.MetadataSESTemplate]
+		if template == "" || validateTemplated(email) != nil {
+			groups = append(groups, []int{i})
+			continue
+		}
+
+		key := template + "\x00" + email.From.String()
+		if email.Tracking.Disabled() {
+			key += "\x00untracked"
+		}
+		if g, ok := open[key]; ok && len(groups[g]) < maxBulkDestinations {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+
+		open[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	return groups
+}
+
+// validateTemplated checks that an email sent with a stored SES template
+// carries nothing SES would drop. Priority headers, which the client adds to
+// high and urgent emails, are the exception: they only flag the message in
+// mail clients, so it is sent without them.
+func validateTemplated(email *core.Email) error {
+	priority := email.Priority.Headers()
+	for key := range email.Headers {
+		if _, ok := priority[key]; !ok {
+			return core.NewValidationError("headers", "emails sent with a stored SES template cannot have custom headers")
+		}
+	}
+	withoutHeaders := *email
+	withoutHeaders.Headers = nil
+	return withoutHeaders.ValidateSESTemplate()
+}
+
+// configurationSet returns the configuration set to send an email with: the
+// "untracked_configuration_set" setting when the email turns open or click
+// tracking off and the setting is set, the "configuration_set" setting
+// otherwise, or nil when neither applies. SES tracks opens and clicks
+// through the event destinations of the configuration set.
+func (p *Provider) configurationSet(email *core.Email) *string {
+	if email.Tracking.Disabled() {
+		if configSet := p.config.Get("untracked_configuration_set"); configSet != "" {
+			return aws.String(configSet)
+		}
+	}
+	if configSet := p.config.Get("configuration_set"); configSet != "" {
+		return aws.String(configSet)
+	}
+	return nil
+}
+
+// templateData encodes an email's substitutions as SES template data.
+func templateData(email *core.Email) (string, error) {
+	data := email.Substitutions
+	if data == nil {
+		data = map[string]string{}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", core.NewProviderError("aws_ses", "template_data_error", err.Error())
+	}
+	return string(encoded), nil
+}
+
+// returnPath returns the address SES forwards bounces to, set with the
+// "return_path" setting, or nil to leave SES to use the source. SES requires
+// it to be a verified identity, so it is not derived from the bounce domain:
+// the envelope MAIL FROM domain is the custom MAIL FROM domain of the sending
+// identity, configured in SES.
+func (p *Provider) returnPath() *string {
+	if address := p.config.Get("return_path"); address != "" {
+		return aws.String(address)
+	}
+	return nil
+}
+
+// destination returns the SES destination for an email's recipients.
+func (p *Provider) destination(email *core.Email) *types.Destination {
+	destination := &types.Destination{
+		ToAddresses: p.convertAddresses(email.To),
+	}
+	if len(email.CC) > 0 {
+		destination.CcAddresses = p.convertAddresses(email.CC)
+	}
+	if len(email.BCC) > 0 {
+		destination.BccAddresses = p.convertAddresses(email.BCC)
+	}
+	return destination
+}
+
+// messageTags returns the email's metadata and tags as SES message tags,
+// published with sending events through the configuration set. Each tag is
+// a message tag with the value "true", unless the metadata has an entry of
+// the same name. Tag names and values may only contain ASCII letters,
+// digits, underscores and dashes, so other characters are replaced with
+// underscores.
+func messageTags(email *core.Email) []types.MessageTag {
+	metadata := email.CallerMetadata()
+	if len(metadata) == 0 && len(email.Tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]types.MessageTag, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, types.MessageTag{
+			Name:  aws.String(tagText(key)),
+			Value: aws.String(tagText(metadata[key])),
+		})
+	}
+
+	// Skip tags whose name is already taken, as SES rejects repeated names
+	names := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		names[*tag.Name] = true
+	}
+	for _, tag := range email.Tags {
+		name := tagText(tag)
+		if names[name] {
+			continue
+		}
+		names[name] = true
+		tags = append(tags, types.MessageTag{Name: aws.String(name), Value: aws.String("true")})
+	}
+	return tags
+}
+
+// tagText replaces the characters SES does not accept in message tags and
+// truncates the text to the 256 characters allowed.
+func tagText(text string) string {
+	tag := []rune(text)
+	for i, r := range tag {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			tag[i] = '_'
+		}
+	}
+	if len(tag) > 256 {
+		tag = tag[:256]
+	}
+	return string(tag)
+}
+
+// SupportsSubstitutions reports that SES applies Email.Substitutions itself:
+// as template data for emails naming a stored SES template, and locally
+// otherwise.
+func (p *Provider) SupportsSubstitutions() bool {
+	return true
+}
+
+// SetClock makes the provider read the Date of raw messages from now.
+func (p *Provider) SetClock(now func() time.Time) {
+	p.now = now
+}
+
+// SetRequestSigner makes the provider sign every SES API request with signer,
+// after the request has been signed with AWS credentials.
+func (p *Provider) SetRequestSigner(signer core.RequestSigner) {
+	p.client = ses.NewFromConfig(p.awsConfig, func(o *ses.Options) {
+		o.HTTPClient = &signingClient{base: o.HTTPClient, signer: signer}
+	})
+	p.contacts = sesv2.NewFromConfig(p.awsConfig, func(o *sesv2.Options) {
+		o.HTTPClient = &signingClient{base: o.HTTPClient, signer: signer}
+	})
+}
+
+// signingClient signs requests with a core.RequestSigner before sending them
+// with the SDK's HTTP client.
+type signingClient struct {
+	base   ses.HTTPClient
+	signer core.RequestSigner
+}
+
+// Do signs and sends req.
+func (c *signingClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.signer(req); err != nil {
+		return nil, err
+	}
+	return c.base.Do(req)
+}
+
+// Warm resolves AWS credentials and opens connections to the SES endpoint
+// with concurrent GetSendQuota calls, which also validate the credentials.
+func (p *Provider) Warm(ctx context.Context, connections int) error {
+	return core.WarmConcurrently(ctx, connections, func(ctx context.Context) error {
+		if _, err := p.client.GetSendQuota(ctx, &ses.GetSendQuotaInput{}); err != nil {
+			return classifyError("warm_error", "failed to reach SES: "+err.Error(), err)
+		}
+		return nil
+	})
+}
+
 // ValidateConfig validates the provider configuration.
 func (p *Provider) ValidateConfig() error {
 	if p.config.Get("region") == "" {
 		return core.NewValidationError("region", "AWS region is required")
 	}
-	return nil
+	return core.MIMEOptionsFromSettings(p.config).Validate()
 }
 
-// Name returns the provider name.
+// Name returns the provider name, which can be overridden with the "name" setting.
 func (p *Provider) Name() string {
+	if name := p.config.Get("name"); name != "" {
+		return name
+	}
 	return "aws_ses"
 }
 
+// classifyError converts an AWS SDK error into a provider error. Throttling,
+// server-side and transport failures are retryable so that the client can fail
+// over to another region; other client errors are permanent.
+func classifyError(code, message string, err error) *core.ProviderError {
+	var providerErr *core.ProviderError
+
+	var apiErr smithy.APIError
+	var respErr *awshttp.ResponseError
+	switch {
+	case errors.Is(err, context.Canceled):
+		providerErr = core.NewProviderError("aws_ses", code, message)
+	case errors.As(err, &apiErr) && isThrottlingCode(apiErr.ErrorCode()):
+		providerErr = core.NewRetryableProviderError("aws_ses", code, message)
+	case errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500:
+		providerErr = core.NewTemporaryProviderError("aws_ses", code, message)
+	case errors.As(err, &respErr):
+		providerErr = core.NewProviderError("aws_ses", code, message)
+	default:
+		// No response was received, e.g. DNS or connection failures
+		providerErr = core.NewTemporaryProviderError("aws_ses", code, message)
+	}
+
+	if errors.As(err, &respErr) {
+		providerErr.StatusCode = respErr.HTTPStatusCode()
+	}
+	providerErr.Cause = err
+
+	return providerErr
+}
+
+// isThrottlingCode reports whether an AWS error code indicates throttling.
+func isThrottlingCode(code string) bool {
+	switch code {
+	case "Throttling", "ThrottlingException", "TooManyRequestsException":
+		return true
+	default:
+		return false
+	}
+}
+
 // convertAddresses converts core.Address slice to string slice.
 func (p *Provider) convertAddresses(addresses []core.Address) []string {
 	result := make([]string, len(addresses))
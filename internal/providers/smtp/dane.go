@@ -0,0 +1,340 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeTLSA is the DNS TLSA record type (RFC 6698).
+const typeTLSA dnsmessage.Type = 52
+
+// TLSA certificate usages usable for SMTP (RFC 7672 section 3.1.3).
+const (
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+)
+
+// TLSA selectors.
+const (
+	tlsaSelectorCert = 0
+	tlsaSelectorSPKI = 1
+)
+
+// TLSA matching types.
+const (
+	tlsaMatchFull   = 0
+	tlsaMatchSHA256 = 1
+	tlsaMatchSHA512 = 2
+)
+
+// dnsTimeout bounds a TLSA query.
+const dnsTimeout = 5 * time.Second
+
+// tlsaRecord is a usable TLSA record.
+type tlsaRecord struct {
+	usage        uint8
+	selector     uint8
+	matchingType uint8
+	data         []byte
+}
+
+// matches reports whether cert matches the record's selector and data.
+func (r tlsaRecord) matches(cert *x509.Certificate) bool {
+	var content []byte
+	switch r.selector {
+	case tlsaSelectorCert:
+		content = cert.Raw
+	case tlsaSelectorSPKI:
+		content = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch r.matchingType {
+	case tlsaMatchFull:
+		return bytes.Equal(content, r.data)
+	case tlsaMatchSHA256:
+		sum := sha256.Sum256(content)
+		return bytes.Equal(sum[:], r.data)
+	case tlsaMatchSHA512:
+		sum := sha512.Sum512(content)
+		return bytes.Equal(sum[:], r.data)
+	default:
+		return false
+	}
+}
+
+// tlsaRecords returns the usable DNSSEC-signed TLSA records of the server
+// host on port, cached for their TTL up to the policy cache TTL. It returns
+// none when the records are missing, unsigned or unusable, in which case
+// delivery is opportunistic. DNSSEC validation is left to the "dns_resolver"
+// setting's resolver, whose authenticated data flag is trusted, so it must be
+// a validating resolver reached over a trusted path, such as one on localhost.
+func (p *Provider) tlsaRecords(ctx context.Context, host, port string) ([]tlsaRecord, error) {
+	name := "_" + port + "._tcp." + trimDot(host) + "."
+	now := time.Now()
+	if records, ok := p.policies.cachedTLSA(name, now); ok {
+		return records, nil
+	}
+
+	records, ttl, err := p.lookupTLSA(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up TLSA records of %s: %w", host, err)
+	}
+
+	expires := now.Add(min(ttl, p.policyCacheTTL()))
+	if len(records) == 0 {
+		expires = now.Add(p.policyCacheTTL())
+	}
+	p.policies.storeTLSA(name, tlsaCacheEntry{records: records, expires: expires})
+	return records, nil
+}
+
+// lookupTLSA queries the DNS resolver for the TLSA records at name, returning
+// the usable records and their lowest TTL when the resolver authenticated
+// them with DNSSEC.
+func (p *Provider) lookupTLSA(ctx context.Context, name string) ([]tlsaRecord, time.Duration, error) {
+	query, id, err := tlsaQuery(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	server := p.config.Get("dns_resolver")
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	response, err := exchange(ctx, "udp", server, query)
+	if err == nil {
+		var header dnsmessage.Header
+		if header, err = peekHeader(response); err == nil && header.Truncated {
+			response, err = exchange(ctx, "tcp", server, query)
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parseTLSAResponse(response, id)
+}
+
+// tlsaQuery builds a recursive TLSA query for name requesting DNSSEC
+// validation.
+func tlsaQuery(name string) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               id,
+		RecursionDesired: true,
+		AuthenticData:    true,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: qname, Type: typeTLSA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, 0, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, true); err != nil {
+		return nil, 0, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, 0, err
+	}
+
+	query, err := builder.Finish()
+	return query, id, err
+}
+
+// exchange sends a DNS query to server over network and returns the response.
+func exchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		response := make([]byte, 4096)
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, err
+		}
+		return response[:n], nil
+	}
+
+	// Messages over TCP are prefixed with their length
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// peekHeader parses the header of a DNS message.
+func peekHeader(message []byte) (dnsmessage.Header, error) {
+	var parser dnsmessage.Parser
+	return parser.Start(message)
+}
+
+// parseTLSAResponse returns the usable TLSA records in an authenticated
+// response to the query with the given id, and their lowest TTL.
+func parseTLSAResponse(response []byte, id uint16) ([]tlsaRecord, time.Duration, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case header.ID != id || !header.Response:
+		return nil, 0, errors.New("mismatched DNS response")
+	case header.RCode == dnsmessage.RCodeNameError:
+		return nil, 0, nil
+	case header.RCode != dnsmessage.RCodeSuccess:
+		return nil, 0, fmt.Errorf("DNS query failed: %v", header.RCode)
+	case !header.AuthenticData:
+		// Unsigned records must not be used (RFC 7672 section 2.2)
+		return nil, 0, nil
+	}
+
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var records []tlsaRecord
+	var ttl time.Duration
+	for {
+		rh, err := parser.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if rh.Type != typeTLSA || rh.Class != dnsmessage.ClassINET {
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		resource, err := parser.UnknownResource()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(resource.Data) < 4 {
+			continue
+		}
+		record := tlsaRecord{
+			usage:        resource.Data[0],
+			selector:     resource.Data[1],
+			matchingType: resource.Data[2],
+			data:         resource.Data[3:],
+		}
+		if record.usage != tlsaUsageDANETA && record.usage != tlsaUsageDANEEE {
+			continue
+		}
+		records = append(records, record)
+
+		if rrTTL := time.Duration(rh.TTL) * time.Second; ttl == 0 || rrTTL < ttl {
+			ttl = rrTTL
+		}
+	}
+	return records, ttl, nil
+}
+
+// daneConfig returns a TLS configuration authenticating the server host by
+// its TLSA records (RFC 7672 section 3.2). A DANE-EE record must match the
+// server certificate, whose names and validity are not checked. A DANE-TA
+// record must match a certificate in the server's chain that the server
+// certificate, valid for host, chains to.
+func daneConfig(host string, records []tlsaRecord) *tls.Config {
+	return &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+		// Verification against TLSA records replaces the WebPKI
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyDANE(host, records, state.PeerCertificates)
+		},
+	}
+}
+
+// verifyDANE checks a server's certificate chain against its TLSA records.
+func verifyDANE(host string, records []tlsaRecord, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("smtp: server presented no certificate")
+	}
+	leaf := chain[0]
+
+	for _, record := range records {
+		switch record.usage {
+		case tlsaUsageDANEEE:
+			if record.matches(leaf) {
+				return nil
+			}
+		case tlsaUsageDANETA:
+			for _, anchor := range chain[1:] {
+				if !record.matches(anchor) {
+					continue
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(anchor)
+				intermediates := x509.NewCertPool()
+				for _, cert := range chain[1:] {
+					intermediates.AddCert(cert)
+				}
+				_, err := leaf.Verify(x509.VerifyOptions{
+					DNSName:       trimDot(host),
+					Roots:         roots,
+					Intermediates: intermediates,
+				})
+				if err == nil {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("smtp: certificate of %s matches none of its TLSA records", host)
+}
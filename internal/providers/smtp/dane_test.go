@@ -0,0 +1,180 @@
+package smtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// tlsaAnswer is a TLSA record in a test DNS response.
+type tlsaAnswer struct {
+	typ  dnsmessage.Type
+	ttl  uint32
+	data []byte
+}
+
+// tlsaResponse builds a DNS response to the query with the given id.
+func tlsaResponse(t *testing.T, id uint16, rcode dnsmessage.RCode, authenticated bool, answers ...tlsaAnswer) []byte {
+	t.Helper()
+
+	name := dnsmessage.MustNewName("_25._tcp.mx.example.com.")
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:            id,
+		Response:      true,
+		RCode:         rcode,
+		AuthenticData: authenticated,
+	})
+	if err := builder.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: typeTLSA, Class: dnsmessage.ClassINET}); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.StartAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, answer := range answers {
+		header := dnsmessage.ResourceHeader{Name: name, Type: answer.typ, Class: dnsmessage.ClassINET, TTL: answer.ttl}
+		if err := builder.UnknownResource(header, dnsmessage.UnknownResource{Type: answer.typ, Data: answer.data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestParseTLSAResponse(t *testing.T) {
+	digest := make([]byte, 32)
+	daneEE := tlsaAnswer{typ: typeTLSA, ttl: 600, data: append([]byte{tlsaUsageDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256}, digest...)}
+	daneTA := tlsaAnswer{typ: typeTLSA, ttl: 300, data: append([]byte{tlsaUsageDANETA, tlsaSelectorCert, tlsaMatchSHA256}, digest...)}
+	pkixEE := tlsaAnswer{typ: typeTLSA, ttl: 60, data: append([]byte{1, tlsaSelectorCert, tlsaMatchSHA256}, digest...)}
+	cname := tlsaAnswer{typ: dnsmessage.TypeCNAME, ttl: 30, data: []byte{0}}
+	short := tlsaAnswer{typ: typeTLSA, ttl: 30, data: []byte{tlsaUsageDANEEE, tlsaSelectorSPKI}}
+
+	tests := []struct {
+		name     string
+		response []byte
+		records  int
+		ttl      time.Duration
+		wantErr  bool
+	}{
+		{"authenticated records", tlsaResponse(t, 7, dnsmessage.RCodeSuccess, true, daneEE, daneTA), 2, 300 * time.Second, false},
+		{"unsigned records are ignored", tlsaResponse(t, 7, dnsmessage.RCodeSuccess, false, daneEE), 0, 0, false},
+		{"PKIX usages, other types and short records are skipped", tlsaResponse(t, 7, dnsmessage.RCodeSuccess, true, pkixEE, cname, short, daneEE), 1, 600 * time.Second, false},
+		{"no such name", tlsaResponse(t, 7, dnsmessage.RCodeNameError, true), 0, 0, false},
+		{"server failure", tlsaResponse(t, 7, dnsmessage.RCodeServerFailure, true), 0, 0, true},
+		{"mismatched id", tlsaResponse(t, 8, dnsmessage.RCodeSuccess, true, daneEE), 0, 0, true},
+		{"malformed", []byte{0, 7}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, ttl, err := parseTLSAResponse(tt.response, 7)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTLSAResponse error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(records) != tt.records {
+				t.Errorf("got %d records, want %d", len(records), tt.records)
+			}
+			if ttl != tt.ttl {
+				t.Errorf("TTL = %v, want %v", ttl, tt.ttl)
+			}
+		})
+	}
+}
+
+// testCertificate creates a certificate for host signed by parent, or
+// self-signed when parent is nil.
+func testCertificate(t *testing.T, host string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if !isCA {
+		template.DNSNames = []string{host}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestVerifyDANE(t *testing.T) {
+	anchor, anchorKey := testCertificate(t, "Test CA", true, nil, nil)
+	leaf, _ := testCertificate(t, "mx.example.com", false, anchor, anchorKey)
+	other, _ := testCertificate(t, "other.example.com", false, nil, nil)
+
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	anchorSum := sha256.Sum256(anchor.Raw)
+
+	tests := []struct {
+		name    string
+		host    string
+		records []tlsaRecord
+		chain   []*x509.Certificate
+		wantErr bool
+	}{
+		{"DANE-EE matching the leaf key", "mx.example.com", []tlsaRecord{{tlsaUsageDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, spki[:]}}, []*x509.Certificate{leaf}, false},
+		{"DANE-EE ignores the leaf's names", "mail.example.org", []tlsaRecord{{tlsaUsageDANEEE, tlsaSelectorCert, tlsaMatchFull, leaf.Raw}}, []*x509.Certificate{leaf}, false},
+		{"DANE-EE not matching", "mx.example.com", []tlsaRecord{{tlsaUsageDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, spki[:]}}, []*x509.Certificate{other}, true},
+		{"DANE-TA matching the chain", "mx.example.com", []tlsaRecord{{tlsaUsageDANETA, tlsaSelectorCert, tlsaMatchSHA256, anchorSum[:]}}, []*x509.Certificate{leaf, anchor}, false},
+		{"DANE-TA checks the leaf's names", "mail.example.org", []tlsaRecord{{tlsaUsageDANETA, tlsaSelectorCert, tlsaMatchSHA256, anchorSum[:]}}, []*x509.Certificate{leaf, anchor}, true},
+		{"DANE-TA does not match the leaf", "mx.example.com", []tlsaRecord{{tlsaUsageDANETA, tlsaSelectorCert, tlsaMatchFull, leaf.Raw}}, []*x509.Certificate{leaf}, true},
+		{"unknown matching type", "mx.example.com", []tlsaRecord{{tlsaUsageDANEEE, tlsaSelectorSPKI, 9, spki[:]}}, []*x509.Certificate{leaf}, true},
+		{"no certificate", "mx.example.com", []tlsaRecord{{tlsaUsageDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256, spki[:]}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDANE(tt.host, tt.records, tt.chain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyDANE error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDANERequiresResolver(t *testing.T) {
+	settings := core.ProviderSettings{"host": "smtp.example.com", "tls_policy": tlsPolicyDANE}
+	if err := validateTLSPolicy(settings, false); err == nil {
+		t.Error("DANE without a dns_resolver passed validation")
+	}
+
+	settings["dns_resolver"] = "127.0.0.1:53"
+	if err := validateTLSPolicy(settings, false); err != nil {
+		t.Errorf("validateTLSPolicy: %v", err)
+	}
+}
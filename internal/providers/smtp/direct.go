@@ -0,0 +1,182 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// defaultDirectPort is the port MX hosts are reached on in direct delivery.
+const defaultDirectPort = "25"
+
+// sendDirect delivers a message to the MX hosts of each recipient domain,
+// trying the hosts of a domain in preference order until one accepts it.
+// The message may reach some domains when delivery to others fails.
+func (p *Provider) sendDirect(ctx context.Context, localIP net.IP, from string, recipients []string, msg []byte) error {
+	byDomain := make(map[string][]string)
+	for _, recipient := range recipients {
+		domain := recipient[strings.LastIndex(recipient, "@")+1:]
+		domain = trimDot(domain)
+		byDomain[domain] = append(byDomain[domain], recipient)
+	}
+
+	domains := make([]string, 0, len(byDomain))
+	for domain := range byDomain {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var failures []string
+	refused := 0
+	for _, domain := range domains {
+		err := p.deliverDomain(ctx, domain, localIP, from, byDomain[domain], msg)
+		if err == nil {
+			continue
+		}
+		failures = append(failures, domain+": "+err.Error())
+		var policyErr *policyError
+		if errors.As(err, &policyErr) {
+			refused++
+		}
+	}
+
+	if len(failures) > 0 {
+		message := fmt.Sprintf("failed to deliver to %d of %d domains: %s",
+			len(failures), len(domains), strings.Join(failures, "; "))
+		if refused == len(failures) {
+			return &policyError{err: errors.New(message)}
+		}
+		return errors.New(message)
+	}
+	return nil
+}
+
+// deliverDomain delivers a message to the recipients of one domain.
+func (p *Provider) deliverDomain(ctx context.Context, domain string, localIP net.IP, from string, recipients []string, msg []byte) error {
+	hosts, err := p.mxHosts(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	var sts *stsPolicy
+	if p.config.Get("tls_policy") == tlsPolicyMTASTS {
+		// A policy that cannot be fetched or is not cached is treated as
+		// absent (RFC 8461 section 5.1)
+		sts, _ = p.stsPolicyFor(ctx, domain)
+	}
+
+	port := p.port()
+	var errs []string
+	refused := 0
+	for _, host := range hosts {
+		err := p.deliverHost(ctx, host, port, sts, localIP, from, recipients, msg)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, host+": "+err.Error())
+		var policyErr *policyError
+		if errors.As(err, &policyErr) {
+			refused++
+		}
+	}
+
+	err = errors.New(strings.Join(errs, ", "))
+	if refused == len(errs) {
+		return &policyError{err: err}
+	}
+	return err
+}
+
+// deliverHost delivers a message to one MX host of a domain whose MTA-STS
+// policy, if any, is sts.
+func (p *Provider) deliverHost(ctx context.Context, host, port string, sts *stsPolicy, localIP net.IP, from string, recipients []string, msg []byte) error {
+	if !sts.allows(host) {
+		return &policyError{err: errors.New("not an MX host of the MTA-STS policy")}
+	}
+
+	policy, err := p.serverPolicy(ctx, host, port, sts)
+	if err != nil {
+		return err
+	}
+
+	return p.sendMailFrom(ctx, net.JoinHostPort(host, port), host, dialOptions{
+		localIP: localIP,
+		helo:    p.heloName(),
+		policy:  policy,
+	}, from, recipients, msg)
+}
+
+// mxHosts returns the MX hosts of domain in preference order, or the domain
+// itself when it has no MX records (RFC 5321 section 5.1). Lookups are
+// cached for the "mx_cache_ttl" setting.
+func (p *Provider) mxHosts(ctx context.Context, domain string) ([]string, error) {
+	records, err := p.mx.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return []string{domain}, nil
+		}
+		return nil, fmt.Errorf("failed to look up MX records: %w", err)
+	}
+	if len(records) == 0 {
+		return []string{domain}, nil
+	}
+	if len(records) == 1 && trimDot(records[0].Host) == "" {
+		return nil, errors.New("domain does not accept mail (null MX)")
+	}
+
+	hosts := make([]string, len(records))
+	for i, record := range records {
+		hosts[i] = trimDot(record.Host)
+	}
+	return hosts, nil
+}
+
+// MXCacheStats implements core.MXCacher.
+func (p *Provider) MXCacheStats() core.MXCacheStats {
+	return p.mx.Stats()
+}
+
+// PreresolveMX implements core.MXCacher, caching the MX records of the
+// domains ahead of direct delivery to them. Relays resolve recipient
+// domains themselves, so nothing is looked up unless delivery is direct.
+func (p *Provider) PreresolveMX(ctx context.Context, domains []string) error {
+	if !direct(p.config) {
+		return nil
+	}
+	return p.mx.Preresolve(ctx, domains)
+}
+
+// settingDuration returns the duration of a setting, or zero when it is not
+// set or invalid.
+func settingDuration(settings core.ProviderSettings, key string) time.Duration {
+	d, _ := time.ParseDuration(settings.Get(key))
+	return d
+}
+
+// port returns the "port" setting, which defaults to 25 in direct delivery.
+func (p *Provider) port() string {
+	if port := p.config.Get("port"); port != "" {
+		return port
+	}
+	return defaultDirectPort
+}
+
+// heloName returns the "helo" setting, which defaults to the host name in
+// direct delivery. Relays are greeted as localhost unless it is set.
+func (p *Provider) heloName() string {
+	if helo := p.config.Get("helo"); helo != "" || !direct(p.config) {
+		return helo
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "localhost"
+}
@@ -0,0 +1,208 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MTA-STS policy modes.
+const (
+	stsModeEnforce = "enforce"
+	stsModeTesting = "testing"
+	stsModeNone    = "none"
+)
+
+// Limits on fetched MTA-STS policies (RFC 8461 sections 3.2 and 3.3).
+const (
+	stsMaxPolicySize = 64 * 1024
+	stsMaxAge        = 31557600 * time.Second
+	stsFetchTimeout  = 30 * time.Second
+)
+
+// stsPolicy is a domain's MTA-STS policy.
+type stsPolicy struct {
+	// id is the policy id from the domain's _mta-sts TXT record.
+	id string
+
+	mode   string
+	mx     []string
+	maxAge time.Duration
+}
+
+// allows reports whether the policy lists the MX host mx. Policies not in
+// enforce mode allow any host.
+func (s *stsPolicy) allows(mx string) bool {
+	if s == nil || s.mode != stsModeEnforce {
+		return true
+	}
+
+	mx = trimDot(mx)
+	for _, pattern := range s.mx {
+		pattern = trimDot(pattern)
+		if wildcard, ok := strings.CutPrefix(pattern, "*."); ok {
+			// A wildcard matches exactly one leftmost label
+			if label, rest, found := strings.Cut(mx, "."); found && label != "" && rest == wildcard {
+				return true
+			}
+		} else if mx == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// stsPolicyFor returns the MTA-STS policy of a recipient domain, or nil when
+// it has none. Policies are cached for their max_age and refetched when the
+// domain's policy id changes; the absence of a policy is cached for the
+// policy cache TTL.
+func (p *Provider) stsPolicyFor(ctx context.Context, domain string) (*stsPolicy, error) {
+	now := time.Now()
+	cached, fresh := p.policies.cachedSTS(domain, now)
+
+	id, err := p.stsPolicyID(ctx, domain)
+	if err != nil {
+		// A cached policy survives DNS failures until it expires
+		if fresh {
+			return cached.policy, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case id == "" && fresh:
+		return cached.policy, nil
+	case id == "":
+		p.policies.storeSTS(domain, stsCacheEntry{expires: now.Add(p.policyCacheTTL())})
+		return nil, nil
+	case fresh && cached.policy != nil && cached.policy.id == id:
+		return cached.policy, nil
+	}
+
+	policy, err := p.fetchSTSPolicy(ctx, domain)
+	if err != nil {
+		if fresh {
+			return cached.policy, nil
+		}
+		return nil, fmt.Errorf("failed to fetch MTA-STS policy of %s: %w", domain, err)
+	}
+	policy.id = id
+
+	p.policies.storeSTS(domain, stsCacheEntry{policy: policy, expires: now.Add(policy.maxAge)})
+	return policy, nil
+}
+
+// stsPolicyID returns the policy id from the _mta-sts TXT record of domain,
+// or an empty string when it has none.
+func (p *Provider) stsPolicyID(ctx context.Context, domain string) (string, error) {
+	records, err := p.resolver.LookupTXT(ctx, "_mta-sts."+domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var id string
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1") {
+			continue
+		}
+		if id != "" {
+			// Multiple records mean no policy (RFC 8461 section 3.1)
+			return "", nil
+		}
+		for _, field := range strings.Split(record, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(field), "id="); ok {
+				id = value
+			}
+		}
+		if id == "" {
+			return "", nil
+		}
+	}
+	return id, nil
+}
+
+// fetchSTSPolicy fetches and parses the MTA-STS policy of domain.
+func (p *Provider) fetchSTSPolicy(ctx context.Context, domain string) (*stsPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, stsFetchTimeout)
+	defer cancel()
+
+	url := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.stsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" {
+		return nil, fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	return parseSTSPolicy(io.LimitReader(resp.Body, stsMaxPolicySize))
+}
+
+// parseSTSPolicy parses an MTA-STS policy (RFC 8461 section 3.2).
+func parseSTSPolicy(r io.Reader) (*stsPolicy, error) {
+	policy := &stsPolicy{}
+	var version string
+	var maxAge bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			policy.mode = value
+		case "mx":
+			policy.mx = append(policy.mx, value)
+		case "max_age":
+			seconds, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_age %q", value)
+			}
+			policy.maxAge = min(time.Duration(seconds)*time.Second, stsMaxAge)
+			maxAge = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version != "STSv1":
+		return nil, fmt.Errorf("unsupported version %q", version)
+	case policy.mode != stsModeEnforce && policy.mode != stsModeTesting && policy.mode != stsModeNone:
+		return nil, fmt.Errorf("invalid mode %q", policy.mode)
+	case !maxAge:
+		return nil, errors.New("missing max_age")
+	case policy.mode != stsModeNone && len(policy.mx) == 0:
+		return nil, errors.New("missing mx")
+	}
+	return policy, nil
+}
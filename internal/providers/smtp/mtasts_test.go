@@ -0,0 +1,79 @@
+package smtp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSTSPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		want    *stsPolicy
+		wantErr bool
+	}{
+		{
+			name:   "enforce",
+			policy: "version: STSv1\r\nmode: enforce\r\nmx: mx1.example.com\r\nmx: *.example.net\r\nmax_age: 86400\r\n",
+			want:   &stsPolicy{mode: stsModeEnforce, mx: []string{"mx1.example.com", "*.example.net"}, maxAge: 24 * time.Hour},
+		},
+		{
+			name:   "none without mx",
+			policy: "version: STSv1\nmode: none\nmax_age: 60\n",
+			want:   &stsPolicy{mode: stsModeNone, maxAge: time.Minute},
+		},
+		{
+			name:   "max_age is capped at a year",
+			policy: "version: STSv1\nmode: testing\nmx: mx.example.com\nmax_age: 4000000000\n",
+			want:   &stsPolicy{mode: stsModeTesting, mx: []string{"mx.example.com"}, maxAge: stsMaxAge},
+		},
+		{name: "unsupported version", policy: "version: STSv2\nmode: enforce\nmx: mx.example.com\nmax_age: 60\n", wantErr: true},
+		{name: "invalid mode", policy: "version: STSv1\nmode: strict\nmx: mx.example.com\nmax_age: 60\n", wantErr: true},
+		{name: "missing max_age", policy: "version: STSv1\nmode: enforce\nmx: mx.example.com\n", wantErr: true},
+		{name: "invalid max_age", policy: "version: STSv1\nmode: enforce\nmx: mx.example.com\nmax_age: -1\n", wantErr: true},
+		{name: "enforce without mx", policy: "version: STSv1\nmode: enforce\nmax_age: 60\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSTSPolicy(strings.NewReader(tt.policy))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSTSPolicy error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSTSPolicy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSTSPolicyAllows(t *testing.T) {
+	enforce := &stsPolicy{mode: stsModeEnforce, mx: []string{"mx1.example.com", "*.example.net"}}
+	report := &stsPolicy{mode: stsModeTesting, mx: []string{"mx1.example.com"}}
+
+	tests := []struct {
+		name   string
+		policy *stsPolicy
+		mx     string
+		want   bool
+	}{
+		{"listed host", enforce, "mx1.example.com", true},
+		{"listed host with trailing dot and capitals", enforce, "MX1.Example.com.", true},
+		{"unlisted host", enforce, "mx2.example.com", false},
+		{"wildcard matches one label", enforce, "mx.example.net", true},
+		{"wildcard does not match two labels", enforce, "a.mx.example.net", false},
+		{"wildcard does not match the bare domain", enforce, "example.net", false},
+		{"testing mode allows any host", report, "mx2.example.com", true},
+		{"no policy allows any host", nil, "mx2.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allows(tt.mx); got != tt.want {
+				t.Errorf("allows(%q) = %v, want %v", tt.mx, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package smtp
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/smtp"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/lattiq/mailer/internal/core"
@@ -14,70 +16,114 @@ import (
 
 // Provider implements the core.Provider interface for SMTP.
 type Provider struct {
-	config core.ProviderSettings
+	config   core.ProviderSettings
+	policies *policyCache
+	resolver *net.Resolver
+
+	// mx caches the MX records of recipient domains in direct delivery
+	mx *core.MXCache
+
+	// stsClient fetches MTA-STS policies, which must not be redirected
+	stsClient *http.Client
+
+	// now returns the time written into the Date header and Message-ID
+	now func() time.Time
 }
 
 // NewProvider creates a new SMTP provider.
 func NewProvider(settings core.ProviderSettings) (core.Provider, error) {
-	host := settings.Get("host")
-	if host == "" {
-		return nil, core.NewValidationError("host", "SMTP host is required")
+	if err := validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	provider := &Provider{
+		config:   settings,
+		policies: &policyCache{},
+		resolver: net.DefaultResolver,
+		now:      time.Now,
+		mx:       core.NewMXCache(net.DefaultResolver, settingDuration(settings, "mx_cache_ttl"), settingDuration(settings, "mx_cache_stale_ttl")),
+		stsClient: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	return provider, nil
+}
+
+// validateSettings validates the provider settings. The host and port are
+// not needed for direct delivery.
+func validateSettings(settings core.ProviderSettings) error {
+	direct := direct(settings)
+
+	if settings.Get("host") == "" && !direct {
+		return core.NewValidationError("host", "SMTP host is required")
 	}
 
 	port := settings.Get("port")
-	if port == "" {
-		return nil, core.NewValidationError("port", "SMTP port is required")
+	if port == "" && !direct {
+		return core.NewValidationError("port", "SMTP port is required")
 	}
 
 	// Validate port number
-	if _, err := strconv.Atoi(port); err != nil {
-		return nil, core.NewValidationError("port", "invalid port number: "+port)
+	if _, err := strconv.Atoi(port); err != nil && port != "" {
+		return core.NewValidationError("port", "invalid port number: "+port)
 	}
 
-	provider := &Provider{
-		config: settings,
+	if err := validateTLSPolicy(settings, direct); err != nil {
+		return err
 	}
 
-	return provider, nil
+	for _, key := range []string{"mx_cache_ttl", "mx_cache_stale_ttl"} {
+		if value := settings.Get(key); value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				return core.NewValidationErrorWithValue(key, "must be a positive duration", value)
+			}
+		}
+	}
+
+	return core.MIMEOptionsFromSettings(settings).Validate()
 }
 
 // Send sends a single email using SMTP.
 func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResult, error) {
 	host := p.config.Get("host")
 	port := p.config.Get("port")
-	username := p.config.Get("username")
-	password := p.config.Get("password")
 	useTLS := p.config.Get("tls") == "true"
-	skipVerify := p.config.Get("tls_skip_verify") == "true"
 
 	addr := host + ":" + port
+	tlsConfig := p.tlsConfig()
 
-	// Create TLS config if needed
-	var tlsConfig *tls.Config
-	if useTLS {
-		tlsConfig = &tls.Config{
-			ServerName:         host,
-			InsecureSkipVerify: false,            // Always verify TLS certificates for security
-			MinVersion:         tls.VersionTLS12, // Require TLS 1.2 or higher for security
-		}
-		// Only allow insecure mode if explicitly configured for development
-		if skipVerify {
-			// Log a warning that this is insecure (if logger is available)
-			tlsConfig.InsecureSkipVerify = true
-		}
+	// Resolve the source address of the email's IP pool, if any
+	sourceIP, err := p.sourceAddress(email)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a simple message ID (SMTP doesn't provide one), derived from
+	// the message in deterministic mode
+	idHost := host
+	if direct(p.config) {
+		idHost = p.heloName()
+	}
+	mimeOpts := core.MIMEOptionsFromSettings(p.config)
+	mimeOpts.Date = p.now()
+	if mimeOpts.Deterministic {
+		mimeOpts.MessageIDDomain = idHost
+	} else {
+		mimeOpts.MessageID = fmt.Sprintf("%d@%s", mimeOpts.Date.UnixNano(), idHost)
 	}
 
 	// Build email message
-	message, err := p.buildMessage(email)
+	message, err := core.BuildMessage(email, mimeOpts)
 	if err != nil {
 		return nil, core.NewProviderError("smtp", "message_build_error", "failed to build message: "+err.Error())
 	}
+	messageID := core.MessageID(message)
 
 	// Send email
-	var auth smtp.Auth
-	if username != "" && password != "" {
-		auth = smtp.PlainAuth("", username, password, host)
-	}
+	auth := p.auth()
 
 	// Get all recipient addresses
 	var recipients []string
@@ -91,25 +137,50 @@ func (p *Provider) Send(ctx context.Context, email *core.Email) (*core.SendResul
 		recipients = append(recipients, bcc.Email)
 	}
 
+	// Bounces go to the envelope sender, at the bounce domain when one is set
+	envelopeFrom := core.ReturnPath(email.From, p.config.Get("bounce_domain"))
+
 	// Send the email
 	var sendErr error
-	if useTLS {
-		sendErr = p.sendMailTLS(addr, auth, email.From.Email, recipients, message, tlsConfig)
-	} else {
-		sendErr = smtp.SendMail(addr, auth, email.From.Email, recipients, message)
+	switch policy := p.config.Get("tls_policy"); {
+	case direct(p.config):
+		sendErr = p.sendDirect(ctx, sourceIP, envelopeFrom, recipients, message)
+	case policy != "" && policy != tlsPolicyOpportunistic:
+		var tlsPolicy connPolicy
+		if tlsPolicy, sendErr = p.serverPolicy(ctx, host, port, nil); sendErr == nil {
+			sendErr = p.sendMailFrom(ctx, addr, host, dialOptions{
+				localIP: sourceIP,
+				helo:    p.heloName(),
+				auth:    auth,
+				policy:  tlsPolicy,
+			}, envelopeFrom, recipients, message)
+		}
+	case sourceIP != nil:
+		sendErr = p.sendMailFrom(ctx, addr, host, dialOptions{
+			localIP: sourceIP,
+			helo:    p.heloName(),
+			auth:    auth,
+			policy:  connPolicy{tlsConfig: tlsConfig},
+		}, envelopeFrom, recipients, message)
+	case useTLS:
+		sendErr = p.sendMailTLS(addr, auth, envelopeFrom, recipients, message, tlsConfig)
+	default:
+		sendErr = smtp.SendMail(addr, auth, envelopeFrom, recipients, message)
 	}
 
 	if sendErr != nil {
+		var policyErr *policyError
+		if errors.As(sendErr, &policyErr) {
+			return nil, core.NewProviderError("smtp", "tls_policy_error", "delivery refused by TLS policy: "+sendErr.Error())
+		}
 		return nil, core.NewProviderError("smtp", "send_error", "failed to send email: "+sendErr.Error())
 	}
 
-	// Generate a simple message ID (SMTP doesn't provide one)
-	messageID := fmt.Sprintf("%d@%s", time.Now().UnixNano(), host)
-
 	return &core.SendResult{
-		MessageID: messageID,
-		Provider:  p.Name(),
-		Timestamp: time.Now(),
+		MessageID:     messageID,
+		Provider:      p.Name(),
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
 	}, nil
 }
 
@@ -136,100 +207,89 @@ func (p *Provider) SendBatch(ctx context.Context, emails []*core.Email) (*core.B
 	return result, nil
 }
 
+// SetClock makes the provider read the Date and Message-ID time from now.
+func (p *Provider) SetClock(now func() time.Time) {
+	p.now = now
+}
+
 // ValidateConfig validates the provider configuration.
 func (p *Provider) ValidateConfig() error {
-	if p.config.Get("host") == "" {
-		return core.NewValidationError("host", "SMTP host is required")
-	}
+	return validateSettings(p.config)
+}
 
-	port := p.config.Get("port")
-	if port == "" {
-		return core.NewValidationError("port", "SMTP port is required")
+// Name returns the provider name, which can be overridden with the "name" setting.
+func (p *Provider) Name() string {
+	if name := p.config.Get("name"); name != "" {
+		return name
 	}
+	return "smtp"
+}
 
-	if _, err := strconv.Atoi(port); err != nil {
-		return core.NewValidationError("port", "invalid port number: "+port)
+// tlsConfig returns the TLS configuration for the "tls" setting, or nil when
+// TLS is not enabled.
+func (p *Provider) tlsConfig() *tls.Config {
+	if p.config.Get("tls") != "true" {
+		return nil
 	}
 
-	return nil
+	tlsConfig := &tls.Config{
+		ServerName:         p.config.Get("host"),
+		InsecureSkipVerify: false,            // Always verify TLS certificates for security
+		MinVersion:         tls.VersionTLS12, // Require TLS 1.2 or higher for security
+	}
+	// Only allow insecure mode if explicitly configured for development
+	if p.config.Get("tls_skip_verify") == "true" {
+		// Log a warning that this is insecure (if logger is available)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig
 }
 
-// Name returns the provider name.
-func (p *Provider) Name() string {
-	return "smtp"
+// auth returns PLAIN authentication for the configured credentials, or nil
+// when none are configured.
+func (p *Provider) auth() smtp.Auth {
+	username := p.config.Get("username")
+	password := p.config.Get("password")
+	if username == "" || password == "" {
+		return nil
+	}
+	return smtp.PlainAuth("", username, password, p.config.Get("host"))
 }
 
-// buildMessage builds the email message in RFC 5322 format.
-func (p *Provider) buildMessage(email *core.Email) ([]byte, error) {
-	var message strings.Builder
+// Warm validates connectivity by opening the given number of connections
+// concurrently, each completing the greeting, STARTTLS when offered and
+// authentication before quitting. Connections are not kept, since each send
+// opens its own, but DNS, credential and TLS policy problems surface before
+// the first send. Direct delivery has no server to warm.
+func (p *Provider) Warm(ctx context.Context, connections int) error {
+	if direct(p.config) {
+		return nil
+	}
 
-	// Headers
-	message.WriteString("From: " + email.From.String() + "\r\n")
+	host := p.config.Get("host")
+	port := p.config.Get("port")
+	addr := host + ":" + port
 
-	if len(email.To) > 0 {
-		var toAddrs []string
-		for _, to := range email.To {
-			toAddrs = append(toAddrs, to.String())
+	policy := connPolicy{tlsConfig: p.tlsConfig()}
+	if name := p.config.Get("tls_policy"); name != "" && name != tlsPolicyOpportunistic {
+		var err error
+		if policy, err = p.serverPolicy(ctx, host, port, nil); err != nil {
+			return core.NewTemporaryProviderError("smtp", "warm_error", err.Error())
 		}
-		message.WriteString("To: " + strings.Join(toAddrs, ", ") + "\r\n")
 	}
 
-	if len(email.CC) > 0 {
-		var ccAddrs []string
-		for _, cc := range email.CC {
-			ccAddrs = append(ccAddrs, cc.String())
+	return core.WarmConcurrently(ctx, connections, func(ctx context.Context) error {
+		client, err := dialClient(ctx, addr, host, dialOptions{
+			helo:   p.heloName(),
+			auth:   p.auth(),
+			policy: policy,
+		})
+		if err != nil {
+			return core.NewTemporaryProviderError("smtp", "warm_error", "failed to connect: "+err.Error())
 		}
-		message.WriteString("Cc: " + strings.Join(ccAddrs, ", ") + "\r\n")
-	}
-
-	message.WriteString("Subject: " + email.Subject + "\r\n")
-	message.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
-	message.WriteString("MIME-Version: 1.0\r\n")
-
-	// Add custom headers
-	for key, value := range email.Headers {
-		message.WriteString(key + ": " + value + "\r\n")
-	}
-
-	// Handle multipart message if both HTML and text bodies exist
-	if email.HTMLBody != "" && email.TextBody != "" {
-		boundary := fmt.Sprintf("boundary_%d", time.Now().UnixNano())
-		message.WriteString("Content-Type: multipart/alternative; boundary=" + boundary + "\r\n")
-		message.WriteString("\r\n")
-
-		// Text part
-		message.WriteString("--" + boundary + "\r\n")
-		message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-		message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
-		message.WriteString("\r\n")
-		message.WriteString(email.TextBody + "\r\n")
-		message.WriteString("\r\n")
-
-		// HTML part
-		message.WriteString("--" + boundary + "\r\n")
-		message.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-		message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
-		message.WriteString("\r\n")
-		message.WriteString(email.HTMLBody + "\r\n")
-		message.WriteString("\r\n")
-
-		// End boundary
-		message.WriteString("--" + boundary + "--\r\n")
-	} else if email.HTMLBody != "" {
-		// HTML only
-		message.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
-		message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
-		message.WriteString("\r\n")
-		message.WriteString(email.HTMLBody + "\r\n")
-	} else {
-		// Text only
-		message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-		message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
-		message.WriteString("\r\n")
-		message.WriteString(email.TextBody + "\r\n")
-	}
-
-	return []byte(message.String()), nil
+		defer client.Close()
+		return client.Quit()
+	})
 }
 
 // sendMailTLS sends mail using TLS.
@@ -238,3 +298,156 @@ func (p *Provider) sendMailTLS(addr string, auth smtp.Auth, from string, to []st
 	// This is a simplified version - production code would need more robust TLS handling
 	return smtp.SendMail(addr, auth, from, to, msg)
 }
+
+// sourceAddress returns the local IP address to send the email from, taken
+// from the "ip_pool.<name>" setting of its IP pool or the pool name itself
+// when that is an IP literal. It returns nil when the email has no IP pool.
+func (p *Provider) sourceAddress(email *core.Email) (net.IP, error) {
+	pool := email.Metadata[core.MetadataIPPool]
+	if pool == "" {
+		return nil, nil
+	}
+
+	value := p.config.Get("ip_pool." + pool)
+	if value == "" {
+		value = pool
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, core.NewValidationErrorWithValue("ip_pool", "IP pool does not resolve to a source address", pool)
+	}
+	return ip, nil
+}
+
+// dialOptions configures a connection opened by dialClient.
+type dialOptions struct {
+	// localIP is the local address to dial from; nil uses any.
+	localIP net.IP
+
+	// helo is the name to greet the server with; empty uses localhost.
+	helo string
+
+	// auth authenticates the connection when not nil.
+	auth smtp.Auth
+
+	// policy is the TLS policy of the connection.
+	policy connPolicy
+}
+
+// sendMailFrom sends mail like smtp.SendMail, but with the given dial
+// options, such as a local address, which smtp.SendMail does not support.
+func (p *Provider) sendMailFrom(ctx context.Context, addr, host string, opts dialOptions, from string, to []string, msg []byte) error {
+	client, err := dialClient(ctx, addr, host, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// dialClient connects to addr, upgrades the connection with STARTTLS when
+// offered or required by the TLS policy and authenticates.
+func dialClient(ctx context.Context, addr, host string, opts dialOptions) (*smtp.Client, error) {
+	dialer := &net.Dialer{}
+	if opts.localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.localIP}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn = withContext(ctx, conn)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if opts.helo != "" {
+		if err := client.Hello(opts.helo); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	ok, _ := client.Extension("STARTTLS")
+	switch {
+	case ok:
+		tlsConfig := opts.policy.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			if opts.policy.requireTLS {
+				return nil, &policyError{err: err}
+			}
+			return nil, err
+		}
+	case opts.policy.requireTLS:
+		_ = client.Close()
+		return nil, &policyError{err: errTLSRequired}
+	}
+
+	if opts.auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			_ = client.Close()
+			return nil, errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := client.Auth(opts.auth); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// ctxConn is a connection bounded by a context for its whole session, not
+// only the dial.
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+// withContext returns conn with ctx's deadline applied to every read and
+// write, and with pending and later reads and writes failing once ctx is
+// done.
+func withContext(ctx context.Context, conn net.Conn) net.Conn {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	return &ctxConn{Conn: conn, stop: stop}
+}
+
+// Close stops watching the context and closes the connection.
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
@@ -0,0 +1,206 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// TLS policy modes of the "tls_policy" setting.
+const (
+	// tlsPolicyOpportunistic upgrades with STARTTLS when the server offers
+	// it and otherwise delivers in plaintext.
+	tlsPolicyOpportunistic = "opportunistic"
+
+	// tlsPolicyRequire refuses delivery unless the connection is upgraded
+	// with STARTTLS and the server certificate verifies.
+	tlsPolicyRequire = "require"
+
+	// tlsPolicyMTASTS applies the recipient domain's MTA-STS policy (RFC
+	// 8461): domains publishing an enforced policy receive mail only over
+	// verified TLS to the MX hosts it lists. Other domains are opportunistic.
+	tlsPolicyMTASTS = "mta-sts"
+
+	// tlsPolicyDANE applies the server's DNSSEC-signed TLSA records (RFC
+	// 7672): servers publishing them receive mail only over TLS with a
+	// certificate matching the records. Other servers are opportunistic.
+	tlsPolicyDANE = "dane"
+)
+
+// defaultPolicyCacheTTL is how long TLSA records and the absence of MTA-STS
+// policies are cached when "tls_policy_cache_ttl" is not set.
+const defaultPolicyCacheTTL = time.Hour
+
+// errTLSRequired is returned when the TLS policy requires STARTTLS and the
+// server does not offer it.
+var errTLSRequired = errors.New("smtp: TLS policy requires STARTTLS, which the server does not offer")
+
+// policyError marks a delivery refused by the TLS policy.
+type policyError struct {
+	err error
+}
+
+func (e *policyError) Error() string { return e.err.Error() }
+func (e *policyError) Unwrap() error { return e.err }
+
+// connPolicy is the TLS policy for one connection.
+type connPolicy struct {
+	// requireTLS refuses the connection when STARTTLS is not offered or fails.
+	requireTLS bool
+
+	// tlsConfig upgrades the connection; nil uses a verifying configuration
+	// for the server name.
+	tlsConfig *tls.Config
+}
+
+// validateTLSPolicy validates the "tls_policy" and "tls_policy_cache_ttl"
+// settings, and that DANE has a "dns_resolver" to trust.
+func validateTLSPolicy(settings core.ProviderSettings, direct bool) error {
+	switch policy := settings.Get("tls_policy"); policy {
+	case "", tlsPolicyOpportunistic, tlsPolicyRequire:
+	case tlsPolicyDANE:
+		// The resolver's DNSSEC validation is trusted, so it is never
+		// guessed from the system configuration
+		if settings.Get("dns_resolver") == "" {
+			return core.NewValidationError("dns_resolver",
+				"DANE requires a trusted validating resolver, such as one on localhost")
+		}
+	case tlsPolicyMTASTS:
+		if !direct {
+			return core.NewValidationErrorWithValue("tls_policy", "MTA-STS applies to direct delivery only", policy)
+		}
+	default:
+		return core.NewValidationErrorWithValue("tls_policy",
+			"must be opportunistic, require, mta-sts or dane", policy)
+	}
+
+	if ttl := settings.Get("tls_policy_cache_ttl"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			return core.NewValidationErrorWithValue("tls_policy_cache_ttl", "must be a positive duration", ttl)
+		}
+	}
+	return nil
+}
+
+// policyCache caches MTA-STS policies per recipient domain and TLSA records
+// per server, shared by all sends of a provider.
+type policyCache struct {
+	mutex sync.Mutex
+	sts   map[string]stsCacheEntry
+	tlsa  map[string]tlsaCacheEntry
+}
+
+// stsCacheEntry is a cached MTA-STS lookup; policy is nil for domains without
+// a policy.
+type stsCacheEntry struct {
+	policy  *stsPolicy
+	expires time.Time
+}
+
+// tlsaCacheEntry is a cached TLSA lookup; records is empty when the server
+// has no usable DNSSEC-signed records.
+type tlsaCacheEntry struct {
+	records []tlsaRecord
+	expires time.Time
+}
+
+// cachedSTS returns the unexpired cached MTA-STS lookup for domain, if any.
+func (c *policyCache) cachedSTS(domain string, now time.Time) (stsCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.sts[domain]
+	return entry, ok && now.Before(entry.expires)
+}
+
+// storeSTS caches the MTA-STS lookup for domain.
+func (c *policyCache) storeSTS(domain string, entry stsCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sts == nil {
+		c.sts = make(map[string]stsCacheEntry)
+	}
+	c.sts[domain] = entry
+}
+
+// cachedTLSA returns the unexpired cached TLSA lookup for name, if any.
+func (c *policyCache) cachedTLSA(name string, now time.Time) ([]tlsaRecord, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.tlsa[name]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.records, true
+}
+
+// storeTLSA caches the TLSA lookup for name.
+func (c *policyCache) storeTLSA(name string, entry tlsaCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.tlsa == nil {
+		c.tlsa = make(map[string]tlsaCacheEntry)
+	}
+	c.tlsa[name] = entry
+}
+
+// policyCacheTTL returns the "tls_policy_cache_ttl" setting.
+func (p *Provider) policyCacheTTL() time.Duration {
+	if d, err := time.ParseDuration(p.config.Get("tls_policy_cache_ttl")); err == nil && d > 0 {
+		return d
+	}
+	return defaultPolicyCacheTTL
+}
+
+// serverPolicy returns the connection policy for the server host, on the
+// given port, under the "tls_policy" setting. sts is the recipient domain's
+// MTA-STS policy in direct delivery, if any.
+func (p *Provider) serverPolicy(ctx context.Context, host, port string, sts *stsPolicy) (connPolicy, error) {
+	verified := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch p.config.Get("tls_policy") {
+	case tlsPolicyRequire:
+		verified.InsecureSkipVerify = p.config.Get("tls_skip_verify") == "true"
+		return connPolicy{requireTLS: true, tlsConfig: verified}, nil
+	case tlsPolicyMTASTS:
+		if sts != nil && sts.mode == stsModeEnforce {
+			return connPolicy{requireTLS: true, tlsConfig: verified}, nil
+		}
+	case tlsPolicyDANE:
+		records, err := p.tlsaRecords(ctx, host, port)
+		if err != nil {
+			return connPolicy{}, err
+		}
+		if len(records) > 0 {
+			return connPolicy{requireTLS: true, tlsConfig: daneConfig(host, records)}, nil
+		}
+	}
+
+	// Opportunistic TLS between MTAs does not verify certificates (RFC
+	// 7435), since MX hosts commonly present certificates for other names;
+	// relays are verified as before unless "tls_skip_verify" is set
+	verified.InsecureSkipVerify = direct(p.config) || p.config.Get("tls_skip_verify") == "true"
+	return connPolicy{tlsConfig: verified}, nil
+}
+
+// direct reports whether settings select direct delivery to recipients' MX
+// hosts.
+func direct(settings core.ProviderSettings) bool {
+	return settings.Get("direct") == "true"
+}
+
+// trimDot returns a DNS name without its trailing dot, lowercased.
+func trimDot(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
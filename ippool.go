@@ -0,0 +1,13 @@
+package mailer
+
+import "github.com/lattiq/mailer/internal/core"
+
+// applyIPPool returns the email with the IP pool configured for its category
+// recorded in its metadata, or the email unchanged when no pool applies.
+func (c *Client) applyIPPool(email *Email) *Email {
+	pool := c.config.IPPools[email.Category()]
+	if pool == "" {
+		return email
+	}
+	return email.WithMetadata(core.MetadataIPPool, pool)
+}
@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ContentLimits restricts the size and complexity of emails of a given priority.
+// Zero values mean "no limit".
+type ContentLimits struct {
+	// MaxSize is the maximum size in bytes of the subject, bodies and attachments combined.
+	MaxSize int64
+
+	// MaxAttachments is the maximum number of attachments.
+	MaxAttachments int
+
+	// NoAttachments rejects any email that carries attachments.
+	NoAttachments bool
+
+	// MaxRecipients is the maximum number of recipients (To + CC + BCC).
+	MaxRecipients int
+}
+
+// checkContentLimits validates an email against the limits configured for its priority.
+func (c *Client) checkContentLimits(email *Email) error {
+	limits, ok := c.config.ContentLimits[email.Priority]
+	if !ok {
+		return nil
+	}
+
+	priority := email.Priority.String()
+
+	if limits.NoAttachments && email.HasAttachments() {
+		return NewValidationError("attachments", priority+" priority emails must not have attachments")
+	}
+
+	if limits.MaxAttachments > 0 && len(email.Attachments) > limits.MaxAttachments {
+		return NewValidationErrorWithValue("attachments",
+			priority+" priority emails allow at most "+strconv.Itoa(limits.MaxAttachments)+" attachments",
+			len(email.Attachments))
+	}
+
+	if limits.MaxRecipients > 0 && email.TotalRecipients() > limits.MaxRecipients {
+		return NewValidationErrorWithValue("recipients",
+			priority+" priority emails allow at most "+strconv.Itoa(limits.MaxRecipients)+" recipients",
+			email.TotalRecipients())
+	}
+
+	// Reject early what is too large by the attachment sizes known up front;
+	// checkContentSize enforces the limit once all attachments are read
+	if limits.MaxSize > 0 {
+		if size := emailSize(email); size > limits.MaxSize {
+			return sizeLimitError(priority, limits.MaxSize, size)
+		}
+	}
+
+	return nil
+}
+
+// checkContentSize validates the size of an email against the limit
+// configured for its priority, counting the attachments as audit read them.
+func (c *Client) checkContentSize(email *Email, audit *attachmentAudit) error {
+	limits, ok := c.config.ContentLimits[email.Priority]
+	if !ok || limits.MaxSize <= 0 {
+		return nil
+	}
+
+	size := int64(len(email.Subject) + len(email.HTMLBody) + len(email.TextBody))
+	if audit != nil {
+		for _, record := range audit.records {
+			size += record.Size
+		}
+	}
+	if size > limits.MaxSize {
+		return sizeLimitError(email.Priority.String(), limits.MaxSize, size)
+	}
+	return nil
+}
+
+// sizeLimitError reports an email of the priority exceeding maxSize.
+func sizeLimitError(priority string, maxSize, size int64) error {
+	return NewValidationErrorWithValue("size",
+		fmt.Sprintf("%s priority emails must not exceed %d bytes", priority, maxSize),
+		size)
+}
+
+// emailSize estimates the size of an email's content in bytes, as a lower
+// bound. Attachments count towards the size when their length is known
+// without consuming the reader, either through Size or a Len method.
+func emailSize(email *Email) int64 {
+	size := int64(len(email.Subject) + len(email.HTMLBody) + len(email.TextBody))
+	for _, attachment := range email.Attachments {
+		switch {
+		case attachment.Size > 0:
+			size += attachment.Size
+		case attachment.Data != nil:
+			if sized, ok := attachment.Data.(interface{ Len() int }); ok {
+				size += int64(sized.Len())
+			}
+		}
+	}
+	return size
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// LocaleResolver determines the locale and timezone for a template send when
+// the request's TemplateOptions leave them empty, e.g. from the context, the
+// recipient or a contact store.
+type LocaleResolver interface {
+	ResolveLocale(ctx context.Context, req *TemplateRequest) (locale, timezone string, err error)
+}
+
+// LocaleResolverFunc adapts a function to the LocaleResolver interface.
+type LocaleResolverFunc func(ctx context.Context, req *TemplateRequest) (locale, timezone string, err error)
+
+// ResolveLocale calls f(ctx, req).
+func (f LocaleResolverFunc) ResolveLocale(ctx context.Context, req *TemplateRequest) (string, string, error) {
+	return f(ctx, req)
+}
+
+// localeContextKey is the context key for values set by ContextWithLocale.
+type localeContextKey struct{}
+
+// localeContextValue holds the locale and timezone stored in a context.
+type localeContextValue struct {
+	locale   string
+	timezone string
+}
+
+// ContextWithLocale returns a context carrying the locale and timezone used by
+// ContextLocaleResolver, typically set once per incoming request.
+func ContextWithLocale(ctx context.Context, locale, timezone string) context.Context {
+	return context.WithValue(ctx, localeContextKey{}, localeContextValue{locale: locale, timezone: timezone})
+}
+
+// ContextLocaleResolver resolves the locale and timezone stored with
+// ContextWithLocale. It is used when no LocaleResolver is configured.
+var ContextLocaleResolver LocaleResolver = LocaleResolverFunc(func(ctx context.Context, _ *TemplateRequest) (string, string, error) {
+	value, _ := ctx.Value(localeContextKey{}).(localeContextValue)
+	return value.locale, value.timezone, nil
+})
+
+// resolveTemplateOptions returns the request's template options with an empty
+// locale or timezone filled in by the configured LocaleResolver.
+func (c *Client) resolveTemplateOptions(ctx context.Context, req *TemplateRequest) (*TemplateOptions, error) {
+	var options TemplateOptions
+	if req.Options != nil {
+		options = *req.Options
+	}
+
+	if options.Locale != "" && options.Timezone != "" {
+		return &options, nil
+	}
+
+	resolver := c.config.Templates.LocaleResolver
+	if resolver == nil {
+		resolver = ContextLocaleResolver
+	}
+
+	locale, timezone, err := resolver.ResolveLocale(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if options.Locale == "" {
+		options.Locale = locale
+	}
+	if options.Timezone == "" {
+		options.Timezone = timezone
+	}
+
+	return &options, nil
+}
+
+// renderLocalized renders the most specific locale variant of a template part,
+// trying e.g. the "welcome.de-AT", then "welcome.de", then "welcome" template,
+// with the "t" function translating into the locale.
+func (c *Client) renderLocalized(name, locale, part string, data interface{}) (string, error) {
+	resolver := templateResolver(c.config.Templates)
+	for _, candidate := range localeCandidates(locale) {
+		output, err := c.templateEng.RenderLocale(resolver.PartName(name+"."+candidate, part), locale, data)
+		if !errors.Is(err, ErrTemplateNotFound) {
+			return output, err
+		}
+	}
+	return c.templateEng.RenderLocale(resolver.PartName(name, part), locale, data)
+}
+
+// localeCandidates returns the locale followed by its parent languages, e.g.
+// "de-AT" yields "de-AT" and "de".
+func localeCandidates(locale string) []string {
+	locale = strings.ReplaceAll(strings.TrimSpace(locale), "_", "-")
+	if locale == "" {
+		return nil
+	}
+
+	candidates := []string{locale}
+	for i := strings.LastIndex(locale, "-"); i > 0; i = strings.LastIndex(locale, "-") {
+		locale = locale[:i]
+		candidates = append(candidates, locale)
+	}
+	return candidates
+}
@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"context"
+	"io/fs"
 )
 
 // Public interfaces for the mailer library
@@ -32,6 +33,10 @@ type (
 		// Render renders a template with the provided data.
 		Render(templateName string, data interface{}) (string, error)
 
+		// RenderLocale renders a template with the "t" function translating
+		// into the given locale.
+		RenderLocale(templateName, locale string, data interface{}) (string, error)
+
 		// RegisterTemplate registers a template with the given name and content.
 		RegisterTemplate(name string, content string) error
 
@@ -39,5 +44,27 @@ type (
 		// Templates should follow the naming convention: <name>.<type>.<ext>
 		// where type is 'subject', 'html', or 'text'.
 		LoadTemplatesFromDir(dir string) error
+
+		// LoadTemplatesFromFS loads all templates under root in fsys, such as
+		// an embed.FS, following the same conventions as LoadTemplatesFromDir.
+		LoadTemplatesFromFS(fsys fs.FS, root string) error
+
+		// Names returns the sorted names of all registered templates.
+		Names() []string
+
+		// List returns information about all registered templates, sorted by name.
+		List() []TemplateInfo
+
+		// Defaults returns the defaults the template manifest declares for a
+		// template, if any.
+		Defaults(template string) (TemplateDefaults, bool)
+
+		// Purge removes all registered templates.
+		Purge()
+
+		// Reload replaces all registered templates with those in the configured
+		// directory. Templates registered with RegisterTemplate are dropped.
+		// On error the previously registered templates are kept.
+		Reload() error
 	}
 )
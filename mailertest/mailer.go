@@ -0,0 +1,118 @@
+package mailertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/lattiq/mailer"
+)
+
+// RecordingMailer is a mailer.Mailer that records the emails sent through
+// it instead of sending them. Emails are validated as the client validates
+// them; template requests are recorded unrendered, see TemplateRequests.
+type RecordingMailer struct {
+	*Recorder
+
+	mu        sync.Mutex
+	templates []*mailer.TemplateRequest
+	closed    bool
+}
+
+// NewRecordingMailer returns a RecordingMailer with nothing recorded.
+func NewRecordingMailer() *RecordingMailer {
+	return &RecordingMailer{Recorder: newRecorder()}
+}
+
+// Send implements mailer.Mailer, recording email unless it is invalid or
+// an error is injected.
+func (m *RecordingMailer) Send(ctx context.Context, email *mailer.Email) error {
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+	if err := email.Validate(); err != nil {
+		return err
+	}
+	return m.record(email)
+}
+
+// SendBatch implements mailer.Mailer, recording each email unless it is
+// invalid or an error is injected, and returning a *mailer.BatchError for
+// the others.
+func (m *RecordingMailer) SendBatch(ctx context.Context, emails []*mailer.Email) error {
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+
+	var failed []mailer.BatchItemError
+	for i, email := range emails {
+		err := email.Validate()
+		if err == nil {
+			err = m.record(email)
+		}
+		if err != nil {
+			failed = append(failed, mailer.BatchItemError{Index: i, Error: err})
+		}
+	}
+	if len(failed) > 0 {
+		return &mailer.BatchError{
+			Message: fmt.Sprintf("%d/%d emails failed", len(failed), len(emails)),
+			Errors:  failed,
+			Total:   len(emails),
+			Failed:  len(failed),
+		}
+	}
+	return nil
+}
+
+// SendTemplate implements mailer.Mailer, recording req unless an error is
+// injected. The template is not rendered, and no email is recorded.
+func (m *RecordingMailer) SendTemplate(ctx context.Context, req *mailer.TemplateRequest) error {
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+	if req == nil {
+		return mailer.NewValidationError("request", "template request is required")
+	}
+
+	if err := m.injected(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.templates = append(m.templates, req)
+	return nil
+}
+
+// TemplateRequests returns the recorded template requests, in the order
+// they were sent.
+func (m *RecordingMailer) TemplateRequests() []*mailer.TemplateRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*mailer.TemplateRequest(nil), m.templates...)
+}
+
+// Close implements mailer.Mailer. Sends after Close fail with
+// mailer.ErrClientClosed.
+func (m *RecordingMailer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// check returns the error of a send that is not attempted: the context's
+// error or, once the mailer is closed, mailer.ErrClientClosed.
+func (m *RecordingMailer) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return mailer.ErrClientClosed
+	}
+	return nil
+}
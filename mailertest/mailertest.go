@@ -0,0 +1,176 @@
+// Package mailertest provides in-memory mailers for unit-testing email flows
+// without network access.
+//
+// RecordingMailer implements mailer.Mailer, for code that depends on the
+// interface:
+//
+//	func TestSignup(t *testing.T) {
+//		m := mailertest.NewRecordingMailer()
+//		signup(ctx, m, "ada@example.com")
+//
+//		email := m.AssertSentTo(t, "ada@example.com")
+//		if email.Subject != "Welcome" {
+//			t.Errorf("subject = %q", email.Subject)
+//		}
+//	}
+//
+// MockProvider is a provider for a real client, so that templates,
+// middleware, retries and failover run as in production:
+//
+//	mock := mailertest.NewMockProvider("mock")
+//	defer mock.Close()
+//	client, err := mailer.New(mailer.DefaultConfig(),
+//		mailer.WithProvider(mailertest.ProviderType, mock.Settings()))
+//
+// Both record the emails they accept and can be made to fail with SetError
+// and FailNext.
+package mailertest
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+// Recorder records accepted emails and injects send errors. Its methods are
+// safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	emails   []*mailer.Email
+	err      error
+	failNext []error
+	changed  chan struct{}
+}
+
+// newRecorder returns an empty recorder.
+func newRecorder() *Recorder {
+	return &Recorder{changed: make(chan struct{})}
+}
+
+// SetError makes every send fail with err until it is called with nil.
+func (r *Recorder) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// FailNext makes the next send fail with err. Calls queue up: the first
+// call fails the next send, the second the one after it, and so on.
+func (r *Recorder) FailNext(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failNext = append(r.failNext, err)
+}
+
+// injected returns the error injected into the next send, if any.
+func (r *Recorder) injected() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.injectedLocked()
+}
+
+// injectedLocked is injected with r.mu held.
+func (r *Recorder) injectedLocked() error {
+	if len(r.failNext) > 0 {
+		err := r.failNext[0]
+		r.failNext = r.failNext[1:]
+		return err
+	}
+	return r.err
+}
+
+// record returns the injected error of a send, or records email when there
+// is none.
+func (r *Recorder) record(email *mailer.Email) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.injectedLocked(); err != nil {
+		return err
+	}
+
+	r.emails = append(r.emails, email)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return nil
+}
+
+// Emails returns the accepted emails, in the order they were sent.
+func (r *Recorder) Emails() []*mailer.Email {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*mailer.Email(nil), r.emails...)
+}
+
+// Count returns the number of accepted emails.
+func (r *Recorder) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.emails)
+}
+
+// LastEmail returns the last accepted email, or nil when none was.
+func (r *Recorder) LastEmail() *mailer.Email {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.emails) == 0 {
+		return nil
+	}
+	return r.emails[len(r.emails)-1]
+}
+
+// Reset forgets the accepted emails and the injected errors.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emails = nil
+	r.err = nil
+	r.failNext = nil
+}
+
+// AssertSentTo fails the test unless an accepted email was addressed to
+// address, in its To, CC or BCC, compared case-insensitively. It returns
+// the last such email.
+func (r *Recorder) AssertSentTo(t testing.TB, address string) *mailer.Email {
+	t.Helper()
+
+	emails := r.Emails()
+	for i := len(emails) - 1; i >= 0; i-- {
+		for _, recipient := range emails[i].AllRecipients() {
+			if strings.EqualFold(recipient.Email, address) {
+				return emails[i]
+			}
+		}
+	}
+	t.Errorf("mailertest: no email sent to %s (%d emails sent)", address, len(emails))
+	return nil
+}
+
+// WaitForCount waits until at least n emails were accepted, e.g. by code
+// sending in the background, failing the test if they are not within
+// timeout. It returns the accepted emails.
+func (r *Recorder) WaitForCount(t testing.TB, n int, timeout time.Duration) []*mailer.Email {
+	t.Helper()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		count, changed := len(r.emails), r.changed
+		r.mu.Unlock()
+		if count >= n {
+			return r.Emails()
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			t.Errorf("mailertest: %d emails sent within %v, want %d", count, timeout, n)
+			return r.Emails()
+		}
+	}
+}
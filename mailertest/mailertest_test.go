@@ -0,0 +1,121 @@
+package mailertest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func testEmail(to string) *mailer.Email {
+	return &mailer.Email{
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: to}},
+		Subject:  "Hello",
+		TextBody: "Hello",
+	}
+}
+
+func TestRecordingMailerInjectsErrorsInOrder(t *testing.T) {
+	m := mailertest.NewRecordingMailer()
+	first, second, always := errors.New("first"), errors.New("second"), errors.New("always")
+	m.FailNext(first)
+	m.FailNext(second)
+
+	ctx := context.Background()
+	if err := m.Send(ctx, testEmail("a@example.com")); !errors.Is(err, first) {
+		t.Errorf("first send error = %v, want %v", err, first)
+	}
+	if err := m.Send(ctx, testEmail("b@example.com")); !errors.Is(err, second) {
+		t.Errorf("second send error = %v, want %v", err, second)
+	}
+	if err := m.Send(ctx, testEmail("c@example.com")); err != nil {
+		t.Errorf("third send error = %v, want nil", err)
+	}
+
+	m.SetError(always)
+	if err := m.Send(ctx, testEmail("d@example.com")); !errors.Is(err, always) {
+		t.Errorf("send error = %v, want %v", err, always)
+	}
+	m.SetError(nil)
+
+	if m.Count() != 1 || m.LastEmail().To[0].Email != "c@example.com" {
+		t.Errorf("recorded %d emails, last %+v; want only the third", m.Count(), m.LastEmail())
+	}
+	m.AssertSentTo(t, "C@Example.com")
+}
+
+func TestRecordingMailerSendBatchReportsFailedItems(t *testing.T) {
+	m := mailertest.NewRecordingMailer()
+	m.FailNext(errors.New("rejected"))
+
+	invalid := testEmail("b@example.com")
+	invalid.From = mailer.Address{}
+	err := m.SendBatch(context.Background(), []*mailer.Email{
+		testEmail("a@example.com"), invalid, testEmail("c@example.com"),
+	})
+
+	var batchErr *mailer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("SendBatch error = %v, want a *mailer.BatchError", err)
+	}
+	if batchErr.Failed != 2 || batchErr.Errors[0].Index != 0 || batchErr.Errors[1].Index != 1 {
+		t.Errorf("failed items = %+v, want indexes 0 and 1", batchErr.Errors)
+	}
+	if m.Count() != 1 {
+		t.Errorf("recorded %d emails, want 1", m.Count())
+	}
+}
+
+func TestRecordingMailerRejectsSendsAfterClose(t *testing.T) {
+	m := mailertest.NewRecordingMailer()
+	if err := m.SendTemplate(context.Background(), &mailer.TemplateRequest{Template: "welcome"}); err != nil {
+		t.Fatalf("SendTemplate: %v", err)
+	}
+	if got := m.TemplateRequests(); len(got) != 1 || got[0].Template != "welcome" {
+		t.Errorf("TemplateRequests() = %v, want the welcome request", got)
+	}
+
+	m.Close()
+	if err := m.Send(context.Background(), testEmail("a@example.com")); !errors.Is(err, mailer.ErrClientClosed) {
+		t.Errorf("Send after Close error = %v, want %v", err, mailer.ErrClientClosed)
+	}
+}
+
+func TestWaitForCountReturnsOnceSent(t *testing.T) {
+	m := mailertest.NewRecordingMailer()
+	go func() {
+		for _, to := range []string{"a@example.com", "b@example.com"} {
+			time.Sleep(5 * time.Millisecond)
+			m.Send(context.Background(), testEmail(to))
+		}
+	}()
+
+	if emails := m.WaitForCount(t, 2, time.Second); len(emails) != 2 {
+		t.Errorf("WaitForCount returned %d emails, want 2", len(emails))
+	}
+}
+
+func TestMockProviderRecordsClientSends(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	client, err := mailer.New(mailer.DefaultConfig(), mailer.WithProvider(mailertest.ProviderType, mock.Settings()))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Send(context.Background(), testEmail("a@example.com")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	mock.AssertSentTo(t, "a@example.com")
+
+	mock.Reset()
+	if mock.Count() != 0 {
+		t.Errorf("Count() after Reset = %d, want 0", mock.Count())
+	}
+}
@@ -0,0 +1,115 @@
+package mailertest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+// ProviderType is the provider type of mock providers, configured with the
+// settings returned by MockProvider.Settings.
+const ProviderType mailer.ProviderType = "mailertest"
+
+func init() {
+	mailer.RegisterProvider(ProviderType, providerFromSettings)
+}
+
+// providers holds the mock providers by ID, for the provider factory to find
+// from their settings.
+var (
+	providers sync.Map // string -> *MockProvider
+	nextID    atomic.Int64
+)
+
+// MockProvider is an in-memory provider that records the emails a client
+// sends through it instead of delivering them.
+type MockProvider struct {
+	*Recorder
+
+	name string
+	id   string
+	sent atomic.Int64
+}
+
+// NewMockProvider returns a mock provider with the given name. Configure a
+// client with it using Settings, and call Close once the client is closed.
+func NewMockProvider(name string) *MockProvider {
+	p := &MockProvider{
+		Recorder: newRecorder(),
+		name:     name,
+		id:       strconv.FormatInt(nextID.Add(1), 10),
+	}
+	providers.Store(p.id, p)
+	return p
+}
+
+// providerFromSettings returns the provider the settings were created for.
+func providerFromSettings(settings mailer.ProviderSettings) (mailer.Provider, error) {
+	p, ok := providers.Load(settings.Get("id"))
+	if !ok {
+		return nil, fmt.Errorf("mailertest: no mock provider with id %q; use MockProvider.Settings", settings.Get("id"))
+	}
+	return p.(*MockProvider), nil
+}
+
+// Settings returns the settings configuring a client with the provider,
+// with ProviderType:
+//
+//	mailer.WithProvider(mailertest.ProviderType, mock.Settings())
+func (p *MockProvider) Settings() mailer.ProviderSettings {
+	return mailer.ProviderSettings{"id": p.id, "name": p.name}
+}
+
+// Close releases the provider, after which clients can no longer be
+// configured with its settings. Recorded emails remain available.
+func (p *MockProvider) Close() {
+	providers.Delete(p.id)
+}
+
+// Name implements mailer.Provider.
+func (p *MockProvider) Name() string {
+	return p.name
+}
+
+// ValidateConfig implements mailer.Provider.
+func (p *MockProvider) ValidateConfig() error {
+	return nil
+}
+
+// Send implements mailer.Provider, recording email unless an error is
+// injected.
+func (p *MockProvider) Send(ctx context.Context, email *mailer.Email) (*mailer.SendResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := p.record(email); err != nil {
+		return nil, err
+	}
+
+	return &mailer.SendResult{
+		MessageID:     p.name + "-" + strconv.FormatInt(p.sent.Add(1), 10),
+		Provider:      p.name,
+		Timestamp:     time.Now(),
+		CorrelationID: email.CorrelationID(),
+	}, nil
+}
+
+// SendBatch implements mailer.Provider by sending the emails one by one, so
+// injected errors fail individual emails.
+func (p *MockProvider) SendBatch(ctx context.Context, emails []*mailer.Email) (*mailer.BatchResult, error) {
+	result := &mailer.BatchResult{Total: len(emails), Provider: p.name}
+	for i, email := range emails {
+		sent, err := p.Send(ctx, email)
+		if err != nil {
+			result.Failed = append(result.Failed, mailer.BatchFailure{Index: i, Email: email, Error: err})
+			continue
+		}
+		result.Successful = append(result.Successful, sent)
+	}
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer and meter of the client.
+const instrumentationName = "github.com/lattiq/mailer"
+
+// newTracer returns the client's tracer from the configured tracer provider,
+// or the global one when none is configured.
+func newTracer(config TracingConfig) trace.Tracer {
+	provider := config.Provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// metrics holds the instruments recording provider calls.
+type metrics struct {
+	requests metric.Int64Counter
+	duration metric.Float64Histogram
+}
+
+// newMetrics creates the instruments from the configured meter provider, or
+// the global one when none is configured, named with the configured
+// namespace, observing the MX caches reported by mxCaches. It returns nil
+// when metrics are disabled.
+func newMetrics(config MetricsConfig, mxCaches func() map[string]MXCacheStats) (*metrics, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	provider := config.Provider
+	if provider == nil {
+		provider = otel.GetMeterProvider()
+	}
+	meter := provider.Meter(instrumentationName)
+
+	prefix := ""
+	if config.Namespace != "" {
+		prefix = config.Namespace + "."
+	}
+
+	requests, err := meter.Int64Counter(prefix+"provider.requests",
+		metric.WithDescription("Emails handed to providers, by provider and outcome"))
+	if err != nil {
+		return nil, err
+	}
+	duration, err := meter.Float64Histogram(prefix+"provider.duration",
+		metric.WithDescription("Duration of provider calls per email"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := observeMXCaches(meter, prefix, mxCaches); err != nil {
+		return nil, err
+	}
+
+	return &metrics{requests: requests, duration: duration}, nil
+}
+
+// observeMXCaches registers instruments reporting the lookups and entries
+// of the providers' MX caches, by provider and, for lookups, outcome: hit,
+// miss or stale.
+func observeMXCaches(meter metric.Meter, prefix string, mxCaches func() map[string]MXCacheStats) error {
+	lookups, err := meter.Int64ObservableCounter(prefix+"dns.mx_cache.lookups",
+		metric.WithDescription("MX record lookups of recipient domains, by provider and cache outcome"))
+	if err != nil {
+		return err
+	}
+	entries, err := meter.Int64ObservableGauge(prefix+"dns.mx_cache.entries",
+		metric.WithDescription("Recipient domains with cached MX records, by provider"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		for provider, stats := range mxCaches() {
+			attr := attribute.String("mailer.provider", provider)
+			for outcome, count := range map[string]int64{"hit": stats.Hits, "miss": stats.Misses, "stale": stats.Stale} {
+				observer.ObserveInt64(lookups, count, metric.WithAttributes(attr, attribute.String("mailer.cache_outcome", outcome)))
+			}
+			observer.ObserveInt64(entries, int64(stats.Entries), metric.WithAttributes(attr))
+		}
+		return nil
+	}, lookups, entries)
+	return err
+}
+
+// record records an email handed to provider, taking duration and failing
+// when failed.
+func (m *metrics) record(ctx context.Context, provider string, duration time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+
+	outcome := "success"
+	if failed {
+		outcome = "failure"
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("mailer.provider", provider),
+		attribute.String("mailer.outcome", outcome),
+	)
+	m.requests.Add(ctx, 1, attrs)
+	m.duration.Record(ctx, duration.Seconds(), attrs)
+}
@@ -0,0 +1,207 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SendFunc sends a single email.
+type SendFunc func(ctx context.Context, email *Email) error
+
+// Middleware wraps the send pipeline, e.g. to log every email, inject
+// headers, rewrite content or enforce policy. A middleware may change the
+// email it passes to next, or return an error without calling next to stop
+// the send. Middleware should copy an email before changing it, since the
+// email belongs to the caller.
+type Middleware func(next SendFunc) SendFunc
+
+// chain wraps send in middleware, the first middleware outermost.
+func chain(middleware []Middleware, send SendFunc) SendFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		send = middleware[i](send)
+	}
+	return send
+}
+
+// Progress of an email through the middleware chain of a batch.
+const (
+	// batchCallPending is an email whose chain has neither called next nor
+	// returned.
+	batchCallPending = iota
+
+	// batchCallReached is an email whose chain called next, and waits for
+	// the email's outcome in the batch.
+	batchCallReached
+
+	// batchCallReturned is an email whose chain returned without calling
+	// next, stopping the email.
+	batchCallReturned
+)
+
+// batchMiddleware runs each email of a batch through the middleware chain,
+// with the batch send at the end of every chain, so that middleware sees the
+// outcome of each email as it does with Send.
+type batchMiddleware struct {
+	client *Client
+	ctx    context.Context
+	emails []*Email
+	calls  []*batchCall
+}
+
+// batchCall is the run of the middleware chain for an email of a batch.
+type batchCall struct {
+	mutex   sync.Mutex
+	state   int
+	ctx     context.Context
+	email   *Email
+	ready   chan struct{} // closed when the state leaves batchCallPending
+	outcome chan error    // receives the outcome of the email in the batch
+	done    chan struct{} // closed when the chain returns
+	err     error         // the error the chain returned, once done
+}
+
+// startBatchMiddleware runs the middleware chain of each email of a batch
+// concurrently, and returns once every email has reached the end of its
+// chain or been stopped by the middleware.
+func (c *Client) startBatchMiddleware(ctx context.Context, emails []*Email) *batchMiddleware {
+	batch := &batchMiddleware{client: c, ctx: ctx, emails: emails}
+	if len(c.config.Middleware) == 0 {
+		return batch
+	}
+
+	batch.calls = make([]*batchCall, len(emails))
+	for i, email := range emails {
+		call := &batchCall{
+			ready:   make(chan struct{}),
+			outcome: make(chan error, 1),
+			done:    make(chan struct{}),
+		}
+		batch.calls[i] = call
+
+		send := chain(c.config.Middleware, call.next(c))
+		go func() {
+			defer close(call.done)
+			err := send(ctx, email)
+
+			call.mutex.Lock()
+			defer call.mutex.Unlock()
+			call.err = err
+			if call.state == batchCallPending {
+				call.state = batchCallReturned
+				close(call.ready)
+			}
+		}()
+	}
+	for _, call := range batch.calls {
+		<-call.ready
+	}
+	return batch
+}
+
+// next is the end of the email's middleware chain. The first call before the
+// chain returns hands the email to the batch and returns its outcome; any
+// other call, such as a retry after the batch was sent, sends the email on
+// its own.
+func (call *batchCall) next(c *Client) SendFunc {
+	return func(ctx context.Context, email *Email) error {
+		call.mutex.Lock()
+		if call.state != batchCallPending {
+			call.mutex.Unlock()
+			if email == nil {
+				return NewValidationError("email", "email is required")
+			}
+			return c.send(ctx, email)
+		}
+		call.state = batchCallReached
+		call.ctx, call.email = ctx, email
+		close(call.ready)
+		call.mutex.Unlock()
+
+		return <-call.outcome
+	}
+}
+
+// passed returns the emails as passed on by the middleware with the context
+// each was passed with, which of them the middleware stopped, and the errors
+// it returned for those it stopped with an error. An email stopped without an
+// error was handled by the middleware, as it would be with Send.
+func (b *batchMiddleware) passed() ([]*Email, []context.Context, []bool, []BatchItemError) {
+	emails := make([]*Email, len(b.emails))
+	contexts := make([]context.Context, len(b.emails))
+	stopped := make([]bool, len(b.emails))
+	if b.calls == nil {
+		copy(emails, b.emails)
+		for i := range contexts {
+			contexts[i] = b.ctx
+		}
+		return emails, contexts, stopped, nil
+	}
+
+	var rejected []BatchItemError
+	for i, call := range b.calls {
+		call.mutex.Lock()
+		if call.state == batchCallReached {
+			emails[i], contexts[i] = call.email, call.ctx
+		} else {
+			stopped[i] = true
+			if call.err != nil {
+				rejected = append(rejected, BatchItemError{Index: i, Error: call.err})
+			}
+		}
+		call.mutex.Unlock()
+	}
+	return emails, contexts, stopped, rejected
+}
+
+// finish returns the outcome of the batch send, err, to each email waiting at
+// the end of its chain, waits for the chains to return and reports the errors
+// they returned. An error other than a *BatchError failed the whole batch and
+// is returned as is.
+func (b *batchMiddleware) finish(err error) error {
+	if b.calls == nil {
+		return err
+	}
+
+	var batchErr *BatchError
+	failed := make(map[int]error)
+	if errors.As(err, &batchErr) {
+		for _, item := range batchErr.Errors {
+			failed[item.Index] = item.Error
+		}
+	}
+	for i, call := range b.calls {
+		call.mutex.Lock()
+		reached := call.state == batchCallReached
+		call.mutex.Unlock()
+		if !reached {
+			continue
+		}
+		if batchErr == nil {
+			call.outcome <- err
+		} else {
+			call.outcome <- failed[i]
+		}
+	}
+
+	var items []BatchItemError
+	for i, call := range b.calls {
+		<-call.done
+		if call.err != nil {
+			items = append(items, BatchItemError{Index: i, Error: call.err})
+		}
+	}
+	if err != nil && batchErr == nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return &BatchError{
+		Message: fmt.Sprintf("%d/%d emails failed", len(items), len(b.emails)),
+		Total:   len(b.emails),
+		Failed:  len(items),
+		Errors:  items,
+	}
+}
@@ -0,0 +1,121 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func TestBatchMiddlewareSeesEachOutcome(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	var mutex sync.Mutex
+	outcomes := make(map[string]error)
+	audit := func(next mailer.SendFunc) mailer.SendFunc {
+		return func(ctx context.Context, email *mailer.Email) error {
+			err := next(ctx, email)
+			mutex.Lock()
+			outcomes[email.To[0].Email] = err
+			mutex.Unlock()
+			if err != nil {
+				return fmt.Errorf("audited: %w", err)
+			}
+			return nil
+		}
+	}
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithMiddleware(audit),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	rejected := errors.New("rejected")
+	mock.FailNext(rejected)
+
+	emails := make([]*mailer.Email, 3)
+	for i := range emails {
+		emails[i] = validEmail()
+		emails[i].To = []mailer.Address{{Email: fmt.Sprintf("recipient%d@example.com", i)}}
+	}
+	err = client.SendBatch(context.Background(), emails)
+
+	var batchErr *mailer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("SendBatch error = %v, want a *mailer.BatchError", err)
+	}
+	if batchErr.Failed != 1 || batchErr.Errors[0].Index != 0 {
+		t.Fatalf("failed items = %+v, want only index 0", batchErr.Errors)
+	}
+	if got := batchErr.Errors[0].Error.Error(); got != "audited: rejected" {
+		t.Errorf("item error = %q, want the middleware's error", got)
+	}
+
+	if len(outcomes) != 3 {
+		t.Fatalf("middleware saw %d outcomes, want 3", len(outcomes))
+	}
+	if !errors.Is(outcomes["recipient0@example.com"], rejected) {
+		t.Errorf("outcome of the failed email = %v, want %v", outcomes["recipient0@example.com"], rejected)
+	}
+	for _, recipient := range []string{"recipient1@example.com", "recipient2@example.com"} {
+		if outcomes[recipient] != nil {
+			t.Errorf("outcome of %s = %v, want nil", recipient, outcomes[recipient])
+		}
+	}
+}
+
+type recipientKey struct{}
+
+func TestBatchMiddlewareContextReachesEmailChecks(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	tag := func(next mailer.SendFunc) mailer.SendFunc {
+		return func(ctx context.Context, email *mailer.Email) error {
+			return next(context.WithValue(ctx, recipientKey{}, email.To[0].Email), email)
+		}
+	}
+	var mutex sync.Mutex
+	seen := make(map[string]any)
+	resolver := mailer.TextOnlyResolverFunc(func(ctx context.Context, recipient mailer.Address) (bool, error) {
+		mutex.Lock()
+		seen[recipient.Email] = ctx.Value(recipientKey{})
+		mutex.Unlock()
+		return false, nil
+	})
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithMiddleware(tag),
+		mailer.WithTextOnlyResolver(resolver),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	emails := []*mailer.Email{validEmail(), validEmail()}
+	emails[1].To = []mailer.Address{{Email: "other@example.com"}}
+	for _, email := range emails {
+		email.HTMLBody = "<p>Hello</p>"
+	}
+	if err := client.SendBatch(context.Background(), emails); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	for _, email := range emails {
+		recipient := email.To[0].Email
+		if seen[recipient] != recipient {
+			t.Errorf("context value for %s = %v, want the one set by the middleware", recipient, seen[recipient])
+		}
+	}
+}
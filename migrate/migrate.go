@@ -0,0 +1,451 @@
+// Package migrate de-risks moving to a new email provider by sending the same
+// messages through the current provider and a candidate in shadow mode, and
+// reporting how they compare on acceptance, latency and rendering.
+//
+// The current provider sends every message as usual. The candidate either
+// sends it to a seed list of internal mailboxes in place of the real
+// recipients, or, without a seed list, runs dry and only renders it:
+//
+//	shadow, err := migrate.New(migrate.Config{
+//		Current:   migrate.Target{Type: mailer.ProviderSendGrid, Settings: sendgridSettings},
+//		Candidate: migrate.Target{Type: mailer.ProviderPostmark, Settings: postmarkSettings},
+//		SeedList:  []mailer.Address{{Email: "seed@example.com"}},
+//	})
+//	...
+//	err = shadow.Send(ctx, email) // the current provider's result
+//	...
+//	shadow.Report().WriteTo(os.Stdout)
+//
+// Both providers are sent the email as the client sends it, with its
+// unsubscribe and priority headers added and substitutions applied.
+// Rendering is compared on the MIME message built for each provider's
+// settings, such as "charset" and "transfer_encoding", with dates, message
+// IDs and multipart boundaries normalized.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// Target is a provider taking part in a migration.
+type Target struct {
+	// Type is the provider type.
+	Type mailer.ProviderType
+
+	// Settings are the provider settings, as for the client.
+	Settings mailer.ProviderSettings
+}
+
+// Config configures a shadow migration.
+type Config struct {
+	// Current is the provider in use, which sends every message to its real
+	// recipients.
+	Current Target
+
+	// Candidate is the provider being migrated to.
+	Candidate Target
+
+	// SeedList, when non-empty, makes the candidate send each message to
+	// these addresses in place of its real recipients. Otherwise the
+	// candidate runs dry and messages are only rendered for it.
+	SeedList []mailer.Address
+
+	// Timeout bounds each provider call (default 30 seconds).
+	Timeout time.Duration
+}
+
+// Outcome is how one provider handled a message.
+type Outcome struct {
+	// Provider is the provider's name.
+	Provider string
+
+	// Sent reports whether the message was sent; false for a dry run.
+	Sent bool
+
+	// Accepted reports whether the provider accepted the message.
+	Accepted bool
+
+	// MessageID is the provider's message ID for accepted messages.
+	MessageID string
+
+	// Latency is the duration of the provider call.
+	Latency time.Duration
+
+	// Err is the provider's error for rejected messages.
+	Err error
+}
+
+// Comparison is the result of shadowing one message.
+type Comparison struct {
+	// Subject is the subject of the message.
+	Subject string
+
+	// Current and Candidate are the outcomes of the two providers.
+	Current, Candidate Outcome
+
+	// RenderDiff lists the lines of the message as rendered for the current
+	// provider ("-") and the candidate ("+") that differ. It is empty when
+	// the renderings match.
+	RenderDiff []string
+}
+
+// AcceptanceMismatch reports whether the candidate sent the message and
+// disagreed with the current provider on accepting it.
+func (c *Comparison) AcceptanceMismatch() bool {
+	return c.Candidate.Sent && c.Current.Accepted != c.Candidate.Accepted
+}
+
+// RenderMismatch reports whether the renderings differ.
+func (c *Comparison) RenderMismatch() bool {
+	return len(c.RenderDiff) > 0
+}
+
+// Shadow sends messages through the current and candidate providers and
+// collects their comparisons. It is safe for concurrent use.
+type Shadow struct {
+	config    Config
+	current   mailer.Provider
+	candidate mailer.Provider
+
+	mu          sync.Mutex
+	comparisons []*Comparison
+}
+
+// New creates a Shadow from config.
+func New(config Config) (*Shadow, error) {
+	current, err := mailer.NewProvider(config.Current.Type, config.Current.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create current provider: %w", err)
+	}
+	candidate, err := mailer.NewProvider(config.Candidate.Type, config.Candidate.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create candidate provider: %w", err)
+	}
+
+	for i, seed := range config.SeedList {
+		if !seed.Valid() {
+			return nil, mailer.NewValidationErrorWithValue("seed_list", fmt.Sprintf("invalid seed address at index %d", i), seed.Email)
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return &Shadow{config: config, current: current, candidate: candidate}, nil
+}
+
+// Send sends email through the current provider and shadows it through the
+// candidate, recording the comparison for Report. It returns the current
+// provider's error, so that it can stand in for a direct send; candidate
+// failures are only reported.
+func (s *Shadow) Send(ctx context.Context, email *mailer.Email) error {
+	comparison, err := s.Compare(ctx, email)
+	if err != nil {
+		return err
+	}
+	return comparison.Current.Err
+}
+
+// Compare is like Send but returns the comparison. Its error is non-nil only
+// when the email is invalid or cannot be prepared for sending.
+func (s *Shadow) Compare(ctx context.Context, email *mailer.Email) (*Comparison, error) {
+	if err := email.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Attachment readers are consumed by each send and rendering, so they
+	// are buffered and replayed
+	attachments, err := bufferAttachments(email)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{Subject: email.Subject}
+
+	current := withAttachments(email, attachments)
+	comparison.Current = s.send(ctx, s.current, current)
+
+	if len(s.config.SeedList) > 0 {
+		seeded := withAttachments(email, attachments)
+		seeded.To = s.config.SeedList
+		seeded.CC = nil
+		seeded.BCC = nil
+		comparison.Candidate = s.send(ctx, s.candidate, seeded)
+	} else {
+		comparison.Candidate = Outcome{Provider: s.candidate.Name()}
+	}
+
+	currentEML, err := render(withAttachments(email, attachments), s.config.Current.Settings)
+	if err != nil {
+		return nil, err
+	}
+	candidateEML, err := render(withAttachments(email, attachments), s.config.Candidate.Settings)
+	if err != nil {
+		return nil, err
+	}
+	comparison.RenderDiff = diffLines(currentEML, candidateEML)
+
+	s.mu.Lock()
+	s.comparisons = append(s.comparisons, comparison)
+	s.mu.Unlock()
+
+	return comparison, nil
+}
+
+// send sends email with provider and records the outcome.
+func (s *Shadow) send(ctx context.Context, provider mailer.Provider, email *mailer.Email) Outcome {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	outcome := Outcome{Provider: provider.Name(), Sent: true}
+
+	start := time.Now()
+	result, err := provider.Send(ctx, email.Prepare(provider))
+	outcome.Latency = time.Since(start)
+
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+	outcome.Accepted = true
+	if result != nil {
+		outcome.MessageID = result.MessageID
+	}
+	return outcome
+}
+
+// boundaryPattern matches multipart boundary parameters.
+var boundaryPattern = regexp.MustCompile(`boundary="?([^";\r\n]+)"?`)
+
+// render returns the MIME message for email, prepared as the client prepares
+// it and built for a provider's settings, normalized for comparison: a fixed
+// date and message ID, and numbered boundaries.
+func render(email *mailer.Email, settings mailer.ProviderSettings) (string, error) {
+	opts := core.MIMEOptionsFromSettings(settings)
+	opts.MessageID = "shadow@migrate"
+	opts.Date = time.Unix(0, 0).UTC()
+
+	message, err := core.BuildMessage(email.Prepare(nil), opts)
+	if err != nil {
+		return "", err
+	}
+
+	rendered := string(message)
+	for i, match := range boundaryPattern.FindAllStringSubmatch(rendered, -1) {
+		rendered = strings.ReplaceAll(rendered, match[1], fmt.Sprintf("boundary-%d", i+1))
+	}
+	return rendered, nil
+}
+
+// bufferAttachments reads the data of email's attachments.
+func bufferAttachments(email *mailer.Email) ([][]byte, error) {
+	buffered := make([][]byte, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		if attachment.Data == nil {
+			continue
+		}
+		data, err := io.ReadAll(attachment.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %s: %w", attachment.Filename, err)
+		}
+		buffered[i] = data
+	}
+	return buffered, nil
+}
+
+// withAttachments returns a copy of email whose attachments read from the
+// buffered data.
+func withAttachments(email *mailer.Email, buffered [][]byte) *mailer.Email {
+	copied := *email
+	copied.Attachments = make([]mailer.Attachment, len(email.Attachments))
+	for i, attachment := range email.Attachments {
+		if buffered[i] != nil {
+			attachment.Data = bytes.NewReader(buffered[i])
+		}
+		copied.Attachments[i] = attachment
+	}
+	return &copied
+}
+
+// diffLines returns the differing lines of a and b, prefixed "-" and "+",
+// using their longest common subsequence.
+func diffLines(a, b string) []string {
+	if a == b {
+		return nil
+	}
+	x := strings.Split(strings.ReplaceAll(a, "\r\n", "\n"), "\n")
+	y := strings.Split(strings.ReplaceAll(b, "\r\n", "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	return diff
+}
+
+// Report summarizes the comparisons recorded by a Shadow.
+type Report struct {
+	// Messages is the number of messages shadowed.
+	Messages int
+
+	// CurrentAccepted and CandidateAccepted count the messages each provider
+	// accepted; CandidateSent counts those the candidate sent at all.
+	CurrentAccepted, CandidateAccepted, CandidateSent int
+
+	// AcceptanceMismatches and RenderMismatches count the messages on which
+	// the providers disagreed.
+	AcceptanceMismatches, RenderMismatches int
+
+	// CurrentLatency and CandidateLatency summarize the latency of the sends.
+	CurrentLatency, CandidateLatency Latency
+
+	// Comparisons are the individual comparisons, in the order recorded.
+	Comparisons []*Comparison
+}
+
+// Latency summarizes send latencies.
+type Latency struct {
+	Mean, P50, P95, Max time.Duration
+}
+
+// Report returns a summary of the comparisons recorded so far.
+func (s *Shadow) Report() *Report {
+	s.mu.Lock()
+	comparisons := append([]*Comparison(nil), s.comparisons...)
+	s.mu.Unlock()
+
+	report := &Report{Messages: len(comparisons), Comparisons: comparisons}
+
+	var current, candidate []time.Duration
+	for _, c := range comparisons {
+		if c.Current.Accepted {
+			report.CurrentAccepted++
+		}
+		current = append(current, c.Current.Latency)
+		if c.Candidate.Sent {
+			report.CandidateSent++
+			candidate = append(candidate, c.Candidate.Latency)
+			if c.Candidate.Accepted {
+				report.CandidateAccepted++
+			}
+		}
+		if c.AcceptanceMismatch() {
+			report.AcceptanceMismatches++
+		}
+		if c.RenderMismatch() {
+			report.RenderMismatches++
+		}
+	}
+	report.CurrentLatency = summarize(current)
+	report.CandidateLatency = summarize(candidate)
+
+	return report
+}
+
+// summarize computes latency statistics.
+func summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+
+	return Latency{
+		Mean: total / time.Duration(len(sorted)),
+		P50:  at(0.50),
+		P95:  at(0.95),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// WriteTo writes the report as text, listing each message on which the
+// providers disagreed.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "messages: %d\n", r.Messages)
+	fmt.Fprintf(&buf, "accepted: current %d/%d, candidate %d/%d sent\n",
+		r.CurrentAccepted, r.Messages, r.CandidateAccepted, r.CandidateSent)
+	fmt.Fprintf(&buf, "latency current:   mean %v, p50 %v, p95 %v, max %v\n",
+		r.CurrentLatency.Mean, r.CurrentLatency.P50, r.CurrentLatency.P95, r.CurrentLatency.Max)
+	if r.CandidateSent > 0 {
+		fmt.Fprintf(&buf, "latency candidate: mean %v, p50 %v, p95 %v, max %v\n",
+			r.CandidateLatency.Mean, r.CandidateLatency.P50, r.CandidateLatency.P95, r.CandidateLatency.Max)
+	}
+	fmt.Fprintf(&buf, "acceptance mismatches: %d\n", r.AcceptanceMismatches)
+	fmt.Fprintf(&buf, "rendering mismatches: %d\n", r.RenderMismatches)
+
+	for i, c := range r.Comparisons {
+		if !c.AcceptanceMismatch() && !c.RenderMismatch() {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n#%d %q\n", i+1, c.Subject)
+		if c.AcceptanceMismatch() {
+			fmt.Fprintf(&buf, "  %s: %s\n", c.Current.Provider, describe(c.Current))
+			fmt.Fprintf(&buf, "  %s: %s\n", c.Candidate.Provider, describe(c.Candidate))
+		}
+		for _, line := range c.RenderDiff {
+			fmt.Fprintf(&buf, "  %s\n", line)
+		}
+	}
+
+	return buf.WriteTo(w)
+}
+
+// describe summarizes an outcome for the report.
+func describe(o Outcome) string {
+	if o.Accepted {
+		return "accepted " + o.MessageID
+	}
+	var providerErr *mailer.ProviderError
+	if errors.As(o.Err, &providerErr) {
+		return "rejected [" + providerErr.Code + "] " + providerErr.Message
+	}
+	return fmt.Sprintf("rejected: %v", o.Err)
+}
@@ -0,0 +1,58 @@
+package migrate_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+	"github.com/lattiq/mailer/migrate"
+)
+
+func TestShadowSendsEmailsAsTheClientDoes(t *testing.T) {
+	current := mailertest.NewMockProvider("current")
+	defer current.Close()
+	candidate := mailertest.NewMockProvider("candidate")
+	defer candidate.Close()
+
+	shadow, err := migrate.New(migrate.Config{
+		Current:   migrate.Target{Type: mailertest.ProviderType, Settings: current.Settings()},
+		Candidate: migrate.Target{Type: mailertest.ProviderType, Settings: candidate.Settings()},
+		SeedList:  []mailer.Address{{Email: "seed@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	email := &mailer.Email{
+		From:          mailer.Address{Email: "news@example.com"},
+		To:            []mailer.Address{{Email: "jane@example.com"}},
+		Subject:       "Hello %recipient.name%",
+		TextBody:      "Hello",
+		Priority:      mailer.PriorityUrgent,
+		Unsubscribe:   &mailer.Unsubscribe{URL: "https://example.com/unsubscribe?u=jane", OneClick: true},
+		Substitutions: map[string]string{"name": "Jane"},
+	}
+	comparison, err := shadow.Compare(context.Background(), email)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if comparison.RenderMismatch() {
+		t.Errorf("renderings differ: %v", comparison.RenderDiff)
+	}
+
+	for _, mock := range []*mailertest.MockProvider{current, candidate} {
+		sent := mock.LastEmail()
+		if sent == nil {
+			t.Fatalf("%s received no email", mock.Name())
+		}
+		if sent.Subject != "Hello Jane" {
+			t.Errorf("%s got subject %q, want substitutions applied", mock.Name(), sent.Subject)
+		}
+		for _, header := range []string{"List-Unsubscribe", "List-Unsubscribe-Post", "X-Priority"} {
+			if sent.Headers[header] == "" {
+				t.Errorf("%s got no %s header", mock.Name(), header)
+			}
+		}
+	}
+}
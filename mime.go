@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MIMEOptions controls how BuildMIMEWithOptions encodes a message.
+type MIMEOptions = core.MIMEOptions
+
+// Content transfer encodings of MIMEOptions.TransferEncoding.
+const (
+	TransferEncodingAuto            = core.TransferEncodingAuto
+	TransferEncodingQuotedPrintable = core.TransferEncodingQuotedPrintable
+	TransferEncodingBase64          = core.TransferEncodingBase64
+	TransferEncoding7Bit            = core.TransferEncoding7Bit
+	TransferEncoding8Bit            = core.TransferEncoding8Bit
+)
+
+// BuildMIME serializes the email as an RFC 5322 message with MIME parts, as
+// the SMTP provider sends it, for use with SES SendRawEmail, archival or
+// other pipelines that take raw messages. It uses UTF-8 and picks a
+// transfer encoding per part; see BuildMIMEWithOptions.
+func BuildMIME(email *Email) ([]byte, error) {
+	return BuildMIMEWithOptions(email, MIMEOptions{})
+}
+
+// BuildMIMEWithOptions is BuildMIME with the given charset, transfer
+// encoding, date and Message-ID. The email is validated and its
+// substitutions applied. The date defaults to the current time on
+// SystemClock. Without a Message-ID, one is generated from the date at the
+// domain of the sender, or derived from the message in deterministic mode
+// so that the output is stable for golden files. BCC recipients are not
+// written, as they must not appear in the message.
+func BuildMIMEWithOptions(email *Email, opts MIMEOptions) ([]byte, error) {
+	if email == nil {
+		return nil, NewValidationError("email", "email is required")
+	}
+	if err := email.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.Date.IsZero() {
+		opts.Date = SystemClock.Now()
+	}
+	if opts.MessageID == "" {
+		domain := addressDomain(email.From.Email)
+		if opts.Deterministic {
+			if opts.MessageIDDomain == "" {
+				opts.MessageIDDomain = domain
+			}
+		} else {
+			opts.MessageID = fmt.Sprintf("%d.%d@%s", opts.Date.UnixNano(), SystemRand.Int63n(1<<62), domain)
+		}
+	}
+	return core.BuildMessage(email.Prepare(nil), opts)
+}
@@ -0,0 +1,80 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// mjmlExtension is the extension of MJML template files, which are compiled
+// to HTML when they are loaded, whatever the configured extensions.
+const mjmlExtension = ".mjml"
+
+// MJMLCompiler compiles MJML markup to responsive HTML. Template actions in
+// the markup, such as {{.Name}}, must be passed through unchanged; they are
+// executed after compilation.
+type MJMLCompiler interface {
+	Compile(ctx context.Context, mjml string) (string, error)
+}
+
+// MJMLCompilerFunc adapts a function to the MJMLCompiler interface.
+type MJMLCompilerFunc func(ctx context.Context, mjml string) (string, error)
+
+// Compile calls f(ctx, mjml).
+func (f MJMLCompilerFunc) Compile(ctx context.Context, mjml string) (string, error) {
+	return f(ctx, mjml)
+}
+
+// MJMLCommand returns a compiler that runs an external command, writing the
+// MJML to its standard input and reading the HTML from its standard output,
+// such as MJMLCommand("mjml", "-i", "-s") for the reference compiler or
+// MJMLCommand("mrml", "render") for its Rust port.
+func MJMLCommand(name string, args ...string) MJMLCompiler {
+	return MJMLCompilerFunc(func(ctx context.Context, mjml string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdin = strings.NewReader(mjml)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return "", fmt.Errorf("%w: %s", err, message)
+			}
+			return "", err
+		}
+		return stdout.String(), nil
+	})
+}
+
+// compileMJML compiles the MJML template name with the configured compiler.
+// It reports false when no compiler is configured, recording the template
+// as skipped.
+func (te *TemplateEngineImpl) compileMJML(name, content string) (string, bool, error) {
+	if te.config.MJML == nil {
+		te.mutex.Lock()
+		te.skippedMJML = append(te.skippedMJML, name)
+		te.mutex.Unlock()
+		return "", false, nil
+	}
+
+	html, err := te.config.MJML.Compile(context.Background(), content)
+	if err != nil {
+		return "", false, NewTemplateError(name, "parse", "failed to compile MJML", err)
+	}
+	return html, true, nil
+}
+
+// SkippedMJML returns the names of the MJML templates that were not loaded
+// because no MJML compiler is configured. An HTML template of the same name,
+// if any, is used in their place.
+func (te *TemplateEngineImpl) SkippedMJML() []string {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	skipped := append([]string(nil), te.skippedMJML...)
+	sort.Strings(skipped)
+	return skipped
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MXCacheStats are the counters of a provider's cache of recipient domain MX
+// records since the provider was created.
+type MXCacheStats = core.MXCacheStats
+
+// MXResolver looks up MX records, as net.Resolver does.
+type MXResolver = core.MXResolver
+
+// PreresolveMX looks up and caches the MX records of the domains in every
+// provider that caches them, such as SMTP in direct delivery, so that the
+// first sends of a campaign to high-volume domains do not wait on DNS. It
+// returns the lookups that failed; domains without MX records are not
+// errors.
+func (c *Client) PreresolveMX(ctx context.Context, domains ...string) error {
+	if err := c.initProviders(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, provider := range c.configuredProviders() {
+		cacher, ok := provider.(core.MXCacher)
+		if !ok {
+			continue
+		}
+		if err := cacher.PreresolveMX(ctx, domains); err != nil {
+			errs = append(errs, fmt.Errorf("provider %s: %w", provider.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mxCacheStats returns the MX cache counters of the providers that cache MX
+// records, by provider name, or nil when none does.
+func (c *Client) mxCacheStats() map[string]MXCacheStats {
+	var stats map[string]MXCacheStats
+	for _, provider := range c.configuredProviders() {
+		cacher, ok := provider.(core.MXCacher)
+		if !ok {
+			continue
+		}
+		if stats == nil {
+			stats = make(map[string]MXCacheStats)
+		}
+		stats[provider.Name()] = cacher.MXCacheStats()
+	}
+	return stats
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/lattiq/mailer"
+)
+
+// emailChannel delivers notifications as emails through a mailer.Client.
+type emailChannel struct {
+	client *mailer.Client
+	from   mailer.Address
+}
+
+// Email returns the email channel, which sends notifications through client
+// from the given address, with the client's middleware, retries, failover
+// and rate limiting. Messages with a Template are sent with SendTemplate.
+func Email(client *mailer.Client, from mailer.Address) Channel {
+	return &emailChannel{client: client, from: from}
+}
+
+// Name returns ChannelEmail.
+func (c *emailChannel) Name() string {
+	return ChannelEmail
+}
+
+// Deliver sends the message to the recipient's email address.
+func (c *emailChannel) Deliver(ctx context.Context, recipient Recipient, message Message) error {
+	if recipient.Email.Email == "" {
+		return ErrNoAddress
+	}
+
+	if message.Template != "" {
+		metadata := make(map[string]interface{}, len(message.Metadata))
+		for key, value := range message.Metadata {
+			metadata[key] = value
+		}
+		return c.client.SendTemplate(ctx, &mailer.TemplateRequest{
+			Template: message.Template,
+			To:       []mailer.Address{recipient.Email},
+			From:     c.from,
+			Subject:  message.Subject,
+			Data:     message.Data,
+			Priority: message.Priority,
+			Metadata: metadata,
+		})
+	}
+
+	return c.client.Send(ctx, &mailer.Email{
+		From:     c.from,
+		To:       []mailer.Address{recipient.Email},
+		Subject:  message.Subject,
+		TextBody: message.Text,
+		HTMLBody: message.HTML,
+		Priority: message.Priority,
+		Metadata: message.Metadata,
+	})
+}
+
+// SMSSender sends text messages, implemented by adapters for SMS gateways.
+type SMSSender interface {
+	// SendSMS sends text to the phone number to.
+	SendSMS(ctx context.Context, to, text string) error
+}
+
+// smsChannel delivers the text of notifications through an SMSSender.
+type smsChannel struct {
+	name   string
+	sender SMSSender
+}
+
+// SMS returns a channel with the given name, such as "sms", that sends the
+// text of notifications through sender to the recipient's address on it.
+func SMS(name string, sender SMSSender) Channel {
+	return &smsChannel{name: name, sender: sender}
+}
+
+// Name returns the channel name.
+func (c *smsChannel) Name() string {
+	return c.name
+}
+
+// Deliver sends the message text to the recipient's phone number.
+func (c *smsChannel) Deliver(ctx context.Context, recipient Recipient, message Message) error {
+	to := recipient.Address(c.name)
+	if to == "" {
+		return ErrNoAddress
+	}
+	return c.sender.SendSMS(ctx, to, message.Text)
+}
+
+// ChannelFunc adapts a function to a Channel with the given name, e.g. for a
+// webhook.
+func ChannelFunc(name string, deliver func(ctx context.Context, recipient Recipient, message Message) error) Channel {
+	return &funcChannel{name: name, deliver: deliver}
+}
+
+// funcChannel is the Channel returned by ChannelFunc.
+type funcChannel struct {
+	name    string
+	deliver func(ctx context.Context, recipient Recipient, message Message) error
+}
+
+// Name returns the channel name.
+func (c *funcChannel) Name() string {
+	return c.name
+}
+
+// Deliver calls the channel's function.
+func (c *funcChannel) Deliver(ctx context.Context, recipient Recipient, message Message) error {
+	return c.deliver(ctx, recipient, message)
+}
+
+// reliableChannel wraps a channel in retries and a circuit breaker.
+type reliableChannel struct {
+	Channel
+	retry   *mailer.RetryManager
+	breaker *mailer.CircuitBreaker
+}
+
+// Reliable wraps channel in the retry and circuit breaker behavior the email
+// client uses: errors for which mailer.IsRetryable reports true, such as
+// those from mailer.NewRetryableProviderError, are retried with backoff, and
+// the breaker opens after repeated failures so that Notify falls back to the
+// next channel at once. Disabled configurations leave that behavior out.
+func Reliable(channel Channel, retry mailer.RetryConfig, breaker mailer.CircuitBreakerConfig) Channel {
+	reliable := &reliableChannel{Channel: channel}
+	if retry.Enabled {
+		reliable.retry = mailer.NewRetryManager(retry)
+	}
+	if breaker.Enabled {
+		reliable.breaker = mailer.NewCircuitBreaker(breaker)
+	}
+	return reliable
+}
+
+// Deliver delivers through the wrapped channel, inside the circuit breaker
+// and retries.
+func (c *reliableChannel) Deliver(ctx context.Context, recipient Recipient, message Message) error {
+	deliver := func() error {
+		if c.breaker == nil {
+			return c.Channel.Deliver(ctx, recipient, message)
+		}
+		return c.breaker.Execute(func() error {
+			return c.Channel.Deliver(ctx, recipient, message)
+		})
+	}
+
+	if c.retry == nil {
+		return deliver()
+	}
+	return c.retry.Retry(ctx, deliver)
+}
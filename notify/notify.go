@@ -0,0 +1,197 @@
+// Package notify delivers notifications over several channels, with email as
+// one of them, falling back through each recipient's preferred channels until
+// one delivers.
+//
+// Email is delivered by a mailer.Client. Other channels, such as SMS or
+// webhooks, are implemented outside this package against the Channel
+// interface, and can be given the client's retry and circuit breaker
+// behavior with Reliable:
+//
+//	notifier, err := notify.New(
+//		notify.Email(client, mailer.Address{Email: "alerts@example.com"}),
+//		notify.Reliable(notify.SMS("sms", twilioSender), retryConfig, breakerConfig),
+//	)
+//	...
+//	result, err := notifier.Notify(ctx, notify.Recipient{
+//		Email:     mailer.Address{Email: "user@example.com"},
+//		Addresses: map[string]string{"sms": "+15551234567"},
+//		Channels:  []string{"sms", "email"}, // email if SMS fails
+//	}, notify.Message{Subject: "Login code", Text: "Your code is 123456."})
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lattiq/mailer"
+)
+
+// ChannelEmail is the name of the email channel.
+const ChannelEmail = "email"
+
+// ErrNoAddress is returned by channels for recipients they have no address for.
+var ErrNoAddress = errors.New("recipient has no address for channel")
+
+// Recipient is the person or system a notification is for.
+type Recipient struct {
+	// Email is the recipient's email address, used by the email channel.
+	Email mailer.Address
+
+	// Addresses holds the recipient's address on other channels, keyed by
+	// channel name, such as a phone number for "sms".
+	Addresses map[string]string
+
+	// Channels lists the channels to try, in order of preference. Empty
+	// tries every channel in the order given to New.
+	Channels []string
+}
+
+// Address returns the recipient's address on the named channel, or an empty
+// string when it has none.
+func (r Recipient) Address(channel string) string {
+	if channel == ChannelEmail {
+		return r.Email.Email
+	}
+	return r.Addresses[channel]
+}
+
+// Message is the content of a notification. Channels use the parts that suit
+// them: email prefers the template, then the HTML and text bodies, while
+// text-only channels such as SMS send Text.
+type Message struct {
+	// Subject is the email subject, also usable as a title by other channels.
+	Subject string
+
+	// Text is the plain text content.
+	Text string
+
+	// HTML is the HTML content of emails.
+	HTML string
+
+	// Template names a template the email channel renders with Data in
+	// place of the subject and bodies.
+	Template string
+
+	// Data is the template data.
+	Data interface{}
+
+	// Priority is the notification priority.
+	Priority mailer.Priority
+
+	// Metadata contains arbitrary data for tracking, passed to every channel.
+	Metadata map[string]string
+}
+
+// Channel delivers notifications over one medium.
+type Channel interface {
+	// Name returns the channel name recipients refer to in their
+	// preferences, such as "email" or "sms".
+	Name() string
+
+	// Deliver delivers the message to the recipient. It returns ErrNoAddress
+	// when the recipient has no address on the channel. Errors for which
+	// mailer.IsRetryable reports true are retried by Reliable.
+	Deliver(ctx context.Context, recipient Recipient, message Message) error
+}
+
+// Attempt records a delivery attempt on one channel.
+type Attempt struct {
+	// Channel is the name of the channel.
+	Channel string
+
+	// Err is the delivery error, or nil when the channel delivered.
+	Err error
+}
+
+// Result describes the delivery of a notification.
+type Result struct {
+	// Channel is the channel that delivered the notification.
+	Channel string
+
+	// Attempts lists every channel tried, in order, ending with the one
+	// that delivered.
+	Attempts []Attempt
+}
+
+// DeliveryError is returned when no channel delivered a notification.
+type DeliveryError struct {
+	// Attempts lists every channel tried, in order, with its error.
+	Attempts []Attempt
+}
+
+func (e *DeliveryError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "notify: no channel to deliver to"
+	}
+	failures := make([]string, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		failures[i] = attempt.Channel + ": " + attempt.Err.Error()
+	}
+	return "notify: delivery failed on every channel: " + strings.Join(failures, "; ")
+}
+
+// Unwrap returns the errors of the attempts, so that errors.Is and errors.As
+// match any of them.
+func (e *DeliveryError) Unwrap() []error {
+	errs := make([]error, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		errs[i] = attempt.Err
+	}
+	return errs
+}
+
+// Notifier delivers notifications over a set of channels.
+type Notifier struct {
+	channels map[string]Channel
+	order    []string
+}
+
+// New creates a notifier over the given channels, whose order is the default
+// preference order. Channel names must be unique.
+func New(channels ...Channel) (*Notifier, error) {
+	n := &Notifier{channels: make(map[string]Channel, len(channels))}
+	for _, channel := range channels {
+		if channel == nil {
+			return nil, mailer.NewValidationError("channels", "channel must not be nil")
+		}
+		name := channel.Name()
+		if _, ok := n.channels[name]; ok {
+			return nil, mailer.NewValidationErrorWithValue("channels", "duplicate channel name", name)
+		}
+		n.channels[name] = channel
+		n.order = append(n.order, name)
+	}
+	return n, nil
+}
+
+// Notify delivers the message to the recipient on the first of their
+// preferred channels that succeeds, moving on to the next when a channel
+// fails or has no address for them. It stops early when ctx is done. When no
+// channel delivers, the error is a *DeliveryError listing each attempt.
+func (n *Notifier) Notify(ctx context.Context, recipient Recipient, message Message) (*Result, error) {
+	preferences := recipient.Channels
+	if len(preferences) == 0 {
+		preferences = n.order
+	}
+
+	var attempts []Attempt
+	for _, name := range preferences {
+		channel, ok := n.channels[name]
+		if !ok {
+			attempts = append(attempts, Attempt{Channel: name, Err: fmt.Errorf("unknown channel %q", name)})
+			continue
+		}
+		err := channel.Deliver(ctx, recipient, message)
+		attempts = append(attempts, Attempt{Channel: name, Err: err})
+		if err == nil {
+			return &Result{Channel: name, Attempts: attempts}, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, &DeliveryError{Attempts: attempts}
+}
@@ -0,0 +1,123 @@
+package notify_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+	"github.com/lattiq/mailer/notify"
+)
+
+// smsFunc adapts a function to a notify.SMSSender.
+type smsFunc func(ctx context.Context, to, text string) error
+
+func (f smsFunc) SendSMS(ctx context.Context, to, text string) error {
+	return f(ctx, to, text)
+}
+
+func newEmailChannel(t *testing.T) (notify.Channel, *mailertest.MockProvider) {
+	t.Helper()
+
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+	client, err := mailer.New(mailer.DefaultConfig(), mailer.WithProvider(mailertest.ProviderType, mock.Settings()))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return notify.Email(client, mailer.Address{Email: "alerts@example.com"}), mock
+}
+
+func TestNotifyFallsBackToEmail(t *testing.T) {
+	email, mock := newEmailChannel(t)
+	down := errors.New("gateway down")
+	sms := notify.SMS("sms", smsFunc(func(context.Context, string, string) error { return down }))
+
+	notifier, err := notify.New(email, sms)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	result, err := notifier.Notify(context.Background(), notify.Recipient{
+		Email:     mailer.Address{Email: "user@example.com"},
+		Addresses: map[string]string{"sms": "+15551234567"},
+		Channels:  []string{"sms", "email"},
+	}, notify.Message{Subject: "Login code", Text: "Your code is 123456."})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	if result.Channel != notify.ChannelEmail || len(result.Attempts) != 2 || !errors.Is(result.Attempts[0].Err, down) {
+		t.Errorf("result = %+v, want email after the failed SMS attempt", result)
+	}
+	sent := mock.AssertSentTo(t, "user@example.com")
+	if sent != nil && sent.TextBody != "Your code is 123456." {
+		t.Errorf("TextBody = %q, want the message text", sent.TextBody)
+	}
+}
+
+func TestNotifyReportsEveryFailedAttempt(t *testing.T) {
+	email, _ := newEmailChannel(t)
+	sms := notify.SMS("sms", smsFunc(func(context.Context, string, string) error { return nil }))
+
+	notifier, err := notify.New(email, sms)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// The recipient has neither an email address nor a phone number
+	_, err = notifier.Notify(context.Background(), notify.Recipient{
+		Channels: []string{"sms", "email", "push"},
+	}, notify.Message{Text: "Hello"})
+
+	var deliveryErr *notify.DeliveryError
+	if !errors.As(err, &deliveryErr) {
+		t.Fatalf("Notify error = %v, want a *notify.DeliveryError", err)
+	}
+	if len(deliveryErr.Attempts) != 3 {
+		t.Fatalf("attempts = %+v, want 3", deliveryErr.Attempts)
+	}
+	if !errors.Is(err, notify.ErrNoAddress) {
+		t.Errorf("Notify error = %v, want it to match ErrNoAddress", err)
+	}
+}
+
+func TestNewRejectsDuplicateChannels(t *testing.T) {
+	deliver := func(context.Context, notify.Recipient, notify.Message) error { return nil }
+	_, err := notify.New(notify.ChannelFunc("webhook", deliver), notify.ChannelFunc("webhook", deliver))
+
+	var validationErr *mailer.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("New error = %v, want a *mailer.ValidationError", err)
+	}
+}
+
+func TestReliableRetriesRetryableErrors(t *testing.T) {
+	calls := 0
+	flaky := notify.ChannelFunc("webhook", func(context.Context, notify.Recipient, notify.Message) error {
+		calls++
+		if calls < 3 {
+			return mailer.NewRetryableProviderError("webhook", "unavailable", "service unavailable")
+		}
+		return nil
+	})
+
+	channel := notify.Reliable(flaky, mailer.RetryConfig{
+		Enabled:      true,
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+		Multiplier:   1,
+	}, mailer.CircuitBreakerConfig{})
+
+	if err := channel.Deliver(context.Background(), notify.Recipient{}, notify.Message{}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("delivered after %d calls, want 3", calls)
+	}
+}
@@ -1,7 +1,14 @@
 package mailer
 
 import (
+	"context"
+	"io"
+	"io/fs"
+	"slices"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Option is a functional option for configuring the mailer client.
@@ -24,6 +31,44 @@ func WithFallbackProvider(providerType ProviderType, settings ProviderSettings)
 	}
 }
 
+// WithProviderRoute adds a provider that sends are split with, alongside the
+// primary provider, taking weight relative to the other providers' weights.
+// A weight requires the ExperimentWeightedRouting feature.
+func WithProviderRoute(providerType ProviderType, weight int, settings ProviderSettings) Option {
+	return func(c *Config) {
+		c.Provider.Routes = append(c.Provider.Routes, ProviderRoute{
+			Type:     providerType,
+			Settings: settings,
+			Weight:   weight,
+		})
+	}
+}
+
+// WithRouting sets the policy routing sends between the primary provider,
+// with the given weight, and the providers added with WithProviderRoute.
+func WithRouting(policy RoutingPolicy, primaryWeight int) Option {
+	return func(c *Config) {
+		c.Provider.Routing = policy
+		c.Provider.PrimaryWeight = primaryWeight
+	}
+}
+
+// WithRoutingRule adds a rule selecting the provider of the emails it
+// matches. Rules are matched in the order they are added.
+func WithRoutingRule(rule RoutingRule) Option {
+	return func(c *Config) {
+		c.Routing.Rules = append(c.Routing.Rules, rule)
+	}
+}
+
+// WithDefaultRoute sets the named provider as the provider of emails no
+// routing rule matches.
+func WithDefaultRoute(provider string) Option {
+	return func(c *Config) {
+		c.Routing.Default = provider
+	}
+}
+
 // WithTimeout sets the provider operation timeout.
 func WithTimeout(timeout time.Duration) Option {
 	return func(c *Config) {
@@ -31,6 +76,17 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithPriorityTimeout sets the provider operation timeout for emails of the
+// given priority, overriding the timeout set by WithTimeout.
+func WithPriorityTimeout(priority Priority, timeout time.Duration) Option {
+	return func(c *Config) {
+		if c.Provider.PriorityTimeouts == nil {
+			c.Provider.PriorityTimeouts = make(map[Priority]time.Duration)
+		}
+		c.Provider.PriorityTimeouts[priority] = timeout
+	}
+}
+
 // WithMaxConnsPerHost sets the maximum number of connections per host.
 func WithMaxConnsPerHost(maxConns int) Option {
 	return func(c *Config) {
@@ -46,6 +102,93 @@ func WithTemplates(directory string) Option {
 	}
 }
 
+// WithTemplateEngine enables template functionality with an existing
+// engine, such as one generated by cmd/mailergen.
+func WithTemplateEngine(engine TemplateEngine) Option {
+	return func(c *Config) {
+		c.Templates.Enabled = true
+		c.Templates.Engine = engine
+	}
+}
+
+// WithTemplatesFS enables template functionality and loads templates from
+// root in fsys, such as an embed.FS, so that they ship inside the binary.
+func WithTemplatesFS(fsys fs.FS, root string) Option {
+	return func(c *Config) {
+		c.Templates.Enabled = true
+		c.Templates.FS = fsys
+		c.Templates.Directory = root
+	}
+}
+
+// WithTemplateAssets publishes the assets in directory to store and serves them
+// from baseURL through the "asset" template function.
+func WithTemplateAssets(directory, baseURL string, store AssetStore) Option {
+	return func(c *Config) {
+		c.Templates.Assets = AssetConfig{
+			Directory: directory,
+			BaseURL:   baseURL,
+			Store:     store,
+		}
+	}
+}
+
+// WithTextPartCheck reports HTML templates without a text part when templates
+// are loaded, with severity TemplateCheckWarn or TemplateCheckError.
+func WithTextPartCheck(severity string) Option {
+	return func(c *Config) {
+		c.Templates.TextPartCheck = severity
+	}
+}
+
+// WithAutoText derives the text body of templated emails from their HTML
+// body when the template has no text part.
+func WithAutoText() Option {
+	return func(c *Config) {
+		c.Templates.AutoText = true
+	}
+}
+
+// WithTemplateResolver sets the naming scheme used to register template files
+// and find template parts.
+func WithTemplateResolver(resolver TemplateResolver) Option {
+	return func(c *Config) {
+		c.Templates.Resolver = resolver
+	}
+}
+
+// WithLocaleResolver sets the resolver used to determine the locale and
+// timezone of template requests that omit them.
+func WithLocaleResolver(resolver LocaleResolver) Option {
+	return func(c *Config) {
+		c.Templates.LocaleResolver = resolver
+	}
+}
+
+// WithTranslations loads the message catalogs in directory for the "t"
+// template function, falling back to defaultLocale for missing messages.
+func WithTranslations(directory, defaultLocale string) Option {
+	return func(c *Config) {
+		c.Templates.Translations = directory
+		c.Templates.DefaultLocale = defaultLocale
+	}
+}
+
+// WithInlineCSS inlines the <style> rules of rendered HTML templates into
+// style attributes.
+func WithInlineCSS() Option {
+	return func(c *Config) {
+		c.Templates.InlineCSS = true
+	}
+}
+
+// WithMJMLCompiler compiles ".mjml" template files to HTML with compiler.
+func WithMJMLCompiler(compiler MJMLCompiler) Option {
+	return func(c *Config) {
+		c.Templates.MJML = compiler
+	}
+}
+
 // WithTemplateCache configures template caching.
 func WithTemplateCache(enabled bool, cacheSize int) Option {
 	return func(c *Config) {
@@ -61,6 +204,17 @@ func WithTemplateAutoReload(enabled bool) Option {
 	}
 }
 
+// WithMiddleware appends middleware to the send pipeline. Middleware runs
+// before validation for every email sent with Send, SendTemplate or
+// SendBatch. For batch emails, next returns the email's outcome once every
+// email of the batch has reached next or been stopped and the batch is sent,
+// and an error returned by the middleware fails only that email.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(c *Config) {
+		c.Middleware = append(c.Middleware, middleware...)
+	}
+}
+
 // WithRetry configures retry behavior.
 func WithRetry(maxAttempts int, initialDelay, maxDelay time.Duration, multiplier float64) Option {
 	return func(c *Config) {
@@ -72,6 +226,18 @@ func WithRetry(maxAttempts int, initialDelay, maxDelay time.Duration, multiplier
 	}
 }
 
+// WithRetryPolicy sets the retry policy for a class of retryable errors.
+func WithRetryPolicy(class ErrorClass, policy RetryPolicy) Option {
+	return func(c *Config) {
+		policies := make(map[ErrorClass]RetryPolicy, len(c.Retry.Policies)+1)
+		for existing, p := range c.Retry.Policies {
+			policies[existing] = p
+		}
+		policies[class] = policy
+		c.Retry.Policies = policies
+	}
+}
+
 // WithJitter enables or disables jitter in retry delays.
 func WithJitter(enabled bool) Option {
 	return func(c *Config) {
@@ -96,6 +262,42 @@ func WithRateLimit(rate int, period time.Duration, burst int) Option {
 	}
 }
 
+// WithRateLimitWait makes sends wait up to maxWait for rate limiter tokens,
+// in arrival order, instead of failing when there are not enough.
+func WithRateLimitWait(maxWait time.Duration) Option {
+	return func(c *Config) {
+		c.RateLimit.MaxWait = maxWait
+	}
+}
+
+// WithBlockingRateLimit makes sends wait for rate limiter tokens, in arrival
+// order, until they are available or the send's context is done.
+func WithBlockingRateLimit() Option {
+	return func(c *Config) {
+		c.RateLimit.Block = true
+	}
+}
+
+// WithRateLimitRule caps the sends of a template or category, such as
+// password resets per recipient.
+func WithRateLimitRule(rule RateLimitRule) Option {
+	return func(c *Config) {
+		c.RateLimit.Rules = append(c.RateLimit.Rules, rule)
+	}
+}
+
+// WithRateLimitReserve reserves tokens of the rate limiter's burst for emails
+// of at least the given priority, which then preempt lower priority emails
+// when the limiter is saturated.
+func WithRateLimitReserve(priority Priority, tokens int) Option {
+	return func(c *Config) {
+		if c.RateLimit.Reserved == nil {
+			c.RateLimit.Reserved = make(map[Priority]int)
+		}
+		c.RateLimit.Reserved[priority] = tokens
+	}
+}
+
 // WithPerRecipientRateLimit enables per-recipient rate limiting.
 func WithPerRecipientRateLimit(enabled bool) Option {
 	return func(c *Config) {
@@ -120,6 +322,179 @@ func WithoutCircuitBreaker() Option {
 	}
 }
 
+// WithContentLimits sets the content limits enforced for emails of the given priority.
+func WithContentLimits(priority Priority, limits ContentLimits) Option {
+	return func(c *Config) {
+		if c.ContentLimits == nil {
+			c.ContentLimits = make(map[Priority]ContentLimits)
+		}
+		c.ContentLimits[priority] = limits
+	}
+}
+
+// WithLatencySLO sets the dispatch latency objective for emails of the given priority.
+func WithLatencySLO(priority Priority, slo LatencySLO) Option {
+	return func(c *Config) {
+		if c.LatencySLOs == nil {
+			c.LatencySLOs = make(map[Priority]LatencySLO)
+		}
+		c.LatencySLOs[priority] = slo
+	}
+}
+
+// WithTypoCheck checks recipient domains for likely misspellings, such as
+// gamil.com, with the given mode: TypoCheckWarn, TypoCheckReject or
+// TypoCheckCorrect. Additional correctly spelled domains can be listed.
+func WithTypoCheck(mode string, domains ...string) Option {
+	return func(c *Config) {
+		c.TypoCheck.Mode = mode
+		c.TypoCheck.Domains = append(c.TypoCheck.Domains, domains...)
+	}
+}
+
+// WithIdempotency records the results of sends with an idempotency key in
+// store for ttl, so that repeating such a send does not deliver it again.
+// A nil store records them in memory.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(c *Config) {
+		c.Idempotency.Store = store
+		c.Idempotency.TTL = ttl
+	}
+}
+
+// WithAttachmentScanner scans attachments before they are sent, rejecting
+// emails with an attachment the scanner does not find clean.
+func WithAttachmentScanner(scanner AttachmentScanner) Option {
+	return func(c *Config) {
+		c.AttachmentScanner = scanner
+	}
+}
+
+// WithTextOnlyResolver sends emails without their HTML part when all their
+// recipients prefer text-only emails according to resolver.
+func WithTextOnlyResolver(resolver TextOnlyResolver) Option {
+	return func(c *Config) {
+		c.TextOnlyResolver = resolver
+	}
+}
+
+// WithOnSent calls fn with the result of each email sent through Send or
+// SendTemplate.
+func WithOnSent(fn func(ctx context.Context, email *Email, result *SendResult)) Option {
+	return func(c *Config) {
+		c.OnSent = fn
+	}
+}
+
+// WithBatchChunks splits batches into chunks of size emails, sending up to
+// concurrency chunks at once.
+func WithBatchChunks(size, concurrency int) Option {
+	return func(c *Config) {
+		c.Batch.ChunkSize = size
+		c.Batch.Concurrency = concurrency
+	}
+}
+
+// WithAdaptiveBatching splits batches into chunks whose size, between
+// minChunkSize and maxChunkSize, and concurrency, up to maxConcurrency, adapt
+// to the provider's observed latency and errors.
+func WithAdaptiveBatching(minChunkSize, maxChunkSize, maxConcurrency int) Option {
+	return func(c *Config) {
+		c.Batch.Adaptive = true
+		c.Batch.MinChunkSize = minChunkSize
+		c.Batch.MaxChunkSize = maxChunkSize
+		c.Batch.MaxConcurrency = maxConcurrency
+	}
+}
+
+// WithClock sets the clock used by retries, the circuit breaker and statistics.
+func WithClock(clock Clock) Option {
+	return func(c *Config) {
+		c.Clock = clock
+	}
+}
+
+// WithRand sets the source of randomness used for retry jitter.
+func WithRand(r Rand) Option {
+	return func(c *Config) {
+		c.Rand = r
+	}
+}
+
+// WithIPPool sends emails of the given category from the named IP pool:
+// the ip_pool_name on SendGrid, the sending IP pool on Mailgun, and the source
+// address on SMTP (an IP literal or an "ip_pool.<name>" provider setting).
+func WithIPPool(category, pool string) Option {
+	return func(c *Config) {
+		if c.IPPools == nil {
+			c.IPPools = make(map[string]string)
+		}
+		c.IPPools[category] = pool
+	}
+}
+
+// WithBounceDomain sends bounces to a dedicated domain, distinct from the
+// From domain, by rewriting the Return-Path of every email.
+func WithBounceDomain(domain string) Option {
+	return func(c *Config) {
+		c.BounceDomain = domain
+	}
+}
+
+// WithServerless makes the client suited to serverless functions such as
+// AWS Lambda, with no background goroutines and providers created on the
+// first send.
+func WithServerless() Option {
+	return func(c *Config) {
+		c.Serverless = true
+	}
+}
+
+// WithConfigBundle applies a decrypted configuration bundle, overriding the
+// provider credentials and routing it sets.
+func WithConfigBundle(bundle *ConfigBundle) Option {
+	return func(c *Config) {
+		if bundle.Provider != "" {
+			c.Provider.Type = bundle.Provider
+		}
+		if bundle.Primary != nil {
+			c.Provider.Primary = bundle.Primary
+		}
+		if bundle.Fallback != nil {
+			fallback := bundle.Fallback
+			c.Provider.Fallback = &fallback
+		}
+		if bundle.FailoverErrorRate != 0 {
+			c.Provider.FailoverErrorRate = bundle.FailoverErrorRate
+		}
+		if len(bundle.IPPools) > 0 {
+			pools := make(map[string]string, len(c.IPPools)+len(bundle.IPPools))
+			for category, pool := range c.IPPools {
+				pools[category] = pool
+			}
+			for category, pool := range bundle.IPPools {
+				pools[category] = pool
+			}
+			c.IPPools = pools
+		}
+		if bundle.BounceDomain != "" {
+			c.BounceDomain = bundle.BounceDomain
+		}
+	}
+}
+
+// WithExperimental enables the named experimental features.
+func WithExperimental(names ...string) Option {
+	return func(c *Config) {
+		if c.Experimental == nil {
+			c.Experimental = make(map[string]bool)
+		}
+		for _, name := range names {
+			c.Experimental[name] = true
+		}
+	}
+}
+
 // WithTracing configures distributed tracing.
 func WithTracing(serviceName, serviceVersion string, sampleRate float64) Option {
 	return func(c *Config) {
@@ -137,6 +512,55 @@ func WithTracingEndpoint(endpoint string) Option {
 	}
 }
 
+// WithTracerProvider creates spans with the given tracer provider instead
+// of the global one, e.g. for tests or isolated per-tenant pipelines.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Config) {
+		c.Monitoring.Tracing.Provider = provider
+	}
+}
+
+// WithDeliveryTracing records the trace context of each send in its
+// metadata, so that delivery events can be attached to the trace of the send
+// with RecordDeliveryEvent.
+func WithDeliveryTracing() Option {
+	return func(c *Config) {
+		c.Monitoring.Tracing.DeliveryEvents = true
+	}
+}
+
+// WithPostMortem writes a diagnostic bundle to w, as a line of JSON, for
+// each send that fails after retries and failover.
+func WithPostMortem(w io.Writer) Option {
+	return func(c *Config) {
+		c.PostMortem.Writer = w
+	}
+}
+
+// WithPostMortemDir writes a diagnostic bundle to a file in dir for each
+// send that fails after retries and failover.
+func WithPostMortemDir(dir string) Option {
+	return func(c *Config) {
+		c.PostMortem.Directory = dir
+	}
+}
+
+// WithBaggageAttribution records the OpenTelemetry baggage members with the
+// given keys as span attributes and email metadata on every send.
+func WithBaggageAttribution(keys ...string) Option {
+	return func(c *Config) {
+		c.Monitoring.BaggageKeys = append(c.Monitoring.BaggageKeys, keys...)
+	}
+}
+
+// WithCorrelationIDs generates a correlation ID for each email sent without
+// one, recorded wherever the email is observed.
+func WithCorrelationIDs() Option {
+	return func(c *Config) {
+		c.Monitoring.CorrelationIDs = true
+	}
+}
+
 // WithoutTracing disables distributed tracing.
 func WithoutTracing() Option {
 	return func(c *Config) {
@@ -160,6 +584,21 @@ func WithMetricsEndpoint(endpoint string) Option {
 	}
 }
 
+// WithMeterProvider records metrics with the given meter provider instead of
+// the global one.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *Config) {
+		c.Monitoring.Metrics.Provider = provider
+	}
+}
+
+// WithStatsWindow sets the rolling window used for provider statistics.
+func WithStatsWindow(window time.Duration) Option {
+	return func(c *Config) {
+		c.Monitoring.Metrics.Window = window
+	}
+}
+
 // WithoutMetrics disables metrics collection.
 func WithoutMetrics() Option {
 	return func(c *Config) {
@@ -170,6 +609,7 @@ func WithoutMetrics() Option {
 // WithLogging configures logging.
 func WithLogging(level, format, output string) Option {
 	return func(c *Config) {
+		c.Monitoring.Logging.Enabled = true
 		c.Monitoring.Logging.Level = level
 		c.Monitoring.Logging.Format = format
 		c.Monitoring.Logging.Output = output
@@ -200,6 +640,102 @@ func WithAWSSESCredentials(region, accessKey, secretKey string) Option {
 	})
 }
 
+// WithSESMultiRegion configures AWS SES in two regions with health-checked failover.
+// Sends go to the primary region; retryable failures, and all traffic while the
+// primary region's error rate is elevated, go to the secondary region. Both
+// regions keep the other settings of an AWS SES provider configured by an
+// earlier option, such as credentials and the configuration set.
+func WithSESMultiRegion(primaryRegion, secondaryRegion string) Option {
+	return func(c *Config) {
+		base := sesBaseSettings(c)
+		c.Provider.Type = ProviderAWSSES
+		c.Provider.Primary = sesRegionSettings(base, primaryRegion)
+		fallback := sesRegionSettings(base, secondaryRegion)
+		fallback["type"] = string(ProviderAWSSES)
+		c.Provider.Fallback = &fallback
+		if c.Provider.FailoverErrorRate == 0 {
+			c.Provider.FailoverErrorRate = 0.5
+		}
+	}
+}
+
+// SESRegionWeight is an AWS SES region and its share of sends, relative to
+// the other regions' weights.
+type SESRegionWeight struct {
+	Region string
+	Weight int
+}
+
+// WithSESWeightedRegions configures AWS SES in several regions with sends
+// split between them by weight. The first region is the primary provider and
+// the others are routes, with weighted routing. Like WithSESMultiRegion, the
+// regions keep the other settings of an AWS SES provider configured by an
+// earlier option, and replace the SES routes of an earlier call. It requires
+// the ExperimentWeightedRouting feature.
+func WithSESWeightedRegions(regions ...SESRegionWeight) Option {
+	return func(c *Config) {
+		if len(regions) == 0 {
+			return
+		}
+
+		base := sesBaseSettings(c)
+		c.Provider.Type = ProviderAWSSES
+		c.Provider.Primary = sesRegionSettings(base, regions[0].Region)
+		c.Provider.PrimaryWeight = regions[0].Weight
+		c.Provider.Routing = RoutingWeighted
+		c.Provider.Routes = slices.DeleteFunc(c.Provider.Routes, func(route ProviderRoute) bool {
+			return route.Type == ProviderAWSSES
+		})
+		for _, region := range regions[1:] {
+			c.Provider.Routes = append(c.Provider.Routes, ProviderRoute{
+				Type:     ProviderAWSSES,
+				Settings: sesRegionSettings(base, region.Region),
+				Weight:   region.Weight,
+			})
+		}
+	}
+}
+
+// sesBaseSettings returns the primary provider settings when it is AWS SES,
+// for the regions of a multi-region configuration to share.
+func sesBaseSettings(c *Config) ProviderSettings {
+	if c.Provider.Type != ProviderAWSSES {
+		return nil
+	}
+	return c.Provider.Primary
+}
+
+// sesRegionSettings returns a copy of base for the given region, named after
+// it so that each region has its own statistics and circuit breaker.
+func sesRegionSettings(base ProviderSettings, region string) ProviderSettings {
+	settings := ProviderSettings{}
+	for key, value := range base {
+		settings[key] = value
+	}
+	settings["region"] = region
+	settings["name"] = string(ProviderAWSSES) + ":" + region
+	return settings
+}
+
+// WithWarmPool opens the given number of connections to each provider when the
+// client is created, failing New if a provider cannot be reached.
+func WithWarmPool(size int) Option {
+	return func(c *Config) {
+		c.Provider.WarmPoolSize = size
+	}
+}
+
+// WithRequestSigner signs every API request of the provider with the given
+// name, such as "sendgrid" or "aws_ses:eu-west-1", with signer.
+func WithRequestSigner(providerName string, signer RequestSigner) Option {
+	return func(c *Config) {
+		if c.Provider.RequestSigners == nil {
+			c.Provider.RequestSigners = make(map[string]RequestSigner)
+		}
+		c.Provider.RequestSigners[providerName] = signer
+	}
+}
+
 // WithSendGrid creates a SendGrid provider configuration.
 func WithSendGrid(apiKey string) Option {
 	return WithProvider(ProviderSendGrid, ProviderSettings{
@@ -224,6 +760,22 @@ func WithMailgunEU(apiKey, domain string) Option {
 	})
 }
 
+// WithPostmark creates a Postmark provider configuration.
+func WithPostmark(serverToken string) Option {
+	return WithProvider(ProviderPostmark, ProviderSettings{
+		"server_token": serverToken,
+	})
+}
+
+// WithPostmarkStream creates a Postmark provider configuration that sends
+// through the given message stream, e.g. "broadcast".
+func WithPostmarkStream(serverToken, messageStream string) Option {
+	return WithProvider(ProviderPostmark, ProviderSettings{
+		"server_token":   serverToken,
+		"message_stream": messageStream,
+	})
+}
+
 // WithSMTP creates an SMTP provider configuration.
 func WithSMTP(host, port string) Option {
 	return WithProvider(ProviderSMTP, ProviderSettings{
@@ -258,3 +810,15 @@ func WithSMTPTLS(host, port, username, password string, skipVerify bool) Option
 		}(),
 	})
 }
+
+// WithSMTPDirect creates an SMTP provider configuration that delivers directly
+// to the MX hosts of each recipient domain, greeting them as helo (the host
+// name when empty). tlsPolicy is "opportunistic", "require", "mta-sts" or
+// "dane"; an empty policy is opportunistic.
+func WithSMTPDirect(helo, tlsPolicy string) Option {
+	return WithProvider(ProviderSMTP, ProviderSettings{
+		"direct":     "true",
+		"helo":       helo,
+		"tls_policy": tlsPolicy,
+	})
+}
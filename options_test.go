@@ -0,0 +1,41 @@
+package mailer_test
+
+import (
+	"testing"
+
+	"github.com/lattiq/mailer"
+)
+
+func TestSESWeightedRegionsReplacesEarlierRegions(t *testing.T) {
+	config := mailer.DefaultConfig()
+	for _, option := range []mailer.Option{
+		mailer.WithProviderRoute(mailer.ProviderSendGrid, 0, mailer.ProviderSettings{"api_key": "key"}),
+		mailer.WithSESWeightedRegions(
+			mailer.SESRegionWeight{Region: "us-east-1", Weight: 1},
+			mailer.SESRegionWeight{Region: "eu-west-1", Weight: 1},
+			mailer.SESRegionWeight{Region: "ap-south-1", Weight: 1},
+		),
+		mailer.WithSESWeightedRegions(
+			mailer.SESRegionWeight{Region: "us-west-2", Weight: 80},
+			mailer.SESRegionWeight{Region: "eu-central-1", Weight: 20},
+		),
+	} {
+		option(&config)
+	}
+
+	if got := config.Provider.Primary.Get("region"); got != "us-west-2" {
+		t.Errorf("primary region = %q, want us-west-2", got)
+	}
+	var regions []string
+	for _, route := range config.Provider.Routes {
+		if route.Type == mailer.ProviderAWSSES {
+			regions = append(regions, route.Settings.Get("region"))
+		}
+	}
+	if len(regions) != 1 || regions[0] != "eu-central-1" {
+		t.Errorf("SES route regions = %v, want [eu-central-1]", regions)
+	}
+	if len(config.Provider.Routes) != 2 || config.Provider.Routes[0].Type != mailer.ProviderSendGrid {
+		t.Errorf("routes = %+v, want the SendGrid route kept", config.Provider.Routes)
+	}
+}
@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"context"
+	"sort"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MetadataProvider is the Email.Metadata key that forces a send to use the
+// configured provider with that name, e.g. "sendgrid" or "aws_ses:eu-west-1".
+const MetadataProvider = core.MetadataProvider
+
+// providerContextKey is the context key for values set by ContextWithProvider.
+type providerContextKey struct{}
+
+// ContextWithProvider returns a context that forces sends made with it to use
+// the configured provider with the given name, bypassing failover. This is
+// intended for migration testing and provider-specific debugging.
+func ContextWithProvider(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, providerContextKey{}, name)
+}
+
+// forcedProvider returns the provider a send is forced to use by the context
+// or, if email is non-nil, its metadata. It returns nil when neither forces one.
+func (c *Client) forcedProvider(ctx context.Context, email *Email) (Provider, error) {
+	name, _ := ctx.Value(providerContextKey{}).(string)
+	if name == "" && email != nil {
+		name = email.Metadata[MetadataProvider]
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	for _, provider := range c.configuredProviders() {
+		if provider.Name() == name {
+			return provider, nil
+		}
+	}
+	return nil, NewValidationErrorWithValue("provider", "provider is not configured", name)
+}
+
+// sendForced sends the emails of a batch through the provider the context
+// forces or, when it forces none, grouped by the provider their metadata
+// forces, with emails forced to none sent as usual. When there are several
+// groups, a group that fails as a whole fails each of its emails.
+func (c *Client) sendForced(ctx context.Context, forced Provider, emails []*Email, audits []*attachmentAudit) (*BatchResult, error) {
+	if forced != nil {
+		return c.sendChunks(ctx, forced, emails, audits)
+	}
+
+	var names []string
+	groups := make(map[string][]int)
+	for i, email := range emails {
+		name := email.Metadata[MetadataProvider]
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], i)
+	}
+	if len(names) == 1 && names[0] == "" {
+		return c.sendChunks(ctx, nil, emails, audits)
+	}
+
+	result := &BatchResult{Total: len(emails)}
+	for _, name := range names {
+		indexes := groups[name]
+		group := make([]*Email, len(indexes))
+		groupAudits := make([]*attachmentAudit, len(indexes))
+		for n, i := range indexes {
+			group[n] = emails[i]
+			groupAudits[n] = audits[i]
+		}
+
+		provider, err := c.forcedProvider(ctx, group[0])
+		var groupResult *BatchResult
+		if err == nil {
+			groupResult, err = c.sendChunks(ctx, provider, group, groupAudits)
+		}
+		if err != nil {
+			if len(names) == 1 {
+				return nil, err
+			}
+			for n, email := range group {
+				result.Failed = append(result.Failed, BatchFailure{Index: indexes[n], Email: email, Error: err})
+			}
+			continue
+		}
+
+		if result.Provider == "" {
+			result.Provider = groupResult.Provider
+		}
+		result.Successful = append(result.Successful, groupResult.Successful...)
+		for _, failure := range groupResult.Failed {
+			failure.Index = indexes[failure.Index]
+			result.Failed = append(result.Failed, failure)
+		}
+	}
+
+	sort.Slice(result.Failed, func(i, j int) bool {
+		return result.Failed[i].Index < result.Failed[j].Index
+	})
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package mailer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func newRoutedClient(t *testing.T) (*mailer.Client, *mailertest.MockProvider, *mailertest.MockProvider) {
+	t.Helper()
+
+	primary := mailertest.NewMockProvider("primary")
+	t.Cleanup(primary.Close)
+	secondary := mailertest.NewMockProvider("secondary")
+	t.Cleanup(secondary.Close)
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, primary.Settings()),
+		mailer.WithProviderRoute(mailertest.ProviderType, 0, secondary.Settings()),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, primary, secondary
+}
+
+func TestSendBatchHonorsProviderMetadata(t *testing.T) {
+	client, primary, secondary := newRoutedClient(t)
+
+	emails := []*mailer.Email{validEmail(), validEmail(), validEmail()}
+	emails[1].Metadata = map[string]string{mailer.MetadataProvider: "secondary"}
+
+	if err := client.SendBatch(context.Background(), emails); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+	if primary.Count() != 2 || secondary.Count() != 1 {
+		t.Errorf("primary sent %d and secondary %d emails, want 2 and 1", primary.Count(), secondary.Count())
+	}
+}
+
+func TestSendBatchContextOverridesProviderMetadata(t *testing.T) {
+	client, primary, secondary := newRoutedClient(t)
+
+	emails := []*mailer.Email{validEmail(), validEmail()}
+	emails[1].Metadata = map[string]string{mailer.MetadataProvider: "primary"}
+
+	ctx := mailer.ContextWithProvider(context.Background(), "secondary")
+	if err := client.SendBatch(ctx, emails); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+	if primary.Count() != 0 || secondary.Count() != 2 {
+		t.Errorf("primary sent %d and secondary %d emails, want 0 and 2", primary.Count(), secondary.Count())
+	}
+}
+
+func TestSendBatchFailsGroupOfFailingProvider(t *testing.T) {
+	client, primary, secondary := newRoutedClient(t)
+	secondary.SetError(errors.New("unavailable"))
+
+	emails := []*mailer.Email{validEmail(), validEmail()}
+	emails[1].Metadata = map[string]string{mailer.MetadataProvider: "secondary"}
+
+	err := client.SendBatch(context.Background(), emails)
+	var batchErr *mailer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("SendBatch error = %v, want a *mailer.BatchError", err)
+	}
+	if batchErr.Failed != 1 || batchErr.Errors[0].Index != 1 {
+		t.Errorf("failed items = %+v, want only index 1", batchErr.Errors)
+	}
+	if primary.Count() != 1 {
+		t.Errorf("primary sent %d emails, want 1", primary.Count())
+	}
+}
+
+func TestSendBatchRejectsUnknownProviderMetadata(t *testing.T) {
+	client, primary, _ := newRoutedClient(t)
+
+	emails := []*mailer.Email{validEmail(), validEmail()}
+	emails[1].Metadata = map[string]string{mailer.MetadataProvider: "unknown"}
+
+	assertValidationError(t, client.SendBatch(context.Background(), emails))
+	if primary.Count() != 0 {
+		t.Errorf("primary sent %d emails, want 0", primary.Count())
+	}
+}
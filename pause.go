@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MetadataTemplate is the Email.Metadata key holding the name of the template
+// an email was rendered from. SendTemplate sets it.
+const MetadataTemplate = core.MetadataTemplate
+
+// pauses records the paused categories and templates.
+type pauses struct {
+	mutex      sync.RWMutex
+	categories map[string]bool
+	templates  map[string]bool
+}
+
+// set pauses or resumes name in set, one of p's maps.
+func (p *pauses) set(set *map[string]bool, name string, paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if *set == nil {
+		*set = make(map[string]bool)
+	}
+	if paused {
+		(*set)[name] = true
+	} else {
+		delete(*set, name)
+	}
+}
+
+// check returns an error wrapping ErrPaused when email's category or template
+// is paused.
+func (p *pauses) check(email *Email) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if category := email.Category(); category != "" && p.categories[category] {
+		return &PausedError{Category: category}
+	}
+	if template := email.Metadata[MetadataTemplate]; template != "" && p.templates[template] {
+		return &PausedError{Template: template}
+	}
+	return nil
+}
+
+// names returns the sorted names in set, one of p's maps.
+func (p *pauses) names(set *map[string]bool) []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	names := make([]string, 0, len(*set))
+	for name := range *set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PauseCategory stops sends of emails in the given category, such as
+// "marketing", until ResumeCategory is called. Sends of paused emails fail
+// with ErrPaused; other traffic on the client is unaffected. The category is
+// read from the "category" metadata or the X-Category header.
+func (c *Client) PauseCategory(category string) {
+	c.pauses.set(&c.pauses.categories, category, true)
+	c.logger.Warn("category paused", "category", category)
+}
+
+// ResumeCategory resumes sends of emails in a category paused by PauseCategory.
+func (c *Client) ResumeCategory(category string) {
+	c.pauses.set(&c.pauses.categories, category, false)
+	c.logger.Info("category resumed", "category", category)
+}
+
+// PauseTemplate stops sends of emails rendered by SendTemplate from the given
+// template until ResumeTemplate is called. Sends of paused emails fail with
+// ErrPaused.
+func (c *Client) PauseTemplate(template string) {
+	c.pauses.set(&c.pauses.templates, template, true)
+	c.logger.Warn("template paused", "template", template)
+}
+
+// ResumeTemplate resumes sends of a template paused by PauseTemplate.
+func (c *Client) ResumeTemplate(template string) {
+	c.pauses.set(&c.pauses.templates, template, false)
+	c.logger.Info("template resumed", "template", template)
+}
+
+// PausedCategories returns the currently paused categories, sorted.
+func (c *Client) PausedCategories() []string {
+	return c.pauses.names(&c.pauses.categories)
+}
+
+// PausedTemplates returns the currently paused templates, sorted.
+func (c *Client) PausedTemplates() []string {
+	return c.pauses.names(&c.pauses.templates)
+}
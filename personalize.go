@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// Recipient is a batch recipient together with their substitution values.
+type Recipient struct {
+	// Address is the recipient's address.
+	Address Address
+
+	// Variables are the values for %recipient.<key>% tokens in the email.
+	Variables map[string]string
+}
+
+// SubstitutionToken returns the %recipient.<key>% token replaced by the value for key.
+func SubstitutionToken(key string) string {
+	return core.SubstitutionToken(key)
+}
+
+// Personalize creates one email per recipient from a single rendered base email.
+// Each copy is addressed to one recipient (CC and BCC are dropped), shares the
+// base email's content and carries the recipient's
+// variables as substitutions, so no template is re-rendered per recipient.
+// The base email's attachments are read once, and each copy gets its own
+// readers of the content and its own headers and metadata.
+// Send the result with SendBatch; tokens are substituted by the provider where
+// supported and by the client otherwise.
+func Personalize(base *Email, recipients []Recipient) []*Email {
+	attachments := readAttachments(base.Attachments)
+
+	emails := make([]*Email, len(recipients))
+	for i, recipient := range recipients {
+		email := *base
+		email.To = []Address{recipient.Address}
+		email.CC = nil
+		email.BCC = nil
+		email.Substitutions = recipient.Variables
+		email.Headers = copyStrings(base.Headers)
+		email.Metadata = copyStrings(base.Metadata)
+		if len(base.Attachments) > 0 {
+			email.Attachments = make([]Attachment, len(base.Attachments))
+			for j, attachment := range base.Attachments {
+				attachment.Data = attachments[j].reader()
+				email.Attachments[j] = attachment
+			}
+		}
+		emails[i] = &email
+	}
+	return emails
+}
+
+// attachmentContent is the content of an attachment read by Personalize, or
+// the error reading it.
+type attachmentContent struct {
+	data []byte
+	err  error
+	none bool
+}
+
+// readAttachments reads the content of each attachment.
+func readAttachments(attachments []Attachment) []attachmentContent {
+	contents := make([]attachmentContent, len(attachments))
+	for i, attachment := range attachments {
+		if attachment.Data == nil {
+			contents[i].none = true
+			continue
+		}
+		contents[i].data, contents[i].err = io.ReadAll(attachment.Data)
+	}
+	return contents
+}
+
+// reader returns a new reader of the content, which fails with the read
+// error if the attachment could not be read, or nil for an attachment
+// without data.
+func (a attachmentContent) reader() io.Reader {
+	switch {
+	case a.none:
+		return nil
+	case a.err != nil:
+		return errReader{a.err}
+	default:
+		return bytes.NewReader(a.data)
+	}
+}
+
+// errReader is a reader that fails with err.
+type errReader struct {
+	err error
+}
+
+// Read implements io.Reader.
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// copyStrings returns a copy of m, or nil if m is nil.
+func copyStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,318 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// PostMortemConfig configures the diagnostic bundles captured when a send
+// fails for good, after retries and failover, to attach to bug reports and
+// provider support tickets. Bundles leave out recipient addresses, subjects,
+// bodies and provider credentials.
+type PostMortemConfig struct {
+	// Writer receives each bundle as a line of JSON.
+	Writer io.Writer
+
+	// Directory receives each bundle as an indented JSON file named
+	// "postmortem-<time>-<n>.json".
+	Directory string
+}
+
+// enabled reports whether bundles are captured.
+func (p PostMortemConfig) enabled() bool {
+	return p.Writer != nil || p.Directory != ""
+}
+
+// PostMortem is the diagnostic bundle of a failed send.
+type PostMortem struct {
+	Time            time.Time             `json:"time"`
+	Version         string                `json:"version"`
+	CorrelationID   string                `json:"correlation_id,omitempty"`
+	Error           string                `json:"error"`
+	Email           PostMortemEmail       `json:"email"`
+	Attempts        []PostMortemAttempt   `json:"attempts"`
+	CircuitBreakers []PostMortemBreaker   `json:"circuit_breakers,omitempty"`
+	RateLimiter     *PostMortemLimiter    `json:"rate_limiter,omitempty"`
+	Providers       []providerStatsExport `json:"providers"`
+	Config          PostMortemSnapshot    `json:"config"`
+}
+
+// PostMortemEmail describes the failed email without its addresses,
+// subject or content.
+type PostMortemEmail struct {
+	FromDomain       string                 `json:"from_domain"`
+	RecipientDomains []string               `json:"recipient_domains"`
+	Recipients       int                    `json:"recipients"`
+	SubjectLength    int                    `json:"subject_length"`
+	HTMLSize         int                    `json:"html_size"`
+	TextSize         int                    `json:"text_size"`
+	Headers          []string               `json:"headers,omitempty"`
+	Metadata         map[string]string      `json:"metadata,omitempty"`
+	Priority         string                 `json:"priority"`
+	Category         string                 `json:"category,omitempty"`
+	Attachments      []PostMortemAttachment `json:"attachments,omitempty"`
+}
+
+// PostMortemAttachment describes an attachment of the failed email.
+type PostMortemAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size,omitempty"`
+	Inline      bool   `json:"inline,omitempty"`
+}
+
+// PostMortemAttempt is a provider call made for the failed email.
+type PostMortemAttempt struct {
+	Provider   string    `json:"provider"`
+	Started    time.Time `json:"started"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Retryable  bool      `json:"retryable"`
+}
+
+// PostMortemBreaker is the state of a provider's circuit breaker after the
+// failure.
+type PostMortemBreaker struct {
+	Provider  string `json:"provider"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	Successes int    `json:"successes"`
+}
+
+// PostMortemLimiter is the rate limiter state after the failure.
+type PostMortemLimiter struct {
+	Available int `json:"available"`
+	Burst     int `json:"burst"`
+}
+
+// PostMortemSnapshot is the reliability configuration of the client, with
+// provider settings that may hold credentials redacted.
+type PostMortemSnapshot struct {
+	Provider          ProviderType          `json:"provider"`
+	Primary           map[string]string     `json:"primary"`
+	Fallback          map[string]string     `json:"fallback,omitempty"`
+	Timeout           string                `json:"timeout"`
+	Retry             *RetryConfig          `json:"retry,omitempty"`
+	RateLimit         *RateLimitConfig      `json:"rate_limit,omitempty"`
+	CircuitBreaker    *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	FailoverErrorRate float64               `json:"failover_error_rate,omitempty"`
+}
+
+// attemptLog collects the provider calls of a send for its post-mortem.
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []PostMortemAttempt
+}
+
+// attemptLogContextKey is the context key of a send's attempt log.
+type attemptLogContextKey struct{}
+
+// withAttemptLog returns a context recording the provider calls made with
+// it, when post-mortems are enabled.
+func (c *Client) withAttemptLog(ctx context.Context) (context.Context, *attemptLog) {
+	if !c.config.PostMortem.enabled() {
+		return ctx, nil
+	}
+	log := &attemptLog{}
+	return context.WithValue(ctx, attemptLogContextKey{}, log), log
+}
+
+// recordAttempt adds a provider call to the attempt log of ctx, if any.
+func recordAttempt(ctx context.Context, provider string, started time.Time, duration time.Duration, err error) {
+	log, _ := ctx.Value(attemptLogContextKey{}).(*attemptLog)
+	if log == nil {
+		return
+	}
+
+	attempt := PostMortemAttempt{
+		Provider:   provider,
+		Started:    started.UTC(),
+		DurationMS: duration.Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.Retryable = IsRetryable(err)
+		var providerErr *core.ProviderError
+		if errors.As(err, &providerErr) {
+			attempt.Code = providerErr.Code
+			attempt.StatusCode = providerErr.StatusCode
+		}
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.attempts = append(log.attempts, attempt)
+}
+
+// postMortem assembles the bundle of a failed send.
+func (c *Client) postMortem(email *Email, sendErr error, log *attemptLog) *PostMortem {
+	bundle := &PostMortem{
+		Time:          c.clock.Now().UTC(),
+		Version:       Version,
+		CorrelationID: email.CorrelationID(),
+		Error:         sendErr.Error(),
+		Email:         postMortemEmail(email),
+		Attempts:      []PostMortemAttempt{},
+		Config:        c.postMortemSnapshot(),
+	}
+	if log != nil {
+		log.mu.Lock()
+		bundle.Attempts = append(bundle.Attempts, log.attempts...)
+		log.mu.Unlock()
+	}
+
+	bundle.CircuitBreakers = c.postMortemBreakers()
+	if c.rateLimiter != nil {
+		bundle.RateLimiter = &PostMortemLimiter{
+			Available: len(c.rateLimiter.tokens),
+			Burst:     cap(c.rateLimiter.tokens),
+		}
+	}
+
+	bundle.Providers = providerStatsRows(c.Stats())
+
+	return bundle
+}
+
+// postMortemEmail describes an email without its addresses, subject or
+// content.
+func postMortemEmail(email *Email) PostMortemEmail {
+	described := PostMortemEmail{
+		FromDomain:    addressDomain(email.From.Email),
+		Recipients:    email.TotalRecipients(),
+		SubjectLength: len(email.Subject),
+		HTMLSize:      len(email.HTMLBody),
+		TextSize:      len(email.TextBody),
+		Metadata:      email.Metadata,
+		Priority:      email.Priority.String(),
+		Category:      email.Category(),
+	}
+
+	domains := make(map[string]bool)
+	for _, recipient := range email.AllRecipients() {
+		domains[addressDomain(recipient.Email)] = true
+	}
+	for domain := range domains {
+		described.RecipientDomains = append(described.RecipientDomains, domain)
+	}
+	sort.Strings(described.RecipientDomains)
+
+	for name := range email.Headers {
+		described.Headers = append(described.Headers, name)
+	}
+	sort.Strings(described.Headers)
+
+	for _, attachment := range email.Attachments {
+		described.Attachments = append(described.Attachments, PostMortemAttachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.DetectContentType(),
+			Size:        attachment.Size,
+			Inline:      attachment.Inline,
+		})
+	}
+
+	return described
+}
+
+// addressDomain returns the lower-cased domain of an email address.
+func addressDomain(address string) string {
+	return strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+}
+
+// postMortemSnapshot returns the client's reliability configuration.
+func (c *Client) postMortemSnapshot() PostMortemSnapshot {
+	snapshot := PostMortemSnapshot{
+		Provider:          c.config.Provider.Type,
+		Timeout:           c.config.Provider.Timeout.String(),
+		FailoverErrorRate: c.config.Provider.FailoverErrorRate,
+	}
+
+	// Rotation replaces the provider settings under providerMu
+	c.providerMu.RLock()
+	snapshot.Primary = redactSettings(c.config.Provider.Primary)
+	if c.config.Provider.Fallback != nil {
+		snapshot.Fallback = redactSettings(*c.config.Provider.Fallback)
+	}
+	c.providerMu.RUnlock()
+	if c.config.Retry.Enabled {
+		retry := c.config.Retry
+		snapshot.Retry = &retry
+	}
+	if c.config.RateLimit.Enabled || len(c.config.RateLimit.Rules) > 0 {
+		rateLimit := c.config.RateLimit
+		snapshot.RateLimit = &rateLimit
+	}
+	if c.config.CircuitBreaker.Enabled {
+		breaker := c.config.CircuitBreaker
+		snapshot.CircuitBreaker = &breaker
+	}
+	return snapshot
+}
+
+// redactSettings returns provider settings with the values of those that
+// may hold credentials replaced.
+func redactSettings(settings ProviderSettings) map[string]string {
+	redacted := make(map[string]string, len(settings))
+	for key, value := range settings {
+		lower := strings.ToLower(key)
+		for _, sensitive := range []string{"key", "secret", "password", "token", "pass", "credential"} {
+			if strings.Contains(lower, sensitive) {
+				value = "[REDACTED]"
+				break
+			}
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// postMortemWriter serializes writes of bundles to the configured writer
+// and numbers the files written to the configured directory.
+type postMortemWriter struct {
+	mu    sync.Mutex
+	count int
+}
+
+// capturePostMortem writes the bundle of a failed send to the configured
+// writer and directory, logging rather than returning write errors so that
+// the send's own error is reported.
+func (c *Client) capturePostMortem(email *Email, sendErr error, log *attemptLog) {
+	if !c.config.PostMortem.enabled() {
+		return
+	}
+	bundle := c.postMortem(email, sendErr, log)
+
+	c.postMortems.mu.Lock()
+	defer c.postMortems.mu.Unlock()
+
+	if w := c.config.PostMortem.Writer; w != nil {
+		if err := json.NewEncoder(w).Encode(bundle); err != nil {
+			c.logger.Warn("failed to write post-mortem", "error", err)
+		}
+	}
+
+	if dir := c.config.PostMortem.Directory; dir != "" {
+		c.postMortems.count++
+		name := fmt.Sprintf("postmortem-%s-%d.json", bundle.Time.Format("20060102T150405.000000000Z"), c.postMortems.count)
+		content, err := json.MarshalIndent(bundle, "", "  ")
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, name), content, 0o600)
+		}
+		if err != nil {
+			c.logger.Warn("failed to write post-mortem", "file", name, "error", err)
+		}
+	}
+}
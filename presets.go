@@ -0,0 +1,49 @@
+package mailer
+
+import "time"
+
+// Presets bundle the rate limit and circuit breaker settings that suit a
+// provider's published sending limits. They are starting points: accounts
+// are granted different quotas, so adjust them with WithRateLimit and
+// WithCircuitBreaker, applied after the preset, once the account's quota is
+// known.
+
+// PresetSES tunes rate limiting and the circuit breaker for AWS SES
+// production access, whose default sending rate is 14 emails per second.
+// Sandbox accounts are limited to 1 email per second.
+func PresetSES() Option {
+	return preset(
+		WithRateLimit(14, time.Second, 14),
+		WithCircuitBreaker(5, 2, 30*time.Second),
+	)
+}
+
+// PresetSendGridFree tunes rate limiting and the circuit breaker for the
+// SendGrid free plan, which allows 100 emails per day. The breaker waits
+// longer before probing, since failures on the free plan are usually an
+// exhausted daily quota rather than an outage.
+func PresetSendGridFree() Option {
+	return preset(
+		WithRateLimit(100, 24*time.Hour, 10),
+		WithCircuitBreaker(3, 1, 5*time.Minute),
+	)
+}
+
+// PresetMailgunFlex tunes rate limiting and the circuit breaker for the
+// Mailgun Flex plan, which limits new accounts to 100 emails per hour until
+// the account is verified.
+func PresetMailgunFlex() Option {
+	return preset(
+		WithRateLimit(100, time.Hour, 10),
+		WithCircuitBreaker(5, 2, 2*time.Minute),
+	)
+}
+
+// preset returns an option applying opts in order.
+func preset(opts ...Option) Option {
+	return func(c *Config) {
+		for _, opt := range opts {
+			opt(c)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ruleSweepSize is the number of rule buckets above which idle buckets are
+// dropped, bounding the memory used by per-recipient rules.
+const ruleSweepSize = 10000
+
+// RateLimitKey selects what a rate limit rule gives separate allowances to.
+type RateLimitKey string
+
+// Rate limit keys.
+const (
+	// RateLimitPerRecipient gives each recipient address its own allowance.
+	RateLimitPerRecipient RateLimitKey = "recipient"
+
+	// RateLimitPerDomain gives each recipient domain its own allowance, so
+	// that a domain throttling the sender, such as gmail.com, does not use
+	// up the allowance of mail to other domains.
+	RateLimitPerDomain RateLimitKey = "domain"
+
+	// RateLimitPerProvider gives each provider its own allowance, counting
+	// emails against each provider they are sent through, including the
+	// fallback when they fail over.
+	RateLimitPerProvider RateLimitKey = "provider"
+
+	// RateLimitPerTag gives each tag its own allowance, e.g. one per tenant.
+	// Emails without tags are not limited by the rule.
+	RateLimitPerTag RateLimitKey = "tag"
+)
+
+// RateLimitRule caps the sends of a template or category, e.g. password
+// resets at 5 per minute per recipient to stop abuse, or newsletters at
+// 10000 per hour overall. Sends over the cap fail with a *RateLimitError
+// naming the rule instead of waiting.
+type RateLimitRule struct {
+	// Name identifies the rule in errors, e.g. "password-reset".
+	Name string
+
+	// Template limits emails rendered from the template by SendTemplate.
+	// Empty matches any template, or none.
+	Template string
+
+	// Category limits emails in the category. Empty matches any category,
+	// or none.
+	Category string
+
+	// Rate is the number of emails allowed per Period.
+	Rate int
+
+	// Period is the time period for the rate.
+	Period time.Duration
+
+	// PerRecipient gives each recipient address its own allowance, counting
+	// every recipient of an email. It is the same as Per set to
+	// RateLimitPerRecipient.
+	PerRecipient bool
+
+	// Per gives each recipient, recipient domain, provider or tag its own
+	// allowance. An email counts once against each of its distinct keys,
+	// e.g. each domain it is sent to. Empty counts emails overall.
+	Per RateLimitKey
+}
+
+// per returns what the rule gives separate allowances to, or an empty key
+// when it counts emails overall.
+func (r RateLimitRule) per() RateLimitKey {
+	if r.PerRecipient {
+		return RateLimitPerRecipient
+	}
+	return r.Per
+}
+
+// keys returns the keys of the allowances the email counts against: an
+// empty key for rules counting emails overall, or the email's distinct
+// recipients, recipient domains, provider or tags.
+func (r RateLimitRule) keys(email *Email, provider string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	switch r.per() {
+	case RateLimitPerRecipient:
+		// A recipient listed twice receives the email once
+		for _, recipient := range email.AllRecipients() {
+			add(strings.ToLower(recipient.Email))
+		}
+	case RateLimitPerDomain:
+		for _, recipient := range email.AllRecipients() {
+			add(strings.ToLower(addressDomain(recipient.Email)))
+		}
+	case RateLimitPerProvider:
+		add(provider)
+	case RateLimitPerTag:
+		for _, tag := range email.Tags {
+			add(tag)
+		}
+	default:
+		add("")
+	}
+	return keys
+}
+
+// matches reports whether the rule applies to the email.
+func (r RateLimitRule) matches(email *Email) bool {
+	if r.Template != "" && email.Metadata[MetadataTemplate] != r.Template {
+		return false
+	}
+	if r.Category != "" && email.Category() != r.Category {
+		return false
+	}
+	return true
+}
+
+// ruleBucket is a token bucket refilled continuously at the rule's rate.
+type ruleBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// ruleKey identifies a bucket: a rule and, for keyed rules, a recipient,
+// domain, provider or tag.
+type ruleKey struct {
+	rule int
+	key  string
+}
+
+// ruleLimiter enforces rate limit rules with a token bucket per rule and,
+// for keyed rules, per key.
+type ruleLimiter struct {
+	rules   []RateLimitRule
+	clock   Clock
+	mu      sync.Mutex
+	buckets map[ruleKey]*ruleBucket
+}
+
+// newRuleLimiter creates a limiter for the rules, or returns nil when there
+// are none.
+func newRuleLimiter(rules []RateLimitRule, clock Clock) *ruleLimiter {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &ruleLimiter{
+		rules:   rules,
+		clock:   clock,
+		buckets: make(map[ruleKey]*ruleBucket),
+	}
+}
+
+// allow takes a token from each bucket the email counts against under the
+// rules not keyed by provider, or returns a *RateLimitError for the first
+// rule it would exceed without taking any.
+func (rl *ruleLimiter) allow(email *Email) error {
+	return rl.take(email, "", false)
+}
+
+// allowProvider is allow for the rules keyed by provider, counting the
+// email against the named provider it is sent through.
+func (rl *ruleLimiter) allowProvider(email *Email, provider string) error {
+	return rl.take(email, provider, true)
+}
+
+// take applies the rules keyed by provider when perProvider is set, or the
+// other rules otherwise.
+func (rl *ruleLimiter) take(email *Email, provider string, perProvider bool) error {
+	if rl == nil {
+		return nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock.Now()
+	if len(rl.buckets) > ruleSweepSize {
+		rl.sweep(now)
+	}
+
+	var buckets []*ruleBucket
+	for i, rule := range rl.rules {
+		if (rule.per() == RateLimitPerProvider) != perProvider || !rule.matches(email) {
+			continue
+		}
+
+		for _, key := range rule.keys(email, provider) {
+			bucket := rl.bucket(ruleKey{rule: i, key: key}, now)
+			if bucket.tokens < 1 {
+				return rl.exceeded(rule, key, bucket)
+			}
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return nil
+}
+
+// bucket returns the bucket for key, refilled up to now.
+func (rl *ruleLimiter) bucket(key ruleKey, now time.Time) *ruleBucket {
+	rule := rl.rules[key.rule]
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &ruleBucket{tokens: float64(rule.Rate), updated: now}
+		rl.buckets[key] = bucket
+		return bucket
+	}
+
+	elapsed := now.Sub(bucket.updated)
+	bucket.tokens = min(bucket.tokens+float64(rule.Rate)*elapsed.Seconds()/rule.Period.Seconds(), float64(rule.Rate))
+	bucket.updated = now
+	return bucket
+}
+
+// levels returns the level of each rule. Only rules counting emails overall
+// have a single level to report.
+func (rl *ruleLimiter) levels() []adminRateLimit {
+	if rl == nil {
+		return nil
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock.Now()
+	levels := make([]adminRateLimit, len(rl.rules))
+	for i, rule := range rl.rules {
+		levels[i] = adminRateLimit{
+			Name:         rule.Name,
+			Rate:         rule.Rate,
+			Period:       rule.Period.String(),
+			PerRecipient: rule.per() == RateLimitPerRecipient,
+			Per:          string(rule.per()),
+		}
+		if rule.per() == "" {
+			tokens := rl.bucket(ruleKey{rule: i}, now).tokens
+			levels[i].Available = &tokens
+		}
+	}
+	return levels
+}
+
+// sweep drops the buckets that have refilled completely, which behave like
+// new ones.
+func (rl *ruleLimiter) sweep(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.updated) >= rl.rules[key.rule].Period {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// exceeded returns the error for a send over the rule's rate for the key.
+func (rl *ruleLimiter) exceeded(rule RateLimitRule, key string, bucket *ruleBucket) *RateLimitError {
+	// Time until the bucket holds a whole token again
+	perToken := rule.Period / time.Duration(rule.Rate)
+	retryAfter := time.Duration((1 - bucket.tokens) * float64(perToken))
+
+	message := fmt.Sprintf("rule %s allows %d emails per %v", rule.Name, rule.Rate, rule.Period)
+	switch rule.per() {
+	case RateLimitPerRecipient, RateLimitPerDomain:
+		message += " to " + key
+	case RateLimitPerProvider:
+		message += " through provider " + key
+	case RateLimitPerTag:
+		message += " tagged " + key
+	}
+
+	err := NewRateLimitError(message, retryAfter)
+	err.Rule = rule.Name
+	err.Key = key
+	if rule.per() == RateLimitPerRecipient {
+		err.Recipient = key
+	}
+	err.Limit = rule.Rate
+	err.Window = rule.Period
+	return err
+}
+
+// validateRateLimitRules checks the rate limit rules.
+func validateRateLimitRules(rules []RateLimitRule) error {
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		switch {
+		case rule.Name == "":
+			return NewValidationError("rate_limit.rules", "rule name is required")
+		case names[rule.Name]:
+			return NewValidationErrorWithValue("rate_limit.rules", "duplicate rule name", rule.Name)
+		case rule.Rate <= 0:
+			return NewValidationErrorWithValue("rate_limit.rules", "rule "+rule.Name+" must have a rate greater than 0", rule.Rate)
+		case rule.Period <= 0:
+			return NewValidationErrorWithValue("rate_limit.rules", "rule "+rule.Name+" must have a period greater than 0", rule.Period)
+		case rule.PerRecipient && rule.Per != "" && rule.Per != RateLimitPerRecipient:
+			return NewValidationErrorWithValue("rate_limit.rules", "rule "+rule.Name+" cannot set both PerRecipient and Per", rule.Per)
+		}
+		switch rule.Per {
+		case "", RateLimitPerRecipient, RateLimitPerDomain, RateLimitPerProvider, RateLimitPerTag:
+		default:
+			return NewValidationErrorWithValue("rate_limit.rules", "rule "+rule.Name+" has an unknown key", rule.Per)
+		}
+		names[rule.Name] = true
+	}
+	return nil
+}
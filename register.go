@@ -0,0 +1,41 @@
+package mailer
+
+import "sync"
+
+// ProviderFactory creates a provider from its settings.
+type ProviderFactory func(settings ProviderSettings) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[ProviderType]ProviderFactory)
+)
+
+// RegisterProvider makes a provider type available to clients, for
+// providers implemented outside the library, such as an in-house gateway or
+// a fake provider in tests. Registered types are configured like the
+// built-in ones, as the primary, fallback or a route. RegisterProvider
+// panics if factory is nil or the type is built in or already registered;
+// it is meant to be called from an init function.
+func RegisterProvider(providerType ProviderType, factory ProviderFactory) {
+	if factory == nil {
+		panic("mailer: RegisterProvider factory is nil")
+	}
+	if providerType.builtin() {
+		panic("mailer: RegisterProvider called for built-in provider type " + string(providerType))
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[providerType]; dup {
+		panic("mailer: RegisterProvider called twice for provider type " + string(providerType))
+	}
+	registry[providerType] = factory
+}
+
+// registeredProvider returns the factory of a registered provider type.
+func registeredProvider(providerType ProviderType) (ProviderFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[providerType]
+	return factory, ok
+}
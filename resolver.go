@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// TemplateResolver defines how template files are named and how SendTemplate
+// finds the subject, HTML and text parts of a template, so that projects can
+// keep their own file layout (e.g. "emails/otp/body.html.tmpl").
+type TemplateResolver interface {
+	// TemplateName returns the name a template file is registered under,
+	// given its slash-separated path relative to the template directory, or
+	// an empty string to skip the file.
+	TemplateName(path string) string
+
+	// PartName returns the registered name of a part of the template
+	// requested by SendTemplate. part is TemplateTypeSubject,
+	// TemplateTypeHTML or TemplateTypeText.
+	PartName(template, part string) string
+}
+
+// DefaultTemplateResolver is the resolver used when none is configured. Files
+// are named after their path without extension, with directories joined by
+// dots, and parts are found as "<template>.<part>": "otp.html.html" is
+// registered as "otp.html", the HTML part of the "otp" template.
+var DefaultTemplateResolver TemplateResolver = defaultTemplateResolver{}
+
+// defaultTemplateResolver implements the dot-joined naming convention.
+type defaultTemplateResolver struct{}
+
+// TemplateName strips the extension and joins directories with dots.
+func (defaultTemplateResolver) TemplateName(path string) string {
+	name := strings.TrimSuffix(path, filepath.Ext(path))
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// PartName returns "<template>.<part>".
+func (defaultTemplateResolver) PartName(template, part string) string {
+	return template + "." + part
+}
+
+// templateResolver returns the configured template resolver or the default one.
+func templateResolver(config TemplateConfig) TemplateResolver {
+	if config.Resolver != nil {
+		return config.Resolver
+	}
+	return DefaultTemplateResolver
+}
@@ -2,9 +2,8 @@ package mailer
 
 import (
 	"context"
-	"crypto/rand"
+	"fmt"
 	"math"
-	"math/big"
 	"sync"
 	"time"
 )
@@ -12,12 +11,16 @@ import (
 // RetryManager handles retry logic for failed operations.
 type RetryManager struct {
 	config RetryConfig
+	clock  Clock
+	rand   Rand
 }
 
 // NewRetryManager creates a new retry manager with the given configuration.
 func NewRetryManager(config RetryConfig) *RetryManager {
 	return &RetryManager{
 		config: config,
+		clock:  SystemClock,
+		rand:   SystemRand,
 	}
 }
 
@@ -28,6 +31,7 @@ func (r *RetryManager) Retry(ctx context.Context, fn func() error) error {
 	}
 
 	var lastErr error
+	retries := make(map[ErrorClass]int)
 	for attempt := 1; attempt <= r.config.MaxAttempts; attempt++ {
 		err := fn()
 		if err == nil {
@@ -46,8 +50,19 @@ func (r *RetryManager) Retry(ctx context.Context, fn func() error) error {
 			break
 		}
 
-		// Calculate delay for next attempt
-		delay := r.calculateDelay(attempt)
+		// Calculate delay for next attempt from the policy of the error's
+		// class, or the global backoff when it has none
+		class := ClassifyError(err)
+		retries[class]++
+		var delay time.Duration
+		if policy, ok := r.config.Policies[class]; ok {
+			if policy.MaxRetries < 0 || (policy.MaxRetries > 0 && retries[class] > policy.MaxRetries) {
+				break
+			}
+			delay = r.jitter(policy.delay(retries[class]))
+		} else {
+			delay = r.calculateDelay(attempt)
+		}
 
 		// Check if we should retry after rate limit
 		if retryAfter := GetRetryAfter(err); retryAfter > 0 {
@@ -55,10 +70,16 @@ func (r *RetryManager) Retry(ctx context.Context, fn func() error) error {
 		}
 
 		// Wait for the delay or context cancellation
+		if delay <= 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(delay):
+		case <-r.clock.After(delay):
 			// Continue to next attempt
 		}
 	}
@@ -76,17 +97,16 @@ func (r *RetryManager) calculateDelay(attempt int) time.Duration {
 		delay = r.config.MaxDelay
 	}
 
-	// Add jitter if enabled
+	return r.jitter(delay)
+}
+
+// jitter adds up to 10% random jitter to delay when enabled.
+func (r *RetryManager) jitter(delay time.Duration) time.Duration {
 	if r.config.Jitter {
-		// Add up to 10% jitter using cryptographically secure random
 		jitterRange := float64(delay) * 0.1
 		maxJitter := int64(jitterRange)
 		if maxJitter > 0 {
-			jitterBig, err := rand.Int(rand.Reader, big.NewInt(maxJitter))
-			if err == nil {
-				jitter := time.Duration(jitterBig.Int64())
-				delay += jitter
-			}
+			delay += time.Duration(r.rand.Int63n(maxJitter))
 		}
 	}
 
@@ -98,14 +118,55 @@ type RateLimiter struct {
 	config     RateLimitConfig
 	tokens     chan struct{}
 	lastRefill time.Time
+
+	// clock, when set, refills the bucket as tokens are taken instead of a
+	// background goroutine refilling it.
+	clock Clock
+
+	// acquireMu makes checking the reserve and taking tokens atomic, and
+	// guards the queue.
+	acquireMu sync.Mutex
+
+	// queue holds the sends waiting for tokens, highest priority first and
+	// in arrival order within a priority. Only the head of the queue takes
+	// tokens.
+	queue []*rateWaiter
+}
+
+// rateWaiter is a send waiting for tokens. Its turn channel is closed when
+// it reaches the head of the queue, and replaced when a send of higher
+// priority takes its place.
+type rateWaiter struct {
+	needed   int
+	priority Priority
+	turn     chan struct{}
 }
 
 // NewRateLimiter creates a new rate limiter with the given configuration.
 func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	rl := newRateLimiter(config, SystemClock)
+
+	// Start token refill goroutine
+	go rl.refillTokens()
+
+	return rl
+}
+
+// newLazyRateLimiter creates a rate limiter that refills its bucket for the
+// time elapsed on clock whenever tokens are taken, without a goroutine.
+func newLazyRateLimiter(config RateLimitConfig, clock Clock) *RateLimiter {
+	rl := newRateLimiter(config, clock)
+	rl.clock = clock
+	return rl
+}
+
+// newRateLimiter creates a rate limiter with a full bucket, last refilled at
+// the current time on clock.
+func newRateLimiter(config RateLimitConfig, clock Clock) *RateLimiter {
 	rl := &RateLimiter{
 		config:     config,
 		tokens:     make(chan struct{}, config.Burst),
-		lastRefill: time.Now(),
+		lastRefill: clock.Now(),
 	}
 
 	// Fill initial tokens
@@ -119,39 +180,256 @@ func NewRateLimiter(config RateLimitConfig) *RateLimiter {
 	}
 tokensFilled:
 
-	// Start token refill goroutine
-	go rl.refillTokens()
-
 	return rl
 }
 
-// Wait waits until the rate limit allows the operation to proceed.
+// Wait waits until the rate limit allows the operation to proceed. Without
+// a MaxWait or Block, it fails at once with a *RateLimitError when there
+// are not enough tokens. Otherwise sends wait for tokens by priority, and in
+// arrival order within a priority, each reserving its place behind the
+// tokens of the sends ahead of it. Waiting sends of lower priority do not
+// hold up a send.
+// They fail with a *RateLimitError when the expected wait exceeds MaxWait,
+// unless Block is set, and with an error matching context.DeadlineExceeded
+// when it would outlast ctx's deadline, rather than waiting for a token
+// they cannot use. A blocked send returns ctx's error when ctx is done.
 func (rl *RateLimiter) Wait(ctx context.Context, email *Email) error {
 	if !rl.config.Enabled {
 		return nil
 	}
 
-	// For per-recipient rate limiting, we need to account for all recipients
-	tokensNeeded := 1
-	if rl.config.PerRecipient {
-		tokensNeeded = email.TotalRecipients()
+	tokensNeeded := rl.tokensNeeded(email)
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Acquire the needed tokens
-	for i := 0; i < tokensNeeded; i++ {
+	rl.acquireMu.Lock()
+	if rl.clock != nil {
+		rl.refill()
+	}
+
+	// Leave the tokens reserved for higher priorities and those the sends
+	// queued ahead of this one wait for, or fail or queue without taking any
+	// when there are not enough
+	reserved := rl.reservedAbove(email.Priority)
+	if len(rl.tokens)-tokensNeeded >= reserved+rl.queuedAhead(email.Priority) {
+		rl.take(tokensNeeded)
+		rl.acquireMu.Unlock()
+		return nil
+	}
+
+	interval := rl.config.Period / time.Duration(rl.config.Rate)
+	waits := rl.config.Block || rl.config.MaxWait > 0
+	if !waits || tokensNeeded+reserved > rl.config.Burst {
+		rl.acquireMu.Unlock()
+		return NewRateLimitError("rate limit exceeded", interval)
+	}
+	wait := rl.expectedWait(tokensNeeded, reserved, email.Priority)
+	if !rl.config.Block && wait > rl.config.MaxWait {
+		rl.acquireMu.Unlock()
+		return NewRateLimitError("rate limit exceeded", wait)
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(clockOrDefault(rl.clock).Now()) < wait {
+		rl.acquireMu.Unlock()
+		return fmt.Errorf("rate limit wait of %v would exceed the deadline: %w", wait, context.DeadlineExceeded)
+	}
+
+	waiter := &rateWaiter{needed: tokensNeeded, priority: email.Priority, turn: make(chan struct{})}
+	rl.enqueue(waiter)
+	rl.acquireMu.Unlock()
+	defer rl.leave(waiter)
+
+	for {
+		rl.acquireMu.Lock()
+		if rl.clock != nil {
+			rl.refill()
+		}
+		head := rl.queue[0] == waiter
+		if head && len(rl.tokens)-tokensNeeded >= reserved {
+			rl.take(tokensNeeded)
+			rl.acquireMu.Unlock()
+			return nil
+		}
+		turn := waiter.turn
+		rl.acquireMu.Unlock()
+
+		// The head polls for tokens, the others wait for their turn
+		var ready <-chan struct{} = turn
+		var tick <-chan time.Time
+		if head {
+			ready, tick = nil, rl.after(interval)
+		}
 		select {
+		case <-ready:
+		case <-tick:
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-rl.tokens:
-			// Token acquired, continue
-		default:
-			// No tokens available, calculate retry after
-			retryAfter := rl.config.Period / time.Duration(rl.config.Rate)
-			return NewRateLimitError("rate limit exceeded", retryAfter)
 		}
 	}
+}
+
+// ExpectedWait estimates how long Wait would wait for the email's tokens,
+// behind the sends already waiting, e.g. to enqueue the email for later
+// rather than block on it. It is zero when the tokens are available.
+func (rl *RateLimiter) ExpectedWait(email *Email) time.Duration {
+	if !rl.config.Enabled {
+		return 0
+	}
+
+	rl.acquireMu.Lock()
+	defer rl.acquireMu.Unlock()
+
+	if rl.clock != nil {
+		rl.refill()
+	}
+	return rl.expectedWait(rl.tokensNeeded(email), rl.reservedAbove(email.Priority), email.Priority)
+}
+
+// tokensNeeded returns the number of tokens a send of email takes: one, or
+// one per recipient for per-recipient rate limiting.
+func (rl *RateLimiter) tokensNeeded(email *Email) int {
+	if rl.config.PerRecipient {
+		return email.TotalRecipients()
+	}
+	return 1
+}
+
+// expectedWait returns the time until the bucket holds the tokens needed by
+// a send of priority, above those reserved and those the sends queued ahead
+// of it wait for; rl.acquireMu must be held.
+func (rl *RateLimiter) expectedWait(needed, reserved int, priority Priority) time.Duration {
+	missing := needed + reserved + rl.queuedAhead(priority) - len(rl.tokens)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing) * (rl.config.Period / time.Duration(rl.config.Rate))
+}
+
+// take takes n tokens from the bucket; rl.acquireMu must be held and the
+// bucket must hold them.
+func (rl *RateLimiter) take(n int) {
+	for range n {
+		<-rl.tokens
+	}
+}
+
+// queuedAhead returns the number of tokens the sends queued ahead of a send
+// of priority wait for: those of its own priority or higher; rl.acquireMu
+// must be held.
+func (rl *RateLimiter) queuedAhead(priority Priority) int {
+	queued := 0
+	for _, waiter := range rl.queue {
+		if waiter.priority < priority {
+			break
+		}
+		queued += waiter.needed
+	}
+	return queued
+}
 
-	return nil
+// enqueue adds waiter to the queue behind the sends of its priority or
+// higher, giving it the turn when it becomes the head; rl.acquireMu must be
+// held.
+func (rl *RateLimiter) enqueue(waiter *rateWaiter) {
+	i := 0
+	for i < len(rl.queue) && rl.queue[i].priority >= waiter.priority {
+		i++
+	}
+	rl.queue = append(rl.queue, nil)
+	copy(rl.queue[i+1:], rl.queue[i:])
+	rl.queue[i] = waiter
+
+	if i == 0 {
+		// The previous head waits for its turn again
+		if len(rl.queue) > 1 {
+			rl.queue[1].turn = make(chan struct{})
+		}
+		close(waiter.turn)
+	}
+}
+
+// leave removes waiter from the queue, passing the turn to the next waiter
+// when it was at the head.
+func (rl *RateLimiter) leave(waiter *rateWaiter) {
+	rl.acquireMu.Lock()
+	defer rl.acquireMu.Unlock()
+
+	for i, queued := range rl.queue {
+		if queued != waiter {
+			continue
+		}
+		rl.queue = append(rl.queue[:i], rl.queue[i+1:]...)
+		if i == 0 && len(rl.queue) > 0 {
+			close(rl.queue[0].turn)
+		}
+		return
+	}
+}
+
+// after returns a channel receiving after d on the limiter's clock, or in
+// real time when the bucket is refilled by a goroutine.
+func (rl *RateLimiter) after(d time.Duration) <-chan time.Time {
+	if rl.clock != nil {
+		return rl.clock.After(d)
+	}
+	return time.After(d)
+}
+
+// available returns the number of tokens in the bucket.
+func (rl *RateLimiter) available() int {
+	rl.acquireMu.Lock()
+	defer rl.acquireMu.Unlock()
+
+	if rl.clock != nil {
+		rl.refill()
+	}
+	return len(rl.tokens)
+}
+
+// reservedAbove returns the number of tokens reserved for priorities higher
+// than priority.
+func (rl *RateLimiter) reservedAbove(priority Priority) int {
+	reserved := 0
+	for p, tokens := range rl.config.Reserved {
+		if p > priority {
+			reserved += tokens
+		}
+	}
+	return reserved
+}
+
+// refill adds the tokens due for the time elapsed since the last refill;
+// rl.acquireMu must be held.
+func (rl *RateLimiter) refill() {
+	interval := rl.config.Period / time.Duration(rl.config.Rate)
+	now := rl.clock.Now()
+	due := int(now.Sub(rl.lastRefill) / interval)
+	if due == 0 {
+		return
+	}
+	rl.lastRefill = rl.lastRefill.Add(time.Duration(due) * interval)
+	rl.add(due)
+}
+
+// credit adds the tokens due for d, time the refill goroutine missed while
+// the process was frozen. Lazily refilled buckets are already credited.
+func (rl *RateLimiter) credit(d time.Duration) {
+	if rl.clock != nil {
+		return
+	}
+	rl.add(int(d / (rl.config.Period / time.Duration(rl.config.Rate))))
+}
+
+// add adds up to n tokens to the bucket, stopping when it is full.
+func (rl *RateLimiter) add(n int) {
+	for range n {
+		select {
+		case rl.tokens <- struct{}{}:
+		default:
+			return
+		}
+	}
 }
 
 // refillTokens periodically refills the token bucket.
@@ -204,6 +482,7 @@ type CircuitBreaker struct {
 	failureCount int
 	successCount int
 	lastFailTime time.Time
+	clock        Clock
 	mutex        sync.RWMutex
 }
 
@@ -212,6 +491,7 @@ func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
 	return &CircuitBreaker{
 		config: config,
 		state:  CircuitBreakerClosed,
+		clock:  SystemClock,
 	}
 }
 
@@ -245,11 +525,11 @@ func (cb *CircuitBreaker) canExecute() bool {
 		return true
 	case CircuitBreakerOpen:
 		// Check if enough time has passed to try half-open
-		if time.Since(cb.lastFailTime) >= cb.config.Timeout {
+		if cb.clock.Now().Sub(cb.lastFailTime) >= cb.config.Timeout {
 			cb.mutex.RUnlock()
 			cb.mutex.Lock()
 			// Double check after acquiring write lock
-			if cb.state == CircuitBreakerOpen && time.Since(cb.lastFailTime) >= cb.config.Timeout {
+			if cb.state == CircuitBreakerOpen && cb.clock.Now().Sub(cb.lastFailTime) >= cb.config.Timeout {
 				cb.state = CircuitBreakerHalfOpen
 				cb.successCount = 0
 			}
@@ -265,6 +545,19 @@ func (cb *CircuitBreaker) canExecute() bool {
 	}
 }
 
+// allows reports whether the circuit breaker would let a call through
+// without changing its state: it is not open, or has been open long enough
+// to try recovering.
+func (cb *CircuitBreaker) allows() bool {
+	if !cb.config.Enabled {
+		return true
+	}
+
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.state != CircuitBreakerOpen || cb.clock.Now().Sub(cb.lastFailTime) >= cb.config.Timeout
+}
+
 // recordResult records the result of an operation.
 func (cb *CircuitBreaker) recordResult(err error) {
 	cb.mutex.Lock()
@@ -272,7 +565,7 @@ func (cb *CircuitBreaker) recordResult(err error) {
 
 	if err != nil {
 		cb.failureCount++
-		cb.lastFailTime = time.Now()
+		cb.lastFailTime = cb.clock.Now()
 
 		// Check if we should open the circuit
 		if cb.state == CircuitBreakerClosed && cb.failureCount >= cb.config.FailureThreshold {
@@ -291,7 +584,7 @@ func (cb *CircuitBreaker) recordResult(err error) {
 		}
 
 		// Reset failure count after successful operations in closed state
-		if cb.state == CircuitBreakerClosed && time.Since(cb.lastFailTime) >= cb.config.ResetTimeout {
+		if cb.state == CircuitBreakerClosed && cb.clock.Now().Sub(cb.lastFailTime) >= cb.config.ResetTimeout {
 			cb.failureCount = 0
 		}
 	}
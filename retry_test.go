@@ -0,0 +1,95 @@
+package mailer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lattiq/mailer"
+)
+
+func TestRateLimiterAdmitsUrgentSendsAheadOfQueuedLowSends(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	limiter := mailer.NewRateLimiter(mailer.RateLimitConfig{
+		Enabled: true,
+		Rate:    1,
+		Period:  interval,
+		Burst:   1,
+		Block:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	low := &mailer.Email{Priority: mailer.PriorityLow}
+	urgent := &mailer.Email{Priority: mailer.PriorityUrgent}
+
+	// Use up the bucket, then queue low priority sends behind it
+	if err := limiter.Wait(ctx, low); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	const queued = 3
+	admitted := make(chan mailer.Priority, queued+1)
+	for range queued {
+		go func() {
+			if err := limiter.Wait(ctx, low); err == nil {
+				admitted <- mailer.PriorityLow
+			}
+		}()
+	}
+	for limiter.ExpectedWait(low) < (queued+1)*interval {
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		if err := limiter.Wait(ctx, urgent); err == nil {
+			admitted <- mailer.PriorityUrgent
+		}
+	}()
+
+	if first := <-admitted; first != mailer.PriorityUrgent {
+		t.Fatalf("first admitted send has priority %v, want urgent", first)
+	}
+	for range queued {
+		if next := <-admitted; next != mailer.PriorityLow {
+			t.Fatalf("admitted send has priority %v, want low", next)
+		}
+	}
+}
+
+func TestRateLimiterAdmitsSendsOfOnePriorityInArrivalOrder(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	limiter := mailer.NewRateLimiter(mailer.RateLimitConfig{
+		Enabled: true,
+		Rate:    1,
+		Period:  interval,
+		Burst:   1,
+		Block:   true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := &mailer.Email{Priority: mailer.PriorityNormal}
+	if err := limiter.Wait(ctx, email); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// Queue the sends one at a time, so that their arrival order is known
+	const queued = 3
+	admitted := make(chan int, queued)
+	for i := range queued {
+		go func() {
+			if err := limiter.Wait(ctx, email); err == nil {
+				admitted <- i
+			}
+		}()
+		for limiter.ExpectedWait(email) < time.Duration(i+2)*interval {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for want := range queued {
+		if got := <-admitted; got != want {
+			t.Fatalf("admitted send %d, want %d", got, want)
+		}
+	}
+}
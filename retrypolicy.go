@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrorClass groups retryable errors that call for the same retry policy.
+type ErrorClass string
+
+const (
+	// ErrorClassThrottled is rate limiting by the provider or the client,
+	// which needs long waits that honor any Retry-After.
+	ErrorClassThrottled ErrorClass = "throttled"
+
+	// ErrorClassTimeout is a provider call that did not complete in time,
+	// which is usually worth retrying quickly.
+	ErrorClassTimeout ErrorClass = "timeout"
+
+	// ErrorClassConnectionReset is a connection closed by the peer or
+	// network, which a fresh connection usually fixes at once.
+	ErrorClassConnectionReset ErrorClass = "connection_reset"
+
+	// ErrorClassServer is a temporary server-side or transport failure.
+	ErrorClassServer ErrorClass = "server"
+
+	// ErrorClassOther is any other retryable error.
+	ErrorClassOther ErrorClass = "other"
+)
+
+// RetryPolicy is the backoff for retryable errors of a class. The delay
+// before the nth retry of the class is InitialDelay * Multiplier^(n-1),
+// capped at MaxDelay, or the error's Retry-After when it has one.
+type RetryPolicy struct {
+	// MaxRetries caps the retries of errors in the class. Zero leaves them
+	// capped only by RetryConfig.MaxAttempts; a negative value disables
+	// retries for the class.
+	MaxRetries int
+
+	// InitialDelay is the delay before the first retry; zero retries
+	// immediately.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the delay; zero leaves it uncapped.
+	MaxDelay time.Duration
+
+	// Multiplier grows the delay between retries (default: 1, a constant
+	// delay).
+	Multiplier float64
+}
+
+// DefaultRetryPolicies returns the retry policies of DefaultRetryConfig:
+// long waits for throttling, a quick retry for timeouts and a single
+// immediate retry for reset connections. Other classes use the global
+// backoff of RetryConfig.
+func DefaultRetryPolicies() map[ErrorClass]RetryPolicy {
+	return map[ErrorClass]RetryPolicy{
+		ErrorClassThrottled:       {InitialDelay: time.Second, MaxDelay: 30 * time.Second, Multiplier: 2},
+		ErrorClassTimeout:         {InitialDelay: 50 * time.Millisecond, Multiplier: 1},
+		ErrorClassConnectionReset: {MaxRetries: 1},
+	}
+}
+
+// ClassifyError returns the class of a retryable error, which selects its
+// retry policy.
+func ClassifyError(err error) ErrorClass {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrProviderTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF),
+		strings.Contains(err.Error(), "connection reset by peer"):
+		return ErrorClassConnectionReset
+	}
+
+	var rateLimitErr *RateLimitError
+	var providerErr *ProviderError
+	switch {
+	case errors.As(err, &rateLimitErr), errors.Is(err, ErrRateLimited), GetRetryAfter(err) > 0:
+		return ErrorClassThrottled
+	case errors.As(err, &providerErr):
+		// Providers report throttling as retryable but not temporary
+		switch {
+		case providerErr.StatusCode == 429, providerErr.IsRetryable && !providerErr.IsTemporary:
+			return ErrorClassThrottled
+		case providerErr.IsTemporary:
+			return ErrorClassServer
+		}
+	}
+	return ErrorClassOther
+}
+
+// delay returns the delay before the nth retry under the policy.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	delay := time.Duration(float64(p.InitialDelay) * math.Pow(multiplier, float64(retry-1)))
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// validateRetryPolicies checks the retry policies.
+func validateRetryPolicies(policies map[ErrorClass]RetryPolicy) error {
+	for class, policy := range policies {
+		field := "retry.policies." + string(class)
+		switch {
+		case policy.InitialDelay < 0:
+			return NewValidationErrorWithValue(field, "initial delay must not be negative", policy.InitialDelay)
+		case policy.MaxDelay < 0:
+			return NewValidationErrorWithValue(field, "max delay must not be negative", policy.MaxDelay)
+		case policy.Multiplier != 0 && policy.Multiplier < 1:
+			return NewValidationErrorWithValue(field, "multiplier must be at least 1.0", policy.Multiplier)
+		}
+	}
+	return nil
+}
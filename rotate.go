@@ -0,0 +1,196 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// providers returns the current primary and fallback providers.
+func (c *Client) providers() (primary, fallback Provider) {
+	c.providerMu.RLock()
+	defer c.providerMu.RUnlock()
+	return c.provider, c.fallback
+}
+
+// configuredProviders returns the primary provider, the fallback provider
+// if any, and the route providers, or nil before they are created.
+func (c *Client) configuredProviders() []Provider {
+	c.providerMu.RLock()
+	defer c.providerMu.RUnlock()
+
+	if c.provider == nil {
+		return nil
+	}
+	providers := []Provider{c.provider}
+	if c.fallback != nil {
+		providers = append(providers, c.fallback)
+	}
+	return append(providers, c.routes...)
+}
+
+// RotateProviderCredentials replaces the configured provider with the given
+// name by a new instance created from its current settings overlaid with
+// settings, e.g. a new "api_key". The new instance is health-checked before
+// it is swapped in: providers that support warming open a connection, which
+// validates the new credentials, and others validate their configuration.
+// Sends that started on the old instance are allowed to finish, until ctx is
+// done, after which the old instance is closed if it implements io.Closer.
+// Sends are not interrupted at any point.
+func (c *Client) RotateProviderCredentials(ctx context.Context, providerName string, settings ProviderSettings) error {
+	c.rotateMu.Lock()
+	defer c.rotateMu.Unlock()
+
+	if err := c.initProviders(); err != nil {
+		return err
+	}
+	primary, fallback := c.providers()
+
+	var old Provider
+	var oldSettings ProviderSettings
+	providerType := c.config.Provider.Type
+	route := -1
+	switch {
+	case primary.Name() == providerName:
+		old, oldSettings = primary, c.config.Provider.Primary
+	case fallback != nil && fallback.Name() == providerName:
+		old, oldSettings = fallback, *c.config.Provider.Fallback
+		providerType = ProviderType(oldSettings.Get("type"))
+	default:
+		c.providerMu.RLock()
+		for i, provider := range c.routes {
+			if provider.Name() == providerName {
+				route = i
+				old, oldSettings = provider, c.config.Provider.Routes[i].Settings
+				providerType = c.config.Provider.Routes[i].Type
+			}
+		}
+		c.providerMu.RUnlock()
+		if old == nil {
+			return NewValidationErrorWithValue("provider", "provider is not configured", providerName)
+		}
+	}
+
+	merged := make(ProviderSettings, len(oldSettings)+len(settings))
+	for key, value := range oldSettings {
+		merged[key] = value
+	}
+	for key, value := range settings {
+		merged[key] = value
+	}
+	if merged.Get("name") != oldSettings.Get("name") || merged.Get("type") != oldSettings.Get("type") {
+		return NewValidationError("settings", "rotation cannot change the provider's name or type")
+	}
+
+	replacement, err := createProvider(providerType, withBounceDomain(merged, c.config.BounceDomain))
+	if err != nil {
+		return fmt.Errorf("failed to create provider %s: %w", providerName, err)
+	}
+	if signer := c.config.Provider.RequestSigners[providerName]; signer != nil {
+		if err := applyRequestSigners(map[string]RequestSigner{providerName: signer}, replacement); err != nil {
+			return err
+		}
+	}
+	applyClock(c.clock, replacement)
+	if err := checkProvider(ctx, replacement); err != nil {
+		return fmt.Errorf("health check of rotated provider %s failed: %w", providerName, err)
+	}
+
+	c.providerMu.Lock()
+	switch {
+	case route >= 0:
+		routes := append([]Provider(nil), c.routes...)
+		routes[route] = replacement
+		c.routes = routes
+		c.config.Provider.Routes = append([]ProviderRoute(nil), c.config.Provider.Routes...)
+		c.config.Provider.Routes[route].Settings = merged
+	case old == c.provider:
+		c.provider = replacement
+		c.config.Provider.Primary = merged
+	default:
+		c.fallback = replacement
+		c.config.Provider.Fallback = &merged
+	}
+	c.providerMu.Unlock()
+
+	c.logger.Info("provider credentials rotated", "provider", providerName)
+
+	if err := c.inflight.drain(ctx, old); err != nil {
+		return fmt.Errorf("provider %s rotated, but in-flight sends did not drain: %w", providerName, err)
+	}
+	if closer, ok := old.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return fmt.Errorf("provider %s rotated, but closing the old instance failed: %w", providerName, err)
+		}
+	}
+
+	return nil
+}
+
+// checkProvider health-checks a provider with a single warm connection, or
+// by validating its configuration when it cannot be warmed.
+func checkProvider(ctx context.Context, provider Provider) error {
+	if warmer, ok := provider.(core.Warmer); ok {
+		return warmer.Warm(ctx, 1)
+	}
+	return provider.ValidateConfig()
+}
+
+// inflightSends counts the sends in progress on each provider instance, so
+// that a rotated-out instance can be drained before it is closed.
+type inflightSends struct {
+	mutex   sync.Mutex
+	counts  map[Provider]int
+	waiters map[Provider][]chan struct{}
+}
+
+// acquire records a send starting on provider and returns the function that
+// records its end.
+func (f *inflightSends) acquire(provider Provider) func() {
+	f.mutex.Lock()
+	if f.counts == nil {
+		f.counts = make(map[Provider]int)
+	}
+	f.counts[provider]++
+	f.mutex.Unlock()
+
+	return func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		f.counts[provider]--
+		if f.counts[provider] > 0 {
+			return
+		}
+		delete(f.counts, provider)
+		for _, waiter := range f.waiters[provider] {
+			close(waiter)
+		}
+		delete(f.waiters, provider)
+	}
+}
+
+// drain waits until no sends are in progress on provider or ctx is done.
+func (f *inflightSends) drain(ctx context.Context, provider Provider) error {
+	f.mutex.Lock()
+	if f.counts[provider] == 0 {
+		f.mutex.Unlock()
+		return nil
+	}
+	if f.waiters == nil {
+		f.waiters = make(map[Provider][]chan struct{})
+	}
+	done := make(chan struct{})
+	f.waiters[provider] = append(f.waiters[provider], done)
+	f.mutex.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
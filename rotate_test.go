@@ -0,0 +1,59 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+// TestRotationDuringPostMortems rotates credentials while failed sends
+// capture post-mortems of the provider settings, for the race detector.
+func TestRotationDuringPostMortems(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+	mock.SetError(errors.New("rejected"))
+	var captured bytes.Buffer
+
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithPostMortem(&captured),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := client.RotateProviderCredentials(context.Background(), "mock", mailer.ProviderSettings{"api_key": "rotated"}); err != nil {
+				t.Errorf("RotateProviderCredentials: %v", err)
+				return
+			}
+		}
+	}()
+	for range 200 {
+		if err := client.Send(context.Background(), validEmail()); err == nil {
+			t.Error("Send succeeded, want the injected error")
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if captured.Len() == 0 {
+		t.Error("no post-mortem was captured")
+	}
+}
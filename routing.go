@@ -0,0 +1,312 @@
+package mailer
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RoutingPolicy decides which of the primary provider and its routes each
+// send goes to.
+type RoutingPolicy string
+
+const (
+	// RoutingWeighted picks a provider at random, in proportion to the
+	// weights.
+	RoutingWeighted RoutingPolicy = "weighted"
+
+	// RoutingRoundRobin cycles through the providers, in proportion to the
+	// weights.
+	RoutingRoundRobin RoutingPolicy = "round_robin"
+
+	// RoutingLeastErrors picks the provider with the lowest rolling error
+	// rate among those with a weight, preferring earlier ones on ties.
+	RoutingLeastErrors RoutingPolicy = "least_errors"
+)
+
+// ProviderRoute is a provider that sends are split with, alongside the
+// primary provider, e.g. while migrating traffic between providers.
+type ProviderRoute struct {
+	// Type is the type of the provider.
+	Type ProviderType
+
+	// Settings contains the provider's settings.
+	Settings ProviderSettings
+
+	// Weight is the provider's share of sends, relative to the other
+	// routes and ProviderConfig.PrimaryWeight. Routes without a weight are
+	// only sent through when a routing rule selects them or a send is
+	// forced to them.
+	Weight int
+}
+
+// validateRoutes checks the routes and routing policy of the provider
+// configuration.
+func validateRoutes(config ProviderConfig) error {
+	switch config.Routing {
+	case "", RoutingWeighted, RoutingRoundRobin, RoutingLeastErrors:
+	default:
+		return NewValidationErrorWithValue("provider.routing", "routing policy must be empty, \"weighted\", \"round_robin\" or \"least_errors\"", config.Routing)
+	}
+
+	if len(config.Routes) == 0 {
+		return nil
+	}
+	if config.PrimaryWeight < 0 {
+		return NewValidationError("provider.primary_weight", "primary weight must not be negative")
+	}
+
+	for i, route := range config.Routes {
+		field := "provider.routes." + strconv.Itoa(i)
+		if !route.Type.Valid() {
+			return NewValidationErrorWithValue(field+".type", "invalid or unsupported provider type", route.Type)
+		}
+		if route.Weight < 0 {
+			return NewValidationError(field+".weight", "weight must not be negative")
+		}
+	}
+	return nil
+}
+
+// router holds the state of round-robin routing.
+type router struct {
+	mutex   sync.Mutex
+	current []int
+}
+
+// routed returns the primary provider followed by the route providers, with
+// their weights.
+func (c *Client) routed() ([]Provider, []int) {
+	c.providerMu.RLock()
+	defer c.providerMu.RUnlock()
+
+	providers := append([]Provider{c.provider}, c.routes...)
+	weights := []int{c.config.Provider.PrimaryWeight}
+	for _, route := range c.config.Provider.Routes {
+		weights = append(weights, route.Weight)
+	}
+	return providers, weights
+}
+
+// route returns the provider a send goes to according to the routing
+// policy: the primary provider when no routes are configured or none of the
+// providers has a weight. Providers that are unavailable, because their
+// circuit breaker is open or their error rate is over the failover
+// threshold, are skipped while others are not.
+func (c *Client) route() Provider {
+	providers, weights := c.routed()
+	if len(providers) == 1 {
+		return providers[0]
+	}
+
+	weighted := make([]int, 0, len(providers))
+	for i := range providers {
+		if weights[i] > 0 {
+			weighted = append(weighted, i)
+		}
+	}
+	if len(weighted) == 0 {
+		return providers[0]
+	}
+
+	candidates := make([]int, 0, len(weighted))
+	for _, i := range weighted {
+		if c.available(providers[i]) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = weighted
+	}
+
+	switch c.config.Provider.Routing {
+	case RoutingRoundRobin:
+		return providers[c.routing.next(candidates, weights)]
+	case RoutingLeastErrors:
+		best := candidates[0]
+		for _, i := range candidates[1:] {
+			if c.stats.provider(providers[i].Name()).ErrorRate < c.stats.provider(providers[best].Name()).ErrorRate {
+				best = i
+			}
+		}
+		return providers[best]
+	default:
+		total := 0
+		for _, i := range candidates {
+			total += weights[i]
+		}
+		pick := int(randOrDefault(c.config.Rand).Int63n(int64(total)))
+		for _, i := range candidates {
+			if pick < weights[i] {
+				return providers[i]
+			}
+			pick -= weights[i]
+		}
+		return providers[candidates[len(candidates)-1]]
+	}
+}
+
+// next returns the next of the candidate indexes with smooth weighted
+// round-robin, which spreads each provider's turns evenly over the cycle.
+func (r *router) next(candidates []int, weights []int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.current) != len(weights) {
+		r.current = make([]int, len(weights))
+	}
+
+	total := 0
+	best := candidates[0]
+	for _, i := range candidates {
+		r.current[i] += weights[i]
+		total += weights[i]
+		if r.current[i] > r.current[best] {
+			best = i
+		}
+	}
+	r.current[best] -= total
+	return best
+}
+
+// RoutingConfig selects the provider of each send by rules on the email,
+// e.g. to send to consumer mailbox providers through SES and to internal
+// domains through SMTP.
+type RoutingConfig struct {
+	// Rules are matched in order; the first rule matching an email selects
+	// the provider it is sent through.
+	Rules []RoutingRule
+
+	// Default names the provider of emails no rule matches. Empty routes
+	// them by ProviderConfig.Routing.
+	Default string
+}
+
+// RoutingRule selects a provider for the emails it matches. An email matches
+// when it meets every condition the rule sets.
+type RoutingRule struct {
+	// Provider is the name of the configured provider emails are sent
+	// through, e.g. "aws_ses" or the "name" setting of a route.
+	Provider string
+
+	// Domains matches emails whose recipients are all at one of the
+	// domains, compared case-insensitively.
+	Domains []string
+
+	// Header matches emails with the header, compared case-insensitively,
+	// whose value is HeaderValue, or any value when HeaderValue is empty.
+	Header      string
+	HeaderValue string
+
+	// Priorities matches emails of one of the priorities.
+	Priorities []Priority
+
+	// Category matches emails in the category.
+	Category string
+
+	// MetadataKey matches emails with the metadata key whose value is
+	// MetadataValue, or any value when MetadataValue is empty.
+	MetadataKey   string
+	MetadataValue string
+}
+
+// matches reports whether the rule applies to the email.
+func (r RoutingRule) matches(email *Email) bool {
+	if len(r.Domains) > 0 {
+		recipients := email.AllRecipients()
+		if len(recipients) == 0 {
+			return false
+		}
+		for _, recipient := range recipients {
+			domain := addressDomain(recipient.Email)
+			if !slices.ContainsFunc(r.Domains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+				return false
+			}
+		}
+	}
+	if r.Header != "" {
+		value, ok := headerValue(email.Headers, r.Header)
+		if !ok || (r.HeaderValue != "" && value != r.HeaderValue) {
+			return false
+		}
+	}
+	if len(r.Priorities) > 0 && !slices.Contains(r.Priorities, email.Priority) {
+		return false
+	}
+	if r.Category != "" && email.Category() != r.Category {
+		return false
+	}
+	if r.MetadataKey != "" {
+		value, ok := email.Metadata[r.MetadataKey]
+		if !ok || (r.MetadataValue != "" && value != r.MetadataValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValue returns the value of the named header, matching the name
+// case-insensitively.
+func headerValue(headers map[string]string, name string) (string, bool) {
+	if value, ok := headers[name]; ok {
+		return value, true
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// validateRoutingRules checks that every routing rule names a provider and
+// sets a condition. Provider names are checked once the providers exist.
+func validateRoutingRules(config RoutingConfig) error {
+	for i, rule := range config.Rules {
+		field := "routing.rules." + strconv.Itoa(i)
+		if rule.Provider == "" {
+			return NewValidationError(field+".provider", "provider is required")
+		}
+		if len(rule.Domains) == 0 && rule.Header == "" && len(rule.Priorities) == 0 && rule.Category == "" && rule.MetadataKey == "" {
+			return NewValidationError(field, "rule must match on a domain, header, priority, category or metadata key")
+		}
+	}
+	return nil
+}
+
+// checkRoutingProviders checks that the routing rules and default name
+// configured providers.
+func checkRoutingProviders(config RoutingConfig, names map[string]bool) error {
+	for i, rule := range config.Rules {
+		if !names[rule.Provider] {
+			return NewValidationErrorWithValue("routing.rules."+strconv.Itoa(i)+".provider", "provider is not configured", rule.Provider)
+		}
+	}
+	if config.Default != "" && !names[config.Default] {
+		return NewValidationErrorWithValue("routing.default", "provider is not configured", config.Default)
+	}
+	return nil
+}
+
+// ruleProvider returns the provider the routing rules select for the email,
+// or nil when no rule matches and there is no default.
+func (c *Client) ruleProvider(email *Email) Provider {
+	name := c.config.Routing.Default
+	for _, rule := range c.config.Routing.Rules {
+		if rule.matches(email) {
+			name = rule.Provider
+			break
+		}
+	}
+	if name == "" {
+		return nil
+	}
+
+	for _, provider := range c.configuredProviders() {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package mailer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func TestRoutingRulesSelectProvider(t *testing.T) {
+	tests := []struct {
+		name  string
+		email func() *mailer.Email
+		want  string
+	}{
+		{
+			name:  "no rule matches",
+			email: validEmail,
+			want:  "primary",
+		},
+		{
+			name: "domain of every recipient",
+			email: func() *mailer.Email {
+				email := validEmail()
+				email.To = []mailer.Address{{Email: "a@Partner.example"}, {Email: "b@partner.example"}}
+				return email
+			},
+			want: "secondary",
+		},
+		{
+			name: "domain of only some recipients",
+			email: func() *mailer.Email {
+				email := validEmail()
+				email.To = []mailer.Address{{Email: "a@partner.example"}, {Email: "b@example.com"}}
+				return email
+			},
+			want: "primary",
+		},
+		{
+			name: "header name compared case-insensitively",
+			email: func() *mailer.Email {
+				email := validEmail()
+				email.Headers = map[string]string{"x-route": "backup"}
+				return email
+			},
+			want: "backup",
+		},
+		{
+			name: "earlier rule wins",
+			email: func() *mailer.Email {
+				email := validEmail()
+				email.To = []mailer.Address{{Email: "a@partner.example"}}
+				email.Headers = map[string]string{"X-Route": "backup"}
+				return email
+			},
+			want: "secondary",
+		},
+		{
+			name: "priority",
+			email: func() *mailer.Email {
+				email := validEmail()
+				email.Priority = mailer.PriorityUrgent
+				return email
+			},
+			want: "backup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mocks := map[string]*mailertest.MockProvider{}
+			for _, name := range []string{"primary", "secondary", "backup"} {
+				mocks[name] = mailertest.NewMockProvider(name)
+				t.Cleanup(mocks[name].Close)
+			}
+
+			client, err := mailer.New(mailer.DefaultConfig(),
+				mailer.WithProvider(mailertest.ProviderType, mocks["primary"].Settings()),
+				mailer.WithProviderRoute(mailertest.ProviderType, 0, mocks["secondary"].Settings()),
+				mailer.WithProviderRoute(mailertest.ProviderType, 0, mocks["backup"].Settings()),
+				mailer.WithRoutingRule(mailer.RoutingRule{Provider: "secondary", Domains: []string{"partner.example"}}),
+				mailer.WithRoutingRule(mailer.RoutingRule{Provider: "backup", Header: "X-Route", HeaderValue: "backup"}),
+				mailer.WithRoutingRule(mailer.RoutingRule{Provider: "backup", Priorities: []mailer.Priority{mailer.PriorityUrgent}}),
+			)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			t.Cleanup(func() { client.Close() })
+
+			if err := client.Send(context.Background(), tt.email()); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			for name, mock := range mocks {
+				want := 0
+				if name == tt.want {
+					want = 1
+				}
+				if mock.Count() != want {
+					t.Errorf("%s received %d emails, want %d", name, mock.Count(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutingRulesRejectUnconfiguredProvider(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	_, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithRoutingRule(mailer.RoutingRule{Provider: "missing", Category: "billing"}),
+	)
+	assertValidationError(t, err)
+}
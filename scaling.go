@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Load is a point-in-time snapshot of the client's send load, for exposing
+// to autoscalers such as the Kubernetes Horizontal Pod Autoscaler or KEDA.
+type Load struct {
+	// InFlight is the number of Send and SendBatch calls in progress,
+	// including those waiting to retry.
+	InFlight int
+
+	// Rate is the number of emails handed to providers per second, averaged
+	// over the statistics window.
+	Rate float64
+
+	// Draining reports whether Drain has been called.
+	Draining bool
+}
+
+// Load returns the client's current send load.
+func (c *Client) Load() Load {
+	c.mu.RLock()
+	draining := c.draining
+	c.mu.RUnlock()
+
+	stats := c.Stats()
+	requests := 0
+	for _, provider := range stats.Providers {
+		requests += provider.Requests
+	}
+
+	load := Load{
+		InFlight: c.active.count(),
+		Draining: draining,
+	}
+	if stats.Window > 0 {
+		load.Rate = float64(requests) / stats.Window.Seconds()
+	}
+	return load
+}
+
+// Drain prepares the client for shutdown, e.g. from a Kubernetes preStop
+// hook: new sends fail with ErrDraining while Drain waits for those in
+// progress to finish. It returns ctx's error if they have not finished by
+// ctx's deadline. The client must still be closed afterwards.
+func (c *Client) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	c.logger.Info("draining client", "in_flight", c.active.count())
+	return c.active.wait(ctx)
+}
+
+// checkOpen returns ErrClientClosed or ErrDraining when the client no longer
+// accepts sends.
+func (c *Client) checkOpen() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.openErr()
+}
+
+// openErr implements checkOpen; c.mu must be held.
+func (c *Client) openErr() error {
+	switch {
+	case c.closed:
+		return ErrClientClosed
+	case c.draining:
+		return ErrDraining
+	default:
+		return nil
+	}
+}
+
+// begin records a send starting, as checkOpen allows, and returns the
+// function that records its end. The check and the count happen under the
+// same lock, so Drain sees every send it did not reject.
+func (c *Client) begin() (func(), error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := c.openErr(); err != nil {
+		return nil, err
+	}
+	return c.active.acquire(), nil
+}
+
+// activeSends counts the sends in progress on the client.
+type activeSends struct {
+	mutex   sync.Mutex
+	active  int
+	waiters []chan struct{}
+}
+
+// acquire records a send starting and returns the function that records its
+// end.
+func (a *activeSends) acquire() func() {
+	a.mutex.Lock()
+	a.active++
+	a.mutex.Unlock()
+
+	return func() {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+
+		a.active--
+		if a.active > 0 {
+			return
+		}
+		for _, waiter := range a.waiters {
+			close(waiter)
+		}
+		a.waiters = nil
+	}
+}
+
+// count returns the number of sends in progress.
+func (a *activeSends) count() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.active
+}
+
+// wait waits until no sends are in progress or ctx is done.
+func (a *activeSends) wait(ctx context.Context) error {
+	a.mutex.Lock()
+	if a.active == 0 {
+		a.mutex.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	a.waiters = append(a.waiters, done)
+	a.mutex.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// initProviders creates the primary and fallback providers, unless they
+// have been created already. Serverless clients create them on first use,
+// so that cold starts that send nothing do not pay for it.
+func (c *Client) initProviders() error {
+	c.providerMu.Lock()
+	defer c.providerMu.Unlock()
+
+	if c.provider != nil {
+		return nil
+	}
+
+	config := c.config
+	provider, err := createProvider(config.Provider.Type, withBounceDomain(config.Provider.Primary, config.BounceDomain))
+	if err != nil {
+		return fmt.Errorf("failed to create primary provider: %w", err)
+	}
+
+	// Initialize fallback provider if configured
+	var fallback Provider
+	if config.Provider.Fallback != nil {
+		fallbackType := ProviderType(config.Provider.Fallback.Get("type"))
+		if fallbackType != "" {
+			fallback, err = createProvider(fallbackType, withBounceDomain(*config.Provider.Fallback, config.BounceDomain))
+			if err != nil {
+				return fmt.Errorf("failed to create fallback provider: %w", err)
+			}
+
+			// Statistics and circuit breakers are keyed by provider name,
+			// so a fallback named like the primary without a "name" of its
+			// own, such as a second SES region, is named "<name>-fallback".
+			// The name is kept in its settings for rotation to reuse
+			if fallback.Name() == provider.Name() && config.Provider.Fallback.Get("name") == "" {
+				named := make(ProviderSettings, len(*config.Provider.Fallback)+1)
+				for key, value := range *config.Provider.Fallback {
+					named[key] = value
+				}
+				named.Set("name", provider.Name()+"-fallback")
+				fallback, err = createProvider(fallbackType, withBounceDomain(named, config.BounceDomain))
+				if err != nil {
+					return fmt.Errorf("failed to create fallback provider: %w", err)
+				}
+				c.config.Provider.Fallback = &named
+			}
+		}
+	}
+
+	// The fallback and the providers sends are routed to alongside the
+	// primary need names of their own for statistics and circuit breakers
+	names := map[string]bool{provider.Name(): true}
+	if fallback != nil {
+		if names[fallback.Name()] {
+			return NewValidationErrorWithValue("provider.fallback", "provider name is not unique, set a \"name\" setting", fallback.Name())
+		}
+		names[fallback.Name()] = true
+	}
+	routes := make([]Provider, len(config.Provider.Routes))
+	for i, route := range config.Provider.Routes {
+		routes[i], err = createProvider(route.Type, withBounceDomain(route.Settings, config.BounceDomain))
+		if err != nil {
+			return fmt.Errorf("failed to create route provider %s: %w", route.Type, err)
+		}
+		if names[routes[i].Name()] {
+			return NewValidationErrorWithValue("provider.routes", "provider name is not unique, set a \"name\" setting", routes[i].Name())
+		}
+		names[routes[i].Name()] = true
+	}
+	if err := checkRoutingProviders(config.Routing, names); err != nil {
+		return err
+	}
+
+	if err := applyRequestSigners(config.Provider.RequestSigners, append([]Provider{provider, fallback}, routes...)...); err != nil {
+		return err
+	}
+	applyClock(c.clock, append([]Provider{provider, fallback}, routes...)...)
+
+	c.provider, c.fallback, c.routes = provider, fallback, routes
+	return nil
+}
+
+// Freeze prepares the client for its process being frozen between
+// invocations, as AWS Lambda does once a handler returns. Idle provider
+// connections are closed, since peers drop them while the process is frozen
+// and the first send after thawing would otherwise fail on one. Call Thaw at
+// the start of the next invocation.
+func (c *Client) Freeze() {
+	c.mu.Lock()
+	c.frozenAt = c.clock.Now()
+	c.mu.Unlock()
+
+	for _, provider := range c.configuredProviders() {
+		if closer, ok := provider.(core.IdleCloser); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+// Thaw prepares a client frozen with Freeze for an invocation, crediting
+// the rate limiter with the tokens its refill goroutine missed while the
+// process was frozen. Serverless clients refill as they are used and need
+// no credit.
+func (c *Client) Thaw() {
+	c.mu.Lock()
+	frozenAt := c.frozenAt
+	c.frozenAt = time.Time{}
+	c.mu.Unlock()
+
+	if frozenAt.IsZero() {
+		return
+	}
+
+	frozen := c.clock.Now().Sub(frozenAt)
+	if c.rateLimiter != nil {
+		c.rateLimiter.credit(frozen)
+	}
+	c.logger.Debug("client thawed", "frozen_for", frozen)
+}
@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// applyRequestSigners sets each signer on the provider with its name. It
+// fails when no provider has a signer's name or the provider makes no HTTP
+// requests to sign, such as SMTP.
+func applyRequestSigners(signers map[string]RequestSigner, providers ...Provider) error {
+	for name, signer := range signers {
+		var target Provider
+		for _, provider := range providers {
+			if provider != nil && provider.Name() == name {
+				target = provider
+				break
+			}
+		}
+		if target == nil {
+			return NewValidationErrorWithValue("provider.request_signers", "provider is not configured", name)
+		}
+
+		signable, ok := target.(core.RequestSignable)
+		if !ok {
+			return NewValidationErrorWithValue("provider.request_signers", "provider does not make HTTP requests", name)
+		}
+		signable.SetRequestSigner(signer)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// SimulatorDomain is the domain of the library's simulated recipients. Emails
+// whose recipients are all at this domain are never handed to a provider:
+// the client simulates the outcome named by the recipient's local part, so
+// that success and failure paths can be tested end to end against any
+// configured provider. A "+tag" suffix is ignored, e.g. bounce+order42.
+const SimulatorDomain = "simulator.mailer"
+
+// Simulated recipients and the outcomes the client simulates for them.
+const (
+	// SimulateSuccess is accepted, as if by the provider.
+	SimulateSuccess = "success@" + SimulatorDomain
+
+	// SimulateBounce fails with a permanent provider error, as for a hard bounce.
+	SimulateBounce = "bounce@" + SimulatorDomain
+
+	// SimulateTemporaryFailure fails with a temporary provider error, which is
+	// retried and fails over.
+	SimulateTemporaryFailure = "temporary@" + SimulatorDomain
+
+	// SimulateRateLimit fails with a retryable provider error, as for an HTTP
+	// 429 response.
+	SimulateRateLimit = "ratelimit@" + SimulatorDomain
+)
+
+// sesSimulatorDomain is the domain of the SES mailbox simulator, whose
+// addresses SES accepts even in the sandbox and answers with a simulated
+// delivery, bounce, complaint or out-of-office reply.
+const sesSimulatorDomain = "simulator.amazonses.com"
+
+// IsSimulatorAddress reports whether address is a simulated recipient, either
+// of the library (SimulatorDomain) or of the SES mailbox simulator. Sending to
+// simulated recipients never reaches a real mailbox.
+func IsSimulatorAddress(address string) bool {
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	return domain == SimulatorDomain || domain == sesSimulatorDomain
+}
+
+// simulatedOutcome returns the library simulator address whose outcome is
+// simulated for email, preferring failures over success. It reports false
+// unless every recipient of the email is at SimulatorDomain.
+func simulatedOutcome(email *Email) (string, bool) {
+	outcome := ""
+	for _, list := range [][]Address{email.To, email.CC, email.BCC} {
+		for _, recipient := range list {
+			local, domain, _ := strings.Cut(recipient.Email, "@")
+			if !strings.EqualFold(domain, SimulatorDomain) {
+				return "", false
+			}
+			local, _, _ = strings.Cut(strings.ToLower(local), "+")
+			if outcome == "" || outcome == SimulateSuccess {
+				outcome = local + "@" + SimulatorDomain
+			}
+		}
+	}
+	return outcome, outcome != ""
+}
+
+// simulate returns the simulated result of sending to outcome with provider.
+func (c *Client) simulate(outcome string, provider Provider) (*SendResult, error) {
+	name := provider.Name()
+
+	switch outcome {
+	case SimulateSuccess:
+		now := c.clock.Now()
+		return &SendResult{
+			MessageID: fmt.Sprintf("simulated-%d", now.UnixNano()),
+			Provider:  name,
+			Timestamp: now,
+			Metadata:  map[string]interface{}{"simulated": true},
+		}, nil
+	case SimulateBounce:
+		return nil, core.NewProviderError(name, "simulated_bounce", "simulated hard bounce")
+	case SimulateTemporaryFailure:
+		return nil, core.NewTemporaryProviderError(name, "simulated_temporary_failure", "simulated temporary failure")
+	case SimulateRateLimit:
+		err := core.NewRetryableProviderError(name, "simulated_rate_limit", "simulated rate limit")
+		err.StatusCode = 429
+		return nil, err
+	default:
+		return nil, NewValidationErrorWithValue("to", "unknown simulated outcome", outcome)
+	}
+}
+
+// sendBatchSimulated sends the emails of a batch that are not simulated with
+// provider, simulating the outcome of the others, and merges the results.
+func (c *Client) sendBatchSimulated(ctx context.Context, emails []*Email, provider Provider) (*BatchResult, error) {
+	result := &BatchResult{
+		Total:    len(emails),
+		Provider: provider.Name(),
+	}
+
+	var sent []*Email
+	var indexes []int
+	for i, email := range emails {
+		outcome, ok := simulatedOutcome(email)
+		if !ok {
+			sent = append(sent, email)
+			indexes = append(indexes, i)
+			continue
+		}
+
+		sendResult, err := c.simulate(outcome, provider)
+		if err != nil {
+			result.Failed = append(result.Failed, BatchFailure{Index: i, Email: email, Error: err})
+		} else {
+			sendResult.CorrelationID = email.CorrelationID()
+			result.Successful = append(result.Successful, sendResult)
+		}
+	}
+
+	switch len(sent) {
+	case 0:
+		return result, nil
+	case len(emails):
+		return provider.SendBatch(ctx, emails)
+	}
+
+	sentResult, err := provider.SendBatch(ctx, sent)
+	if err != nil {
+		return nil, err
+	}
+	result.Successful = append(result.Successful, sentResult.Successful...)
+	for _, failure := range sentResult.Failed {
+		failure.Index = indexes[failure.Index]
+		result.Failed = append(result.Failed, failure)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LatencySLO is the latency objective for emails of a given priority.
+type LatencySLO struct {
+	// Dispatch is the maximum time from Send being called to the provider
+	// accepting the email, including rate limiting.
+	Dispatch time.Duration
+
+	// FailFast rejects a send with ErrSLOBreach when no configured provider is
+	// expected to meet the objective, instead of attempting it anyway.
+	FailFast bool
+}
+
+// SLOStats counts latency SLO outcomes for a priority since the client was
+// created.
+type SLOStats struct {
+	// Sends is the number of sends the objective applied to.
+	Sends int
+
+	// Breaches is the number of sends that finished after the objective or
+	// were rejected with ErrSLOBreach.
+	Breaches int
+
+	// Rerouted is the number of sends moved to their failover provider
+	// because the provider they were routed to was expected to miss the
+	// objective.
+	Rerouted int
+
+	// FailedFast is the number of sends rejected with ErrSLOBreach.
+	FailedFast int
+}
+
+// sloTracker counts SLO outcomes per priority.
+type sloTracker struct {
+	mutex  sync.Mutex
+	counts map[Priority]*SLOStats
+}
+
+// update applies fn to the counts for priority.
+func (t *sloTracker) update(priority Priority, fn func(stats *SLOStats)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.counts == nil {
+		t.counts = make(map[Priority]*SLOStats)
+	}
+	stats, ok := t.counts[priority]
+	if !ok {
+		stats = &SLOStats{}
+		t.counts[priority] = stats
+	}
+	fn(stats)
+}
+
+// snapshot returns a copy of the counts.
+func (t *sloTracker) snapshot() map[Priority]SLOStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	counts := make(map[Priority]SLOStats, len(t.counts))
+	for priority, stats := range t.counts {
+		counts[priority] = *stats
+	}
+	return counts
+}
+
+// expectedLatency is the provider's recent 95th percentile latency, or zero
+// while there are too few samples to predict it.
+func (c *Client) expectedLatency(provider Provider) time.Duration {
+	stats := c.stats.provider(provider.Name())
+	if stats.Requests < minFailoverSamples {
+		return 0
+	}
+	return stats.LatencyP95
+}
+
+// routeForSLO decides how a send started at start can meet its priority's
+// latency SLO, given the provider it would be sent through first and the one
+// it would fail over to. It returns the failover provider when only that one
+// is expected to dispatch within the remaining budget, and ErrSLOBreach when
+// neither is and the SLO fails fast. A nil provider and error leave routing
+// unchanged.
+func (c *Client) routeForSLO(email *Email, slo LatencySLO, start time.Time, first, second Provider) (Provider, error) {
+	remaining := slo.Dispatch - c.clock.Now().Sub(start)
+	expected := c.expectedLatency(first)
+	if remaining > 0 && expected <= remaining {
+		return nil, nil
+	}
+
+	if second != nil && remaining > 0 && c.expectedLatency(second) <= remaining {
+		c.slo.update(email.Priority, func(stats *SLOStats) { stats.Rerouted++ })
+		return second, nil
+	}
+
+	if !slo.FailFast {
+		return nil, nil
+	}
+
+	c.slo.update(email.Priority, func(stats *SLOStats) {
+		stats.Breaches++
+		stats.FailedFast++
+	})
+	return nil, fmt.Errorf("%w: %s priority must dispatch within %v, %v remaining and %s expects %v",
+		ErrSLOBreach, email.Priority, slo.Dispatch, max(remaining, 0), first.Name(), expected)
+}
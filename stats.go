@@ -0,0 +1,276 @@
+package mailer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultStatsWindow is the rolling window used when none is configured.
+const defaultStatsWindow = 5 * time.Minute
+
+// maxStatsSamples bounds the number of samples kept per provider so that
+// memory use stays constant regardless of send volume.
+const maxStatsSamples = 4096
+
+// Stats is a point-in-time snapshot of the client's send statistics.
+type Stats struct {
+	// Window is the rolling window the statistics are computed over.
+	Window time.Duration
+
+	// Providers contains statistics for each provider, keyed by provider name.
+	Providers map[string]ProviderStats
+
+	// SLO contains latency SLO outcomes for each priority with an objective.
+	// Unlike the provider statistics, these are totals since the client was
+	// created.
+	SLO map[Priority]SLOStats
+
+	// MXCache contains the counters of the MX record caches of providers
+	// that cache them, such as SMTP, keyed by provider name. Like the SLO
+	// outcomes, these are totals, since each provider was created.
+	MXCache map[string]MXCacheStats
+}
+
+// ProviderStats contains rolling-window statistics for a single provider.
+type ProviderStats struct {
+	// Provider is the name of the provider.
+	Provider string
+
+	// Requests is the number of emails handed to the provider within the window.
+	Requests int
+
+	// Errors is the number of those emails that failed.
+	Errors int
+
+	// ErrorRate is Errors divided by Requests (0 when there were no requests).
+	ErrorRate float64
+
+	// LatencyP50 is the median provider latency within the window.
+	LatencyP50 time.Duration
+
+	// LatencyP95 is the 95th percentile provider latency within the window.
+	LatencyP95 time.Duration
+}
+
+// StatsFormat is the output format of ExportStats.
+type StatsFormat string
+
+const (
+	// StatsFormatCSV writes one CSV row per provider, preceded by a header row.
+	StatsFormatCSV StatsFormat = "csv"
+
+	// StatsFormatJSON writes a single JSON document.
+	StatsFormatJSON StatsFormat = "json"
+)
+
+// statsExport is the JSON representation written by ExportStats.
+type statsExport struct {
+	GeneratedAt time.Time             `json:"generated_at"`
+	Window      string                `json:"window"`
+	Providers   []providerStatsExport `json:"providers"`
+}
+
+// providerStatsExport is the JSON representation of a provider's statistics.
+type providerStatsExport struct {
+	Provider     string  `json:"provider"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyP50MS int64   `json:"latency_p50_ms"`
+	LatencyP95MS int64   `json:"latency_p95_ms"`
+}
+
+// statsCSVHeader is the header row written for StatsFormatCSV.
+var statsCSVHeader = []string{"provider", "requests", "errors", "error_rate", "latency_p50_ms", "latency_p95_ms"}
+
+// ExportStats writes the client's per-provider statistics to w in the given
+// format, for reporting without a metrics pipeline. Statistics cover the
+// rolling window configured with WithStatsWindow.
+func (c *Client) ExportStats(ctx context.Context, w io.Writer, format StatsFormat) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	stats := c.Stats()
+	rows := providerStatsRows(stats)
+
+	switch format {
+	case StatsFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statsExport{
+			GeneratedAt: c.clock.Now().UTC(),
+			Window:      stats.Window.String(),
+			Providers:   rows,
+		})
+	case StatsFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(statsCSVHeader); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := []string{
+				row.Provider,
+				strconv.Itoa(row.Requests),
+				strconv.Itoa(row.Errors),
+				strconv.FormatFloat(row.ErrorRate, 'f', 4, 64),
+				strconv.FormatInt(row.LatencyP50MS, 10),
+				strconv.FormatInt(row.LatencyP95MS, 10),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return NewValidationErrorWithValue("format", "unsupported stats format", string(format))
+	}
+}
+
+// providerStatsRows returns the export rows of the provider statistics,
+// sorted by provider name.
+func providerStatsRows(stats Stats) []providerStatsExport {
+	names := make([]string, 0, len(stats.Providers))
+	for name := range stats.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := make([]providerStatsExport, len(names))
+	for i, name := range names {
+		ps := stats.Providers[name]
+		rows[i] = providerStatsExport{
+			Provider:     ps.Provider,
+			Requests:     ps.Requests,
+			Errors:       ps.Errors,
+			ErrorRate:    ps.ErrorRate,
+			LatencyP50MS: ps.LatencyP50.Milliseconds(),
+			LatencyP95MS: ps.LatencyP95.Milliseconds(),
+		}
+	}
+	return rows
+}
+
+// statsSample records the outcome of a single provider call.
+type statsSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// rollingStats tracks provider samples over a sliding time window.
+type rollingStats struct {
+	window  time.Duration
+	clock   Clock
+	samples map[string][]statsSample
+	mutex   sync.Mutex
+}
+
+// newRollingStats creates a tracker for the given window.
+func newRollingStats(window time.Duration, clock Clock) *rollingStats {
+	if window <= 0 {
+		window = defaultStatsWindow
+	}
+	return &rollingStats{
+		window:  window,
+		clock:   clockOrDefault(clock),
+		samples: make(map[string][]statsSample),
+	}
+}
+
+// record adds a sample for the named provider.
+func (rs *rollingStats) record(provider string, latency time.Duration, failed bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	now := rs.clock.Now()
+	samples := rs.prune(rs.samples[provider], now)
+	if len(samples) >= maxStatsSamples {
+		samples = samples[1:]
+	}
+	rs.samples[provider] = append(samples, statsSample{at: now, latency: latency, failed: failed})
+}
+
+// prune drops samples that fell out of the window.
+func (rs *rollingStats) prune(samples []statsSample, now time.Time) []statsSample {
+	cutoff := now.Add(-rs.window)
+	i := 0
+	for i < len(samples) && samples[i].at.Before(cutoff) {
+		i++
+	}
+	return samples[i:]
+}
+
+// provider returns the statistics for a single provider.
+func (rs *rollingStats) provider(name string) ProviderStats {
+	rs.mutex.Lock()
+	samples := rs.prune(rs.samples[name], rs.clock.Now())
+	rs.samples[name] = samples
+	latencies := make([]time.Duration, len(samples))
+	errs := 0
+	for i, sample := range samples {
+		latencies[i] = sample.latency
+		if sample.failed {
+			errs++
+		}
+	}
+	rs.mutex.Unlock()
+
+	stats := ProviderStats{
+		Provider: name,
+		Requests: len(samples),
+		Errors:   errs,
+	}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.ErrorRate = float64(errs) / float64(len(samples))
+	stats.LatencyP50 = percentile(latencies, 0.50)
+	stats.LatencyP95 = percentile(latencies, 0.95)
+
+	return stats
+}
+
+// snapshot returns the statistics for every provider that has samples.
+func (rs *rollingStats) snapshot() Stats {
+	rs.mutex.Lock()
+	names := make([]string, 0, len(rs.samples))
+	for name := range rs.samples {
+		names = append(names, name)
+	}
+	rs.mutex.Unlock()
+
+	stats := Stats{
+		Window:    rs.window,
+		Providers: make(map[string]ProviderStats, len(names)),
+	}
+	for _, name := range names {
+		stats.Providers[name] = rs.provider(name)
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of sorted values using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -1,11 +1,16 @@
 package mailer
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	textTemplate "text/template"
@@ -20,24 +25,67 @@ type TemplateEngineImpl struct {
 	config        TemplateConfig
 	htmlTemplates map[string]*template.Template
 	textTemplates map[string]*textTemplate.Template
+	info          map[string]TemplateInfo
+	defaults      map[string]TemplateDefaults
+	translations  translations
+	localizedHTML map[string]map[string]*template.Template
+	localizedText map[string]map[string]*textTemplate.Template
+	assets        map[string]string
+	skippedMJML   []string
+	logger        *slog.Logger
+	clock         Clock
 	mutex         sync.RWMutex
 }
 
 // NewTemplateEngine creates a new template engine with the given configuration.
 func NewTemplateEngine(config TemplateConfig) (TemplateEngine, error) {
+	return newTemplateEngine(config, nil, SystemClock)
+}
+
+// newTemplateEngine creates a template engine reporting text part check
+// warnings to logger, if not nil, and recording parse times on clock.
+func newTemplateEngine(config TemplateConfig, logger *slog.Logger, clock Clock) (*TemplateEngineImpl, error) {
 	engine := &TemplateEngineImpl{
 		config:        config,
 		htmlTemplates: make(map[string]*template.Template),
 		textTemplates: make(map[string]*textTemplate.Template),
+		info:          make(map[string]TemplateInfo),
+		defaults:      make(map[string]TemplateDefaults),
+		localizedHTML: make(map[string]map[string]*template.Template),
+		localizedText: make(map[string]map[string]*textTemplate.Template),
+		assets:        make(map[string]string),
+		logger:        logger,
+		clock:         clock,
+	}
+
+	// Load translations before templates, which are localized into each
+	// catalog's locale as they are registered
+	if config.Translations != "" {
+		catalogs, err := loadTranslations(engine.dirFS(config.Translations))
+		if err != nil {
+			return nil, err
+		}
+		engine.translations = catalogs
 	}
 
-	// Load templates from directory if specified
-	if config.Directory != "" {
-		if err := engine.LoadTemplatesFromDir(config.Directory); err != nil {
+	// Publish assets first so templates can reference them with the asset function
+	if config.Assets.Directory != "" {
+		if err := engine.loadAssets(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to load template assets: %w", err)
+		}
+	}
+
+	// Load templates from the directory or file system if specified
+	if config.Directory != "" || config.FS != nil {
+		if err := engine.loadTemplates(engine.dirFS(config.Directory)); err != nil {
 			return nil, fmt.Errorf("failed to load templates from directory: %w", err)
 		}
 	}
 
+	if err := engine.checkTextParts(); err != nil {
+		return nil, err
+	}
+
 	return engine, nil
 }
 
@@ -69,35 +117,163 @@ func (te *TemplateEngineImpl) Render(templateName string, data interface{}) (str
 
 // RegisterTemplate registers a template with the given name and content.
 func (te *TemplateEngineImpl) RegisterTemplate(name string, content string) error {
+	return te.registerTemplate(name, content, "")
+}
+
+// registerTemplate registers a template, recording source as the file it was
+// loaded from.
+func (te *TemplateEngineImpl) registerTemplate(name, content, source string) error {
 	te.mutex.Lock()
 	defer te.mutex.Unlock()
 
+	missingKey := "missingkey=default"
+	if te.config.StrictMissingKeys {
+		missingKey = "missingkey=error"
+	}
+
 	// Determine template type from name or content
 	if strings.Contains(name, ".html") || strings.Contains(content, "<") {
 		// HTML template
-		tmpl, err := template.New(name).Funcs(te.getTemplateFuncs()).Parse(content)
+		tmpl, err := template.New(name).Option(missingKey).Funcs(te.getTemplateFuncs()).Parse(content)
 		if err != nil {
 			return NewTemplateError(name, "parse", "failed to parse HTML template", err)
 		}
+		localized, err := te.localizeHTML(tmpl)
+		if err != nil {
+			return NewTemplateError(name, "parse", "failed to localize HTML template", err)
+		}
 		te.htmlTemplates[name] = tmpl
+		te.localizedHTML[name] = localized
+		te.info[name] = newTemplateInfo(name, TemplateTypeHTML, content, source, tmpl.Tree, te.clock.Now())
 	} else {
 		// Text template
-		tmpl, err := textTemplate.New(name).Funcs(te.getTextTemplateFuncs()).Parse(content)
+		tmpl, err := textTemplate.New(name).Option(missingKey).Funcs(te.getTextTemplateFuncs()).Parse(content)
 		if err != nil {
 			return NewTemplateError(name, "parse", "failed to parse text template", err)
 		}
+		localized, err := te.localizeText(tmpl)
+		if err != nil {
+			return NewTemplateError(name, "parse", "failed to localize text template", err)
+		}
 		te.textTemplates[name] = tmpl
+		te.localizedText[name] = localized
+		te.info[name] = newTemplateInfo(name, TemplateTypeText, content, source, tmpl.Tree, te.clock.Now())
+	}
+
+	return nil
+}
+
+// Names returns the sorted names of all registered templates.
+func (te *TemplateEngineImpl) Names() []string {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	names := make([]string, 0, len(te.htmlTemplates)+len(te.textTemplates))
+	for name := range te.htmlTemplates {
+		names = append(names, name)
+	}
+	for name := range te.textTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Purge removes all registered templates.
+func (te *TemplateEngineImpl) Purge() {
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+
+	te.htmlTemplates = make(map[string]*template.Template)
+	te.textTemplates = make(map[string]*textTemplate.Template)
+	te.info = make(map[string]TemplateInfo)
+	te.defaults = make(map[string]TemplateDefaults)
+	te.localizedHTML = make(map[string]map[string]*template.Template)
+	te.localizedText = make(map[string]map[string]*textTemplate.Template)
+}
+
+// Reload replaces all registered templates with those in the configured
+// directory. The templates are loaded before any are replaced, so on error
+// the previously registered templates are kept, including when the new ones
+// fail the text part check.
+func (te *TemplateEngineImpl) Reload() error {
+	fresh := &TemplateEngineImpl{
+		config:        te.config,
+		htmlTemplates: make(map[string]*template.Template),
+		textTemplates: make(map[string]*textTemplate.Template),
+		info:          make(map[string]TemplateInfo),
+		defaults:      make(map[string]TemplateDefaults),
+		localizedHTML: make(map[string]map[string]*template.Template),
+		localizedText: make(map[string]map[string]*textTemplate.Template),
+		assets:        te.assets,
+		logger:        te.logger,
+		clock:         te.clock,
+	}
+
+	if te.config.Translations != "" {
+		catalogs, err := loadTranslations(fresh.dirFS(te.config.Translations))
+		if err != nil {
+			return fmt.Errorf("failed to reload templates: %w", err)
+		}
+		fresh.translations = catalogs
 	}
 
+	if te.config.Directory != "" || te.config.FS != nil {
+		if err := fresh.loadTemplates(fresh.dirFS(te.config.Directory)); err != nil {
+			return fmt.Errorf("failed to reload templates: %w", err)
+		}
+	}
+
+	if err := fresh.checkTextParts(); err != nil {
+		return fmt.Errorf("failed to reload templates: %w", err)
+	}
+
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+
+	te.htmlTemplates = fresh.htmlTemplates
+	te.textTemplates = fresh.textTemplates
+	te.info = fresh.info
+	te.defaults = fresh.defaults
+	te.translations = fresh.translations
+	te.localizedHTML = fresh.localizedHTML
+	te.localizedText = fresh.localizedText
+	te.skippedMJML = fresh.skippedMJML
+
 	return nil
 }
 
-// LoadTemplatesFromDir loads all templates from the specified directory.
+// LoadTemplatesFromDir loads all templates from the specified directory,
+// along with the defaults of the template manifest at its root, if any.
+// Files with the ".subject" extension are loaded as subject parts, and those
+// with the ".mjml" extension are compiled to HTML templates. The configured
+// text part check is then run over all registered templates.
 func (te *TemplateEngineImpl) LoadTemplatesFromDir(dir string) error {
-	// Clean and validate the directory path
 	cleanDir := filepath.Clean(dir)
+	if err := te.loadTemplates(os.DirFS(cleanDir), ".", diskSource(cleanDir)); err != nil {
+		return err
+	}
+	return te.checkTextParts()
+}
 
-	return filepath.WalkDir(cleanDir, func(path string, d fs.DirEntry, err error) error {
+// LoadTemplatesFromFS loads all templates under root in fsys, such as an
+// embed.FS compiled into the binary, like LoadTemplatesFromDir.
+func (te *TemplateEngineImpl) LoadTemplatesFromFS(fsys fs.FS, root string) error {
+	if err := te.loadTemplates(fsys, fsRoot(root), fsSource); err != nil {
+		return err
+	}
+	return te.checkTextParts()
+}
+
+// loadTemplates loads the templates and manifest under root in fsys,
+// recording source(path) as the source of the file at path.
+func (te *TemplateEngineImpl) loadTemplates(fsys fs.FS, root string, source func(string) string) error {
+	if err := te.loadManifest(fsys, root, source); err != nil {
+		return err
+	}
+
+	return fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -106,15 +282,9 @@ func (te *TemplateEngineImpl) LoadTemplatesFromDir(dir string) error {
 			return nil
 		}
 
-		// Security: Validate that the path is within the specified directory
-		cleanPath := filepath.Clean(path)
-		if !isPathWithinDir(cleanPath, cleanDir) {
-			return fmt.Errorf("security error: path traversal detected: %s", path)
-		}
-
 		// Check if file has a valid template extension
-		ext := filepath.Ext(path)
-		validExt := false
+		ext := path.Ext(name)
+		validExt := ext == subjectExtension || ext == mjmlExtension
 		for _, validExtension := range te.config.Extension {
 			if ext == validExtension {
 				validExt = true
@@ -126,26 +296,47 @@ func (te *TemplateEngineImpl) LoadTemplatesFromDir(dir string) error {
 			return nil
 		}
 
-		// Read template file with validated path
-		content, err := os.ReadFile(cleanPath)
+		// File systems only accept paths within themselves, so the file
+		// cannot be outside root
+		content, err := fs.ReadFile(fsys, name)
 		if err != nil {
-			return fmt.Errorf("failed to read template file %s: %w", cleanPath, err)
+			return fmt.Errorf("failed to read template file %s: %w", source(name), err)
 		}
 
-		// Generate template name from file path
-		relativePath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+		// Name the template with the configured naming scheme, from its
+		// path relative to root
+		relativePath := name
+		if root != "." {
+			relativePath = strings.TrimPrefix(name, root+"/")
+		}
+		resolver := templateResolver(te.config)
+		templateName := resolver.TemplateName(relativePath)
+		if templateName == "" {
+			return nil
 		}
 
-		// Remove extension from template name
-		templateName := strings.TrimSuffix(relativePath, ext)
+		// Subject files are single lines, whatever the file's line ending
+		if ext == subjectExtension {
+			templateName = resolver.PartName(templateName, TemplateTypeSubject)
+			content = bytes.TrimRight(content, "\r\n")
+		}
 
-		// Replace path separators with dots for hierarchical templates
-		templateName = strings.ReplaceAll(templateName, string(filepath.Separator), ".")
+		// MJML files are compiled to HTML before they are parsed, and are
+		// skipped without a compiler so that an HTML file of the same name
+		// can stand in for them
+		if ext == mjmlExtension {
+			html, ok, err := te.compileMJML(templateName, string(content))
+			if err != nil {
+				return fmt.Errorf("failed to compile template %s: %w", source(name), err)
+			}
+			if !ok {
+				return nil
+			}
+			content = []byte(html)
+		}
 
 		// Register the template
-		if err := te.RegisterTemplate(templateName, string(content)); err != nil {
+		if err := te.registerTemplate(templateName, string(content), source(name)); err != nil {
 			return fmt.Errorf("failed to register template %s: %w", templateName, err)
 		}
 
@@ -153,6 +344,40 @@ func (te *TemplateEngineImpl) LoadTemplatesFromDir(dir string) error {
 	})
 }
 
+// dirFS returns the file system and root directory that dir, a configured
+// template, translation or asset directory, is read from, along with the
+// function naming the source of its files. dir is within the configured FS
+// when one is set, and on disk otherwise.
+func (te *TemplateEngineImpl) dirFS(dir string) (fs.FS, string, func(string) string) {
+	if te.config.FS != nil {
+		return te.config.FS, fsRoot(dir), fsSource
+	}
+	cleanDir := filepath.Clean(dir)
+	return os.DirFS(cleanDir), ".", diskSource(cleanDir)
+}
+
+// fsRoot returns dir as a root directory within a file system.
+func fsRoot(dir string) string {
+	root := path.Clean(strings.TrimPrefix(filepath.ToSlash(dir), "/"))
+	if root == "" {
+		return "."
+	}
+	return root
+}
+
+// fsSource names a file by its path within a file system.
+func fsSource(name string) string {
+	return name
+}
+
+// diskSource returns a function naming files by their path on disk, given
+// their path within dir.
+func diskSource(dir string) func(string) string {
+	return func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+}
+
 // getTemplateFuncs returns the template functions for HTML templates.
 func (te *TemplateEngineImpl) getTemplateFuncs() template.FuncMap {
 	titleCaser := cases.Title(language.English)
@@ -168,6 +393,8 @@ func (te *TemplateEngineImpl) getTemplateFuncs() template.FuncMap {
 		"hasPrefix": strings.HasPrefix,
 		"hasSuffix": strings.HasSuffix,
 		"now":       time.Now,
+		"asset":     te.assetURL,
+		"t":         te.translations.translator(te.config.DefaultLocale, ""),
 		"formatTime": func(format string, t time.Time) string {
 			return t.Format(format)
 		},
@@ -249,6 +476,8 @@ func (te *TemplateEngineImpl) getTextTemplateFuncs() textTemplate.FuncMap {
 		"hasPrefix": strings.HasPrefix,
 		"hasSuffix": strings.HasSuffix,
 		"now":       time.Now,
+		"asset":     te.assetURL,
+		"t":         te.translations.translator(te.config.DefaultLocale, ""),
 		"formatTime": func(format string, t time.Time) string {
 			return t.Format(format)
 		},
@@ -291,29 +520,6 @@ func (te *TemplateEngineImpl) getTextTemplateFuncs() textTemplate.FuncMap {
 	}
 }
 
-// isPathWithinDir checks if a given path is within the specified directory to prevent path traversal attacks.
-func isPathWithinDir(path, dir string) bool {
-	// Get absolute paths
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return false
-	}
-
-	absDir, err := filepath.Abs(dir)
-	if err != nil {
-		return false
-	}
-
-	// Check if the path starts with the directory
-	rel, err := filepath.Rel(absDir, absPath)
-	if err != nil {
-		return false
-	}
-
-	// If rel starts with "..", it's outside the directory
-	return !strings.HasPrefix(rel, "..") && rel != ".."
-}
-
 // Close closes the template engine and releases any resources.
 func (te *TemplateEngineImpl) Close() error {
 	te.mutex.Lock()
@@ -322,6 +528,8 @@ func (te *TemplateEngineImpl) Close() error {
 	// Clear template caches
 	te.htmlTemplates = make(map[string]*template.Template)
 	te.textTemplates = make(map[string]*textTemplate.Template)
+	te.localizedHTML = make(map[string]map[string]*template.Template)
+	te.localizedText = make(map[string]map[string]*textTemplate.Template)
 
 	return nil
 }
@@ -0,0 +1,121 @@
+package mailer_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func newCheckedEngine(t *testing.T, templates fstest.MapFS, severity string) (*mailer.TemplateEngineImpl, error) {
+	t.Helper()
+
+	config := mailer.DefaultConfig().Templates
+	config.Enabled = true
+	config.FS = templates
+	config.Directory = "templates"
+	config.TextPartCheck = severity
+
+	engine, err := mailer.NewTemplateEngine(config)
+	if err != nil {
+		return nil, err
+	}
+	return engine.(*mailer.TemplateEngineImpl), nil
+}
+
+func TestMissingTextPartsFollowsPartNames(t *testing.T) {
+	templates := fstest.MapFS{
+		"templates/welcome.html.html":    {Data: []byte("<p>Hello</p>")},
+		"templates/welcome.text.txt":     {Data: []byte("Hello")},
+		"templates/welcome.de.html.html": {Data: []byte("<p>Hallo</p>")},
+		"templates/reset.html.html":      {Data: []byte("<p>Reset</p>")},
+		"templates/reset.txt.txt":        {Data: []byte("Reset")},
+		"templates/otp.text.txt":         {Data: []byte("Code")},
+	}
+
+	engine, err := newCheckedEngine(t, templates, "")
+	if err != nil {
+		t.Fatalf("NewTemplateEngine: %v", err)
+	}
+
+	// reset.txt is not the "reset.text" part SendTemplate renders, and
+	// welcome.de falls back to the text part of welcome
+	want := []string{"reset.html"}
+	if got := engine.MissingTextParts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingTextParts() = %v, want %v", got, want)
+	}
+}
+
+func TestTextPartCheckFailsEngineCreation(t *testing.T) {
+	templates := fstest.MapFS{
+		"templates/welcome.html.html": {Data: []byte("<p>Hello</p>")},
+	}
+
+	if _, err := newCheckedEngine(t, templates, mailer.TemplateCheckError); err == nil {
+		t.Fatal("NewTemplateEngine succeeded with an HTML-only template")
+	}
+	if _, err := newCheckedEngine(t, templates, mailer.TemplateCheckWarn); err != nil {
+		t.Fatalf("NewTemplateEngine with warnings: %v", err)
+	}
+}
+
+func TestTextPartCheckRunsOnReload(t *testing.T) {
+	templates := fstest.MapFS{
+		"templates/welcome.html.html": {Data: []byte("<p>Hello</p>")},
+		"templates/welcome.text.txt":  {Data: []byte("Hello")},
+	}
+
+	engine, err := newCheckedEngine(t, templates, mailer.TemplateCheckError)
+	if err != nil {
+		t.Fatalf("NewTemplateEngine: %v", err)
+	}
+
+	templates["templates/reset.html.html"] = &fstest.MapFile{Data: []byte("<p>Reset</p>")}
+	if err := engine.Reload(); err == nil {
+		t.Fatal("Reload succeeded with an HTML-only template")
+	}
+	if _, err := engine.Render("reset.html", nil); err == nil {
+		t.Error("failed reload replaced the registered templates")
+	}
+
+	if err := engine.LoadTemplatesFromFS(templates, "templates"); err == nil {
+		t.Error("LoadTemplatesFromFS succeeded with an HTML-only template")
+	}
+}
+
+func TestAutoTextDerivesTextBody(t *testing.T) {
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+
+	templates := fstest.MapFS{
+		"templates/welcome.subject":   {Data: []byte("Welcome")},
+		"templates/welcome.html.html": {Data: []byte("<p>Hello {{.Name}}</p>")},
+	}
+	client, err := mailer.New(mailer.DefaultConfig(),
+		mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+		mailer.WithTemplatesFS(templates, "templates"),
+		mailer.WithTextPartCheck(mailer.TemplateCheckError),
+		mailer.WithAutoText(),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	err = client.SendTemplate(context.Background(), &mailer.TemplateRequest{
+		Template: "welcome",
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: "recipient@example.com"}},
+		Data:     map[string]interface{}{"Name": "Ada"},
+	})
+	if err != nil {
+		t.Fatalf("SendTemplate: %v", err)
+	}
+
+	if got := mock.LastEmail().TextBody; got != "Hello Ada" {
+		t.Errorf("TextBody = %q, want %q", got, "Hello Ada")
+	}
+}
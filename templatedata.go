@@ -0,0 +1,369 @@
+package mailer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// TemplateDataTag is the struct tag key read from template data fields.
+// Options are comma-separated:
+//
+//	mail:"required"             rendering fails when the field is the zero value
+//	mail:"redact"               the field is replaced by RedactedValue in logs
+//	mail:"format=number"        the number is formatted for the locale, e.g. 1,234.5
+//	mail:"format=percent"       the fraction is formatted as a percentage, e.g. 25%
+//	mail:"format=currency"      the amount is formatted in the locale's currency
+//	mail:"format=currency:EUR"  the amount is formatted in the given currency
+//	mail:"format=date"          the time is formatted as a date in the timezone
+//	mail:"format=datetime"      the time is formatted as a date and time in the timezone
+//
+// Structs containing tagged fields are passed to templates as maps keyed by
+// field name, so templates reference fields the same way, but methods on
+// those structs are not available.
+const TemplateDataTag = "mail"
+
+// RedactedValue replaces redacted fields in logged template data.
+const RedactedValue = "[REDACTED]"
+
+// Layouts used by the date and datetime formats.
+const (
+	templateDateLayout     = "2006-01-02"
+	templateDateTimeLayout = "2006-01-02 15:04 MST"
+)
+
+// fieldTag holds the parsed options of a field's mail tag.
+type fieldTag struct {
+	required bool
+	redact   bool
+	format   string
+}
+
+// parseFieldTag parses the mail tag of a struct field.
+func parseFieldTag(field reflect.StructField) fieldTag {
+	var tag fieldTag
+	for _, option := range strings.Split(field.Tag.Get(TemplateDataTag), ",") {
+		switch option = strings.TrimSpace(option); {
+		case option == "required":
+			tag.required = true
+		case option == "redact":
+			tag.redact = true
+		case strings.HasPrefix(option, "format="):
+			tag.format = strings.TrimPrefix(option, "format=")
+		}
+	}
+	return tag
+}
+
+// taggedTypes caches whether a type contains mail tags.
+var taggedTypes sync.Map // reflect.Type -> bool
+
+// hasTemplateTags reports whether t, or a type it contains, has a field with
+// a mail tag.
+func hasTemplateTags(t reflect.Type) bool {
+	if cached, ok := taggedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	tagged := hasTemplateTagsSeen(t, make(map[reflect.Type]bool))
+	taggedTypes.Store(t, tagged)
+	return tagged
+}
+
+// hasTemplateTagsSeen implements hasTemplateTags, skipping the types in seen
+// to terminate on recursive types. Results for nested types are not cached,
+// since they can be incomplete while a recursive type is being walked.
+func hasTemplateTagsSeen(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	tagged := false
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		tagged = hasTemplateTagsSeen(t.Elem(), seen)
+	case reflect.Struct:
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if _, ok := field.Tag.Lookup(TemplateDataTag); ok || hasTemplateTagsSeen(field.Type, seen) {
+				tagged = true
+				break
+			}
+		}
+	}
+
+	return tagged
+}
+
+// needsMapping reports whether v contains fields with mail tags, looking
+// into the dynamic values of interfaces such as the values of a
+// map[string]interface{}.
+func needsMapping(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return false
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && needsMapping(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if hasTemplateTags(v.Type()) {
+			return true
+		}
+		if v.Type().Elem().Kind() == reflect.Interface {
+			for i := range v.Len() {
+				if needsMapping(v.Index(i)) {
+					return true
+				}
+			}
+		}
+		return false
+	case reflect.Map:
+		if hasTemplateTags(v.Type()) {
+			return true
+		}
+		if v.Type().Elem().Kind() == reflect.Interface {
+			iter := v.MapRange()
+			for iter.Next() {
+				if needsMapping(iter.Value()) {
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		return hasTemplateTags(v.Type())
+	}
+}
+
+// templateDataMapper converts tagged template data for rendering or logging.
+type templateDataMapper struct {
+	// redact replaces redacted fields instead of formatting values.
+	redact bool
+
+	printer  *message.Printer
+	tag      language.Tag
+	location *time.Location
+}
+
+// prepareTemplateData checks required fields and applies formats in data for
+// the given template options. Data without mail tags is returned unchanged.
+func prepareTemplateData(data interface{}, options *TemplateOptions) (interface{}, error) {
+	if !needsMapping(reflect.ValueOf(data)) {
+		return data, nil
+	}
+
+	tag := language.Make(options.Locale)
+	location := time.UTC
+	if options.Timezone != "" {
+		loaded, err := time.LoadLocation(options.Timezone)
+		if err != nil {
+			return nil, NewValidationErrorWithValue("timezone", "unknown timezone", options.Timezone)
+		}
+		location = loaded
+	}
+
+	mapper := &templateDataMapper{
+		printer:  message.NewPrinter(tag),
+		tag:      tag,
+		location: location,
+	}
+	return mapper.value(reflect.ValueOf(data), "")
+}
+
+// RedactTemplateData returns a copy of data that is safe to log, with fields
+// tagged mail:"redact" replaced by RedactedValue. Data without mail tags is
+// returned unchanged.
+func RedactTemplateData(data interface{}) interface{} {
+	if !needsMapping(reflect.ValueOf(data)) {
+		return data
+	}
+
+	mapper := &templateDataMapper{redact: true}
+	redacted, _ := mapper.value(reflect.ValueOf(data), "")
+	return redacted
+}
+
+// value converts v, whose path from the data root is path.
+func (m *templateDataMapper) value(v reflect.Value, path string) (interface{}, error) {
+	if !needsMapping(v) {
+		if !v.IsValid() || !v.CanInterface() {
+			return nil, nil
+		}
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return m.value(v.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			item, err := m.value(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			entry, err := m.value(iter.Value(), joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = entry
+		}
+		return entries, nil
+	case reflect.Struct:
+		return m.structValue(v, path)
+	default:
+		return v.Interface(), nil
+	}
+}
+
+// structValue converts a struct to a map keyed by exported field name.
+func (m *templateDataMapper) structValue(v reflect.Value, path string) (interface{}, error) {
+	t := v.Type()
+	fields := make(map[string]interface{}, t.NumField())
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldPath := joinPath(path, field.Name)
+		tag := parseFieldTag(field)
+		fv := v.Field(i)
+
+		if m.redact {
+			if tag.redact {
+				fields[field.Name] = RedactedValue
+				continue
+			}
+		} else {
+			if tag.required && fv.IsZero() {
+				return nil, NewValidationError(fieldPath, "required template field is empty")
+			}
+			if tag.format != "" {
+				formatted, err := m.format(fv, tag.format, fieldPath)
+				if err != nil {
+					return nil, err
+				}
+				fields[field.Name] = formatted
+				continue
+			}
+		}
+
+		converted, err := m.value(fv, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		fields[field.Name] = converted
+	}
+
+	return fields, nil
+}
+
+// format renders a field value with the named format.
+func (m *templateDataMapper) format(v reflect.Value, format, path string) (string, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	name, arg, _ := strings.Cut(format, ":")
+	switch name {
+	case "date", "datetime":
+		t, ok := v.Interface().(time.Time)
+		if !ok {
+			return "", NewValidationErrorWithValue(path, "format "+name+" requires a time.Time", v.Type().String())
+		}
+		layout := templateDateLayout
+		if name == "datetime" {
+			layout = templateDateTimeLayout
+		}
+		return t.In(m.location).Format(layout), nil
+	case "number", "percent", "currency":
+		amount, ok := numericValue(v)
+		if !ok {
+			return "", NewValidationErrorWithValue(path, "format "+name+" requires a number", v.Type().String())
+		}
+		switch name {
+		case "number":
+			return m.printer.Sprint(number.Decimal(amount)), nil
+		case "percent":
+			return m.printer.Sprint(number.Percent(amount)), nil
+		}
+
+		unit, err := m.currency(arg)
+		if err != nil {
+			return "", NewValidationErrorWithValue(path, err.Error(), format)
+		}
+		return m.printer.Sprint(currency.Symbol(unit.Amount(amount))), nil
+	default:
+		return "", NewValidationErrorWithValue(path, "unknown template field format", format)
+	}
+}
+
+// currency returns the currency with the given ISO code, or the currency of
+// the locale's region when code is empty.
+func (m *templateDataMapper) currency(code string) (currency.Unit, error) {
+	if code != "" {
+		unit, err := currency.ParseISO(code)
+		if err != nil {
+			return currency.Unit{}, fmt.Errorf("unknown currency %q", code)
+		}
+		return unit, nil
+	}
+
+	unit, confidence := currency.FromTag(m.tag)
+	if confidence == language.No {
+		return currency.Unit{}, fmt.Errorf("locale %q has no currency; name one with format=currency:<code>", m.tag)
+	}
+	return unit, nil
+}
+
+// numericValue returns v as a float64 if it is a number.
+func numericValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// joinPath appends a field name to a dotted data path.
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,242 @@
+package mailer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// Template types reported in TemplateInfo.
+const (
+	// TemplateTypeHTML is an HTML body template, escaped with html/template.
+	TemplateTypeHTML = "html"
+
+	// TemplateTypeText is a plain text body template.
+	TemplateTypeText = "text"
+
+	// TemplateTypeSubject is a subject line template.
+	TemplateTypeSubject = "subject"
+)
+
+// TemplateInfo describes a registered template.
+type TemplateInfo struct {
+	// Name is the name the template is rendered by.
+	Name string
+
+	// Type is TemplateTypeHTML, TemplateTypeText or TemplateTypeSubject.
+	Type string
+
+	// Source is the file the template was loaded from; empty for templates
+	// registered with RegisterTemplate.
+	Source string
+
+	// ParsedAt is when the template was parsed.
+	ParsedAt time.Time
+
+	// Version is a short hash of the template content, changing whenever the
+	// content does.
+	Version string
+
+	// Variables are the sorted data fields the template references, e.g.
+	// "User.Name". Fields inside range and with blocks are relative to the
+	// block's value.
+	Variables []string
+}
+
+// List returns information about all registered templates, sorted by name.
+func (te *TemplateEngineImpl) List() []TemplateInfo {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	infos := make([]TemplateInfo, 0, len(te.info))
+	for _, info := range te.info {
+		info.Variables = append([]string(nil), info.Variables...)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos
+}
+
+// newTemplateInfo describes a template parsed at parsedAt. kind is the type
+// implied by how it was parsed; the ".subject", ".html" and ".text" name
+// suffixes take precedence.
+func newTemplateInfo(name, kind, content, source string, tree *parse.Tree, parsedAt time.Time) TemplateInfo {
+	switch {
+	case strings.HasSuffix(name, ".subject"):
+		kind = TemplateTypeSubject
+	case strings.HasSuffix(name, ".html"):
+		kind = TemplateTypeHTML
+	case strings.HasSuffix(name, ".text"), strings.HasSuffix(name, ".txt"):
+		kind = TemplateTypeText
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	return TemplateInfo{
+		Name:      name,
+		Type:      kind,
+		Source:    source,
+		ParsedAt:  parsedAt,
+		Version:   hex.EncodeToString(sum[:])[:12],
+		Variables: templateVariables(tree),
+	}
+}
+
+// templateVariables returns the sorted, unique field references in a parse tree.
+func templateVariables(tree *parse.Tree) []string {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	collectVariables(tree.Root, seen)
+
+	variables := make([]string, 0, len(seen))
+	for variable := range seen {
+		variables = append(variables, variable)
+	}
+	sort.Strings(variables)
+
+	return variables
+}
+
+// collectVariables walks node, recording field references in seen.
+func collectVariables(node parse.Node, seen map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectVariables(child, seen)
+		}
+	case *parse.ActionNode:
+		collectVariables(n.Pipe, seen)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectVariables(cmd, seen)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectVariables(arg, seen)
+		}
+	case *parse.FieldNode:
+		seen[strings.Join(n.Ident, ".")] = true
+	case *parse.ChainNode:
+		collectVariables(n.Node, seen)
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, seen)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, seen)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, seen)
+	case *parse.TemplateNode:
+		collectVariables(n.Pipe, seen)
+	}
+}
+
+// collectBranch walks the pipeline and both lists of an if, range or with node.
+func collectBranch(n *parse.BranchNode, seen map[string]bool) {
+	collectVariables(n.Pipe, seen)
+	collectVariables(n.List, seen)
+	collectVariables(n.ElseList, seen)
+}
+
+// Severities for TemplateConfig.TextPartCheck.
+const (
+	// TemplateCheckWarn logs each violation through the client's logger.
+	TemplateCheckWarn = "warn"
+
+	// TemplateCheckError makes engine creation fail, listing every violation.
+	TemplateCheckError = "error"
+)
+
+// MissingTextParts returns the registered names of HTML parts whose template
+// has no text part, since HTML-only emails are penalized by providers and spam
+// filters. Parts are found with the configured TemplateResolver, as
+// SendTemplate finds them, and the text part of a localized template may come
+// from the template it falls back to. It returns nil when AutoText is enabled,
+// since every email then gets a text part.
+func (te *TemplateEngineImpl) MissingTextParts() []string {
+	if te.config.AutoText {
+		return nil
+	}
+
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	resolver := templateResolver(te.config)
+	hasText := func(name string) bool {
+		// Localized templates fall back to their parent, as in
+		// Client.renderLocalized
+		for {
+			if _, ok := te.info[resolver.PartName(name, TemplateTypeText)]; ok {
+				return true
+			}
+			i := strings.LastIndex(name, ".")
+			if i <= 0 {
+				return false
+			}
+			name = name[:i]
+		}
+	}
+
+	// A template's name is not known from its parts' names, so every prefix
+	// of a registered name is a candidate whose HTML part is looked up
+	seen := make(map[string]bool)
+	var missing []string
+	for name := range te.info {
+		for end := 1; end <= len(name); end++ {
+			template := name[:end]
+			htmlPart := resolver.PartName(template, TemplateTypeHTML)
+			if seen[htmlPart] {
+				continue
+			}
+			if _, ok := te.info[htmlPart]; !ok {
+				continue
+			}
+			seen[htmlPart] = true
+			if !hasText(template) {
+				missing = append(missing, htmlPart)
+			}
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
+// checkTextParts runs the configured text part check: with TemplateCheckError
+// it returns an error naming every HTML part without a text part, and with
+// TemplateCheckWarn it logs each one.
+func (te *TemplateEngineImpl) checkTextParts() error {
+	if te.config.TextPartCheck == "" {
+		return nil
+	}
+
+	missing := te.MissingTextParts()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if te.config.TextPartCheck == TemplateCheckError {
+		return fmt.Errorf("HTML templates without a text part: %s", strings.Join(missing, ", "))
+	}
+
+	logger := te.logger
+	if logger == nil {
+		return nil
+	}
+	for _, name := range missing {
+		logger.Warn("HTML template has no text part", "template", name)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/mail"
+	"path"
+	"sort"
+
+	"github.com/lattiq/mailer/internal/core"
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateManifestNames are the file names of the optional template manifest
+// at the root of a template directory, in YAML or JSON. A directory may
+// have only one of them.
+var TemplateManifestNames = []string{"manifest.yaml", "manifest.yml", "manifest.json"}
+
+// subjectExtension is the extension of subject files, which are loaded as
+// the subject part of the template named by the rest of their path whatever
+// the configured extensions.
+const subjectExtension = ".subject"
+
+// TemplateDefaults are the defaults a template manifest declares for a
+// template, applied by SendTemplate to requests that leave them unset, so
+// that callers only need to give the template, recipients and data:
+//
+//	welcome:
+//	  from: "Acme <hello@example.com>"
+//	  reply_to: support@example.com
+//	  category: onboarding
+//	  headers:
+//	    X-Campaign: welcome
+type TemplateDefaults struct {
+	// From is the sender, used when the request has none.
+	From Address
+
+	// ReplyTo is sent as the Reply-To header unless the request sets one.
+	ReplyTo Address
+
+	// Headers are added to the request's headers, which take precedence.
+	Headers map[string]string
+
+	// Category is the email's category unless the request's metadata or
+	// headers give one.
+	Category string
+}
+
+// manifestEntry is a template's entry in a manifest file.
+type manifestEntry struct {
+	From     string            `json:"from" yaml:"from"`
+	ReplyTo  string            `json:"reply_to" yaml:"reply_to"`
+	Headers  map[string]string `json:"headers" yaml:"headers"`
+	Category string            `json:"category" yaml:"category"`
+}
+
+// Defaults returns the defaults the manifest declares for a template.
+func (te *TemplateEngineImpl) Defaults(template string) (TemplateDefaults, bool) {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	defaults, ok := te.defaults[template]
+	return defaults, ok
+}
+
+// loadManifest loads the template manifest at root in fsys, if any.
+func (te *TemplateEngineImpl) loadManifest(fsys fs.FS, root string, source func(string) string) error {
+	var name string
+	for _, candidate := range TemplateManifestNames {
+		candidate = path.Join(root, candidate)
+		if _, err := fs.Stat(fsys, candidate); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read template manifest %s: %w", source(candidate), err)
+		}
+		if name != "" {
+			return fmt.Errorf("multiple template manifests: %s and %s", source(name), source(candidate))
+		}
+		name = candidate
+	}
+	if name == "" {
+		return nil
+	}
+
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to read template manifest %s: %w", source(name), err)
+	}
+
+	var entries map[string]manifestEntry
+	if path.Ext(name) == ".json" {
+		err = json.Unmarshal(content, &entries)
+	} else {
+		err = yaml.Unmarshal(content, &entries)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse template manifest %s: %w", source(name), err)
+	}
+
+	templates := make([]string, 0, len(entries))
+	for template := range entries {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	defaults := make(map[string]TemplateDefaults, len(entries))
+	for _, template := range templates {
+		entry := entries[template]
+		from, err := parseManifestAddress(entry.From)
+		if err != nil {
+			return NewTemplateError(template, "parse", "invalid from address in template manifest", err)
+		}
+		replyTo, err := parseManifestAddress(entry.ReplyTo)
+		if err != nil {
+			return NewTemplateError(template, "parse", "invalid reply_to address in template manifest", err)
+		}
+		defaults[template] = TemplateDefaults{
+			From:     from,
+			ReplyTo:  replyTo,
+			Headers:  entry.Headers,
+			Category: entry.Category,
+		}
+	}
+
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+
+	for template, templateDefaults := range defaults {
+		te.defaults[template] = templateDefaults
+	}
+	return nil
+}
+
+// parseManifestAddress parses an address such as "Acme <hello@example.com>",
+// returning the zero address for an empty string.
+func parseManifestAddress(address string) (Address, error) {
+	if address == "" {
+		return Address{}, nil
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return Address{}, err
+	}
+	return Address{Name: parsed.Name, Email: parsed.Address}, nil
+}
+
+// applyTemplateDefaults fills in what the request leaves unset from the
+// template's manifest defaults. The request itself is not modified.
+func applyTemplateDefaults(req *TemplateRequest, defaults TemplateDefaults) *TemplateRequest {
+	applied := *req
+	if applied.From.Email == "" {
+		applied.From = defaults.From
+	}
+
+	headers := make(map[string]string, len(req.Headers)+len(defaults.Headers)+1)
+	for key, value := range defaults.Headers {
+		headers[key] = value
+	}
+	if defaults.ReplyTo.Email != "" {
+		headers["Reply-To"] = defaults.ReplyTo.String()
+	}
+	for key, value := range req.Headers {
+		headers[key] = value
+	}
+	applied.Headers = headers
+
+	if defaults.Category != "" && req.Metadata[core.MetadataCategory] == nil && headers[core.HeaderCategory] == "" {
+		metadata := make(map[string]interface{}, len(req.Metadata)+1)
+		for key, value := range req.Metadata {
+			metadata[key] = value
+		}
+		metadata[core.MetadataCategory] = defaults.Category
+		applied.Metadata = metadata
+	}
+
+	return &applied
+}
@@ -0,0 +1,328 @@
+package templatetest
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lattiq/mailer"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// EmailRenderer renders a template request into the email that would be
+// sent. *mailer.Client implements it.
+type EmailRenderer interface {
+	RenderEmail(ctx context.Context, req *mailer.TemplateRequest) (*mailer.Email, error)
+}
+
+// DiffOp is the operation of a diff line.
+type DiffOp byte
+
+const (
+	// DiffEqual is a line present in both versions.
+	DiffEqual DiffOp = ' '
+
+	// DiffDelete is a line only present in the version before.
+	DiffDelete DiffOp = '-'
+
+	// DiffInsert is a line only present in the version after.
+	DiffInsert DiffOp = '+'
+)
+
+// DiffLine is a line of a part diff.
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// PartDiff is the line diff of one part of the rendered email.
+type PartDiff struct {
+	// Part is "subject", "from", "headers", "html" or "text". HTML is split
+	// into a line per tag so that changes in minified or inlined markup are
+	// located precisely.
+	Part string
+
+	// Lines are the lines of both versions, in order.
+	Lines []DiffLine
+}
+
+// Changed reports whether the part differs between the versions.
+func (p PartDiff) Changed() bool {
+	for _, line := range p.Lines {
+		if line.Op != DiffEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffReport compares an email rendered from two versions of a template.
+type DiffReport struct {
+	// Template is the name of the rendered template.
+	Template string
+
+	// Parts are the diffs of the subject, sender, headers and bodies.
+	Parts []PartDiff
+}
+
+// Changed reports whether any part differs between the versions.
+func (r *DiffReport) Changed() bool {
+	for _, part := range r.Parts {
+		if part.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffDirs renders req with the templates in beforeDir and in afterDir, e.g.
+// a checkout of the templates currently deployed and the working tree, and
+// reports the differences, so template refactors can be reviewed for
+// unintended content changes before they are promoted.
+func DiffDirs(ctx context.Context, beforeDir, afterDir string, req *mailer.TemplateRequest) (*DiffReport, error) {
+	return DiffDirsWithConfig(ctx, mailer.DefaultConfig().Templates, beforeDir, afterDir, req)
+}
+
+// DiffDirsWithConfig is like DiffDirs but loads templates using config, for
+// projects with custom extensions or a template resolver. The directory of
+// config is replaced by beforeDir and afterDir.
+func DiffDirsWithConfig(ctx context.Context, config mailer.TemplateConfig, beforeDir, afterDir string, req *mailer.TemplateRequest) (*DiffReport, error) {
+	before, err := newRenderClient(config, beforeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer before.Close()
+
+	after, err := newRenderClient(config, afterDir)
+	if err != nil {
+		return nil, err
+	}
+	defer after.Close()
+
+	return Diff(ctx, before, after, req)
+}
+
+// Diff renders req with before and after, such as clients configured with
+// two versions of the templates, and reports the differences.
+func Diff(ctx context.Context, before, after EmailRenderer, req *mailer.TemplateRequest) (*DiffReport, error) {
+	beforeEmail, err := before.RenderEmail(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render before version: %w", err)
+	}
+	afterEmail, err := after.RenderEmail(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render after version: %w", err)
+	}
+
+	return &DiffReport{
+		Template: req.Template,
+		Parts: []PartDiff{
+			{Part: "subject", Lines: diffLines(splitLines(beforeEmail.Subject), splitLines(afterEmail.Subject))},
+			{Part: "from", Lines: diffLines(splitLines(beforeEmail.From.String()), splitLines(afterEmail.From.String()))},
+			{Part: "headers", Lines: diffLines(headerLines(beforeEmail.Headers), headerLines(afterEmail.Headers))},
+			{Part: "html", Lines: diffLines(htmlLines(beforeEmail.HTMLBody), htmlLines(afterEmail.HTMLBody))},
+			{Part: "text", Lines: diffLines(splitLines(beforeEmail.TextBody), splitLines(afterEmail.TextBody))},
+		},
+	}, nil
+}
+
+// newRenderClient creates a client that only renders the templates in dir.
+// Serverless clients create their provider on the first send, so none is
+// created.
+func newRenderClient(config mailer.TemplateConfig, dir string) (*mailer.Client, error) {
+	clientConfig := mailer.DefaultConfig()
+	clientConfig.Templates = config
+	clientConfig.Templates.Enabled = true
+	clientConfig.Templates.Directory = dir
+
+	client, err := mailer.New(clientConfig,
+		mailer.WithSMTP("localhost", "25"),
+		mailer.WithServerless(),
+		mailer.WithLogging("error", "text", "stderr"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates from %s: %w", dir, err)
+	}
+	return client, nil
+}
+
+// WriteText writes the report as a unified diff of each changed part, with
+// the unchanged lines around each change.
+func (r *DiffReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s (before)\n+++ %s (after)\n", r.Template, r.Template)
+	for _, part := range r.Parts {
+		if !part.Changed() {
+			continue
+		}
+		fmt.Fprintf(&b, "@@ %s @@\n", part.Part)
+		for _, line := range visibleLines(part.Lines) {
+			if line == nil {
+				b.WriteString("...\n")
+				continue
+			}
+			fmt.Fprintf(&b, "%c%s\n", line.Op, line.Text)
+		}
+	}
+	if !r.Changed() {
+		b.WriteString("no changes\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// diffHTML is the page written by WriteHTML.
+var diffHTML = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Template}} template diff</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; margin: 0; padding: 0 4px; white-space: pre-wrap; }
+.del { background: #ffebe9; }
+.ins { background: #e6ffec; }
+.gap { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.Template}}</h1>
+{{range .Parts}}<h2>{{.Part}}</h2>
+{{range .Lines}}{{if not .}}<pre class="gap">...</pre>
+{{else if eq .Op '-'}}<pre class="del">-{{.Text}}</pre>
+{{else if eq .Op '+'}}<pre class="ins">+{{.Text}}</pre>
+{{else}}<pre> {{.Text}}</pre>
+{{end}}{{end}}{{else}}<p>No changes.</p>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as an HTML page showing each changed part,
+// with the unchanged lines around each change, for reviewing in a browser.
+func (r *DiffReport) WriteHTML(w io.Writer) error {
+	type part struct {
+		Part  string
+		Lines []*DiffLine
+	}
+	page := struct {
+		Template string
+		Parts    []part
+	}{Template: r.Template}
+	for _, p := range r.Parts {
+		if p.Changed() {
+			page.Parts = append(page.Parts, part{Part: p.Part, Lines: visibleLines(p.Lines)})
+		}
+	}
+	return diffHTML.Execute(w, page)
+}
+
+// visibleLines returns the changed lines and the context around them, with
+// a nil line in place of each run of unchanged lines left out.
+func visibleLines(lines []DiffLine) []*DiffLine {
+	visible := make([]bool, len(lines))
+	for i, line := range lines {
+		if line.Op == DiffEqual {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(lines)-1, i+diffContext); j++ {
+			visible[j] = true
+		}
+	}
+
+	var out []*DiffLine
+	for i := range lines {
+		switch {
+		case visible[i]:
+			out = append(out, &lines[i])
+		case i == 0 || visible[i-1]:
+			out = append(out, nil)
+		}
+	}
+	return out
+}
+
+// diffLines returns the line diff of a and b, from their longest common
+// subsequence.
+func diffLines(a, b []string) []DiffLine {
+	// Lines shared at the start and end need no comparison
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+
+	// common[i][j] is the length of the longest common subsequence of
+	// the remaining a[i:] and b[j:]
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	common := make([][]int, len(ma)+1)
+	for i := range common {
+		common[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			lines = append(lines, DiffLine{Op: DiffEqual, Text: ma[i]})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, DiffLine{Op: DiffDelete, Text: ma[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: DiffInsert, Text: mb[j]})
+			j++
+		}
+	}
+
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+	return lines
+}
+
+// splitLines splits s into lines, returning none for an empty string.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+}
+
+// htmlLines splits HTML into lines, breaking between adjacent tags.
+func htmlLines(html string) []string {
+	return splitLines(strings.ReplaceAll(html, "><", ">\n<"))
+}
+
+// headerLines returns the headers as sorted "Name: value" lines.
+func headerLines(headers map[string]string) []string {
+	lines := make([]string, 0, len(headers))
+	for name, value := range headers {
+		lines = append(lines, name+": "+value)
+	}
+	sort.Strings(lines)
+	return lines
+}
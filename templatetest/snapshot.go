@@ -0,0 +1,115 @@
+package templatetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lattiq/mailer"
+)
+
+// Renderer converts an HTML document into a PNG image.
+// Implementations typically drive a headless browser such as chromedp.
+type Renderer interface {
+	RenderPNG(ctx context.Context, html string) ([]byte, error)
+}
+
+// SnapshotResult describes the snapshot produced for a single template.
+type SnapshotResult struct {
+	// Template is the name of the rendered template.
+	Template string
+
+	// Path is the file the PNG snapshot was written to.
+	Path string
+
+	// Changed is true when the snapshot differs from the file previously at Path,
+	// or when no previous snapshot existed.
+	Changed bool
+}
+
+// Snapshot renders every HTML template in dir with its fixture from fixturesDir,
+// converts the output to PNG with renderer and writes it to outDir as
+// "<template>.png". Existing snapshots are overwritten; the results report
+// which ones changed so CI can surface them for visual review.
+func Snapshot(ctx context.Context, dir, fixturesDir, outDir string, renderer Renderer) ([]SnapshotResult, error) {
+	return SnapshotWithConfig(ctx, mailer.TemplateConfig{
+		Enabled:   true,
+		Directory: dir,
+		Extension: DefaultExtensions,
+	}, fixturesDir, outDir, renderer)
+}
+
+// SnapshotWithConfig is like Snapshot but loads templates using config.
+func SnapshotWithConfig(ctx context.Context, config mailer.TemplateConfig, fixturesDir, outDir string, renderer Renderer) ([]SnapshotResult, error) {
+	if renderer == nil {
+		return nil, errors.New("renderer is required")
+	}
+
+	engine, names, err := loadEngine(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	var results []SnapshotResult
+	var errs []error
+	for _, name := range names {
+		if !strings.Contains(name, ".html") {
+			continue
+		}
+
+		result, err := snapshotOne(ctx, engine, name, fixturesDir, outDir, renderer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("template %s: %w", name, err))
+			continue
+		}
+		results = append(results, result)
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// snapshotOne renders and writes the snapshot for a single template.
+func snapshotOne(ctx context.Context, engine mailer.TemplateEngine, name, fixturesDir, outDir string, renderer Renderer) (SnapshotResult, error) {
+	data, err := loadFixture(name, fixturesDir)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	html, err := engine.Render(name, data)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+
+	png, err := renderer.RenderPNG(ctx, html)
+	if err != nil {
+		return SnapshotResult{}, fmt.Errorf("failed to render snapshot: %w", err)
+	}
+
+	path := filepath.Join(outDir, name+".png")
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return SnapshotResult{}, fmt.Errorf("failed to read previous snapshot: %w", err)
+	}
+
+	// Nested template names, such as "emails/welcome", get subdirectories
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return SnapshotResult{}, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if err := os.WriteFile(path, png, 0o600); err != nil {
+		return SnapshotResult{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return SnapshotResult{
+		Template: name,
+		Path:     path,
+		Changed:  !bytes.Equal(previous, png),
+	}, nil
+}
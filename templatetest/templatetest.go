@@ -0,0 +1,172 @@
+// Package templatetest renders email templates against fixture data so that
+// template regressions are caught by the test suites of projects using mailer.
+//
+// A typical test renders every template in the project's template directory:
+//
+//	func TestEmailTemplates(t *testing.T) {
+//		if err := templatetest.RenderAll("templates", "testdata/fixtures"); err != nil {
+//			t.Fatal(err)
+//		}
+//	}
+//
+// Fixtures are JSON files named after the template, e.g. "otp.html.json" for
+// the "otp.html" template, or "otp.json" to share data between all of the
+// otp templates.
+//
+// Snapshot additionally converts rendered HTML templates to PNG images through
+// a caller-supplied Renderer, for visual diffing in CI, and Diff reports the
+// content changes between two versions of a template.
+package templatetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lattiq/mailer"
+)
+
+// DefaultExtensions are the template file extensions loaded by RenderAll.
+var DefaultExtensions = []string{".html", ".htm", ".txt", ".text", ".subject", ".tmpl"}
+
+// RenderAll loads every template in dir and renders it with its fixture from
+// fixturesDir. It returns an error describing every template that failed to
+// render, references a key missing from its fixture, has no fixture, or
+// contains a broken link.
+func RenderAll(dir, fixturesDir string) error {
+	return RenderAllWithConfig(mailer.TemplateConfig{
+		Enabled:   true,
+		Directory: dir,
+		Extension: DefaultExtensions,
+	}, fixturesDir)
+}
+
+// RenderAllWithConfig is like RenderAll but loads templates using config,
+// for projects with custom extensions or template functions settings.
+// Missing keys are always treated as errors.
+func RenderAllWithConfig(config mailer.TemplateConfig, fixturesDir string) error {
+	engine, names, err := loadEngine(config)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := renderOne(engine, name, fixturesDir); err != nil {
+			errs = append(errs, fmt.Errorf("template %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadEngine creates a strict template engine and returns it together with
+// the names of the templates it loaded.
+func loadEngine(config mailer.TemplateConfig) (mailer.TemplateEngine, []string, error) {
+	config.StrictMissingKeys = true
+
+	engine, err := mailer.NewTemplateEngine(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+
+	return engine, engine.Names(), nil
+}
+
+// renderOne renders a single template against its fixture and checks the output.
+func renderOne(engine mailer.TemplateEngine, name, fixturesDir string) error {
+	data, err := loadFixture(name, fixturesDir)
+	if err != nil {
+		return err
+	}
+
+	output, err := engine.Render(name, data)
+	if err != nil {
+		return err
+	}
+
+	return CheckLinks(output)
+}
+
+// loadFixture reads the fixture for a template, trying the full template name
+// first and then its base name (the part before the first dot).
+func loadFixture(name, fixturesDir string) (interface{}, error) {
+	candidates := []string{name + ".json"}
+	if base, _, found := strings.Cut(name, "."); found {
+		candidates = append(candidates, base+".json")
+	}
+
+	for _, candidate := range candidates {
+		content, err := os.ReadFile(filepath.Join(fixturesDir, filepath.FromSlash(candidate)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(content, &data); err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", candidate, err)
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("no fixture found (tried %s)", strings.Join(candidates, ", "))
+}
+
+// linkPattern matches href and src attribute values in rendered HTML.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// CheckLinks reports links in rendered output that are empty, malformed,
+// missing a scheme, or contain unrendered placeholders. It does not make
+// network requests.
+func CheckLinks(rendered string) error {
+	var errs []error
+	for _, match := range linkPattern.FindAllStringSubmatch(rendered, -1) {
+		link := match[1] + match[2]
+		if err := checkLink(link); err != nil {
+			errs = append(errs, fmt.Errorf("broken link %q: %w", link, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkLink validates a single link.
+func checkLink(link string) error {
+	link = strings.TrimSpace(link)
+	switch {
+	case link == "":
+		return errors.New("empty link")
+	case strings.Contains(strings.ToLower(link), "no%20value"), strings.Contains(link, "<no value>"), strings.Contains(link, "{{"):
+		return errors.New("unrendered placeholder")
+	case strings.Contains(link, "ZgotmplZ"):
+		return errors.New("unsafe URL rejected by html/template")
+	case strings.HasPrefix(link, "#"):
+		return nil
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return errors.New("missing host")
+		}
+	case "mailto", "tel", "cid", "data":
+	case "":
+		return errors.New("missing scheme")
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	return nil
+}
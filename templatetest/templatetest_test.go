@@ -0,0 +1,153 @@
+package templatetest_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/templatetest"
+)
+
+// writeFiles writes the files, keyed by slash-separated path, under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "fixture per template and shared fixture",
+			files: map[string]string{
+				"templates/otp.html.html":     `<p>Your code is {{.Code}}</p>`,
+				"templates/otp.text.txt":      `Your code is {{.Code}}`,
+				"fixtures/otp.json":           `{"Code": "123456"}`,
+				"templates/welcome.html.html": `<a href="{{.URL}}">Start</a>`,
+				"fixtures/welcome.html.json":  `{"URL": "https://example.com/start"}`,
+			},
+		},
+		{
+			name: "missing fixture",
+			files: map[string]string{
+				"templates/otp.html.html": `<p>{{.Code}}</p>`,
+			},
+			wantErr: "no fixture found",
+		},
+		{
+			name: "key missing from fixture",
+			files: map[string]string{
+				"templates/otp.html.html": `<p>{{.Code}}</p>`,
+				"fixtures/otp.json":       `{}`,
+			},
+			wantErr: "template otp.html",
+		},
+		{
+			name: "broken link",
+			files: map[string]string{
+				"templates/welcome.html.html": `<a href="{{.URL}}">Start</a>`,
+				"fixtures/welcome.json":       `{"URL": "example.com/start"}`,
+			},
+			wantErr: "broken link",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			err := templatetest.RenderAll(filepath.Join(dir, "templates"), filepath.Join(dir, "fixtures"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("RenderAll: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("RenderAll error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		rendered string
+		wantErr  bool
+	}{
+		{"absolute URLs", `<a href="https://example.com">x</a><img src='http://example.com/a.png'>`, false},
+		{"fragment", `<a href="#top">x</a>`, false},
+		{"mailto", `<a href="mailto:help@example.com">x</a>`, false},
+		{"empty", `<a href="">x</a>`, true},
+		{"no scheme", `<a href="example.com">x</a>`, true},
+		{"unrendered placeholder", `<a href="https://example.com/{{.ID}}">x</a>`, true},
+		{"missing value", `<a href="https://example.com/%3cno%20value%3e">x</a>`, true},
+		{"rejected by html/template", `<a href="#ZgotmplZ">x</a>`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := templatetest.CheckLinks(tt.rendered)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckLinks error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiffDirsReportsChangedParts(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"before/welcome.subject":   "Welcome",
+		"before/welcome.html.html": "<p>Hello {{.Name}}</p><p>Thanks</p>",
+		"after/welcome.subject":    "Welcome",
+		"after/welcome.html.html":  "<p>Hi {{.Name}}</p><p>Thanks</p>",
+	})
+
+	report, err := templatetest.DiffDirs(context.Background(), filepath.Join(dir, "before"), filepath.Join(dir, "after"), &mailer.TemplateRequest{
+		Template: "welcome",
+		From:     mailer.Address{Email: "sender@example.com"},
+		To:       []mailer.Address{{Email: "recipient@example.com"}},
+		Data:     map[string]interface{}{"Name": "Ada"},
+	})
+	if err != nil {
+		t.Fatalf("DiffDirs: %v", err)
+	}
+
+	if !report.Changed() {
+		t.Fatal("report has no changes")
+	}
+	for _, part := range report.Parts {
+		if changed := part.Part == "html"; part.Changed() != changed {
+			t.Errorf("part %s changed = %v, want %v", part.Part, part.Changed(), changed)
+		}
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, want := range []string{"-<p>Hello Ada</p>", "+<p>Hi Ada</p>"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("diff does not contain %q:\n%s", want, text.String())
+		}
+	}
+}
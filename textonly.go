@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/lattiq/mailer/internal/core"
+)
+
+// MetadataTextOnly is the Email.Metadata key that, set to "true", sends the
+// email without its HTML part.
+const MetadataTextOnly = core.MetadataTextOnly
+
+// TextOnlyResolver reports whether recipients prefer text-only emails, e.g.
+// from an accessibility or low-bandwidth setting in a contact store.
+type TextOnlyResolver interface {
+	PrefersTextOnly(ctx context.Context, recipient Address) (bool, error)
+}
+
+// TextOnlyResolverFunc adapts a function to the TextOnlyResolver interface.
+type TextOnlyResolverFunc func(ctx context.Context, recipient Address) (bool, error)
+
+// PrefersTextOnly calls f(ctx, recipient).
+func (f TextOnlyResolverFunc) PrefersTextOnly(ctx context.Context, recipient Address) (bool, error) {
+	return f(ctx, recipient)
+}
+
+// applyTextOnly returns a copy of the email without its HTML part and inline
+// attachments when its metadata asks for text only or every recipient
+// prefers it, deriving the text part from the HTML when the email has none.
+// The email is returned unchanged otherwise.
+func (c *Client) applyTextOnly(ctx context.Context, email *Email) (*Email, error) {
+	if email.HTMLBody == "" {
+		return email, nil
+	}
+
+	textOnly := email.Metadata[MetadataTextOnly] == "true"
+	if !textOnly && c.config.TextOnlyResolver != nil {
+		var err error
+		textOnly, err = c.prefersTextOnly(ctx, email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve text-only preference: %w", err)
+		}
+	}
+	if !textOnly {
+		return email, nil
+	}
+
+	copied := *email
+	if copied.TextBody == "" {
+		copied.TextBody = htmlToText(email.HTMLBody)
+	}
+	copied.HTMLBody = ""
+	copied.Attachments = nil
+	for _, attachment := range email.Attachments {
+		if !attachment.Inline {
+			copied.Attachments = append(copied.Attachments, attachment)
+		}
+	}
+	return &copied, nil
+}
+
+// prefersTextOnly reports whether every recipient of the email prefers
+// text-only emails; a single message cannot drop its HTML for some of them.
+func (c *Client) prefersTextOnly(ctx context.Context, email *Email) (bool, error) {
+	for _, list := range [][]Address{email.To, email.CC, email.BCC} {
+		for _, recipient := range list {
+			prefers, err := c.config.TextOnlyResolver.PrefersTextOnly(ctx, recipient)
+			if err != nil || !prefers {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// htmlBlockElements are the elements whose content starts on a new line in
+// the text derived from HTML.
+var htmlBlockElements = map[string]bool{
+	"address": true, "blockquote": true, "br": true, "div": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "hr": true,
+	"li": true, "ol": true, "p": true, "table": true, "tr": true, "ul": true,
+}
+
+// htmlToText derives a plain text body from HTML: text is kept with its
+// whitespace collapsed, block elements start new lines, link targets follow
+// their text in brackets, and scripts, styles and the head are dropped.
+func htmlToText(body string) string {
+	var text strings.Builder
+	var line []string
+	var href string
+	skip := 0
+
+	flush := func() {
+		if len(line) > 0 {
+			text.WriteString(strings.Join(line, " "))
+			text.WriteString("\n")
+			line = nil
+		}
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch token.Data {
+			case "head", "script", "style", "title":
+				if tokenType == html.StartTagToken {
+					skip++
+				}
+			case "a":
+				href = ""
+				for _, attr := range token.Attr {
+					if attr.Key == "href" && !strings.HasPrefix(attr.Val, "#") {
+						href = attr.Val
+					}
+				}
+			}
+			if htmlBlockElements[token.Data] {
+				flush()
+			}
+		case html.EndTagToken:
+			switch token.Data {
+			case "head", "script", "style", "title":
+				if skip > 0 {
+					skip--
+				}
+			case "a":
+				if href != "" {
+					line = append(line, "["+href+"]")
+					href = ""
+				}
+			}
+			if htmlBlockElements[token.Data] {
+				flush()
+			}
+		case html.TextToken:
+			if skip == 0 {
+				line = append(line, strings.Fields(token.Data)...)
+			}
+		}
+	}
+	flush()
+
+	return strings.TrimSpace(text.String())
+}
@@ -0,0 +1,375 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// TypedTemplateRequest is a TemplateRequest whose data has a concrete type,
+// sent with SendTypedTemplate.
+type TypedTemplateRequest[T any] struct {
+	// Template is the name of the template to use.
+	Template string
+
+	// To contains the recipients for this email.
+	To []Address
+
+	// From is the sender's address.
+	From Address
+
+	// CC contains carbon copy recipients (optional).
+	CC []Address
+
+	// BCC contains blind carbon copy recipients (optional).
+	BCC []Address
+
+	// Subject is the email subject. If empty, the template should provide it.
+	Subject string
+
+	// Data contains the data to be merged with the template.
+	Data T
+
+	// Options provides additional template rendering options.
+	Options *TemplateOptions
+
+	// Priority indicates the email priority level.
+	Priority Priority
+
+	// Headers contains custom email headers.
+	Headers map[string]string
+
+	// Metadata contains arbitrary data for tracking and analytics.
+	Metadata map[string]interface{}
+}
+
+// SendTypedTemplate sends an email using a template whose data has type T.
+// The first send of each template with a given T checks that every field the
+// template references exists in T, as CheckTemplateData does, and the check
+// is repeated after templates are reloaded.
+func SendTypedTemplate[T any](ctx context.Context, client *Client, req TypedTemplateRequest[T]) error {
+	if client.templateEng == nil {
+		return errors.New("template engine not enabled")
+	}
+
+	if err := client.checkTypedTemplate(req.Template, reflect.TypeFor[T]()); err != nil {
+		return err
+	}
+
+	return client.SendTemplate(ctx, &TemplateRequest{
+		Template: req.Template,
+		To:       req.To,
+		From:     req.From,
+		CC:       req.CC,
+		BCC:      req.BCC,
+		Subject:  req.Subject,
+		Data:     req.Data,
+		Options:  req.Options,
+		Priority: req.Priority,
+		Headers:  req.Headers,
+		Metadata: req.Metadata,
+	})
+}
+
+// CheckTemplateData reports the fields referenced by the parts of a template,
+// including its localized variants, that do not exist in T. Call it at startup
+// for each template and data type pair to catch mismatches before the first
+// send. Fields under range and with blocks are checked against the element
+// and value types; fields reached through interfaces, maps, variables and
+// function results cannot be checked and are accepted. It returns nil for
+// template engines other than the built-in one.
+func CheckTemplateData[T any](engine TemplateEngine, template string) error {
+	return checkTemplateData(engine, template, reflect.TypeFor[T]())
+}
+
+// typedCheck identifies a template and data type pair checked by
+// SendTypedTemplate.
+type typedCheck struct {
+	template string
+	dataType reflect.Type
+	versions string
+}
+
+// checkTypedTemplate checks a template against a data type once per template
+// version.
+func (c *Client) checkTypedTemplate(template string, dataType reflect.Type) error {
+	key := typedCheck{template: template, dataType: dataType, versions: templateVersions(c.templateEng, template)}
+	if cached, ok := c.typedChecks.Load(key); ok {
+		return cached.(typedCheckResult).err
+	}
+
+	err := checkTemplateData(c.templateEng, template, dataType)
+	c.typedChecks.Store(key, typedCheckResult{err: err})
+	return err
+}
+
+// typedCheckResult wraps a possibly nil error for storage in a sync.Map.
+type typedCheckResult struct {
+	err error
+}
+
+// templateVersions returns the versions of a template's parts, which change
+// whenever the parts are reloaded with different content.
+func templateVersions(engine TemplateEngine, template string) string {
+	impl, ok := engine.(*TemplateEngineImpl)
+	if !ok {
+		return ""
+	}
+
+	var versions []string
+	for _, info := range impl.List() {
+		if impl.isTemplatePart(info.Name, template) {
+			versions = append(versions, info.Name+"@"+info.Version)
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// checkTemplateData implements CheckTemplateData.
+func checkTemplateData(engine TemplateEngine, template string, dataType reflect.Type) error {
+	impl, ok := engine.(*TemplateEngineImpl)
+	if !ok {
+		return nil
+	}
+
+	trees := impl.templateParts(template)
+	if len(trees) == 0 {
+		return NewTemplateError(template, "check", "template not found", ErrTemplateNotFound)
+	}
+
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		checker := &dataChecker{
+			root:    dataType,
+			methods: !hasTemplateTags(dataType),
+			seen:    make(map[string]bool),
+		}
+		checker.list(trees[name].Root, dataType)
+		for _, problem := range checker.problems {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return NewTemplateError(template, "check",
+			fmt.Sprintf("data type %s does not match the template: %s", dataType, strings.Join(problems, "; ")), nil)
+	}
+	return nil
+}
+
+// templateParts returns the parse trees of the parts of a template and of its
+// localized variants.
+func (te *TemplateEngineImpl) templateParts(template string) map[string]*parse.Tree {
+	te.mutex.RLock()
+	defer te.mutex.RUnlock()
+
+	trees := make(map[string]*parse.Tree)
+	for name, tmpl := range te.htmlTemplates {
+		if te.isTemplatePart(name, template) {
+			trees[name] = tmpl.Tree
+		}
+	}
+	for name, tmpl := range te.textTemplates {
+		if te.isTemplatePart(name, template) {
+			trees[name] = tmpl.Tree
+		}
+	}
+	return trees
+}
+
+// isTemplatePart reports whether name is the registered name of a part of
+// template or of one of its localized variants.
+func (te *TemplateEngineImpl) isTemplatePart(name, template string) bool {
+	resolver := templateResolver(te.config)
+	for _, part := range []string{TemplateTypeSubject, TemplateTypeHTML, TemplateTypeText} {
+		if name == resolver.PartName(template, part) {
+			return true
+		}
+
+		// Localized variants are parts of "<template>.<locale>"
+		rest, ok := strings.CutPrefix(name, template+".")
+		if !ok {
+			continue
+		}
+		locale, ok := strings.CutSuffix(rest, "."+part)
+		if ok && !strings.Contains(locale, ".") && name == resolver.PartName(template+"."+locale, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataChecker walks a template parse tree, tracking the type of dot, and
+// records field references that do not exist in the data type. A nil type
+// means the type is unknown and references on it are not checked.
+type dataChecker struct {
+	root     reflect.Type
+	methods  bool
+	problems []string
+	seen     map[string]bool
+}
+
+// problem records a problem once.
+func (ck *dataChecker) problem(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !ck.seen[message] {
+		ck.seen[message] = true
+		ck.problems = append(ck.problems, message)
+	}
+}
+
+// list checks the nodes of a list with dot of type dot.
+func (ck *dataChecker) list(list *parse.ListNode, dot reflect.Type) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			ck.pipe(n.Pipe, dot)
+		case *parse.IfNode:
+			ck.pipe(n.Pipe, dot)
+			ck.list(n.List, dot)
+			ck.list(n.ElseList, dot)
+		case *parse.RangeNode:
+			ck.list(n.List, elemType(ck.pipe(n.Pipe, dot)))
+			ck.list(n.ElseList, dot)
+		case *parse.WithNode:
+			ck.list(n.List, ck.pipe(n.Pipe, dot))
+			ck.list(n.ElseList, dot)
+		case *parse.TemplateNode:
+			// The invoked template is checked on its own when it is a part
+			ck.pipe(n.Pipe, dot)
+		}
+	}
+}
+
+// pipe checks a pipeline and returns the type of its result.
+func (ck *dataChecker) pipe(pipe *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+	var result reflect.Type
+	for i, cmd := range pipe.Cmds {
+		result = ck.command(cmd, dot)
+		if i > 0 {
+			// Later commands are function calls receiving the previous result
+			result = nil
+		}
+	}
+	if len(pipe.Decl) > 0 {
+		return nil
+	}
+	return result
+}
+
+// command checks a command and returns the type of its result.
+func (ck *dataChecker) command(cmd *parse.CommandNode, dot reflect.Type) reflect.Type {
+	var result reflect.Type
+	for i, arg := range cmd.Args {
+		t := ck.arg(arg, dot)
+		if i == 0 {
+			result = t
+		}
+	}
+	if len(cmd.Args) > 0 {
+		if _, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+			return nil
+		}
+	}
+	return result
+}
+
+// arg checks a command argument and returns its type.
+func (ck *dataChecker) arg(node parse.Node, dot reflect.Type) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return ck.resolve(dot, n.Ident, n.String())
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			return ck.resolve(ck.root, n.Ident[1:], n.String())
+		}
+		return nil
+	case *parse.ChainNode:
+		return ck.resolve(ck.arg(n.Node, dot), n.Field, n.String())
+	case *parse.PipeNode:
+		return ck.pipe(n, dot)
+	default:
+		return nil
+	}
+}
+
+// resolve follows a chain of field names from type t, recording the first
+// that does not exist.
+func (ck *dataChecker) resolve(t reflect.Type, fields []string, reference string) reflect.Type {
+	for _, field := range fields {
+		if t == nil {
+			return nil
+		}
+
+		if ck.methods {
+			if method, ok := reflect.PointerTo(indirectType(t)).MethodByName(field); ok {
+				if method.Type.NumOut() == 0 {
+					return nil
+				}
+				t = method.Type.Out(0)
+				continue
+			}
+		}
+
+		base := indirectType(t)
+		switch base.Kind() {
+		case reflect.Struct:
+			structField, ok := base.FieldByName(field)
+			if !ok || !structField.IsExported() {
+				ck.problem("%s: %s has no field %s", reference, base, field)
+				return nil
+			}
+			t = structField.Type
+		case reflect.Map:
+			t = base.Elem()
+		case reflect.Interface:
+			return nil
+		default:
+			ck.problem("%s: %s has no field %s", reference, base, field)
+			return nil
+		}
+	}
+	return t
+}
+
+// indirectType returns the type pointed to by pointer types.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// elemType returns the type of dot inside a range over a value of type t.
+func elemType(t reflect.Type) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t = indirectType(t); t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return t.Elem()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,298 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Modes for TypoCheckConfig.Mode.
+const (
+	// TypoCheckWarn logs recipients at likely misspelled domains and sends unchanged.
+	TypoCheckWarn = "warn"
+
+	// TypoCheckReject fails the send with a validation error naming the suggestion.
+	TypoCheckReject = "reject"
+
+	// TypoCheckCorrect rewrites recipients at known misspellings, from the
+	// built-in list or TypoCheckConfig.Corrections, and logs the change.
+	// Near misses of known domains are only logged, as in TypoCheckWarn,
+	// since they may be real domains of their own.
+	TypoCheckCorrect = "correct"
+)
+
+// TypoCheckConfig configures detection of misspelled recipient domains, such
+// as gamil.com for gmail.com, which would otherwise hard bounce.
+type TypoCheckConfig struct {
+	// Mode is TypoCheckWarn, TypoCheckReject or TypoCheckCorrect. Empty
+	// disables the check.
+	Mode string
+
+	// Domains are additional correctly spelled domains, e.g. your own or
+	// your customers' most common domains. They are never reported and are
+	// suggested for near misses.
+	Domains []string
+
+	// Corrections map specific misspelled domains to their correct spelling,
+	// taking precedence over the built-in heuristics.
+	Corrections map[string]string
+
+	// Resolver looks up the MX records of domains for CheckAddress
+	// (default: net.DefaultResolver).
+	Resolver MXResolver
+}
+
+// commonDomains are widely used mailbox domains that near misses are
+// compared against.
+var commonDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "yahoo.co.uk", "yahoo.fr",
+	"ymail.com", "hotmail.com", "hotmail.co.uk", "hotmail.fr", "outlook.com",
+	"live.com", "msn.com", "icloud.com", "me.com", "mac.com", "aol.com",
+	"mail.com", "gmx.com", "gmx.de", "gmx.net", "web.de", "proton.me",
+	"protonmail.com", "zoho.com", "yandex.ru", "mail.ru", "comcast.net",
+	"verizon.net", "att.net", "sbcglobal.net", "qq.com", "163.com",
+}
+
+// commonTypos are well-known misspellings. Unlike near misses found by edit
+// distance, they are corrected by TypoCheckCorrect, as none of them is a
+// real mailbox domain.
+var commonTypos = map[string]string{
+	"gamil.com":     "gmail.com",
+	"gmial.com":     "gmail.com",
+	"gmai.com":      "gmail.com",
+	"gmal.com":      "gmail.com",
+	"gmaill.com":    "gmail.com",
+	"gnail.com":     "gmail.com",
+	"gmail.co":      "gmail.com",
+	"gmail.cm":      "gmail.com",
+	"gmaik.com":     "gmail.com",
+	"gmial.co":      "gmail.com",
+	"gamil.co":      "gmail.com",
+	"hotmial.com":   "hotmail.com",
+	"hotamil.com":   "hotmail.com",
+	"hotmai.com":    "hotmail.com",
+	"hotmil.com":    "hotmail.com",
+	"hotmal.com":    "hotmail.com",
+	"hotmail.co":    "hotmail.com",
+	"hotmial.co":    "hotmail.com",
+	"yaho.com":      "yahoo.com",
+	"yahho.com":     "yahoo.com",
+	"yhaoo.com":     "yahoo.com",
+	"yahoo.co":      "yahoo.com",
+	"yahooo.com":    "yahoo.com",
+	"outlok.com":    "outlook.com",
+	"outlook.co":    "outlook.com",
+	"outllok.com":   "outlook.com",
+	"icloud.co":     "icloud.com",
+	"iclould.com":   "icloud.com",
+	"protonmail.co": "protonmail.com",
+}
+
+// SuggestAddress returns the address with its domain corrected when the
+// domain looks like a misspelling of a common mailbox domain, such as
+// "jane@gamil.com" for "jane@gmail.com". It reports false when there is no
+// suggestion. Use it to prompt users at signup, before mail is ever sent.
+func SuggestAddress(address string) (string, bool) {
+	suggestion, _ := suggestAddress(address, TypoCheckConfig{})
+	return suggestion, suggestion != ""
+}
+
+// suggestAddress implements SuggestAddress with the configured domains and
+// corrections, returning an empty suggestion when there is none. certain
+// reports whether the domain is a known misspelling rather than a near miss
+// of a known domain, which may be a real domain of its own, such as me.com
+// for ge.com.
+func suggestAddress(address string, config TypoCheckConfig) (suggestion string, certain bool) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return "", false
+	}
+	local, domain := address[:at], strings.ToLower(address[at+1:])
+
+	if correction, ok := config.Corrections[domain]; ok {
+		return local + "@" + correction, true
+	}
+
+	known := make(map[string]bool, len(commonDomains)+len(config.Domains))
+	for _, d := range commonDomains {
+		known[d] = true
+	}
+	for _, d := range config.Domains {
+		known[strings.ToLower(d)] = true
+	}
+	if known[domain] {
+		return "", false
+	}
+
+	if correction, ok := commonTypos[domain]; ok {
+		return local + "@" + correction, true
+	}
+
+	// Suggest the only known domain one edit away, leaving ambiguous cases alone
+	var near string
+	for d := range known {
+		if editDistance(domain, d) != 1 {
+			continue
+		}
+		if near != "" {
+			return "", false
+		}
+		near = d
+	}
+	if near == "" {
+		return "", false
+	}
+	return local + "@" + near, false
+}
+
+// AddressCheck is the verdict of CheckAddress on an address.
+type AddressCheck struct {
+	// Address is the checked address.
+	Address string
+
+	// Valid reports whether the address is well formed.
+	Valid bool
+
+	// Suggestion is the address with its domain corrected when the domain
+	// looks misspelled, or empty.
+	Suggestion string
+
+	// Certain reports whether Suggestion corrects a known misspelling, from
+	// the built-in list or TypoCheckConfig.Corrections, rather than a near
+	// miss of a known domain. TypoCheckCorrect only applies certain
+	// suggestions.
+	Certain bool
+
+	// Deliverable reports whether the domain has MX records accepting mail.
+	// It is false for addresses that are not valid.
+	Deliverable bool
+}
+
+// CheckAddress verifies an address before it is stored or mailed: whether
+// it is well formed, whether its domain looks misspelled, with the domains
+// and corrections of the client's typo check, and whether the domain
+// accepts mail. A domain that does not exist or has a null MX record is
+// reported as not deliverable; the error is that of a failed DNS lookup.
+func (c *Client) CheckAddress(ctx context.Context, address string) (AddressCheck, error) {
+	check := AddressCheck{
+		Address: address,
+		Valid:   Address{Email: address}.Valid(),
+	}
+	if !check.Valid {
+		return check, nil
+	}
+	check.Suggestion, check.Certain = suggestAddress(address, c.config.TypoCheck)
+
+	resolver := c.config.TypoCheck.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	records, err := resolver.LookupMX(ctx, addressDomain(address))
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+
+	// A single record for "." is a null MX: the domain accepts no mail
+	if len(records) == 1 && records[0].Host == "." {
+		return check, nil
+	}
+	check.Deliverable = len(records) > 0
+	return check, nil
+}
+
+// checkTypos applies the configured typo check to an email's recipients,
+// returning a corrected copy in TypoCheckCorrect mode.
+func (c *Client) checkTypos(email *Email) (*Email, error) {
+	config := c.config.TypoCheck
+	if config.Mode == "" {
+		return email, nil
+	}
+
+	var corrected *Email
+	lists := []struct {
+		field string
+		get   func(e *Email) []Address
+	}{
+		{"to", func(e *Email) []Address { return e.To }},
+		{"cc", func(e *Email) []Address { return e.CC }},
+		{"bcc", func(e *Email) []Address { return e.BCC }},
+	}
+
+	for _, list := range lists {
+		for i, recipient := range list.get(email) {
+			suggestion, certain := suggestAddress(recipient.Email, config)
+			if suggestion == "" {
+				continue
+			}
+
+			switch {
+			case config.Mode == TypoCheckReject:
+				return nil, NewValidationErrorWithValue(list.field,
+					"recipient domain looks misspelled, did you mean "+suggestion+"?", recipient.Email)
+			case config.Mode == TypoCheckCorrect && certain:
+				// Correct a copy, leaving the caller's email untouched
+				if corrected == nil {
+					copied := *email
+					copied.To = append([]Address(nil), email.To...)
+					copied.CC = append([]Address(nil), email.CC...)
+					copied.BCC = append([]Address(nil), email.BCC...)
+					corrected = &copied
+				}
+				list.get(corrected)[i].Email = suggestion
+				c.logger.Warn("corrected misspelled recipient domain",
+					"from", recipient.Email,
+					"to", suggestion,
+					"correlation_id", email.CorrelationID(),
+				)
+			default:
+				c.logger.Warn("recipient domain looks misspelled",
+					"recipient", recipient.Email,
+					"suggestion", suggestion,
+					"correlation_id", email.CorrelationID(),
+				)
+			}
+		}
+	}
+
+	if corrected != nil {
+		return corrected, nil
+	}
+	return email, nil
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b
+// (optimal string alignment), counting a transposition as one edit.
+func editDistance(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,107 @@
+package mailer_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/lattiq/mailer"
+	"github.com/lattiq/mailer/mailertest"
+)
+
+func TestTypoCheckCorrectRewritesOnlyKnownMisspellings(t *testing.T) {
+	tests := []struct {
+		to   string
+		want string
+	}{
+		{"jane@gamil.com", "jane@gmail.com"},
+		{"jane@hotmial.com", "jane@hotmail.com"},
+		{"jane@acme.test", "jane@acme.example"},
+		// Real domains one edit away from a common one are left alone
+		{"jane@ge.com", "jane@ge.com"},
+		{"jane@aon.com", "jane@aon.com"},
+		{"jane@gmc.com", "jane@gmc.com"},
+		{"jane@zoo.com", "jane@zoo.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.to, func(t *testing.T) {
+			mock := mailertest.NewMockProvider("mock")
+			t.Cleanup(mock.Close)
+
+			config := mailer.DefaultConfig()
+			config.Templates.Enabled = false
+			config.TypoCheck.Corrections = map[string]string{"acme.test": "acme.example"}
+			client, err := mailer.New(config,
+				mailer.WithProvider(mailertest.ProviderType, mock.Settings()),
+				mailer.WithTypoCheck(mailer.TypoCheckCorrect),
+			)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			t.Cleanup(func() { client.Close() })
+
+			email := validEmail()
+			email.To = []mailer.Address{{Email: tt.to}}
+			if err := client.Send(context.Background(), email); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if got := mock.LastEmail().To[0].Email; got != tt.want {
+				t.Errorf("sent to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeResolver answers MX lookups from a map, reporting other domains as
+// not found.
+type fakeResolver map[string][]*net.MX
+
+func (r fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func TestCheckAddress(t *testing.T) {
+	config := mailer.DefaultConfig()
+	config.Templates.Enabled = false
+	config.TypoCheck.Resolver = fakeResolver{
+		"gmail.com":   {{Host: "gmail-smtp-in.l.google.com.", Pref: 5}},
+		"ge.com":      {{Host: "mx.ge.com.", Pref: 10}},
+		"nomail.test": {{Host: ".", Pref: 0}},
+	}
+	mock := mailertest.NewMockProvider("mock")
+	t.Cleanup(mock.Close)
+	client, err := mailer.New(config, mailer.WithProvider(mailertest.ProviderType, mock.Settings()))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	tests := []struct {
+		address string
+		want    mailer.AddressCheck
+	}{
+		{"jane@gmail.com", mailer.AddressCheck{Valid: true, Deliverable: true}},
+		{"jane@gamil.com", mailer.AddressCheck{Valid: true, Suggestion: "jane@gmail.com", Certain: true}},
+		{"jane@ge.com", mailer.AddressCheck{Valid: true, Suggestion: "jane@me.com", Deliverable: true}},
+		{"jane@nomail.test", mailer.AddressCheck{Valid: true}},
+		{"not-an-address", mailer.AddressCheck{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			got, err := client.CheckAddress(context.Background(), tt.address)
+			if err != nil {
+				t.Fatalf("CheckAddress: %v", err)
+			}
+			tt.want.Address = tt.address
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}